@@ -0,0 +1,77 @@
+// Package audit records security-relevant events, such as authentication outcomes, and
+// ships them to an external system through a pluggable Sink. The BufferedSink batches
+// events in the background, so shipping them never holds up request handling.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+// Event types
+const (
+	EventAuthentication = "authentication"
+)
+
+// Event outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event is an audit record. It never carries token material.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Outcome   string    `json:"outcome"`
+	Subject   string    `json:"subject,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+}
+
+// Sink ships audit events to an external system, such as a webhook, Kafka or syslog
+type Sink interface {
+	Send(ctx context.Context, events []Event) error
+}
+
+// Closer is implemented by sinks holding events or connections that must be flushed or
+// released on shutdown
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// AuditLogger records audit events to a sink. Failing sinks are logged, never returned to
+// the caller, so auditing can't fail a request.
+type AuditLogger struct {
+	sink Sink
+	log  logger.Logger
+}
+
+// NewAuditLogger creates an AuditLogger writing to sink. log may be nil.
+func NewAuditLogger(sink Sink, log logger.Logger) *AuditLogger {
+	return &AuditLogger{sink: sink, log: log}
+}
+
+// Log records event, stamping it with the current time unless it has one
+func (a *AuditLogger) Log(ctx context.Context, event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if err := a.sink.Send(ctx, []Event{event}); err != nil && a.log != nil {
+		a.log.WarnContext(ctx, "Failed to record audit event", "type", event.Type, "error", err)
+	}
+}
+
+// Close flushes and closes the sink, waiting no longer than ctx allows
+func (a *AuditLogger) Close(ctx context.Context) error {
+	if closer, ok := a.sink.(Closer); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/metrics"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+// Defaults of BufferOptions
+const (
+	defaultBatchSize     = 100
+	defaultQueueSize     = 10000
+	defaultFlushInterval = 5 * time.Second
+)
+
+// BufferOptions tunes a BufferedSink. Zero values take the defaults: batches of 100 events,
+// flushed at least every 5s, with up to 10000 events queued.
+type BufferOptions struct {
+	BatchSize     int
+	QueueSize     int
+	FlushInterval time.Duration
+}
+
+// BufferedSink queues events and sends them to the next sink in batches from a background
+// goroutine, when a batch is full or the flush interval passes. Send never blocks: when the
+// queue is full, because the next sink is slow or down, events are dropped and counted in
+// metrics.AuditEventsDropped, as are batches the next sink fails to take.
+type BufferedSink struct {
+	next          Sink
+	log           logger.Logger
+	batchSize     int
+	flushInterval time.Duration
+
+	queue   chan Event
+	closing chan struct{}
+	done    chan struct{}
+
+	// mu keeps Send from queueing an event once Close began, which run might not drain
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewBufferedSink starts a BufferedSink in front of next. log may be nil.
+func NewBufferedSink(next Sink, opts BufferOptions, log logger.Logger) *BufferedSink {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+
+	b := &BufferedSink{
+		next:          next,
+		log:           log,
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		queue:         make(chan Event, opts.QueueSize),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Send queues events for the next batch, dropping those that don't fit or arrive after Close
+func (b *BufferedSink) Send(_ context.Context, events []Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		metrics.AuditEventsDropped.Add(int64(len(events)))
+		return nil
+	}
+	for _, event := range events {
+		select {
+		case b.queue <- event:
+		default:
+			metrics.AuditEventsDropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// Close stops taking events, flushes the queued ones and closes the next sink. It returns
+// ctx's error when that doesn't finish in time, in which case the remaining events are lost.
+func (b *BufferedSink) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.closing)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if closer, ok := b.next.(Closer); ok {
+		return closer.Close(ctx)
+	}
+	return nil
+}
+
+// run batches queued events until the sink is closed, then drains the queue
+func (b *BufferedSink) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Event, 0, b.batchSize)
+	add := func(event Event) {
+		batch = append(batch, event)
+		if len(batch) >= b.batchSize {
+			b.flush(batch)
+			batch = make([]Event, 0, b.batchSize)
+		}
+	}
+
+	for {
+		select {
+		case event := <-b.queue:
+			add(event)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				b.flush(batch)
+				batch = make([]Event, 0, b.batchSize)
+			}
+		case <-b.closing:
+			for {
+				select {
+				case event := <-b.queue:
+					add(event)
+				default:
+					if len(batch) > 0 {
+						b.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush sends a batch to the next sink, counting its events as dropped if that fails
+func (b *BufferedSink) flush(batch []Event) {
+	ctx := context.Background()
+	if err := b.next.Send(ctx, batch); err != nil {
+		metrics.AuditEventsDropped.Add(int64(len(batch)))
+		if b.log != nil {
+			b.log.WarnContext(ctx, "Failed to ship audit events, dropping them", "events", len(batch), "error", err)
+		}
+	}
+}
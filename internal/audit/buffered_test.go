@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/metrics"
+)
+
+// countingSink counts the events it receives
+type countingSink struct {
+	events atomic.Int64
+	closed atomic.Bool
+}
+
+func (s *countingSink) Send(_ context.Context, events []Event) error {
+	s.events.Add(int64(len(events)))
+	return nil
+}
+
+func (s *countingSink) Close(context.Context) error {
+	s.closed.Store(true)
+	return nil
+}
+
+func TestBufferedSinkFlushesOnClose(t *testing.T) {
+	next := &countingSink{}
+	b := NewBufferedSink(next, BufferOptions{BatchSize: 10, FlushInterval: time.Hour}, nil)
+
+	for i := 0; i < 25; i++ {
+		_ = b.Send(context.Background(), []Event{{Type: EventAuthentication}})
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := next.events.Load(); n != 25 {
+		t.Errorf("next sink received %d events, want 25", n)
+	}
+	if !next.closed.Load() {
+		t.Error("next sink wasn't closed")
+	}
+}
+
+func TestBufferedSinkSendRacingClose(t *testing.T) {
+	next := &countingSink{}
+	b := NewBufferedSink(next, BufferOptions{BatchSize: 7, QueueSize: 100000, FlushInterval: time.Hour}, nil)
+	droppedBefore := metrics.AuditEventsDropped.Value()
+
+	const senders, perSender = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				_ = b.Send(context.Background(), []Event{{Type: EventAuthentication}})
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// Every event was either delivered or counted as dropped, none left in the queue
+	delivered := next.events.Load()
+	dropped := metrics.AuditEventsDropped.Value() - droppedBefore
+	if delivered+dropped != senders*perSender {
+		t.Errorf("delivered %d and dropped %d events, want %d in total", delivered, dropped, senders*perSender)
+	}
+	if len(b.queue) != 0 {
+		t.Errorf("%d events left in the queue after Close", len(b.queue))
+	}
+}
@@ -0,0 +1,31 @@
+// Package clock provides the time source for expiry, clock skew, freshness and rate limit
+// checks, so they can be run against a fixed time instead of the system clock.
+package clock
+
+import "time"
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// Func adapts a function to a Clock, e.g. clock.Func(func() time.Time { return fixed })
+type Func func() time.Time
+
+// Now returns the result of the function
+func (f Func) Now() time.Time {
+	return f()
+}
+
+// System returns the Clock reading the system time
+func System() Clock {
+	return Func(time.Now)
+}
+
+// OrSystem returns c, or the system clock when c is nil
+func OrSystem(c Clock) Clock {
+	if c == nil {
+		return System()
+	}
+	return c
+}
@@ -0,0 +1,285 @@
+package awssecrets
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expiryWindow is how long before they expire temporary credentials are renewed
+const expiryWindow = 5 * time.Minute
+
+// ecsCredentialsHost serves the credentials of ECS tasks at AWS_CONTAINER_CREDENTIALS_RELATIVE_URI
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// defaultIMDSEndpoint is the EC2 instance metadata service; AWS_EC2_METADATA_SERVICE_ENDPOINT
+// overrides it
+const defaultIMDSEndpoint = "http://169.254.169.254"
+
+// credentials are the AWS access keys requests are signed with
+type credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	// expires is when temporary credentials expire, zero for long-term ones
+	expires time.Time
+}
+
+// credentialSource retrieves credentials from one place of the credential chain
+type credentialSource interface {
+	retrieve(ctx context.Context) (credentials, error)
+}
+
+// newCredentialSource picks the source of the credentials the SDKs would use, in their
+// order: access keys in the environment, a web identity token (IRSA on EKS), the ECS or
+// EKS Pod Identity container endpoint, then the EC2 instance profile. Shared credentials
+// files, SSO and credential_process are not supported.
+func newCredentialSource(region string, client *http.Client) credentialSource {
+	switch {
+	case os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "":
+		return staticCredentials{
+			accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "":
+		endpoint := firstNonEmpty(os.Getenv("AWS_ENDPOINT_URL_STS"), fmt.Sprintf("https://sts.%s.amazonaws.com", region))
+		return &webIdentityCredentials{
+			endpoint:    strings.TrimSuffix(endpoint, "/"),
+			roleARN:     os.Getenv("AWS_ROLE_ARN"),
+			sessionName: firstNonEmpty(os.Getenv("AWS_ROLE_SESSION_NAME"), "iam-bridge"),
+			tokenFile:   os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+			client:      client,
+		}
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "":
+		return &containerCredentials{url: ecsCredentialsHost + os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), client: client}
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		return &containerCredentials{
+			url:       os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"),
+			token:     os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"),
+			tokenFile: os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"),
+			client:    client,
+		}
+	case strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true"):
+		return nil
+	default:
+		endpoint := firstNonEmpty(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), defaultIMDSEndpoint)
+		return &instanceCredentials{endpoint: strings.TrimSuffix(endpoint, "/"), client: client}
+	}
+}
+
+// cachedCredentials keeps the credentials of a source until shortly before they expire
+type cachedCredentials struct {
+	source credentialSource
+
+	mu    sync.Mutex
+	creds credentials
+}
+
+// retrieve returns the cached credentials, renewing them when they are about to expire
+func (c *cachedCredentials) retrieve(ctx context.Context) (credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.creds.accessKeyID != "" && (c.creds.expires.IsZero() || time.Until(c.creds.expires) > expiryWindow) {
+		return c.creds, nil
+	}
+	creds, err := c.source.retrieve(ctx)
+	if err != nil {
+		return credentials{}, err
+	}
+	c.creds = creds
+	return creds, nil
+}
+
+// staticCredentials are long-term or session credentials from the environment
+type staticCredentials credentials
+
+func (s staticCredentials) retrieve(context.Context) (credentials, error) {
+	return credentials(s), nil
+}
+
+// webIdentityCredentials assume a role with the web identity token in tokenFile, as
+// mounted by EKS for IAM roles for service accounts
+type webIdentityCredentials struct {
+	endpoint    string
+	roleARN     string
+	sessionName string
+	tokenFile   string
+	client      *http.Client
+}
+
+func (w *webIdentityCredentials) retrieve(ctx context.Context) (credentials, error) {
+	// The token is rotated by the kubelet, so it is read again for every renewal
+	token, err := os.ReadFile(w.tokenFile)
+	if err != nil {
+		return credentials{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {w.roleARN},
+		"RoleSessionName":  {w.sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint+"/", strings.NewReader(form.Encode()))
+	if err != nil {
+		return credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	data, err := fetch(w.client, req)
+	if err != nil {
+		return credentials{}, fmt.Errorf("failed to assume role with web identity: %w", err)
+	}
+
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(data, &resp); err != nil {
+		return credentials{}, fmt.Errorf("failed to decode STS response: %w", err)
+	}
+	c := resp.Credentials
+	if c.AccessKeyID == "" {
+		return credentials{}, errors.New("STS returned no credentials")
+	}
+	return credentials{c.AccessKeyID, c.SecretAccessKey, c.SessionToken, c.Expiration}, nil
+}
+
+// containerCredentials are served to ECS tasks and EKS Pod Identity pods by a local endpoint
+type containerCredentials struct {
+	url string
+	// token or the contents of tokenFile authorize the request, if set
+	token     string
+	tokenFile string
+	client    *http.Client
+}
+
+func (c *containerCredentials) retrieve(ctx context.Context) (credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return credentials{}, err
+	}
+	token := c.token
+	if c.tokenFile != "" {
+		data, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return credentials{}, fmt.Errorf("failed to read container authorization token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	creds, err := fetchJSONCredentials(c.client, req)
+	if err != nil {
+		return credentials{}, fmt.Errorf("failed to get container credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// instanceCredentials are the credentials of the EC2 instance profile, read from the
+// instance metadata service with an IMDSv2 session token
+type instanceCredentials struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (i *instanceCredentials) retrieve(ctx context.Context) (credentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, i.endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return credentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := fetch(i.client, req)
+	if err != nil {
+		return credentials{}, fmt.Errorf("failed to get instance metadata token: %w", err)
+	}
+
+	get := func(path string) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, i.endpoint+path, nil)
+		if err == nil {
+			req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		}
+		return req, err
+	}
+
+	req, err = get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return credentials{}, err
+	}
+	roles, err := fetch(i.client, req)
+	if err != nil {
+		return credentials{}, fmt.Errorf("failed to get instance profile role: %w", err)
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return credentials{}, errors.New("no instance profile attached to the instance")
+	}
+
+	if req, err = get("/latest/meta-data/iam/security-credentials/" + role); err != nil {
+		return credentials{}, err
+	}
+	creds, err := fetchJSONCredentials(i.client, req)
+	if err != nil {
+		return credentials{}, fmt.Errorf("failed to get instance profile credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// fetchJSONCredentials performs req and decodes the credentials in its JSON response, as
+// served by the container and instance metadata endpoints
+func fetchJSONCredentials(client *http.Client, req *http.Request) (credentials, error) {
+	data, err := fetch(client, req)
+	if err != nil {
+		return credentials{}, err
+	}
+
+	var c struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return credentials{}, fmt.Errorf("failed to decode credentials: %w", err)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return credentials{}, errors.New("no credentials returned")
+	}
+	return credentials{c.AccessKeyID, c.SecretAccessKey, c.Token, c.Expiration}, nil
+}
+
+// fetch performs req and returns its response body, failing on statuses other than 200
+func fetch(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return data, nil
+}
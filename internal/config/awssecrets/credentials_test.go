@@ -0,0 +1,219 @@
+package awssecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// clearCredentialEnv unsets the variables the credential chain reads
+func clearCredentialEnv(t *testing.T) {
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME", "AWS_ENDPOINT_URL_STS",
+		"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+		"AWS_EC2_METADATA_DISABLED", "AWS_EC2_METADATA_SERVICE_ENDPOINT",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// jsonCredentials answers with credentials in the format of the metadata endpoints
+func jsonCredentials(w http.ResponseWriter, keyID string) {
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"AccessKeyId":     keyID,
+		"SecretAccessKey": "secret",
+		"Token":           "session",
+		"Expiration":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})
+}
+
+func TestCredentialChain(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(t *testing.T, url string)
+		handler   http.HandlerFunc
+		wantKeyID string
+	}{
+		{
+			name: "environment",
+			setup: func(t *testing.T, _ string) {
+				t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+				t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+			},
+			wantKeyID: "env-key",
+		},
+		{
+			name: "web identity",
+			setup: func(t *testing.T, url string) {
+				t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", writeFile(t, "projected-token\n"))
+				t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/bridge")
+				t.Setenv("AWS_ENDPOINT_URL_STS", url)
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.FormValue("Action") != "AssumeRoleWithWebIdentity" || r.FormValue("WebIdentityToken") != "projected-token" ||
+					r.FormValue("RoleArn") != "arn:aws:iam::123456789012:role/bridge" {
+					http.Error(w, "bad request", http.StatusBadRequest)
+					return
+				}
+				fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>irsa-key</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>
+<Expiration>%s</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`,
+					time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+			},
+			wantKeyID: "irsa-key",
+		},
+		{
+			name: "container endpoint",
+			setup: func(t *testing.T, url string) {
+				t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", url+"/creds")
+				t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", writeFile(t, "pod-identity-token"))
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "pod-identity-token" {
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+					return
+				}
+				jsonCredentials(w, "container-key")
+			},
+			wantKeyID: "container-key",
+		},
+		{
+			name: "instance profile",
+			setup: func(t *testing.T, url string) {
+				t.Setenv("AWS_EC2_METADATA_SERVICE_ENDPOINT", url)
+			},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+					_, _ = w.Write([]byte("imds-token"))
+				case r.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+					http.Error(w, "unauthorized", http.StatusUnauthorized)
+				case r.URL.Path == "/latest/meta-data/iam/security-credentials/":
+					_, _ = w.Write([]byte("bridge-role"))
+				case r.URL.Path == "/latest/meta-data/iam/security-credentials/bridge-role":
+					jsonCredentials(w, "instance-key")
+				default:
+					http.NotFound(w, r)
+				}
+			},
+			wantKeyID: "instance-key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearCredentialEnv(t)
+			handler := tt.handler
+			if handler == nil {
+				handler = func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) }
+			}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+			tt.setup(t, server.URL)
+
+			source := newCredentialSource("eu-west-1", server.Client())
+			if source == nil {
+				t.Fatal("no credential source picked")
+			}
+			creds, err := source.retrieve(context.Background())
+			if err != nil {
+				t.Fatalf("retrieve() error = %v", err)
+			}
+			if creds.accessKeyID != tt.wantKeyID {
+				t.Errorf("access key ID = %q, want %q", creds.accessKeyID, tt.wantKeyID)
+			}
+		})
+	}
+}
+
+func TestCredentialChainMetadataDisabled(t *testing.T) {
+	clearCredentialEnv(t)
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	if source := newCredentialSource("eu-west-1", http.DefaultClient); source != nil {
+		t.Errorf("newCredentialSource() = %T, want none", source)
+	}
+}
+
+// countingSource returns credentials expiring after ttl, counting retrievals
+type countingSource struct {
+	ttl   time.Duration
+	calls atomic.Int64
+}
+
+func (s *countingSource) retrieve(context.Context) (credentials, error) {
+	n := s.calls.Add(1)
+	return credentials{accessKeyID: fmt.Sprintf("key-%d", n), secretAccessKey: "secret", expires: time.Now().Add(s.ttl)}, nil
+}
+
+func TestCachedCredentialsRenewal(t *testing.T) {
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		wantCalls int64
+	}{
+		{"valid credentials are reused", time.Hour, 1},
+		{"credentials about to expire are renewed", expiryWindow / 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := &countingSource{ttl: tt.ttl}
+			cached := &cachedCredentials{source: source}
+			for i := 0; i < 3; i++ {
+				if _, err := cached.retrieve(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if calls := source.calls.Load(); calls != tt.wantCalls {
+				t.Errorf("source called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestResolveSignsWithChainCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.Contains(auth, "Credential=container-key/") || r.Header.Get("X-Amz-Security-Token") != "session" {
+			http.Error(w, `{"__type":"UnrecognizedClientException","message":"bad credentials"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"SecretString":"client-secret"}`))
+	}))
+	defer server.Close()
+
+	r := &Resolver{
+		region:   "eu-west-1",
+		endpoint: server.URL,
+		credentials: &cachedCredentials{source: staticCredentials{
+			accessKeyID: "container-key", secretAccessKey: "secret", sessionToken: "session",
+		}},
+		client: server.Client(),
+	}
+	secret, err := r.Resolve(context.Background(), "prod/keycloak/client")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if secret != "client-secret" {
+		t.Errorf("Resolve() = %q, want client-secret", secret)
+	}
+}
@@ -0,0 +1,210 @@
+// Package awssecrets resolves secret:// config values from AWS Secrets Manager. Requests
+// are signed with Signature Version 4 using credentials from the environment, IAM roles for
+// service accounts, the ECS or EKS Pod Identity container endpoint or the EC2 instance
+// profile, looked up in that order like the AWS SDKs do.
+package awssecrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// service is the Secrets Manager signing name
+const service = "secretsmanager"
+
+// maxResponseSize bounds the GetSecretValue response read
+const maxResponseSize = 1 << 20
+
+// Resolver is a config.SecretResolver reading secrets from AWS Secrets Manager. The ref of a
+// secret:// value is the secret's name or ARN.
+type Resolver struct {
+	region      string
+	endpoint    string
+	credentials credentialSource
+	client      *http.Client
+}
+
+// New creates a new Resolver. The region falls back to AWS_REGION and AWS_DEFAULT_REGION.
+func New(cfg *config.SecretsConfig) (config.SecretResolver, error) {
+	region := firstNonEmpty(cfg.AWS.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+	if region == "" {
+		return nil, errors.New("no AWS region configured")
+	}
+
+	client := &http.Client{}
+	source := newCredentialSource(region, client)
+	if source == nil {
+		return nil, errors.New("no AWS credentials found and the instance metadata service is disabled")
+	}
+
+	endpoint := cfg.AWS.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	return &Resolver{
+		region:      region,
+		endpoint:    strings.TrimSuffix(endpoint, "/"),
+		credentials: &cachedCredentials{source: source},
+		client:      client,
+	}, nil
+}
+
+// Resolve returns the current value of the secret named by ref. Binary secrets are returned
+// as their raw bytes.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": ref})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	creds, err := r.credentials.retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	r.sign(req, body, creds, time.Now().UTC())
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call secrets manager: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secrets manager response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &apiErr)
+		if apiErr.Type == "" {
+			return "", fmt.Errorf("secrets manager returned status %d", resp.StatusCode)
+		}
+		// Error types may be namespaced, e.g. com.amazonaws...#ResourceNotFoundException
+		if i := strings.LastIndex(apiErr.Type, "#"); i >= 0 {
+			apiErr.Type = apiErr.Type[i+1:]
+		}
+		return "", fmt.Errorf("secrets manager returned %s: %s", apiErr.Type, apiErr.Message)
+	}
+
+	var secret struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary string  `json:"SecretBinary"`
+	}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return "", fmt.Errorf("failed to decode secrets manager response: %w", err)
+	}
+	if secret.SecretString != nil {
+		return *secret.SecretString, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(secret.SecretBinary)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode binary secret: %w", err)
+	}
+	return string(raw), nil
+}
+
+// sign adds the Signature Version 4 authorization headers to req
+func (r *Resolver) sign(req *http.Request, body []byte, creds credentials, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	// Signed headers must be sorted by lower-cased name
+	headers := []string{"content-type", "host", "x-amz-date"}
+	if creds.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	headers = append(headers, "x-amz-target")
+
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hashHex(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, r.region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretAccessKey), date)
+	key = hmacSHA256(key, r.region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKeyID, scope, signedHeaders, signature))
+}
+
+// hashHex returns the hex encoded SHA-256 hash of data
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
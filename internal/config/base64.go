@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/viper"
+)
+
+// LoadConfigFromBase64 reads the configuration from the base64-encoded YAML or JSON held
+// by the environment variable envVar, for platforms where mounting a config file is
+// awkward. Environment variables, defaults, secret references and validation apply as
+// with LoadConfig; !include directives are not resolved, as there is no file to resolve
+// them against.
+func LoadConfigFromBase64(envVar string, opts ...LoadOption) (*Config, error) {
+	options := &loadOptions{dotenv: true}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	value := strings.TrimSpace(os.Getenv(envVar))
+	if value == "" {
+		return nil, fmt.Errorf("config env var %s is not set", envVar)
+	}
+
+	data, err := decodeBase64(value)
+	if err != nil {
+		return nil, fmt.Errorf("config env var %s is not valid base64: %w", envVar, err)
+	}
+
+	setDefaults()
+	viper.AutomaticEnv()
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	bindEnvs(reflect.TypeOf(Config{}), "")
+
+	format := configFormat(data)
+	viper.SetConfigType(format)
+	if err := readMigrated(data, format); err != nil {
+		return nil, fmt.Errorf("error parsing %s config from env var %s: %w", format, envVar, err)
+	}
+
+	if options.dotenv && viper.GetBool("app.load_dotenv") {
+		if err := godotenv.Load(".env"); err != nil {
+			log.Printf("No .env file found or error reading .env file: %v", err)
+		}
+	}
+
+	return decodeConfig(options)
+}
+
+// decodeBase64 decodes standard or URL-safe base64, padded or not
+func decodeBase64(value string) ([]byte, error) {
+	var firstErr error
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		data, err := enc.DecodeString(value)
+		if err == nil {
+			return data, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// configFormat detects whether data is a JSON or a YAML document
+func configFormat(data []byte) string {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return "json"
+	}
+	return "yaml"
+}
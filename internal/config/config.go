@@ -1,12 +1,18 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"reflect"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/joho/godotenv"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
@@ -16,22 +22,115 @@ type Config struct {
 	IAM      IAMConfig      `mapstructure:"iam"`
 	Security SecurityConfig `mapstructure:"security"`
 	Logging  LogConfig      `mapstructure:"logging"`
+	Secrets  SecretsConfig  `mapstructure:"secrets"`
 }
 
 // AppConfig holds all application configuration
 type AppConfig struct {
-	Name        string `mapstructure:"name"`
-	Environment string `mapstructure:"environment"`
-	Port        int    `mapstructure:"port"`
-	Debug       bool   `mapstructure:"debug"`
+	Name            string        `mapstructure:"name"`
+	Environment     string        `mapstructure:"environment"`
+	Port            int           `mapstructure:"port"`
+	Debug           bool          `mapstructure:"debug"`
+	HealthChecks    []string      `mapstructure:"health_checks"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	LoadDotenv      bool          `mapstructure:"load_dotenv"`
+	RecoverPanics   bool          `mapstructure:"recover_panics"`
+
+	// TrustedProxies are the CIDRs of proxies whose X-Forwarded-For entries are believed
+	// when determining client IPs
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+
+	// MaxBodyBytes caps request bodies; 0 disables the limit
+	MaxBodyBytes int64 `mapstructure:"max_body_bytes"`
+
+	// StrictEnvironment rejects unknown environment names instead of warning about them
+	StrictEnvironment bool `mapstructure:"strict_environment"`
+
+	// StrictConfig rejects configs setting unknown keys instead of warning about them
+	StrictConfig bool `mapstructure:"strict_config"`
+
+	// HealthCacheControl is the Cache-Control header of the health and metrics endpoints,
+	// no-store by default so intermediaries never serve probes a stale result; empty omits it
+	HealthCacheControl string `mapstructure:"health_cache_control"`
 }
 
+// HealthCheckIDP is the health check that probes the IAM provider
+const HealthCheckIDP = "idp"
+
+// knownHealthChecks lists the health check names accepted in app.health_checks
+var knownHealthChecks = []string{HealthCheckIDP}
+
 // KeycloakConfig holds Keycloak-specific configuration
 type KeycloakConfig struct {
-	BaseURL      string `mapstructure:"base_url"`
-	Realm        string `mapstructure:"realm"`
-	ClientID     string `mapstructure:"client_id"`
-	ClientSecret string `mapstructure:"client_secret"`
+	BaseURL         string        `mapstructure:"base_url"`
+	Realm           string        `mapstructure:"realm"`
+	ClientID        string        `mapstructure:"client_id"`
+	ClientSecret    string        `mapstructure:"client_secret"`
+	RedirectURL     string        `mapstructure:"redirect_url"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	TLS             TLSConfig     `mapstructure:"tls"`
+	Audiences       []string      `mapstructure:"audiences"`
+	TokenValidation string        `mapstructure:"token_validation"`
+	JWKSFile        string        `mapstructure:"jwks_file"`
+
+	// JWKSInline is a JWKS document given in the config itself, an alternative to
+	// JWKSFile for tests and ephemeral environments
+	JWKSInline string `mapstructure:"jwks_inline"`
+
+	// IssuerOverride is the issuer tokens are expected to carry when it differs from the
+	// realm's URL under BaseURL, e.g. when Keycloak is reached internally but mints tokens
+	// with its public URL behind a reverse proxy. Tokens of this issuer are verified with the
+	// realm's keys, so it must name the same realm. It must be https unless
+	// AllowInsecureIssuer is set. Realms don't inherit it.
+	IssuerOverride      string `mapstructure:"issuer_override"`
+	AllowInsecureIssuer bool   `mapstructure:"allow_insecure_issuer"`
+
+	// Realms configures one provider per tenant realm for multi-tenant setups
+	Realms []KeycloakConfig `mapstructure:"realms"`
+}
+
+// Issuer returns the issuer tokens of the realm carry: IssuerOverride when set, the realm's
+// URL under BaseURL otherwise, without trailing slashes
+func (c *KeycloakConfig) Issuer() string {
+	if c.IssuerOverride != "" {
+		return strings.TrimRight(c.IssuerOverride, "/")
+	}
+	return fmt.Sprintf("%s/realms/%s", strings.TrimRight(c.BaseURL, "/"), c.Realm)
+}
+
+// LocalKeys reports whether the signing keys are configured locally, by JWKSFile or
+// JWKSInline, so tokens are validated offline without fetching keys over the network
+func (c *KeycloakConfig) LocalKeys() bool {
+	return c.JWKSFile != "" || c.JWKSInline != ""
+}
+
+// RealmConfigs returns the per-realm configurations. Settings left empty in an entry
+// are inherited from the top-level Keycloak config, so realms on the same server only
+// need their realm name and client credentials.
+func (c *KeycloakConfig) RealmConfigs() []KeycloakConfig {
+	realms := make([]KeycloakConfig, 0, len(c.Realms))
+	for _, r := range c.Realms {
+		if r.BaseURL == "" {
+			r.BaseURL = c.BaseURL
+		}
+		if r.Timeout == 0 {
+			r.Timeout = c.Timeout
+		}
+		if r.TLS.MinVersion == "" {
+			r.TLS = c.TLS
+		}
+		if r.TokenValidation == "" {
+			r.TokenValidation = c.TokenValidation
+		}
+		r.Realms = nil
+		realms = append(realms, r)
+	}
+	return realms
+}
+
+// TLSConfig holds TLS settings for outbound connections
+type TLSConfig struct {
+	MinVersion string `mapstructure:"min_version"`
 }
 
 // CORSConfig holds CORS-related configuration
@@ -45,6 +144,29 @@ type CORSConfig struct {
 type RateLimitConfig struct {
 	Enabled           bool `mapstructure:"enabled"`
 	RequestsPerSecond int  `mapstructure:"requests_per_second"`
+	Headers           bool `mapstructure:"headers"`
+
+	// IPv4Prefix and IPv6Prefix are the prefix lengths clients are counted by, so clients
+	// rotating through their IPv6 allocation share one count
+	IPv4Prefix int `mapstructure:"ipv4_prefix"`
+	IPv6Prefix int `mapstructure:"ipv6_prefix"`
+
+	// Tiers limit authenticated clients per client ID, on top of the per-IP limit
+	Tiers []RateLimitTier `mapstructure:"tiers"`
+
+	// ExemptIPs are the CIDRs or IP addresses of clients that are never rate limited, such
+	// as internal monitoring, checked before authentication. ExemptClients are client IDs
+	// exempt from their tier's limit, checked once their token is validated; the per-IP limit
+	// runs before tokens are validated and still applies to them
+	ExemptIPs     []string `mapstructure:"exempt_ips"`
+	ExemptClients []string `mapstructure:"exempt_clients"`
+}
+
+// RateLimitTier is a request rate applied to each of its clients separately
+type RateLimitTier struct {
+	Name              string   `mapstructure:"name"`
+	RequestsPerSecond int      `mapstructure:"requests_per_second"`
+	Clients           []string `mapstructure:"clients"`
 }
 
 // LogConfig holds logging-related configuration
@@ -53,64 +175,627 @@ type LogConfig struct {
 	Format string `mapstructure:"format"`
 }
 
+// SessionConfig holds server-side session configuration
+type SessionConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	Store        string        `mapstructure:"store"`
+	CookieName   string        `mapstructure:"cookie_name"`
+	CookieSecret string        `mapstructure:"cookie_secret"`
+	Secure       bool          `mapstructure:"secure"`
+	TTL          time.Duration `mapstructure:"ttl"`
+}
+
 // SecurityConfig holds security-related configuration
 type SecurityConfig struct {
 	CORS      CORSConfig      `mapstructure:"cors"`
 	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Session   SessionConfig   `mapstructure:"session"`
+
+	LoginRedirect  LoginRedirectConfig  `mapstructure:"login_redirect"`
+	LoginState     LoginStateConfig     `mapstructure:"login_state"`
+	UserManagement UserManagementConfig `mapstructure:"user_management"`
+	Audit          AuditConfig          `mapstructure:"audit"`
+}
+
+// AuditConfig holds the settings for shipping audit events, such as authentication
+// outcomes, to a webhook. Events are batched in the background; when the webhook can't keep
+// up and the queue is full, events are dropped rather than holding up requests.
+type AuditConfig struct {
+	Enabled       bool               `mapstructure:"enabled"`
+	BatchSize     int                `mapstructure:"batch_size"`
+	QueueSize     int                `mapstructure:"queue_size"`
+	FlushInterval time.Duration      `mapstructure:"flush_interval"`
+	Webhook       AuditWebhookConfig `mapstructure:"webhook"`
+}
+
+// AuditWebhookConfig holds the endpoint audit events are posted to
+type AuditWebhookConfig struct {
+	URL string `mapstructure:"url"`
+	// Headers are sent with each call, e.g. Authorization for the receiving end
+	Headers map[string]string `mapstructure:"headers"`
+	Timeout time.Duration     `mapstructure:"timeout"`
+}
+
+// UserManagementConfig holds the authentication strength required on the user management
+// routes, e.g. an MFA login before roles can be assigned. Empty lists require nothing.
+type UserManagementConfig struct {
+	// RequiredACR lists the accepted authentication context classes (acr), any of which will do
+	RequiredACR []string `mapstructure:"required_acr"`
+	// RequiredAMR lists the authentication methods (amr) that must all have been used
+	RequiredAMR []string `mapstructure:"required_amr"`
+	// AdminRole is the role required to list the realm roles
+	AdminRole string `mapstructure:"admin_role"`
+}
+
+// LoginRedirectConfig holds the settings for redirecting unauthenticated browser requests
+// to a login page instead of responding 401. Without a URL, browsers are sent to the IAM
+// provider's authorization endpoint.
+type LoginRedirectConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	URL     string `mapstructure:"url"`
+}
+
+// LoginStateConfig holds the store of the state, PKCE verifier and nonce of logins started
+// by the server, kept until the callback exchanges the code. The cookie store needs no
+// shared storage between instances; the memory store keeps only a reference in the cookie.
+type LoginStateConfig struct {
+	Store        string        `mapstructure:"store"`
+	CookieName   string        `mapstructure:"cookie_name"`
+	CookieSecret string        `mapstructure:"cookie_secret"`
+	Secure       bool          `mapstructure:"secure"`
+	TTL          time.Duration `mapstructure:"ttl"`
+	// MaxEntries caps the started logins the memory store holds, the oldest being dropped
+	// when full
+	MaxEntries int `mapstructure:"max_entries"`
+}
+
+// CacheConfig holds token validation cache configuration
+type CacheConfig struct {
+	Enabled    bool          `mapstructure:"enabled"`
+	TTL        time.Duration `mapstructure:"ttl"`
+	MaxEntries int           `mapstructure:"max_entries"`
+	Backend    string        `mapstructure:"backend"`
+	KeySalt    string        `mapstructure:"key_salt"`
+	Redis      RedisConfig   `mapstructure:"redis"`
+}
+
+// RedisConfig holds the connection settings of the Redis token cache backend
+type RedisConfig struct {
+	Address string `mapstructure:"address"`
+	// Username authenticates as a Redis ACL user along with Password; empty authenticates
+	// with Password alone, as the default user
+	Username  string         `mapstructure:"username"`
+	Password  string         `mapstructure:"password"`
+	DB        int            `mapstructure:"db"`
+	KeyPrefix string         `mapstructure:"key_prefix"`
+	Timeout   time.Duration  `mapstructure:"timeout"`
+	TLS       RedisTLSConfig `mapstructure:"tls"`
+}
+
+// RedisTLSConfig holds the TLS settings of connections to Redis
+type RedisTLSConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// CAFile is a PEM bundle of the CAs the server certificate is verified against; empty
+	// uses the system roots
+	CAFile string `mapstructure:"ca_file"`
+	// ServerName is the name the server certificate must carry; empty uses the host of Address
+	ServerName string `mapstructure:"server_name"`
+	MinVersion string `mapstructure:"min_version"`
+}
+
+// MinTLSVersion returns the configured minimum TLS version, defaulting to TLS 1.2
+func (c *RedisTLSConfig) MinTLSVersion() (uint16, error) {
+	return (&TLSConfig{MinVersion: c.MinVersion}).MinTLSVersion()
+}
+
+// JTIDenylistConfig holds the settings of the store of revoked token IDs (jti)
+type JTIDenylistConfig struct {
+	Backend string      `mapstructure:"backend"`
+	Redis   RedisConfig `mapstructure:"redis"`
+}
+
+// DegradedModeConfig holds settings for riding out IAM provider outages
+type DegradedModeConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	GracePeriod time.Duration `mapstructure:"grace_period"`
+}
+
+// HTTPConfig tunes the connection pool of outbound calls to the IAM provider
+type HTTPConfig struct {
+	MaxIdleConns    int           `mapstructure:"max_idle_conns"`
+	MaxConnsPerHost int           `mapstructure:"max_conns_per_host"`
+	IdleConnTimeout time.Duration `mapstructure:"idle_conn_timeout"`
+}
+
+// JWKSRefreshRateConfig bounds how often signing keys are refetched for unknown kids
+type JWKSRefreshRateConfig struct {
+	// MaxRefreshes is the number of JWKS fetches allowed per window and key set; 0 allows
+	// any number
+	MaxRefreshes int           `mapstructure:"max_refreshes"`
+	Window       time.Duration `mapstructure:"window"`
+}
+
+// GatewayConfig holds the settings for trusting JWTs minted by an API gateway in front of
+// the bridge. They are verified with the gateway's own key, issuer and audiences, separately
+// from the IAM provider.
+type GatewayConfig struct {
+	Enabled       bool     `mapstructure:"enabled"`
+	Header        string   `mapstructure:"header"`
+	PublicKeyFile string   `mapstructure:"public_key_file"`
+	Issuer        string   `mapstructure:"issuer"`
+	Audiences     []string `mapstructure:"audiences"`
+}
+
+// InternalTokenConfig holds the settings for minting short-lived internal JWTs from validated
+// tokens, so upstream services verify the bridge's key instead of depending on the IdP.
+// They verify them against the JWKS served at /.well-known/jwks.json, which they may cache
+// for JWKSMaxAge. Signing keys come from a PEM file, picked up again when it is replaced, or
+// are generated in memory and rotated every rotation interval. Either way, a new key is
+// published for JWKSMaxAge before it signs, and replaced keys stay published for the key
+// overlap.
+type InternalTokenConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	Header           string        `mapstructure:"header"`
+	KeySource        string        `mapstructure:"key_source"`
+	PrivateKeyFile   string        `mapstructure:"private_key_file"`
+	KeyID            string        `mapstructure:"key_id"`
+	RotationInterval time.Duration `mapstructure:"rotation_interval"`
+	KeyOverlap       time.Duration `mapstructure:"key_overlap"`
+	JWKSMaxAge       time.Duration `mapstructure:"jwks_max_age"`
+	Issuer           string        `mapstructure:"issuer"`
+	Audiences        []string      `mapstructure:"audiences"`
+	TTL              time.Duration `mapstructure:"ttl"`
+}
+
+// SecretsConfig holds the settings of the secrets manager secret:// config values are
+// fetched from
+type SecretsConfig struct {
+	Provider string           `mapstructure:"provider"`
+	Timeout  time.Duration    `mapstructure:"timeout"`
+	AWS      AWSSecretsConfig `mapstructure:"aws"`
+	GCP      GCPSecretsConfig `mapstructure:"gcp"`
+}
+
+// AWSSecretsConfig holds the AWS Secrets Manager settings. Credentials are read from the
+// standard AWS environment variables.
+type AWSSecretsConfig struct {
+	Region   string `mapstructure:"region"`
+	Endpoint string `mapstructure:"endpoint"`
+}
+
+// GCPSecretsConfig holds the GCP Secret Manager settings. Access tokens are fetched from
+// the metadata server.
+type GCPSecretsConfig struct {
+	Project string `mapstructure:"project"`
 }
 
 // IAMConfig holds the configuration for IAM providers
 type IAMConfig struct {
-	Provider string         `mapstructure:"provider"`
-	Keycloak KeycloakConfig `mapstructure:"keycloak"`
+	Provider     string             `mapstructure:"provider"`
+	Keycloak     KeycloakConfig     `mapstructure:"keycloak"`
+	Cache        CacheConfig        `mapstructure:"cache"`
+	DegradedMode DegradedModeConfig `mapstructure:"degraded_mode"`
+	HTTP         HTTPConfig         `mapstructure:"http"`
+	Gateway      GatewayConfig      `mapstructure:"gateway"`
+	JTIDenylist  JTIDenylistConfig  `mapstructure:"jti_denylist"`
+
+	InternalToken InternalTokenConfig `mapstructure:"internal_token"`
+
+	// CheckJTIDenylist rejects tokens whose jti was revoked, e.g. on logout or after one use
+	CheckJTIDenylist bool `mapstructure:"check_jti_denylist"`
+
+	// AllowedClients restricts the clients (azp or client_id) whose tokens are accepted
+	AllowedClients []string `mapstructure:"allowed_clients"`
+
+	// VerboseErrors reports why a token was rejected in 401 responses. Unset, it is on in
+	// development only; see Config.VerboseAuthErrors.
+	VerboseErrors *bool `mapstructure:"verbose_errors"`
+
+	DPoPEnabled     bool `mapstructure:"dpop_enabled"`
+	MTLSBoundTokens bool `mapstructure:"mtls_bound_tokens"`
+
+	// TokenSources are where tokens are looked for, in order: header (Authorization),
+	// cookie (the TokenCookie cookie) and query (the TokenQueryParam parameter, debug only)
+	TokenSources []string `mapstructure:"token_sources"`
+	TokenCookie  string   `mapstructure:"token_cookie"`
+	// TokenCookieCSRFHeader must be sent along cookie tokens on unsafe methods. Browsers
+	// only send custom headers cross-origin when CORS allows it, which defeats CSRF.
+	TokenCookieCSRFHeader string `mapstructure:"token_cookie_csrf_header"`
+	TokenQueryParam       string `mapstructure:"token_query_param"`
+
+	// RejectMultipleAuthHeaders rejects requests carrying more than one Authorization header
+	// with a 400, instead of authenticating with the first
+	RejectMultipleAuthHeaders bool `mapstructure:"reject_multiple_auth_headers"`
+
+	AllowedIssuerPatterns []string `mapstructure:"allowed_issuer_patterns"`
+	GroupsClaim           string   `mapstructure:"groups_claim"`
+	PrincipalClaim        string   `mapstructure:"principal_claim"`
+
+	EnforceTokenType bool     `mapstructure:"enforce_token_type"`
+	AccessTokenTypes []string `mapstructure:"access_token_types"`
+
+	// SlowCallThreshold is the duration above which calls to the IdP are logged; 0 disables it
+	SlowCallThreshold time.Duration `mapstructure:"slow_call_threshold"`
+
+	// EagerInit fetches discovery metadata and signing keys before serving traffic
+	EagerInit bool `mapstructure:"eager_init"`
+	// WarmupConcurrency bounds how many realms are warmed up at once
+	WarmupConcurrency int `mapstructure:"warmup_concurrency"`
+
+	// MaxConcurrentValidations bounds how many calls to the IdP that validations need, such
+	// as introspection, discovery and JWKS fetches, run at once, so a spike on a cold cache
+	// doesn't overwhelm the IdP; 0 disables the limit. Tokens verified against cached keys
+	// don't count. Calls over it wait up to ValidationWaitTimeout, then fail with 503.
+	MaxConcurrentValidations int           `mapstructure:"max_concurrent_validations"`
+	ValidationWaitTimeout    time.Duration `mapstructure:"validation_wait_timeout"`
+
+	// SelfTestOnStart checks the provider's configuration against the live IdP before
+	// serving traffic, failing startup when e.g. the client credentials are rejected
+	SelfTestOnStart bool `mapstructure:"self_test_on_start"`
+
+	// JWKSMaxBytes caps the size of JWKS and discovery documents read from the IdP
+	JWKSMaxBytes int64 `mapstructure:"jwks_max_bytes"`
+
+	// JWKSRefreshRate limits JWKS fetches, so tokens with made-up kids can't flood the IdP
+	JWKSRefreshRate JWKSRefreshRateConfig `mapstructure:"jwks_refresh_rate"`
+
+	// KeyRetirementGrace is how long signing keys still verify tokens after they disappear
+	// from the JWKS; 0, the default, drops them at once. A key may be removed because it
+	// leaked, and retaining it keeps tokens forged with it valid for the grace period, so
+	// only set it for IdPs that remove keys on rotation while tokens they signed are live.
+	KeyRetirementGrace time.Duration `mapstructure:"key_retirement_grace"`
+
+	// ForwardedClaims are the claims passed on to backends as trusted headers, by the
+	// /api/v1/auth/validate forward-auth endpoint and middleware.TrustedHeaders; all others
+	// are dropped
+	ForwardedClaims []string `mapstructure:"forwarded_claims"`
+
+	// AllowTokensWithoutExpiry accepts tokens lacking an exp claim, which never expire
+	AllowTokensWithoutExpiry bool `mapstructure:"allow_tokens_without_expiry"`
+
+	// MaxTokenLifetime rejects tokens whose exp - iat exceeds it, whatever their expiry; 0
+	// disables the check
+	MaxTokenLifetime time.Duration `mapstructure:"max_token_lifetime"`
+
+	DiscoveryCacheTTL   time.Duration `mapstructure:"discovery_cache_ttl"`
+	ClockSkewSeconds    int           `mapstructure:"clock_skew_seconds"`
+	MaxClockSkewSeconds int           `mapstructure:"max_clock_skew_seconds"`
+}
+
+// LoadOption customizes LoadConfig
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	dotenv    bool
+	strict    bool
+	resolvers map[string]SecretResolverFactory
+}
+
+// WithoutDotenv skips loading the .env file, for environments where a stray .env
+// must not be read. Setting app.load_dotenv to false has the same effect.
+func WithoutDotenv() LoadOption {
+	return func(o *loadOptions) {
+		o.dotenv = false
+	}
+}
+
+// WithStrictConfig rejects configs setting keys Config doesn't declare, such as misspelt
+// ones, instead of logging a warning and ignoring them. Setting app.strict_config has the
+// same effect.
+func WithStrictConfig() LoadOption {
+	return func(o *loadOptions) {
+		o.strict = true
+	}
 }
 
 // LoadConfig reads configuration from file or environment variables
-func LoadConfig(path string) (*Config, error) {
-	// Load the .env file
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Printf("No .env file found or error reading .env file: %v", err)
+func LoadConfig(path string, opts ...LoadOption) (*Config, error) {
+	options := &loadOptions{dotenv: true}
+	for _, opt := range opts {
+		opt(options)
 	}
 
 	// Set up Viper
 	viper.AddConfigPath(path)
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
+	setDefaults()
+
+	// Enable Viper to read Environment Variables. Every key is bound explicitly so
+	// env-only setups work even when there is no config file declaring the keys.
+	viper.AutomaticEnv()
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	bindEnvs(reflect.TypeOf(Config{}), "")
 
-	// Read the config file
-	if err := viper.ReadInConfig(); err != nil {
-		var configFileNotFoundError viper.ConfigFileNotFoundError
-		if errors.As(err, &configFileNotFoundError) {
-			return nil, fmt.Errorf("config file not found: %w", err)
+	// Read the config file; a missing file is fine as long as env and defaults are complete
+	readErr := viper.ReadInConfig()
+	var configFileNotFoundError viper.ConfigFileNotFoundError
+	if readErr != nil && !errors.As(readErr, &configFileNotFoundError) {
+		return nil, fmt.Errorf("error reading config file: %w", readErr)
+	}
+	if readErr == nil {
+		if err := readIncludes(); err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
-		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
 
-	// Enable Viper to read Environment Variables
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	// Load the .env file. Env lookups are lazy, so its values still apply even though
+	// the config file was read first to honour app.load_dotenv.
+	if options.dotenv && viper.GetBool("app.load_dotenv") {
+		if err := godotenv.Load(".env"); err != nil {
+			log.Printf("No .env file found or error reading .env file: %v", err)
+		}
+	}
+
+	if readErr != nil {
+		if missing := missingRequiredKeys(); len(missing) > 0 {
+			return nil, fmt.Errorf("config file not found and environment is incomplete, missing: %s: %w",
+				strings.Join(missing, ", "), readErr)
+		}
+		log.Printf("No config file found in %s, using environment variables and defaults", path)
+	}
+
+	return decodeConfig(options)
+}
+
+// decodeConfig unmarshals the config viper holds, resolves its secrets and validates it
+func decodeConfig(options *loadOptions) (*Config, error) {
+	config, err := unmarshalConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolveSecrets(config, options.resolvers); err != nil {
+		return nil, err
+	}
 
-	// Unmarshal the config into the Config struct
+	// Validate the config before handing it out
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	for _, warning := range config.Warnings() {
+		log.Printf("Config warning: %s", warning)
+	}
+
+	return config, nil
+}
+
+// unmarshalConfig unmarshals the config viper holds into a Config. Keys that Config doesn't
+// declare are rejected in strict mode and logged otherwise, each with its full path.
+func unmarshalConfig(options *loadOptions) (*Config, error) {
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	var metadata mapstructure.Metadata
+	if err := viper.Unmarshal(&config, func(dc *mapstructure.DecoderConfig) {
+		dc.Metadata = &metadata
+	}); err != nil {
 		return nil, fmt.Errorf("error unmarshalling config: %w", err)
 	}
 
+	unknown := unknownKeys(metadata.Unused)
+	if len(unknown) == 0 {
+		return &config, nil
+	}
+	if options.strict || config.App.StrictConfig {
+		errs := &ConfigValidationError{}
+		for _, key := range unknown {
+			errs.add(key, "unknown key")
+		}
+		return nil, errs
+	}
+	for _, key := range unknown {
+		log.Printf("Config warning: %s: unknown key, ignored", key)
+	}
 	return &config, nil
 }
 
+// unknownKeys returns the sorted unused keys reported by the decoder, leaving out the
+// version field, which is read by the migration rather than decoded
+func unknownKeys(unused []string) []string {
+	keys := make([]string, 0, len(unused))
+	for _, key := range unused {
+		if key != "version" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// WatchConfig reloads the config file whenever it changes and passes the new config to
+// onChange, along with how it differs from the previous one, starting from current.
+// Changes that leave the config as it was are skipped. Configs that fail to load or
+// validate are logged and ignored, so the running config stays in effect. Only the main
+// file is watched, not the files it includes. Secret references are resolved again with
+// the resolvers given in opts.
+func WatchConfig(current *Config, onChange func(*Config, ConfigDiff), opts ...LoadOption) {
+	options := &loadOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	// Change events arrive one at a time, so previous needs no locking
+	previous := current
+
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		if err := readIncludes(); err != nil {
+			log.Printf("Ignoring config change in %s: %v", e.Name, err)
+			return
+		}
+
+		config, err := unmarshalConfig(options)
+		if err != nil {
+			log.Printf("Ignoring config change in %s: %v", e.Name, err)
+			return
+		}
+		if err := resolveSecrets(config, options.resolvers); err != nil {
+			log.Printf("Ignoring config change in %s: %v", e.Name, err)
+			return
+		}
+		if err := config.Validate(); err != nil {
+			log.Printf("Ignoring config change in %s: %v", e.Name, err)
+			return
+		}
+
+		diff := Diff(previous, config)
+		if diff.Empty() {
+			return
+		}
+		previous = config
+		onChange(config, diff)
+	})
+	viper.WatchConfig()
+}
+
+// MinTLSVersion returns the configured minimum TLS version, defaulting to TLS 1.2
+func (c *TLSConfig) MinTLSVersion() (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(c.MinVersion), "tls") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q, must be one of 1.2, 1.3", c.MinVersion)
+	}
+}
+
+// requiredKeys must be provided by the config file, the environment or a default
+var requiredKeys = []string{
+	"app.port",
+	"iam.provider",
+	"iam.keycloak.base_url",
+	"iam.keycloak.realm",
+	"iam.keycloak.client_id",
+	"iam.keycloak.client_secret",
+}
+
+// setDefaults registers the default values used when neither file nor env sets a key
+func setDefaults() {
+	viper.SetDefault("app.name", "iam-bridge")
+	viper.SetDefault("app.environment", "development")
+	viper.SetDefault("app.port", 8080)
+	viper.SetDefault("app.health_checks", []string{HealthCheckIDP})
+	viper.SetDefault("app.health_cache_control", "no-store")
+	viper.SetDefault("app.shutdown_timeout", "15s")
+	viper.SetDefault("app.load_dotenv", true)
+	viper.SetDefault("app.recover_panics", true)
+	viper.SetDefault("app.max_body_bytes", 1<<20)
+	viper.SetDefault("security.rate_limit.ipv4_prefix", 32)
+	viper.SetDefault("security.rate_limit.ipv6_prefix", 64)
+	viper.SetDefault("security.login_state.store", "memory")
+	viper.SetDefault("security.login_state.cookie_name", "iam_bridge_login")
+	viper.SetDefault("security.login_state.secure", true)
+	viper.SetDefault("security.login_state.ttl", "10m")
+	viper.SetDefault("security.login_state.max_entries", 10000)
+	viper.SetDefault("security.user_management.admin_role", "admin")
+	viper.SetDefault("security.audit.batch_size", 100)
+	viper.SetDefault("security.audit.queue_size", 10000)
+	viper.SetDefault("security.audit.flush_interval", "5s")
+	viper.SetDefault("security.audit.webhook.timeout", "5s")
+	viper.SetDefault("iam.provider", "keycloak")
+	viper.SetDefault("iam.cache.backend", "memory")
+	viper.SetDefault("iam.gateway.header", "X-Gateway-Token")
+	viper.SetDefault("iam.internal_token.header", "X-Internal-Token")
+	viper.SetDefault("iam.internal_token.ttl", "1m")
+	viper.SetDefault("iam.internal_token.key_source", "file")
+	viper.SetDefault("iam.internal_token.rotation_interval", "24h")
+	viper.SetDefault("iam.internal_token.key_overlap", "10m")
+	viper.SetDefault("iam.internal_token.jwks_max_age", "5m")
+	viper.SetDefault("iam.http.max_idle_conns", 100)
+	viper.SetDefault("iam.http.max_conns_per_host", 100)
+	viper.SetDefault("iam.http.idle_conn_timeout", "90s")
+	viper.SetDefault("iam.slow_call_threshold", "1s")
+	viper.SetDefault("iam.warmup_concurrency", 8)
+	viper.SetDefault("iam.validation_wait_timeout", "500ms")
+	viper.SetDefault("iam.forwarded_claims", []string{"sub", "roles"})
+	viper.SetDefault("iam.jwks_max_bytes", 1<<20)
+	viper.SetDefault("iam.jwks_refresh_rate.max_refreshes", 10)
+	viper.SetDefault("iam.jwks_refresh_rate.window", "1m")
+	viper.SetDefault("iam.cache.redis.key_prefix", "iam-bridge:token:")
+	viper.SetDefault("iam.cache.redis.timeout", "1s")
+	viper.SetDefault("iam.jti_denylist.backend", "memory")
+	viper.SetDefault("iam.jti_denylist.redis.key_prefix", "iam-bridge:jti:")
+	viper.SetDefault("iam.jti_denylist.redis.timeout", "1s")
+	viper.SetDefault("iam.groups_claim", "groups")
+	viper.SetDefault("iam.principal_claim", "sub")
+	viper.SetDefault("iam.access_token_types", []string{"at+jwt", "Bearer"})
+	viper.SetDefault("iam.max_clock_skew_seconds", defaultMaxClockSkewSeconds)
+	viper.SetDefault("iam.reject_multiple_auth_headers", true)
+	viper.SetDefault("iam.token_sources", []string{"header"})
+	viper.SetDefault("iam.token_cookie", "access_token")
+	viper.SetDefault("iam.token_cookie_csrf_header", "X-Requested-With")
+	viper.SetDefault("iam.token_query_param", "access_token")
+	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.format", "json")
+	viper.SetDefault("secrets.timeout", "10s")
+}
+
+// bindEnvs binds an environment variable for every key of the config struct t,
+// e.g. IAM_KEYCLOAK_BASE_URL for iam.keycloak.base_url
+func bindEnvs(t reflect.Type, prefix string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			bindEnvs(field.Type, key)
+			continue
+		}
+		// Lists of structs, such as iam.keycloak.realms, can only come from the config file
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			continue
+		}
+		_ = viper.BindEnv(key)
+	}
+}
+
+// missingRequiredKeys lists the required keys with no value from any source
+func missingRequiredKeys() []string {
+	var missing []string
+	for _, key := range requiredKeys {
+		if viper.GetString(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
 // CurrentProvider returns the configured IAM provider name
 func (c *IAMConfig) CurrentProvider() string {
 	return strings.ToLower(c.Provider)
 }
 
+// VerboseAuthErrors reports whether 401 responses say why the token was rejected, as set
+// by iam.verbose_errors, defaulting to whether the application is in development
+func (c *Config) VerboseAuthErrors() bool {
+	if c.IAM.VerboseErrors != nil {
+		return *c.IAM.VerboseErrors
+	}
+	return c.App.IsDevelopment()
+}
+
 // IsDebug returns true if the application is in debug mode
 func (c *Config) IsDebug() bool {
 	return c.App.Debug
 }
 
+// Env returns the normalized environment. Unknown names are returned as is and match
+// none of the known environments.
+func (c *AppConfig) Env() Environment {
+	env, _ := ParseEnvironment(c.Environment)
+	return env
+}
+
 // IsDevelopment returns true if the application is in development mode
 func (c *AppConfig) IsDevelopment() bool {
-	return strings.ToLower(c.Environment) == "development"
+	return c.Env().IsDevelopment()
+}
+
+// IsProduction returns true if the application is in production mode
+func (c *AppConfig) IsProduction() bool {
+	return c.Env().IsProduction()
 }
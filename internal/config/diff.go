@@ -0,0 +1,68 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ConfigDiff describes which subsystems a reloaded config changes, so a reload only
+// reconfigures what actually changed
+type ConfigDiff struct {
+	// Logging is set when the logging settings changed
+	Logging bool
+	// CORS is set when the allowed origins, methods or headers changed
+	CORS bool
+	// RateLimit is set when the rate limiting settings changed
+	RateLimit bool
+	// Provider is set when the IAM settings changed, which requires rebuilding the provider
+	Provider bool
+	// Other is set when settings outside the subsystems above changed. They only take
+	// effect on restart.
+	Other bool
+}
+
+// Diff compares two configs and reports the subsystems whose settings differ
+func Diff(old, new *Config) ConfigDiff {
+	// Compare everything else with the subsystems blanked out
+	oldRest, newRest := *old, *new
+	oldRest.Logging, newRest.Logging = LogConfig{}, LogConfig{}
+	oldRest.Security.CORS, newRest.Security.CORS = CORSConfig{}, CORSConfig{}
+	oldRest.Security.RateLimit, newRest.Security.RateLimit = RateLimitConfig{}, RateLimitConfig{}
+	oldRest.IAM, newRest.IAM = IAMConfig{}, IAMConfig{}
+
+	return ConfigDiff{
+		Logging:   !reflect.DeepEqual(old.Logging, new.Logging),
+		CORS:      !reflect.DeepEqual(old.Security.CORS, new.Security.CORS),
+		RateLimit: !reflect.DeepEqual(old.Security.RateLimit, new.Security.RateLimit),
+		Provider:  !reflect.DeepEqual(old.IAM, new.IAM),
+		Other:     !reflect.DeepEqual(oldRest, newRest),
+	}
+}
+
+// Empty reports whether nothing changed
+func (d ConfigDiff) Empty() bool {
+	return d == ConfigDiff{}
+}
+
+// String lists the changed subsystems, e.g. "logging, provider"
+func (d ConfigDiff) String() string {
+	var changed []string
+	for _, s := range []struct {
+		name    string
+		changed bool
+	}{
+		{"logging", d.Logging},
+		{"cors", d.CORS},
+		{"rate_limit", d.RateLimit},
+		{"provider", d.Provider},
+		{"other", d.Other},
+	} {
+		if s.changed {
+			changed = append(changed, s.name)
+		}
+	}
+	if len(changed) == 0 {
+		return "none"
+	}
+	return strings.Join(changed, ", ")
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Environment is the deployment environment the application runs in
+type Environment string
+
+const (
+	Development Environment = "development"
+	Staging     Environment = "staging"
+	Production  Environment = "production"
+	Test        Environment = "test"
+)
+
+// environments lists the known environments
+var environments = []Environment{Development, Staging, Production, Test}
+
+// ParseEnvironment normalizes s and returns the matching environment. Unknown values are
+// returned as is along with an error, so callers can choose to reject or tolerate them.
+func ParseEnvironment(s string) (Environment, error) {
+	env := Environment(strings.ToLower(strings.TrimSpace(s)))
+	for _, known := range environments {
+		if env == known {
+			return env, nil
+		}
+	}
+
+	names := make([]string, len(environments))
+	for i, known := range environments {
+		names[i] = string(known)
+	}
+	return env, fmt.Errorf("unknown environment %q, must be one of %s", s, strings.Join(names, ", "))
+}
+
+// IsDevelopment returns true for the development environment
+func (e Environment) IsDevelopment() bool {
+	return e == Development
+}
+
+// IsStaging returns true for the staging environment
+func (e Environment) IsStaging() bool {
+	return e == Staging
+}
+
+// IsProduction returns true for the production environment
+func (e Environment) IsProduction() bool {
+	return e == Production
+}
+
+// IsTest returns true for the test environment
+func (e Environment) IsTest() bool {
+	return e == Test
+}
@@ -0,0 +1,288 @@
+package gcpsecrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cloudPlatformScope is the OAuth scope access tokens are requested for
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// defaultTokenURL is the Google OAuth token endpoint, used when a credentials file names none
+const defaultTokenURL = "https://oauth2.googleapis.com/token"
+
+// expiryWindow is how long before they expire access tokens are renewed
+const expiryWindow = time.Minute
+
+// accessToken is an OAuth access token for Google APIs
+type accessToken struct {
+	value   string
+	expires time.Time
+}
+
+// tokenSource fetches access tokens from one place of the Application Default Credentials
+type tokenSource interface {
+	token(ctx context.Context) (accessToken, error)
+}
+
+// credentialsFile is a Google credentials JSON file
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newTokenSource picks the source of access tokens the Google client libraries would use, in
+// their order: the credentials file in GOOGLE_APPLICATION_CREDENTIALS, the gcloud
+// application default credentials file, then the metadata server of the workload (GCE, GKE
+// Workload Identity, Cloud Run). Service account keys and authorized users are supported in
+// files; external accounts (workload identity federation) are not.
+func newTokenSource(metadataHost string, client *http.Client) (tokenSource, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		if dir, err := os.UserConfigDir(); err == nil {
+			wellKnown := filepath.Join(dir, "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(wellKnown); err == nil {
+				path = wellKnown
+			}
+		}
+	}
+	if path == "" {
+		return &metadataTokenSource{host: metadataHost, client: client}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	var file credentialsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode credentials file %s: %w", path, err)
+	}
+
+	switch file.Type {
+	case "service_account":
+		key, err := parsePrivateKey(file.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key in credentials file %s: %w", path, err)
+		}
+		return &serviceAccountTokenSource{
+			email:    file.ClientEmail,
+			key:      key,
+			keyID:    file.PrivateKeyID,
+			tokenURL: firstNonEmpty(file.TokenURI, defaultTokenURL),
+			client:   client,
+		}, nil
+	case "authorized_user":
+		return &refreshTokenSource{
+			clientID:     file.ClientID,
+			clientSecret: file.ClientSecret,
+			refreshToken: file.RefreshToken,
+			tokenURL:     defaultTokenURL,
+			client:       client,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported credentials type %q in %s", file.Type, path)
+	}
+}
+
+// cachedTokenSource keeps the access token of a source until shortly before it expires
+type cachedTokenSource struct {
+	source tokenSource
+
+	mu      sync.Mutex
+	current accessToken
+}
+
+// token returns the cached access token, fetching a new one when it is about to expire
+func (c *cachedTokenSource) token(ctx context.Context) (accessToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current.value != "" && time.Until(c.current.expires) > expiryWindow {
+		return c.current, nil
+	}
+	token, err := c.source.token(ctx)
+	if err != nil {
+		return accessToken{}, err
+	}
+	c.current = token
+	return token, nil
+}
+
+// metadataTokenSource fetches tokens of the workload's service account from the metadata server
+type metadataTokenSource struct {
+	host   string
+	client *http.Client
+}
+
+func (m *metadataTokenSource) token(ctx context.Context) (accessToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+m.host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return accessToken{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	token, err := fetchToken(m.client, req)
+	if err != nil {
+		return accessToken{}, fmt.Errorf("failed to get access token from the metadata server: %w", err)
+	}
+	return token, nil
+}
+
+// serviceAccountTokenSource exchanges JWTs signed with a service account key for tokens
+type serviceAccountTokenSource struct {
+	email    string
+	key      *rsa.PrivateKey
+	keyID    string
+	tokenURL string
+	client   *http.Client
+}
+
+func (s *serviceAccountTokenSource) token(ctx context.Context) (accessToken, error) {
+	assertion, err := s.assertion(time.Now())
+	if err != nil {
+		return accessToken{}, err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	token, err := postToken(ctx, s.client, s.tokenURL, form)
+	if err != nil {
+		return accessToken{}, fmt.Errorf("failed to get access token for service account %s: %w", s.email, err)
+	}
+	return token, nil
+}
+
+// assertion returns the signed JWT a token is requested with
+func (s *serviceAccountTokenSource) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   s.email,
+		"scope": cloudPlatformScope,
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token request: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// refreshTokenSource fetches tokens with the refresh token of a user, as stored by
+// gcloud auth application-default login
+type refreshTokenSource struct {
+	clientID     string
+	clientSecret string
+	refreshToken string
+	tokenURL     string
+	client       *http.Client
+}
+
+func (r *refreshTokenSource) token(ctx context.Context) (accessToken, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {r.clientID},
+		"client_secret": {r.clientSecret},
+		"refresh_token": {r.refreshToken},
+	}
+	token, err := postToken(ctx, r.client, r.tokenURL, form)
+	if err != nil {
+		return accessToken{}, fmt.Errorf("failed to refresh user access token: %w", err)
+	}
+	return token, nil
+}
+
+// postToken requests a token from an OAuth token endpoint
+func postToken(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (accessToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return accessToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return fetchToken(client, req)
+}
+
+// fetchToken performs req and decodes the access token in its response
+func fetchToken(client *http.Client, req *http.Request) (accessToken, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := getJSON(client, req, &token); err != nil {
+		return accessToken{}, err
+	}
+	if token.AccessToken == "" {
+		return accessToken{}, errors.New("no access token returned")
+	}
+	return accessToken{value: token.AccessToken, expires: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)}, nil
+}
+
+// parsePrivateKey parses the PEM PKCS#8 or PKCS#1 RSA key of a service account
+func parsePrivateKey(data string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return key, nil
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package gcpsecrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCredentials writes a credentials file, returning its path
+func writeCredentials(t *testing.T, file map[string]string) string {
+	t.Helper()
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// verifyAssertion checks the JWT of a service account token request against key
+func verifyAssertion(assertion string, key *rsa.PublicKey) bool {
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+}
+
+func TestApplicationDefaultCredentials(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		switch {
+		case r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token" &&
+			r.Header.Get("Metadata-Flavor") == "Google":
+			token = "metadata-token"
+		case r.FormValue("grant_type") == "urn:ietf:params:oauth:grant-type:jwt-bearer" &&
+			verifyAssertion(r.FormValue("assertion"), &key.PublicKey):
+			token = "service-account-token"
+		case r.FormValue("grant_type") == "refresh_token" && r.FormValue("refresh_token") == "refresh":
+			token = "user-token"
+		default:
+			http.Error(w, `{"error":{"status":"UNAUTHENTICATED","message":"bad request"}}`, http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "expires_in": 3600})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		file      map[string]string
+		wantToken string
+	}{
+		{"metadata server", nil, "metadata-token"},
+		{"service account key", map[string]string{
+			"type":         "service_account",
+			"client_email": "bridge@project.iam.gserviceaccount.com",
+			"private_key":  keyPEM,
+			"token_uri":    server.URL + "/token",
+		}, "service-account-token"},
+		{"authorized user", map[string]string{
+			"type":          "authorized_user",
+			"client_id":     "client",
+			"client_secret": "secret",
+			"refresh_token": "refresh",
+		}, "user-token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No gcloud well-known file is found in the empty config directory
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+			if tt.file != nil {
+				t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", writeCredentials(t, tt.file))
+			}
+
+			source, err := newTokenSource(strings.TrimPrefix(server.URL, "http://"), server.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if refresh, ok := source.(*refreshTokenSource); ok {
+				refresh.tokenURL = server.URL + "/token"
+			}
+			token, err := source.token(context.Background())
+			if err != nil {
+				t.Fatalf("token() error = %v", err)
+			}
+			if token.value != tt.wantToken {
+				t.Errorf("token() = %q, want %q", token.value, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestApplicationDefaultCredentialsUnsupported(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", writeCredentials(t, map[string]string{"type": "external_account"}))
+	if _, err := newTokenSource("metadata.google.internal", http.DefaultClient); err == nil {
+		t.Error("newTokenSource() accepted an external account")
+	}
+}
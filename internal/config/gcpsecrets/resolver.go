@@ -0,0 +1,134 @@
+// Package gcpsecrets resolves secret:// config values from GCP Secret Manager, using access
+// tokens of the Application Default Credentials: a service account key or user credentials
+// file, or else the service account attached to the workload, from the metadata server.
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// secretManagerURL is the Secret Manager API base URL
+const secretManagerURL = "https://secretmanager.googleapis.com/v1/"
+
+// defaultMetadataHost serves the workload's access tokens; GCE_METADATA_HOST overrides it
+const defaultMetadataHost = "metadata.google.internal"
+
+// maxResponseSize bounds the responses read
+const maxResponseSize = 1 << 20
+
+// Resolver is a config.SecretResolver reading secrets from GCP Secret Manager. The ref of a
+// secret:// value is a secret name in the configured project, optionally followed by
+// /versions/<version>, or a full projects/<project>/secrets/<name> resource name. The
+// latest version is read unless a version is given.
+type Resolver struct {
+	project string
+	tokens  tokenSource
+	client  *http.Client
+}
+
+// New creates a new Resolver
+func New(cfg *config.SecretsConfig) (config.SecretResolver, error) {
+	metadataHost := os.Getenv("GCE_METADATA_HOST")
+	if metadataHost == "" {
+		metadataHost = defaultMetadataHost
+	}
+
+	client := &http.Client{}
+	source, err := newTokenSource(metadataHost, client)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{
+		project: cfg.GCP.Project,
+		tokens:  &cachedTokenSource{source: source},
+		client:  client,
+	}, nil
+}
+
+// Resolve returns the value of the secret version named by ref
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	name, err := r.versionName(ref)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := r.tokens.token(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, secretManagerURL+name+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.value)
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := getJSON(r.client, req, &version); err != nil {
+		return "", fmt.Errorf("failed to access secret: %w", err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret payload: %w", err)
+	}
+	return string(data), nil
+}
+
+// versionName returns the resource name of the secret version ref names
+func (r *Resolver) versionName(ref string) (string, error) {
+	name := ref
+	if !strings.HasPrefix(name, "projects/") {
+		if r.project == "" {
+			return "", errors.New("secrets.gcp.project must be set for secret names without a project")
+		}
+		name = "projects/" + r.project + "/secrets/" + name
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	return name, nil
+}
+
+// getJSON performs req and decodes its JSON response into v
+func getJSON(client *http.Client, req *http.Request, v interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("status %d: %s: %s", resp.StatusCode, apiErr.Error.Status, apiErr.Error.Message)
+		}
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// includeTag marks a YAML value to be replaced by the contents of another file,
+// e.g. `keycloak: !include keycloak.yaml`
+const includeTag = "!include"
+
+// readIncludes re-reads the config file viper found with its !include directives
+// resolved and migrated to the current schema version. Includes are only resolved in
+// YAML files.
+func readIncludes() error {
+	file := viper.ConfigFileUsed()
+	if file == "" {
+		return nil
+	}
+
+	var data []byte
+	var err error
+	if ext := strings.ToLower(filepath.Ext(file)); ext == ".yaml" || ext == ".yml" {
+		data, err = resolveIncludes(file)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The config type is set to yaml, so viper parses every file as YAML
+	return readMigrated(data, "yaml")
+}
+
+// resolveIncludes reads the YAML file and replaces every value tagged !include with the
+// document of the named file, resolved relative to the including file. Included files
+// may include further files; cycles are reported as errors.
+func resolveIncludes(file string) ([]byte, error) {
+	root, err := loadIncluded(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(root)
+}
+
+// loadIncluded parses file and resolves its includes. stack holds the files being
+// included, outermost first, to detect cycles.
+func loadIncluded(file string, stack []string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	for i, f := range stack {
+		if f == abs {
+			cycle := append(append([]string{}, stack[i:]...), abs)
+			return nil, fmt.Errorf("config include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	stack = append(stack, abs)
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		if len(stack) > 1 {
+			return nil, fmt.Errorf("config include in %s: %w", stack[len(stack)-2], err)
+		}
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", abs, err)
+	}
+	if len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+
+	root := doc.Content[0]
+	if err := expandIncludes(root, filepath.Dir(abs), stack); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// expandIncludes replaces the !include nodes below node in place
+func expandIncludes(node *yaml.Node, dir string, stack []string) error {
+	if node.Tag == includeTag {
+		if node.Kind != yaml.ScalarNode || node.Value == "" {
+			return fmt.Errorf("%s:%d: %s needs a file path", stack[len(stack)-1], node.Line, includeTag)
+		}
+
+		path := node.Value
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		included, err := loadIncluded(path, stack)
+		if err != nil {
+			return err
+		}
+		*node = *included
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := expandIncludes(child, dir, stack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the config schema version this build reads. Configs declare theirs in
+// the top-level version field; configs without one predate versioning and are version 1.
+const CurrentVersion = 1
+
+// unversioned is the version of configs without a version field
+const unversioned = 1
+
+// Settings is a config document as parsed from the file, before defaults and environment
+// variables apply. Keys are matched case-insensitively, as viper does.
+type Settings map[string]interface{}
+
+// Migration transforms the settings of a config document from one schema version to a
+// later one, e.g. by moving a renamed key with Move
+type Migration func(settings Settings) error
+
+type migration struct {
+	to int
+	fn Migration
+}
+
+var (
+	migrationsMu sync.RWMutex
+	migrations   = make(map[int]migration)
+	// deprecatedKeys maps deprecated keys to their replacements
+	deprecatedKeys = make(map[string]string)
+)
+
+func init() {
+	RegisterDeprecatedKey("security.login_redirect.secure_cookie", "security.login_state.secure")
+}
+
+// RegisterMigration registers the migration of config documents from schema version from
+// to version to, applied to older configs before they are unmarshalled. Migrations are
+// chained until the document reaches CurrentVersion. It panics when a migration from the
+// version is already registered or the versions are out of order, and is meant to be
+// called from init functions.
+func RegisterMigration(from, to int, fn Migration) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	if fn == nil || from < unversioned || to <= from || to > CurrentVersion {
+		panic(fmt.Sprintf("config: invalid migration from version %d to %d", from, to))
+	}
+	if _, dup := migrations[from]; dup {
+		panic(fmt.Sprintf("config: migration from version %d registered twice", from))
+	}
+	migrations[from] = migration{to: to, fn: fn}
+}
+
+// RegisterDeprecatedKey marks a dotted key, such as iam.keycloak.url, as replaced by
+// another in the current schema. Configs still setting it load with a warning, its value
+// moved to the replacement unless that is set too.
+func RegisterDeprecatedKey(key, replacement string) {
+	migrationsMu.Lock()
+	defer migrationsMu.Unlock()
+
+	deprecatedKeys[strings.ToLower(key)] = strings.ToLower(replacement)
+}
+
+// migrateConfig brings a config document in the given format, yaml or json, to
+// CurrentVersion and replaces its deprecated keys. Documents needing neither are returned
+// as they are. The returned warnings name the changes made.
+func migrateConfig(data []byte, format string) ([]byte, []string, error) {
+	var settings Settings
+	var err error
+	if format == "json" {
+		err = json.Unmarshal(data, &settings)
+	} else {
+		err = yaml.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing config: %w", err)
+	}
+	if settings == nil {
+		return data, nil, nil
+	}
+
+	version, err := settings.version()
+	if err != nil {
+		return nil, nil, err
+	}
+	if version > CurrentVersion {
+		return nil, nil, fmt.Errorf("config version %d is newer than the supported version %d", version, CurrentVersion)
+	}
+
+	migrationsMu.RLock()
+	defer migrationsMu.RUnlock()
+
+	var warnings []string
+	changed := false
+
+	if version < CurrentVersion {
+		from := version
+		for version < CurrentVersion {
+			m, ok := migrations[version]
+			if !ok {
+				return nil, nil, fmt.Errorf("no migration from config version %d to %d", version, CurrentVersion)
+			}
+			if err := m.fn(settings); err != nil {
+				return nil, nil, fmt.Errorf("error migrating config from version %d to %d: %w", version, m.to, err)
+			}
+			version = m.to
+		}
+		settings.Set("version", version)
+		warnings = append(warnings, fmt.Sprintf(
+			"config version %d is outdated and was migrated to version %d; update the file to stop migrating on every load",
+			from, version))
+		changed = true
+	}
+
+	// Sorted, so the warnings come out in a stable order
+	keys := make([]string, 0, len(deprecatedKeys))
+	for key := range deprecatedKeys {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		replacement := deprecatedKeys[key]
+		if _, ok := settings.Get(key); !ok {
+			continue
+		}
+		if _, ok := settings.Get(replacement); ok {
+			settings.Delete(key)
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated and ignored, as %s is set", key, replacement))
+		} else {
+			settings.Move(key, replacement)
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated, use %s instead", key, replacement))
+		}
+		changed = true
+	}
+
+	if !changed {
+		return data, nil, nil
+	}
+
+	var out []byte
+	if format == "json" {
+		out, err = json.Marshal(settings)
+	} else {
+		out, err = yaml.Marshal(settings)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encoding migrated config: %w", err)
+	}
+	return out, warnings, nil
+}
+
+// readMigrated migrates a config document in the given format and hands it to viper,
+// logging the migration warnings
+func readMigrated(data []byte, format string) error {
+	migrated, warnings, err := migrateConfig(data, format)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		log.Printf("Config warning: %s", warning)
+	}
+	return viper.ReadConfig(bytes.NewReader(migrated))
+}
+
+// version returns the schema version the document declares
+func (s Settings) version() (int, error) {
+	value, ok := s.Get("version")
+	if !ok {
+		return unversioned, nil
+	}
+
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("config version must be an integer, got %v", value)
+}
+
+// Get returns the value at the dotted key, if set
+func (s Settings) Get(key string) (interface{}, bool) {
+	parent, name := s.parent(key, false)
+	if parent == nil {
+		return nil, false
+	}
+	k, ok := parent.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return parent[k], true
+}
+
+// Set sets the value at the dotted key, creating the maps leading to it
+func (s Settings) Set(key string, value interface{}) {
+	parent, name := s.parent(key, true)
+	if k, ok := parent.lookup(name); ok {
+		name = k
+	}
+	parent[name] = value
+}
+
+// Delete removes the dotted key, if set
+func (s Settings) Delete(key string) {
+	parent, name := s.parent(key, false)
+	if parent == nil {
+		return
+	}
+	if k, ok := parent.lookup(name); ok {
+		delete(parent, k)
+	}
+}
+
+// Move moves the value at the dotted key from to the dotted key to, for renamed keys.
+// It reports whether from was set.
+func (s Settings) Move(from, to string) bool {
+	value, ok := s.Get(from)
+	if !ok {
+		return false
+	}
+	s.Delete(from)
+	s.Set(to, value)
+	return true
+}
+
+// parent returns the map holding the last segment of the dotted key, and that segment.
+// Missing maps are created when create is set; otherwise parent is nil.
+func (s Settings) parent(key string, create bool) (Settings, string) {
+	segments := strings.Split(key, ".")
+	current := s
+	for _, segment := range segments[:len(segments)-1] {
+		var next Settings
+		if k, ok := current.lookup(segment); ok {
+			switch child := current[k].(type) {
+			case map[string]interface{}:
+				next = child
+			case Settings:
+				next = child
+			}
+		}
+		if next == nil {
+			if !create {
+				return nil, ""
+			}
+			next = make(Settings)
+			current[segment] = map[string]interface{}(next)
+		}
+		current = next
+	}
+	return current, segments[len(segments)-1]
+}
+
+// lookup returns the key of the map matching name case-insensitively
+func (s Settings) lookup(name string) (string, bool) {
+	if _, ok := s[name]; ok {
+		return name, true
+	}
+	for k := range s {
+		if strings.EqualFold(k, name) {
+			return k, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// secretRefPrefix marks a config value to be fetched from the secrets manager,
+// e.g. `client_secret: "secret://prod/keycloak/client"`
+const secretRefPrefix = "secret://"
+
+// defaultSecretsTimeout bounds secret resolution when secrets.timeout is not configured
+const defaultSecretsTimeout = 10 * time.Second
+
+// SecretResolver fetches secrets referenced from config values. The ref is the part of
+// the value after secret://.
+type SecretResolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// SecretResolverFactory creates the SecretResolver of a secrets provider
+type SecretResolverFactory func(cfg *SecretsConfig) (SecretResolver, error)
+
+// WithSecretResolver makes the secrets provider name, as set in secrets.provider,
+// available for resolving secret:// references
+func WithSecretResolver(name string, factory SecretResolverFactory) LoadOption {
+	return func(o *loadOptions) {
+		if o.resolvers == nil {
+			o.resolvers = make(map[string]SecretResolverFactory)
+		}
+		o.resolvers[strings.ToLower(name)] = factory
+	}
+}
+
+// secretRef is a config value referencing a secret
+type secretRef struct {
+	field string
+	ref   string
+	// set replaces the value with the resolved secret
+	set func(secret string)
+}
+
+// resolveSecrets replaces every secret:// value in config with the secret it references.
+// Every reference that can't be resolved is reported, by field.
+func resolveSecrets(config *Config, resolvers map[string]SecretResolverFactory) error {
+	refs := findSecretRefs(reflect.ValueOf(config).Elem(), "", nil)
+	if len(refs) == 0 {
+		return nil
+	}
+
+	errs := &ConfigValidationError{}
+	name := strings.ToLower(config.Secrets.Provider)
+	factory, ok := resolvers[name]
+	switch {
+	case name == "":
+		for _, r := range refs {
+			errs.add(r.field, "references secret %q but secrets.provider is not set", r.ref)
+		}
+		return errs
+	case !ok:
+		errs.add("secrets.provider", "unknown secrets provider %q", config.Secrets.Provider)
+		return errs
+	}
+
+	resolver, err := factory(&config.Secrets)
+	if err != nil {
+		errs.add("secrets.provider", "failed to create %s secret resolver: %v", name, err)
+		return errs
+	}
+
+	timeout := config.Secrets.Timeout
+	if timeout <= 0 {
+		timeout = defaultSecretsTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, r := range refs {
+		secret, err := resolver.Resolve(ctx, r.ref)
+		if err != nil {
+			errs.add(r.field, "failed to resolve secret %q: %v", r.ref, err)
+			continue
+		}
+		r.set(secret)
+	}
+	return errs.errOrNil()
+}
+
+// findSecretRefs collects the string values under v that reference a secret, keyed by
+// their config field, e.g. iam.keycloak.realms[1].client_secret or headers.authorization
+func findSecretRefs(v reflect.Value, field string, refs []secretRef) []secretRef {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			key := t.Field(i).Tag.Get("mapstructure")
+			if key == "" {
+				continue
+			}
+			if field != "" {
+				key = field + "." + key
+			}
+			refs = findSecretRefs(v.Field(i), key, refs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			refs = findSecretRefs(v.Index(i), fmt.Sprintf("%s[%d]", field, i), refs)
+		}
+	case reflect.Map:
+		// Map values can't be set in place, so refs are found in a copy of each value, which
+		// is stored back once a secret is set in it
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, key := range keys {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			for _, r := range findSecretRefs(elem, fmt.Sprintf("%s.%v", field, key), nil) {
+				set, key := r.set, key
+				r.set = func(secret string) {
+					set(secret)
+					v.SetMapIndex(key, elem)
+				}
+				refs = append(refs, r)
+			}
+		}
+	case reflect.String:
+		if ref, ok := strings.CutPrefix(v.String(), secretRefPrefix); ok {
+			refs = append(refs, secretRef{field: field, ref: ref, set: v.SetString})
+		}
+	}
+	return refs
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mapResolver resolves the refs it holds
+type mapResolver map[string]string
+
+func (r mapResolver) Resolve(_ context.Context, ref string) (string, error) {
+	secret, ok := r[ref]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", ref)
+	}
+	return secret, nil
+}
+
+func TestResolveSecrets(t *testing.T) {
+	resolvers := map[string]SecretResolverFactory{
+		"test": func(*SecretsConfig) (SecretResolver, error) {
+			return mapResolver{"keycloak": "client-secret", "audit": "Bearer audit-token"}, nil
+		},
+	}
+
+	cfg := &Config{Secrets: SecretsConfig{Provider: "test"}}
+	cfg.IAM.Keycloak.ClientSecret = "secret://keycloak"
+	cfg.Security.Audit.Webhook.Headers = map[string]string{
+		"Authorization": "secret://audit",
+		"X-Source":      "iam-bridge",
+	}
+
+	if err := resolveSecrets(cfg, resolvers); err != nil {
+		t.Fatalf("resolveSecrets() error = %v", err)
+	}
+	if got := cfg.IAM.Keycloak.ClientSecret; got != "client-secret" {
+		t.Errorf("client_secret = %q, want the resolved secret", got)
+	}
+	if got := cfg.Security.Audit.Webhook.Headers["Authorization"]; got != "Bearer audit-token" {
+		t.Errorf("webhook Authorization header = %q, want the resolved secret", got)
+	}
+	if got := cfg.Security.Audit.Webhook.Headers["X-Source"]; got != "iam-bridge" {
+		t.Errorf("webhook X-Source header = %q, want it unchanged", got)
+	}
+}
+
+func TestResolveSecretsReportsMapFields(t *testing.T) {
+	resolvers := map[string]SecretResolverFactory{
+		"test": func(*SecretsConfig) (SecretResolver, error) { return mapResolver{}, nil },
+	}
+	cfg := &Config{Secrets: SecretsConfig{Provider: "test"}}
+	cfg.Security.Audit.Webhook.Headers = map[string]string{"Authorization": "secret://missing"}
+
+	err := resolveSecrets(cfg, resolvers)
+	if err == nil || !strings.Contains(err.Error(), "headers.Authorization") {
+		t.Errorf("resolveSecrets() error = %v, want the header's field reported", err)
+	}
+}
@@ -0,0 +1,757 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Upper bounds for duration settings; anything above is almost certainly a typo
+const (
+	maxKeycloakTimeout   = 5 * time.Minute
+	maxCacheTTL          = 24 * time.Hour
+	maxSessionTTL        = 30 * 24 * time.Hour
+	maxLoginStateTTL     = time.Hour
+	maxGracePeriod       = time.Hour
+	maxDiscoveryTTL      = 24 * time.Hour
+	maxShutdownTimeout   = 10 * time.Minute
+	maxRedisTimeout      = time.Minute
+	maxIdleConnTimeout   = time.Hour
+	maxSecretsTimeout    = 5 * time.Minute
+	maxSlowCallThreshold = time.Minute
+	maxKeyRetirement     = 7 * 24 * time.Hour
+	maxTokenLifetime     = 30 * 24 * time.Hour
+	maxAuditFlush        = 5 * time.Minute
+	maxWebhookTimeout    = time.Minute
+	maxInternalTokenTTL  = time.Hour
+	maxKeyRotation       = 90 * 24 * time.Hour
+	maxKeyOverlap        = 7 * 24 * time.Hour
+	maxJWKSMaxAge        = 24 * time.Hour
+	maxValidationWait    = 30 * time.Second
+)
+
+// maxHTTPConns bounds the connection pool settings of outbound IAM provider calls
+const maxHTTPConns = 10000
+
+// Clock skew bounds in seconds: skews above the maximum effectively disable expiry checks,
+// and anything above the warning threshold is unusually lenient
+const (
+	defaultMaxClockSkewSeconds = 300
+	warnClockSkewSeconds       = 60
+)
+
+// FieldError describes a single invalid configuration field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ConfigValidationError aggregates every problem found while validating a config
+type ConfigValidationError struct {
+	Errors []FieldError
+}
+
+// Error implements the error interface
+func (e *ConfigValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", fe.Field, fe.Message))
+	}
+	return "invalid config: " + strings.Join(msgs, "; ")
+}
+
+// add records a validation problem for the given field
+func (e *ConfigValidationError) add(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// errOrNil returns the aggregated error, or nil when no problem was recorded
+func (e *ConfigValidationError) errOrNil() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the configuration and reports all problems at once
+func (c *Config) Validate() error {
+	errs := &ConfigValidationError{}
+
+	c.validateDurations(errs)
+
+	if _, err := ParseEnvironment(c.App.Environment); err != nil && c.App.StrictEnvironment {
+		errs.add("app.environment", "%v", err)
+	}
+
+	for _, proxy := range c.App.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs.add("app.trusted_proxies", "%q is not a CIDR or IP address", proxy)
+		}
+	}
+
+	if c.App.MaxBodyBytes < 0 {
+		errs.add("app.max_body_bytes", "must not be negative, got %d", c.App.MaxBodyBytes)
+	}
+
+	for _, name := range c.App.HealthChecks {
+		if !containsString(knownHealthChecks, name) {
+			errs.add("app.health_checks", "unknown health check %q, must be one of %s",
+				name, strings.Join(knownHealthChecks, ", "))
+		}
+	}
+
+	validateTokenValidation(errs, "iam.keycloak.token_validation", c.IAM.Keycloak.TokenValidation)
+	c.validateJWKSInline(errs)
+	validateIssuerOverride(errs, "iam.keycloak", &c.IAM.Keycloak)
+
+	c.validateCacheBackend(errs)
+
+	c.validateJTIDenylist(errs)
+
+	c.validateGateway(errs)
+	c.validateInternalToken(errs)
+
+	for _, n := range []struct {
+		field string
+		value int
+	}{
+		{"iam.http.max_idle_conns", c.IAM.HTTP.MaxIdleConns},
+		{"iam.http.max_conns_per_host", c.IAM.HTTP.MaxConnsPerHost},
+	} {
+		if n.value < 0 || n.value > maxHTTPConns {
+			errs.add(n.field, "must be between 0 and %d, got %d", maxHTTPConns, n.value)
+		}
+	}
+
+	c.validateTokenSources(errs)
+
+	for i, claim := range c.IAM.ForwardedClaims {
+		if strings.TrimSpace(claim) == "" {
+			errs.add(fmt.Sprintf("iam.forwarded_claims[%d]", i), "must not be empty")
+		}
+	}
+
+	if c.IAM.WarmupConcurrency < 0 {
+		errs.add("iam.warmup_concurrency", "must not be negative, got %d", c.IAM.WarmupConcurrency)
+	}
+
+	if c.IAM.MaxConcurrentValidations < 0 {
+		errs.add("iam.max_concurrent_validations", "must not be negative, got %d", c.IAM.MaxConcurrentValidations)
+	}
+
+	if c.IAM.JWKSMaxBytes <= 0 {
+		errs.add("iam.jwks_max_bytes", "must be positive, got %d", c.IAM.JWKSMaxBytes)
+	}
+	if refresh := c.IAM.JWKSRefreshRate; refresh.MaxRefreshes < 0 {
+		errs.add("iam.jwks_refresh_rate.max_refreshes", "must not be negative, got %d", refresh.MaxRefreshes)
+	} else if refresh.MaxRefreshes > 0 && refresh.Window <= 0 {
+		errs.add("iam.jwks_refresh_rate.window", "must be positive when max_refreshes is set, got %s", refresh.Window)
+	}
+
+	if c.IAM.DegradedMode.Enabled && !c.IAM.Cache.Enabled {
+		errs.add("iam.degraded_mode.enabled", "requires iam.cache.enabled")
+	}
+
+	if c.Security.RateLimit.Enabled && c.Security.RateLimit.RequestsPerSecond <= 0 {
+		errs.add("security.rate_limit.requests_per_second", "must be positive when rate limiting is enabled, got %d",
+			c.Security.RateLimit.RequestsPerSecond)
+	}
+
+	for _, p := range []struct {
+		field string
+		value int
+		bits  int
+	}{
+		{"security.rate_limit.ipv4_prefix", c.Security.RateLimit.IPv4Prefix, 32},
+		{"security.rate_limit.ipv6_prefix", c.Security.RateLimit.IPv6Prefix, 128},
+	} {
+		if c.Security.RateLimit.Enabled && (p.value < 1 || p.value > p.bits) {
+			errs.add(p.field, "must be between 1 and %d, got %d", p.bits, p.value)
+		}
+	}
+	for _, ip := range c.Security.RateLimit.ExemptIPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			errs.add("security.rate_limit.exempt_ips", "%q is not a CIDR or IP address", ip)
+		}
+	}
+
+	if strings.TrimSpace(c.Security.UserManagement.AdminRole) == "" {
+		errs.add("security.user_management.admin_role", "must not be empty")
+	}
+
+	c.validateCORS(errs)
+
+	c.validateLoginRedirect(errs)
+	c.validateLoginState(errs)
+	c.validateAudit(errs)
+
+	c.validateRateLimitTiers(errs)
+
+	c.validateIssuerPatterns(errs)
+
+	c.validateClockSkew(errs)
+
+	if strings.TrimSpace(c.IAM.PrincipalClaim) == "" {
+		errs.add("iam.principal_claim", "must not be empty")
+	}
+
+	if c.IAM.EnforceTokenType && len(c.IAM.AccessTokenTypes) == 0 {
+		errs.add("iam.access_token_types", "must not be empty when enforce_token_type is enabled")
+	}
+
+	if _, err := c.IAM.Keycloak.TLS.MinTLSVersion(); err != nil {
+		errs.add("iam.keycloak.tls.min_version", "%v", err)
+	}
+
+	c.validateRealms(errs)
+
+	return errs.errOrNil()
+}
+
+// validateDurations checks every duration setting is non-negative and within its upper bound
+func (c *Config) validateDurations(errs *ConfigValidationError) {
+	durations := []struct {
+		field string
+		value time.Duration
+		limit time.Duration
+	}{
+		{"app.shutdown_timeout", c.App.ShutdownTimeout, maxShutdownTimeout},
+		{"iam.keycloak.timeout", c.IAM.Keycloak.Timeout, maxKeycloakTimeout},
+		{"iam.cache.ttl", c.IAM.Cache.TTL, maxCacheTTL},
+		{"iam.cache.redis.timeout", c.IAM.Cache.Redis.Timeout, maxRedisTimeout},
+		{"iam.jti_denylist.redis.timeout", c.IAM.JTIDenylist.Redis.Timeout, maxRedisTimeout},
+		{"iam.http.idle_conn_timeout", c.IAM.HTTP.IdleConnTimeout, maxIdleConnTimeout},
+		{"iam.discovery_cache_ttl", c.IAM.DiscoveryCacheTTL, maxDiscoveryTTL},
+		{"iam.slow_call_threshold", c.IAM.SlowCallThreshold, maxSlowCallThreshold},
+		{"iam.key_retirement_grace", c.IAM.KeyRetirementGrace, maxKeyRetirement},
+		{"iam.max_token_lifetime", c.IAM.MaxTokenLifetime, maxTokenLifetime},
+		{"iam.validation_wait_timeout", c.IAM.ValidationWaitTimeout, maxValidationWait},
+		{"iam.internal_token.ttl", c.IAM.InternalToken.TTL, maxInternalTokenTTL},
+		{"iam.internal_token.rotation_interval", c.IAM.InternalToken.RotationInterval, maxKeyRotation},
+		{"iam.internal_token.key_overlap", c.IAM.InternalToken.KeyOverlap, maxKeyOverlap},
+		{"iam.internal_token.jwks_max_age", c.IAM.InternalToken.JWKSMaxAge, maxJWKSMaxAge},
+		{"security.audit.flush_interval", c.Security.Audit.FlushInterval, maxAuditFlush},
+		{"security.audit.webhook.timeout", c.Security.Audit.Webhook.Timeout, maxWebhookTimeout},
+		{"security.session.ttl", c.Security.Session.TTL, maxSessionTTL},
+		{"security.login_state.ttl", c.Security.LoginState.TTL, maxLoginStateTTL},
+		{"iam.degraded_mode.grace_period", c.IAM.DegradedMode.GracePeriod, maxGracePeriod},
+		{"secrets.timeout", c.Secrets.Timeout, maxSecretsTimeout},
+	}
+
+	for _, d := range durations {
+		validateDuration(errs, d.field, d.value, d.limit)
+	}
+
+	if c.IAM.Cache.Enabled && c.IAM.Cache.TTL == 0 {
+		errs.add("iam.cache.ttl", "must be set when the cache is enabled")
+	}
+	if c.Security.Session.Enabled && c.Security.Session.TTL == 0 {
+		errs.add("security.session.ttl", "must be set when sessions are enabled")
+	}
+	if c.IAM.DegradedMode.Enabled && c.IAM.DegradedMode.GracePeriod == 0 {
+		errs.add("iam.degraded_mode.grace_period", "must be set when degraded mode is enabled")
+	}
+}
+
+// validateClockSkew checks the clock skew leeway stays within its configured maximum
+func (c *Config) validateClockSkew(errs *ConfigValidationError) {
+	limit := c.IAM.MaxClockSkewSeconds
+	if limit == 0 {
+		limit = defaultMaxClockSkewSeconds
+	}
+
+	switch skew := c.IAM.ClockSkewSeconds; {
+	case skew < 0:
+		errs.add("iam.clock_skew_seconds", "must not be negative, got %d", skew)
+	case limit >= 0 && skew > limit:
+		errs.add("iam.clock_skew_seconds", "must be at most %d (iam.max_clock_skew_seconds), got %d", limit, skew)
+	}
+	if limit < 0 {
+		errs.add("iam.max_clock_skew_seconds", "must not be negative, got %d", limit)
+	}
+}
+
+// Warnings reports settings that are valid but likely unintended
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if _, err := ParseEnvironment(c.App.Environment); err != nil && !c.App.StrictEnvironment {
+		warnings = append(warnings, fmt.Sprintf("app.environment: %v", err))
+	}
+	if c.IAM.AllowTokensWithoutExpiry {
+		warnings = append(warnings,
+			"iam.allow_tokens_without_expiry is set, tokens lacking exp are accepted and never expire")
+	}
+	if it := c.IAM.InternalToken; it.Enabled && it.KeyID != "" {
+		warnings = append(warnings,
+			"iam.internal_token.key_id is set, a replaced key file takes over at once as the old and new key share the kid")
+	}
+	if c.IAM.InternalToken.Enabled && len(c.App.TrustedProxies) == 0 {
+		warnings = append(warnings,
+			"iam.internal_token is enabled but app.trusted_proxies is empty, internal tokens are only handed to trusted proxies")
+	}
+	if skew := c.IAM.ClockSkewSeconds; skew > warnClockSkewSeconds {
+		warnings = append(warnings, fmt.Sprintf(
+			"iam.clock_skew_seconds is %d, tokens are accepted up to %ds after they expire", skew, skew))
+	}
+	return warnings
+}
+
+// validateRealms checks each multi-tenant realm entry on its own, after inheriting the
+// top-level settings, and reports problems under the entry's index
+func (c *Config) validateRealms(errs *ConfigValidationError) {
+	seen := make(map[string]int)
+	for i, realm := range c.IAM.Keycloak.RealmConfigs() {
+		field := fmt.Sprintf("iam.keycloak.realms[%d]", i)
+
+		required := []struct {
+			name  string
+			value string
+		}{
+			{"base_url", realm.BaseURL},
+			{"realm", realm.Realm},
+			{"client_id", realm.ClientID},
+			{"client_secret", realm.ClientSecret},
+		}
+		for _, r := range required {
+			if r.value == "" {
+				errs.add(field+"."+r.name, "must be set")
+			}
+		}
+
+		if realm.Realm != "" {
+			if j, ok := seen[realm.Realm]; ok {
+				errs.add(field+".realm", "realm %q is already configured by iam.keycloak.realms[%d]", realm.Realm, j)
+			}
+			seen[realm.Realm] = i
+		}
+
+		validateDuration(errs, field+".timeout", realm.Timeout, maxKeycloakTimeout)
+		validateTokenValidation(errs, field+".token_validation", realm.TokenValidation)
+		validateIssuerOverride(errs, field, &realm)
+		if _, err := realm.TLS.MinTLSVersion(); err != nil {
+			errs.add(field+".tls.min_version", "%v", err)
+		}
+	}
+}
+
+// validateIssuerOverride checks an issuer override is an absolute https URL, or http with
+// allow_insecure_issuer
+func validateIssuerOverride(errs *ConfigValidationError, field string, cfg *KeycloakConfig) {
+	if cfg.IssuerOverride == "" {
+		return
+	}
+
+	u, err := url.Parse(cfg.IssuerOverride)
+	switch {
+	case err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http"):
+		errs.add(field+".issuer_override", "%q is not an absolute http(s) URL", cfg.IssuerOverride)
+	case u.Scheme == "http" && !cfg.AllowInsecureIssuer:
+		errs.add(field+".issuer_override", "must use https unless %s.allow_insecure_issuer is set", field)
+	}
+}
+
+// validateCacheBackend checks the token cache backend and its settings
+func (c *Config) validateCacheBackend(errs *ConfigValidationError) {
+	cache := &c.IAM.Cache
+	switch strings.ToLower(cache.Backend) {
+	case "", "memory":
+	case "redis":
+		if cache.Redis.Address == "" {
+			errs.add("iam.cache.redis.address", "must be set for the redis cache backend")
+		}
+		// Instances sharing the cache must derive the same keys from a token, and
+		// authenticate the entries they read with it
+		if cache.KeySalt == "" {
+			errs.add("iam.cache.key_salt", "must be set for the redis cache backend")
+		}
+		validateRedisTLS("iam.cache.redis.tls", &cache.Redis.TLS, errs)
+	default:
+		errs.add("iam.cache.backend", "invalid cache backend %q, must be one of memory, redis", cache.Backend)
+	}
+}
+
+// validateRedisTLS checks the TLS settings of a Redis connection when TLS is enabled
+func validateRedisTLS(field string, cfg *RedisTLSConfig, errs *ConfigValidationError) {
+	if !cfg.Enabled {
+		return
+	}
+	if _, err := cfg.MinTLSVersion(); err != nil {
+		errs.add(field+".min_version", "%v", err)
+	}
+}
+
+// validateJTIDenylist checks the jti denylist backend and its settings when the denylist is checked
+func (c *Config) validateJTIDenylist(errs *ConfigValidationError) {
+	if !c.IAM.CheckJTIDenylist {
+		return
+	}
+
+	denylist := &c.IAM.JTIDenylist
+	switch strings.ToLower(denylist.Backend) {
+	case "", "memory":
+	case "redis":
+		if denylist.Redis.Address == "" {
+			errs.add("iam.jti_denylist.redis.address", "must be set for the redis jti denylist backend")
+		}
+		validateRedisTLS("iam.jti_denylist.redis.tls", &denylist.Redis.TLS, errs)
+	default:
+		errs.add("iam.jti_denylist.backend", "invalid jti denylist backend %q, must be one of memory, redis", denylist.Backend)
+	}
+}
+
+// validateGateway checks the gateway token settings when gateway tokens are trusted
+func (c *Config) validateGateway(errs *ConfigValidationError) {
+	gw := &c.IAM.Gateway
+	if !gw.Enabled {
+		return
+	}
+
+	if strings.TrimSpace(gw.Header) == "" {
+		errs.add("iam.gateway.header", "must be set when gateway tokens are enabled")
+	}
+	if gw.PublicKeyFile == "" {
+		errs.add("iam.gateway.public_key_file", "must be set when gateway tokens are enabled")
+	}
+	if gw.Issuer == "" {
+		errs.add("iam.gateway.issuer", "must be set when gateway tokens are enabled")
+	}
+}
+
+// validateInternalToken checks the settings for minting internal tokens
+func (c *Config) validateInternalToken(errs *ConfigValidationError) {
+	it := &c.IAM.InternalToken
+	if !it.Enabled {
+		return
+	}
+
+	if strings.TrimSpace(it.Header) == "" {
+		errs.add("iam.internal_token.header", "must be set when internal tokens are enabled")
+	}
+	switch strings.ToLower(it.KeySource) {
+	case "", "file":
+		if it.PrivateKeyFile == "" {
+			errs.add("iam.internal_token.private_key_file", "must be set when internal tokens are signed with a key file")
+		}
+	case "memory":
+		if it.RotationInterval <= 0 {
+			errs.add("iam.internal_token.rotation_interval", "must be positive when internal token keys are generated in memory")
+		} else if it.RotationInterval < it.JWKSMaxAge {
+			errs.add("iam.internal_token.rotation_interval",
+				"must be at least iam.internal_token.jwks_max_age (%s), as each key is published that long before it signs, got %s",
+				it.JWKSMaxAge, it.RotationInterval)
+		}
+	default:
+		errs.add("iam.internal_token.key_source", "must be one of file, memory, got %q", it.KeySource)
+	}
+	// A replaced key must stay published until every token it signed has expired, and until
+	// verifiers that cached the JWKS before it was replaced have refetched it
+	if minOverlap := it.TTL + it.JWKSMaxAge; it.KeyOverlap < minOverlap {
+		errs.add("iam.internal_token.key_overlap",
+			"must be at least iam.internal_token.ttl plus jwks_max_age (%s), or tokens outlive the published key, got %s",
+			minOverlap, it.KeyOverlap)
+	}
+	if it.Issuer == "" {
+		errs.add("iam.internal_token.issuer", "must be set when internal tokens are enabled")
+	}
+	if len(it.Audiences) == 0 {
+		errs.add("iam.internal_token.audiences", "must list at least one audience when internal tokens are enabled")
+	}
+	if it.TTL <= 0 {
+		errs.add("iam.internal_token.ttl", "must be positive when internal tokens are enabled")
+	}
+}
+
+// validateTokenValidation checks a token_validation mode
+func validateTokenValidation(errs *ConfigValidationError, field, mode string) {
+	switch strings.ToLower(mode) {
+	case "", "introspection", "userinfo", "jwks":
+	default:
+		errs.add(field, "must be one of introspection, userinfo, jwks, got %q", mode)
+	}
+}
+
+// validateIssuerPatterns checks the allowed issuer patterns and that local JWKS
+// validation, which they rely on, is enabled
+func (c *Config) validateIssuerPatterns(errs *ConfigValidationError) {
+	patterns := c.IAM.AllowedIssuerPatterns
+	if len(patterns) == 0 {
+		return
+	}
+
+	for _, p := range patterns {
+		if !strings.HasPrefix(p, "https://") && !strings.HasPrefix(p, "http://") {
+			errs.add("iam.allowed_issuer_patterns", "pattern %q must be an absolute http(s) URL", p)
+		}
+		if strings.Count(p, "*") > 1 {
+			errs.add("iam.allowed_issuer_patterns", "pattern %q must contain at most one '*'", p)
+		}
+	}
+
+	if strings.ToLower(c.IAM.Keycloak.TokenValidation) != "jwks" || c.IAM.Keycloak.LocalKeys() {
+		errs.add("iam.allowed_issuer_patterns", "requires iam.keycloak.token_validation jwks without a jwks_file or jwks_inline")
+	}
+}
+
+// validateJWKSInline checks the inline JWKS is a JWKS document with keys. Whether the keys
+// are usable is checked when the provider parses them.
+func (c *Config) validateJWKSInline(errs *ConfigValidationError) {
+	inline := c.IAM.Keycloak.JWKSInline
+	if inline == "" {
+		return
+	}
+	if c.IAM.Keycloak.JWKSFile != "" {
+		errs.add("iam.keycloak.jwks_inline", "must not be set together with iam.keycloak.jwks_file")
+	}
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal([]byte(inline), &jwks); err != nil {
+		errs.add("iam.keycloak.jwks_inline", "invalid JWKS: %v", err)
+		return
+	}
+	if len(jwks.Keys) == 0 {
+		errs.add("iam.keycloak.jwks_inline", "JWKS contains no keys")
+	}
+}
+
+// tokenSources are the accepted iam.token_sources
+var tokenSources = []string{"header", "cookie", "query"}
+
+// validateTokenSources checks tokens are looked for somewhere, in known places listed once,
+// and that the cookie, CSRF header and query parameter they're read from are named. Tokens
+// in URLs end up in access logs and browser history, so the query source is debug only.
+func (c *Config) validateTokenSources(errs *ConfigValidationError) {
+	if len(c.IAM.TokenSources) == 0 {
+		errs.add("iam.token_sources", "must not be empty")
+	}
+
+	seen := make(map[string]bool)
+	for _, source := range c.IAM.TokenSources {
+		source = strings.ToLower(source)
+		switch {
+		case !containsString(tokenSources, source):
+			errs.add("iam.token_sources", "unknown token source %q, must be one of %s",
+				source, strings.Join(tokenSources, ", "))
+		case seen[source]:
+			errs.add("iam.token_sources", "duplicate token source %q", source)
+		}
+		seen[source] = true
+	}
+
+	if seen["cookie"] && c.IAM.TokenCookie == "" {
+		errs.add("iam.token_cookie", "must be set when iam.token_sources includes cookie")
+	}
+	if seen["cookie"] && c.IAM.TokenCookieCSRFHeader == "" {
+		errs.add("iam.token_cookie_csrf_header", "must be set when iam.token_sources includes cookie")
+	}
+	if seen["query"] && !c.App.Debug {
+		errs.add("iam.token_sources", "query is only allowed with app.debug, tokens in URLs end up in "+
+			"access logs and browser history")
+	}
+	if seen["query"] && c.IAM.TokenQueryParam == "" {
+		errs.add("iam.token_query_param", "must be set when iam.token_sources includes query")
+	}
+}
+
+// validateRateLimitTiers checks each tier has a unique name and a positive rate, and that
+// clients are in at most one tier and, with an allowlist, are allowed clients
+func (c *Config) validateRateLimitTiers(errs *ConfigValidationError) {
+	names := make(map[string]bool)
+	clients := make(map[string]string)
+	for i, tier := range c.Security.RateLimit.Tiers {
+		field := fmt.Sprintf("security.rate_limit.tiers[%d]", i)
+		if tier.Name == "" {
+			errs.add(field+".name", "must not be empty")
+		} else if names[tier.Name] {
+			errs.add(field+".name", "duplicate tier %q", tier.Name)
+		}
+		names[tier.Name] = true
+
+		if tier.RequestsPerSecond <= 0 {
+			errs.add(field+".requests_per_second", "must be positive, got %d", tier.RequestsPerSecond)
+		}
+
+		for _, client := range tier.Clients {
+			if other, ok := clients[client]; ok {
+				errs.add(field+".clients", "client %q is already in tier %q", client, other)
+			}
+			clients[client] = tier.Name
+
+			if len(c.IAM.AllowedClients) > 0 && !containsString(c.IAM.AllowedClients, client) {
+				errs.add(field+".clients", "client %q is not in iam.allowed_clients", client)
+			}
+		}
+	}
+}
+
+// validateLoginRedirect checks browser requests have somewhere to be redirected to
+func (c *Config) validateLoginRedirect(errs *ConfigValidationError) {
+	redirect := &c.Security.LoginRedirect
+	if !redirect.Enabled {
+		return
+	}
+
+	if redirect.URL == "" {
+		if c.IAM.Keycloak.RedirectURL == "" {
+			errs.add("security.login_redirect.url", "must be set unless iam.keycloak.redirect_url is")
+		}
+		return
+	}
+	if _, err := url.Parse(redirect.URL); err != nil {
+		errs.add("security.login_redirect.url", "invalid URL %q: %v", redirect.URL, err)
+	}
+}
+
+// validateAudit checks the audit webhook and its batching
+func (c *Config) validateAudit(errs *ConfigValidationError) {
+	a := &c.Security.Audit
+	if !a.Enabled {
+		return
+	}
+
+	if u, err := url.Parse(a.Webhook.URL); err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		errs.add("security.audit.webhook.url", "must be an absolute http(s) URL when auditing is enabled, got %q", a.Webhook.URL)
+	}
+	if a.BatchSize <= 0 {
+		errs.add("security.audit.batch_size", "must be positive, got %d", a.BatchSize)
+	}
+	if a.QueueSize < a.BatchSize {
+		errs.add("security.audit.queue_size", "must be at least batch_size (%d), got %d", a.BatchSize, a.QueueSize)
+	}
+	for name := range a.Webhook.Headers {
+		if !isToken(name) {
+			errs.add("security.audit.webhook.headers", "invalid header name %q", name)
+		}
+	}
+}
+
+// minLoginStateSecretLength is the minimum length of security.login_state.cookie_secret,
+// matching the session cookie store's
+const minLoginStateSecretLength = 32
+
+// validateLoginState checks the store of started logins
+func (c *Config) validateLoginState(errs *ConfigValidationError) {
+	state := &c.Security.LoginState
+
+	switch strings.ToLower(state.Store) {
+	case "", "memory":
+	case "cookie":
+		if len(state.CookieSecret) < minLoginStateSecretLength {
+			errs.add("security.login_state.cookie_secret", "must be at least %d characters for the cookie store",
+				minLoginStateSecretLength)
+		}
+	default:
+		errs.add("security.login_state.store", "invalid store %q, must be memory or cookie", state.Store)
+	}
+	if state.CookieName == "" {
+		errs.add("security.login_state.cookie_name", "must be set")
+	}
+	if state.TTL == 0 {
+		errs.add("security.login_state.ttl", "must be set")
+	}
+	if state.MaxEntries < 0 {
+		errs.add("security.login_state.max_entries", "must not be negative, got %d", state.MaxEntries)
+	}
+}
+
+// corsMethods are the HTTP methods accepted in security.cors.allowed_methods
+var corsMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// validateCORS checks the CORS origins, methods and headers. Methods are normalized to
+// upper case, since browsers compare them case-sensitively.
+func (c *Config) validateCORS(errs *ConfigValidationError) {
+	cors := &c.Security.CORS
+
+	seen := make(map[string]bool, len(cors.AllowedOrigins))
+	for _, origin := range cors.AllowedOrigins {
+		if origin != "*" && !isOrigin(origin) {
+			errs.add("security.cors.allowed_origins",
+				"origin %q must be * or a scheme and host such as https://app.example.com", origin)
+		}
+		if seen[origin] {
+			errs.add("security.cors.allowed_origins", "duplicate origin %q", origin)
+		}
+		seen[origin] = true
+	}
+
+	seen = make(map[string]bool, len(cors.AllowedMethods))
+	for i, method := range cors.AllowedMethods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		cors.AllowedMethods[i] = method
+		if !containsString(corsMethods, method) {
+			errs.add("security.cors.allowed_methods", "unknown HTTP method %q", method)
+		}
+		if seen[method] {
+			errs.add("security.cors.allowed_methods", "duplicate method %q", method)
+		}
+		seen[method] = true
+	}
+
+	seen = make(map[string]bool, len(cors.AllowedHeaders))
+	for _, header := range cors.AllowedHeaders {
+		if !isToken(header) {
+			errs.add("security.cors.allowed_headers", "header %q is not a valid header name", header)
+		}
+		name := http.CanonicalHeaderKey(header)
+		if seen[name] {
+			errs.add("security.cors.allowed_headers", "duplicate header %q", header)
+		}
+		seen[name] = true
+	}
+}
+
+// isOrigin reports whether s is a serialized origin: an http(s) scheme and host with an
+// optional port, and no path, query or fragment. Browsers send the Origin header in exactly
+// this form, so anything else, such as a trailing slash, never matches.
+func isOrigin(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && !u.ForceQuery
+}
+
+// isToken reports whether s is a non-empty RFC 9110 token, as required for header names
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r > 0x7e || r <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateDuration records an error when d is negative or exceeds limit
+func validateDuration(errs *ConfigValidationError, field string, d, limit time.Duration) {
+	switch {
+	case d < 0:
+		errs.add(field, "must not be negative, got %s", d)
+	case d > limit:
+		errs.add(field, "must be at most %s, got %s", limit, d)
+	}
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
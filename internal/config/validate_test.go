@@ -0,0 +1,353 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fieldsOf returns the fields errs reports problems with, in order
+func fieldsOf(errs *ConfigValidationError) []string {
+	fields := make([]string, 0, len(errs.Errors))
+	for _, fe := range errs.Errors {
+		fields = append(fields, fe.Field)
+	}
+	return fields
+}
+
+func TestValidateInternalToken(t *testing.T) {
+	valid := InternalTokenConfig{
+		Enabled:          true,
+		Header:           "X-Internal-Token",
+		KeySource:        "file",
+		PrivateKeyFile:   "signing.pem",
+		RotationInterval: 24 * time.Hour,
+		KeyOverlap:       10 * time.Minute,
+		JWKSMaxAge:       5 * time.Minute,
+		Issuer:           "https://bridge.internal",
+		Audiences:        []string{"orders"},
+		TTL:              time.Minute,
+	}
+
+	tests := []struct {
+		name       string
+		modify     func(*InternalTokenConfig)
+		wantFields []string
+	}{
+		{"valid", func(*InternalTokenConfig) {}, nil},
+		{"disabled", func(it *InternalTokenConfig) { *it = InternalTokenConfig{} }, nil},
+		{"overlap covering ttl plus max-age", func(it *InternalTokenConfig) { it.KeyOverlap = 6 * time.Minute }, nil},
+		{"overlap below ttl plus max-age", func(it *InternalTokenConfig) { it.KeyOverlap = 5 * time.Minute },
+			[]string{"iam.internal_token.key_overlap"}},
+		{"memory keys", func(it *InternalTokenConfig) { it.KeySource, it.PrivateKeyFile = "memory", "" }, nil},
+		{"memory keys rotated faster than cached", func(it *InternalTokenConfig) {
+			it.KeySource, it.RotationInterval = "memory", time.Minute
+		}, []string{"iam.internal_token.rotation_interval"}},
+		{"memory keys without rotation", func(it *InternalTokenConfig) {
+			it.KeySource, it.RotationInterval = "memory", 0
+		}, []string{"iam.internal_token.rotation_interval"}},
+		{"file keys without file", func(it *InternalTokenConfig) { it.PrivateKeyFile = "" },
+			[]string{"iam.internal_token.private_key_file"}},
+		{"unknown key source", func(it *InternalTokenConfig) { it.KeySource = "kms" },
+			[]string{"iam.internal_token.key_source"}},
+		{"missing issuer and audiences", func(it *InternalTokenConfig) { it.Issuer, it.Audiences = "", nil },
+			[]string{"iam.internal_token.issuer", "iam.internal_token.audiences"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			c.IAM.InternalToken = valid
+			tt.modify(&c.IAM.InternalToken)
+
+			errs := &ConfigValidationError{}
+			c.validateInternalToken(errs)
+			if got := fieldsOf(errs); !slices.Equal(got, tt.wantFields) && (len(got) > 0 || len(tt.wantFields) > 0) {
+				t.Errorf("validateInternalToken() reported %v, want %v (%v)", got, tt.wantFields, errs.Errors)
+			}
+		})
+	}
+}
+
+func TestValidateTokenSources(t *testing.T) {
+	tests := []struct {
+		name       string
+		modify     func(*Config)
+		wantFields []string
+	}{
+		{"header", func(*Config) {}, nil},
+		{"cookie", func(c *Config) { c.IAM.TokenSources = []string{"header", "cookie"} }, nil},
+		{"cookie without csrf header", func(c *Config) {
+			c.IAM.TokenSources, c.IAM.TokenCookieCSRFHeader = []string{"cookie"}, ""
+		}, []string{"iam.token_cookie_csrf_header"}},
+		{"query outside debug", func(c *Config) { c.IAM.TokenSources = []string{"query"} },
+			[]string{"iam.token_sources"}},
+		{"query in debug", func(c *Config) { c.IAM.TokenSources, c.App.Debug = []string{"query"}, true }, nil},
+		{"duplicate source", func(c *Config) { c.IAM.TokenSources = []string{"header", "Header"} },
+			[]string{"iam.token_sources"}},
+		{"no source", func(c *Config) { c.IAM.TokenSources = nil }, []string{"iam.token_sources"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			c.IAM.TokenSources = []string{"header"}
+			c.IAM.TokenCookie = "access_token"
+			c.IAM.TokenCookieCSRFHeader = "X-Requested-With"
+			c.IAM.TokenQueryParam = "access_token"
+			tt.modify(c)
+
+			errs := &ConfigValidationError{}
+			c.validateTokenSources(errs)
+			if got := fieldsOf(errs); !slices.Equal(got, tt.wantFields) && (len(got) > 0 || len(tt.wantFields) > 0) {
+				t.Errorf("validateTokenSources() reported %v, want %v (%v)", got, tt.wantFields, errs.Errors)
+			}
+		})
+	}
+}
+
+func TestValidateDurations(t *testing.T) {
+	fields := []struct {
+		field string
+		set   func(*Config, time.Duration)
+		limit time.Duration
+	}{
+		{"app.shutdown_timeout", func(c *Config, d time.Duration) { c.App.ShutdownTimeout = d }, maxShutdownTimeout},
+		{"iam.keycloak.timeout", func(c *Config, d time.Duration) { c.IAM.Keycloak.Timeout = d }, maxKeycloakTimeout},
+		{"iam.cache.ttl", func(c *Config, d time.Duration) { c.IAM.Cache.TTL = d }, maxCacheTTL},
+		{"iam.cache.redis.timeout", func(c *Config, d time.Duration) { c.IAM.Cache.Redis.Timeout = d }, maxRedisTimeout},
+		{"iam.jti_denylist.redis.timeout", func(c *Config, d time.Duration) { c.IAM.JTIDenylist.Redis.Timeout = d }, maxRedisTimeout},
+		{"iam.http.idle_conn_timeout", func(c *Config, d time.Duration) { c.IAM.HTTP.IdleConnTimeout = d }, maxIdleConnTimeout},
+		{"iam.discovery_cache_ttl", func(c *Config, d time.Duration) { c.IAM.DiscoveryCacheTTL = d }, maxDiscoveryTTL},
+		{"iam.slow_call_threshold", func(c *Config, d time.Duration) { c.IAM.SlowCallThreshold = d }, maxSlowCallThreshold},
+		{"iam.key_retirement_grace", func(c *Config, d time.Duration) { c.IAM.KeyRetirementGrace = d }, maxKeyRetirement},
+		{"iam.max_token_lifetime", func(c *Config, d time.Duration) { c.IAM.MaxTokenLifetime = d }, maxTokenLifetime},
+		{"iam.validation_wait_timeout", func(c *Config, d time.Duration) { c.IAM.ValidationWaitTimeout = d }, maxValidationWait},
+		{"iam.internal_token.ttl", func(c *Config, d time.Duration) { c.IAM.InternalToken.TTL = d }, maxInternalTokenTTL},
+		{"iam.internal_token.rotation_interval", func(c *Config, d time.Duration) { c.IAM.InternalToken.RotationInterval = d }, maxKeyRotation},
+		{"iam.internal_token.key_overlap", func(c *Config, d time.Duration) { c.IAM.InternalToken.KeyOverlap = d }, maxKeyOverlap},
+		{"iam.internal_token.jwks_max_age", func(c *Config, d time.Duration) { c.IAM.InternalToken.JWKSMaxAge = d }, maxJWKSMaxAge},
+		{"security.audit.flush_interval", func(c *Config, d time.Duration) { c.Security.Audit.FlushInterval = d }, maxAuditFlush},
+		{"security.audit.webhook.timeout", func(c *Config, d time.Duration) { c.Security.Audit.Webhook.Timeout = d }, maxWebhookTimeout},
+		{"security.session.ttl", func(c *Config, d time.Duration) { c.Security.Session.TTL = d }, maxSessionTTL},
+		{"security.login_state.ttl", func(c *Config, d time.Duration) { c.Security.LoginState.TTL = d }, maxLoginStateTTL},
+		{"iam.degraded_mode.grace_period", func(c *Config, d time.Duration) { c.IAM.DegradedMode.GracePeriod = d }, maxGracePeriod},
+		{"secrets.timeout", func(c *Config, d time.Duration) { c.Secrets.Timeout = d }, maxSecretsTimeout},
+	}
+
+	for _, f := range fields {
+		boundaries := []struct {
+			name    string
+			value   time.Duration
+			wantErr bool
+		}{
+			{"negative", -time.Nanosecond, true},
+			{"zero", 0, false},
+			{"at the limit", f.limit, false},
+			{"over the limit", f.limit + time.Nanosecond, true},
+		}
+		for _, b := range boundaries {
+			t.Run(f.field+"/"+b.name, func(t *testing.T) {
+				c := &Config{}
+				f.set(c, b.value)
+
+				errs := &ConfigValidationError{}
+				c.validateDurations(errs)
+				got := fieldsOf(errs)
+				if b.wantErr && !slices.Equal(got, []string{f.field}) {
+					t.Errorf("validateDurations() reported %v, want [%s]", got, f.field)
+				}
+				if !b.wantErr && len(got) > 0 {
+					t.Errorf("validateDurations() reported %v, want nothing (%v)", got, errs.Errors)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateDurationsRequiredWhenEnabled(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		field  string
+	}{
+		{"cache", func(c *Config) { c.IAM.Cache.Enabled = true }, "iam.cache.ttl"},
+		{"sessions", func(c *Config) { c.Security.Session.Enabled = true }, "security.session.ttl"},
+		{"degraded mode", func(c *Config) { c.IAM.DegradedMode.Enabled = true }, "iam.degraded_mode.grace_period"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			tt.modify(c)
+
+			errs := &ConfigValidationError{}
+			c.validateDurations(errs)
+			if got := fieldsOf(errs); !slices.Equal(got, []string{tt.field}) {
+				t.Errorf("validateDurations() reported %v, want [%s]", got, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateReportsDurationFields(t *testing.T) {
+	c := &Config{}
+	c.IAM.Cache.TTL = -time.Minute
+	c.IAM.Cache.Redis.Timeout = 2 * time.Minute
+
+	err := c.Validate()
+	var errs *ConfigValidationError
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() error = %v, want a ConfigValidationError", err)
+	}
+	for _, want := range []string{
+		"iam.cache.ttl: must not be negative, got -1m0s",
+		"iam.cache.redis.timeout: must be at most 1m0s, got 2m0s",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate() error = %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestValidateClockSkew(t *testing.T) {
+	tests := []struct {
+		name        string
+		skew        int
+		max         int
+		wantErr     string
+		wantWarning bool
+	}{
+		{"no skew", 0, 0, "", false},
+		{"at the warning threshold", warnClockSkewSeconds, 0, "", false},
+		{"over the warning threshold", warnClockSkewSeconds + 1, 0, "", true},
+		{"at the default maximum", defaultMaxClockSkewSeconds, 0, "", true},
+		{"over the default maximum", defaultMaxClockSkewSeconds + 1, 0,
+			"iam.clock_skew_seconds: must be at most 300 (iam.max_clock_skew_seconds), got 301", true},
+		{"over a lowered maximum", 31, 30,
+			"iam.clock_skew_seconds: must be at most 30 (iam.max_clock_skew_seconds), got 31", false},
+		{"within a raised maximum", 600, 900, "", true},
+		{"negative", -1, 0, "iam.clock_skew_seconds: must not be negative, got -1", false},
+		{"negative maximum", 0, -1, "iam.max_clock_skew_seconds: must not be negative, got -1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			c.IAM.ClockSkewSeconds = tt.skew
+			c.IAM.MaxClockSkewSeconds = tt.max
+
+			errs := &ConfigValidationError{}
+			c.validateClockSkew(errs)
+			switch {
+			case tt.wantErr == "" && len(errs.Errors) > 0:
+				t.Errorf("validateClockSkew() = %v, want no error", errs)
+			case tt.wantErr != "" && (len(errs.Errors) != 1 || errs.Error() != "invalid config: "+tt.wantErr):
+				t.Errorf("validateClockSkew() = %v, want %q", errs, tt.wantErr)
+			}
+
+			warned := slices.ContainsFunc(c.Warnings(), func(w string) bool {
+				return strings.HasPrefix(w, "iam.clock_skew_seconds")
+			})
+			if warned != tt.wantWarning {
+				t.Errorf("Warnings() warned about the clock skew = %v, want %v", warned, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestValidateJWKSMaxBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr bool
+	}{
+		{"unset", 0, true},
+		{"negative", -1, true},
+		{"one byte", 1, false},
+		{"default", 1 << 20, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{}
+			c.IAM.JWKSMaxBytes = tt.limit
+
+			var errs *ConfigValidationError
+			if err := c.Validate(); !errors.As(err, &errs) {
+				t.Fatalf("Validate() error = %v, want a ConfigValidationError", err)
+			}
+			if got := slices.Contains(fieldsOf(errs), "iam.jwks_max_bytes"); got != tt.wantErr {
+				t.Errorf("Validate() reported iam.jwks_max_bytes = %v, want %v", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeycloakConfigIssuer(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  KeycloakConfig
+		want string
+	}{
+		{"realm URL", KeycloakConfig{BaseURL: "https://kc.example.com", Realm: "test"}, "https://kc.example.com/realms/test"},
+		{"base URL with a trailing slash", KeycloakConfig{BaseURL: "https://kc.example.com/", Realm: "test"}, "https://kc.example.com/realms/test"},
+		{"override", KeycloakConfig{BaseURL: "http://keycloak:8080", Realm: "test", IssuerOverride: "https://auth.example.com/realms/test"},
+			"https://auth.example.com/realms/test"},
+		{"override with a trailing slash", KeycloakConfig{BaseURL: "http://keycloak:8080", Realm: "test", IssuerOverride: "https://auth.example.com/realms/test//"},
+			"https://auth.example.com/realms/test"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.Issuer(); got != tt.want {
+				t.Errorf("Issuer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateIssuerOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		override string
+		insecure bool
+		wantErr  string
+	}{
+		{"unset", "", false, ""},
+		{"https", "https://auth.example.com/realms/test", false, ""},
+		{"http", "http://keycloak:8080/realms/test", false,
+			"iam.keycloak.issuer_override: must use https unless iam.keycloak.allow_insecure_issuer is set"},
+		{"http allowed", "http://keycloak:8080/realms/test", true, ""},
+		{"relative", "/realms/test", false, `iam.keycloak.issuer_override: "/realms/test" is not an absolute http(s) URL`},
+		{"other scheme", "ftp://auth.example.com/realms/test", true,
+			`iam.keycloak.issuer_override: "ftp://auth.example.com/realms/test" is not an absolute http(s) URL`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := KeycloakConfig{IssuerOverride: tt.override, AllowInsecureIssuer: tt.insecure}
+			errs := &ConfigValidationError{}
+			validateIssuerOverride(errs, "iam.keycloak", &cfg)
+			switch {
+			case tt.wantErr == "" && len(errs.Errors) > 0:
+				t.Errorf("validateIssuerOverride() = %v, want no error", errs)
+			case tt.wantErr != "" && (len(errs.Errors) != 1 || errs.Error() != "invalid config: "+tt.wantErr):
+				t.Errorf("validateIssuerOverride() = %v, want %q", errs, tt.wantErr)
+			}
+		})
+	}
+
+	// Realms are checked under their own field
+	c := &Config{}
+	c.IAM.Keycloak.Realms = []KeycloakConfig{{Realm: "acme", IssuerOverride: "http://keycloak:8080/realms/acme"}}
+	var errs *ConfigValidationError
+	if err := c.Validate(); !errors.As(err, &errs) {
+		t.Fatalf("Validate() error = %v, want a ConfigValidationError", err)
+	}
+	if !slices.Contains(fieldsOf(errs), "iam.keycloak.realms[0].issuer_override") {
+		t.Errorf("Validate() reported %v, want iam.keycloak.realms[0].issuer_override", fieldsOf(errs))
+	}
+}
@@ -0,0 +1,29 @@
+// Package metrics exposes the bridge's operational counters through expvar,
+// served as JSON on /debug/vars in debug mode
+package metrics
+
+import "expvar"
+
+var (
+	// DegradedMode is 1 while token validations are served from the cache because
+	// the IAM provider is unavailable, 0 otherwise
+	DegradedMode = expvar.NewInt("iam_degraded_mode")
+	// DegradedValidations counts token validations served from the cache during an outage
+	DegradedValidations = expvar.NewInt("iam_degraded_validations_total")
+
+	// IdPCallsInFlight is the number of calls to the IdP running, bounded by
+	// iam.max_concurrent_validations
+	IdPCallsInFlight = expvar.NewInt("iam_idp_calls_in_flight")
+	// IdPCallsRejected counts calls to the IdP failed because the concurrency limit was
+	// reached and no slot freed up in time
+	IdPCallsRejected = expvar.NewInt("iam_idp_calls_rejected_total")
+
+	// HTTPRequests counts handled requests, keyed by "METHOD path status"
+	HTTPRequests = expvar.NewMap("http_requests_total")
+	// HTTPRequestSeconds sums request durations in seconds, keyed like HTTPRequests
+	HTTPRequestSeconds = expvar.NewMap("http_request_duration_seconds_sum")
+
+	// AuditEventsDropped counts audit events dropped because the audit sink was saturated
+	// or failed
+	AuditEventsDropped = expvar.NewInt("audit_events_dropped_total")
+)
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/audit"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// WithAuditLogger makes AuthMiddleware record the outcome of each authentication, with the
+// token's subject and client on success and the reason on failure
+func WithAuditLogger(auditLogger *audit.AuditLogger) AuthOption {
+	return func(o *authOptions) {
+		o.auditLogger = auditLogger
+	}
+}
+
+// auditAuthentication records an authentication outcome, if auditing is enabled: success
+// for tokenInfo when err is nil, failure otherwise
+func auditAuthentication(c *gin.Context, options *authOptions, tokenInfo *provider.TokenInfo, err error) {
+	if options.auditLogger == nil {
+		return
+	}
+
+	event := audit.Event{
+		Type:      audit.EventAuthentication,
+		Outcome:   audit.OutcomeSuccess,
+		RequestID: GetRequestID(c),
+		Method:    c.Request.Method,
+		Path:      c.FullPath(),
+	}
+	if ip := ClientIP(c.Request); ip != nil {
+		event.ClientIP = ip.String()
+	}
+	if tokenInfo != nil {
+		event.Subject = tokenInfo.Principal
+		event.ClientID = tokenInfo.ClientID()
+	}
+	if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Reason = err.Error()
+		var validationErr *provider.ValidationError
+		if errors.As(err, &validationErr) {
+			event.Reason = string(validationErr.Reason)
+		}
+	}
+	options.auditLogger.Log(c.Request.Context(), event)
+}
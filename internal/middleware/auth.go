@@ -0,0 +1,552 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/audit"
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+const (
+	schemeBearer = "Bearer"
+	schemeDPoP   = "DPoP"
+)
+
+// tokenInfoContextKey and authorizationContextKey key the validated token and its
+// authorization context in the request context. Being unexported struct types, they can't
+// collide with the keys of other packages, unlike string keys.
+type (
+	tokenInfoContextKey     struct{}
+	authorizationContextKey struct{}
+)
+
+var (
+	// ErrAuthenticationRequired is returned when a request carries no valid credentials (401)
+	ErrAuthenticationRequired = errors.New("authentication required")
+	// ErrInsufficientPermissions is returned when valid credentials lack a required grant (403)
+	ErrInsufficientPermissions = errors.New("insufficient permissions")
+	// ErrReauthenticationRequired is returned when the user last authenticated too long ago
+	// for the operation and must sign in again (401)
+	ErrReauthenticationRequired = errors.New("reauthentication required")
+	// ErrInsufficientAuthenticationLevel is returned when the user didn't authenticate with a
+	// required authentication context class (acr) and must step up (401)
+	ErrInsufficientAuthenticationLevel = errors.New("insufficient authentication level")
+	// ErrAuthenticationMethodRequired is returned when the user didn't authenticate with a
+	// required method (amr), such as a second factor (403)
+	ErrAuthenticationMethodRequired = errors.New("authentication method required")
+	// ErrClientNotAllowed is returned when the token was issued to a client outside the allowlist (403)
+	ErrClientNotAllowed = errors.New("client not allowed")
+	// ErrMultipleAuthorizationHeaders is returned when a request carries more than one
+	// Authorization header, which proxies may disagree on (400)
+	ErrMultipleAuthorizationHeaders = errors.New("multiple authorization headers")
+)
+
+// AudienceResolver computes the audiences a token must carry for the given request
+type AudienceResolver func(*http.Request) []string
+
+// AuthOption configures AuthMiddleware
+type AuthOption func(*authOptions)
+
+type authOptions struct {
+	audienceResolver AudienceResolver
+	dpop             bool
+	mtlsBoundTokens  bool
+	allowedClients   map[string]struct{}
+	clientRateLimits *ClientRateLimits
+	clock            clock.Clock
+	loginURL         LoginURLFunc
+	isBrowser        BrowserDetector
+	// allowMultipleAuthHeaders authenticates with the first of several Authorization headers
+	allowMultipleAuthHeaders bool
+	tokenExtractor           TokenExtractor
+	auditLogger              *audit.AuditLogger
+}
+
+// WithAudienceResolver makes AuthMiddleware compute the accepted audiences per request,
+// overriding the provider's static audience list. This lets one bridge front several
+// services that each expect their own audience.
+func WithAudienceResolver(resolver AudienceResolver) AuthOption {
+	return func(o *authOptions) {
+		o.audienceResolver = resolver
+	}
+}
+
+// WithClock sets the time source for DPoP proof and authentication age checks.
+// Without it, the system clock is used.
+func WithClock(c clock.Clock) AuthOption {
+	return func(o *authOptions) {
+		o.clock = c
+	}
+}
+
+// WithDPoP enables DPoP-bound tokens (RFC 9449): requests using the DPoP scheme must carry
+// a valid proof bound to the token, and bound tokens are rejected under the Bearer scheme
+func WithDPoP() AuthOption {
+	return func(o *authOptions) {
+		o.dpop = true
+	}
+}
+
+// WithMTLSBoundTokens requires certificate-bound tokens (RFC 8705): every token must carry
+// a cnf.x5t#S256 confirmation matching the client certificate presented over mutual TLS
+func WithMTLSBoundTokens() AuthOption {
+	return func(o *authOptions) {
+		o.mtlsBoundTokens = true
+	}
+}
+
+// WithAllowedClients accepts only tokens issued to one of the given clients, by their
+// azp or client_id claim
+func WithAllowedClients(clients ...string) AuthOption {
+	return func(o *authOptions) {
+		o.allowedClients = make(map[string]struct{}, len(clients))
+		for _, client := range clients {
+			o.allowedClients[client] = struct{}{}
+		}
+	}
+}
+
+// WithTokenExtractor sets where the token of a request is looked for, such as a chain of
+// TokenExtractors. Without it, only the Authorization header is.
+func WithTokenExtractor(extractor TokenExtractor) AuthOption {
+	return func(o *authOptions) {
+		if extractor != nil {
+			o.tokenExtractor = extractor
+		}
+	}
+}
+
+// WithMultipleAuthHeaders accepts requests carrying several Authorization headers and
+// authenticates them with the first, instead of rejecting them with a 400
+func WithMultipleAuthHeaders() AuthOption {
+	return func(o *authOptions) {
+		o.allowMultipleAuthHeaders = true
+	}
+}
+
+// WithClientRateLimits applies the per-client rate tiers once the token is validated
+func WithClientRateLimits(limits *ClientRateLimits) AuthOption {
+	return func(o *authOptions) {
+		o.clientRateLimits = limits
+	}
+}
+
+// AuthMiddleware validates the bearer token and stores the token information in the context.
+// Requests already authenticated by SessionMiddleware skip the validation, but their token
+// must pass the same checks, such as the allowed clients and token binding, and is validated
+// again against the audiences of WithAudienceResolver. Requests with more
+// than one Authorization header are rejected unless WithMultipleAuthHeaders is set, since a
+// proxy in front may have authorized a different one than the first.
+func AuthMiddleware(iamProvider provider.IAMProvider, opts ...AuthOption) gin.HandlerFunc {
+	options := &authOptions{isBrowser: PrefersHTML, tokenExtractor: HeaderTokenExtractor()}
+	for _, opt := range opts {
+		opt(options)
+	}
+	now := clock.OrSystem(options.clock).Now
+
+	return func(c *gin.Context) {
+		if !options.allowMultipleAuthHeaders && len(c.Request.Header.Values("Authorization")) > 1 {
+			auditAuthentication(c, options, nil, ErrMultipleAuthorizationHeaders)
+			abortWithError(c, ErrMultipleAuthorizationHeaders)
+			return
+		}
+
+		// Session tokens were presented without a scheme, so DPoP-bound ones have no proof
+		if tokenInfo := GetTokenInfo(c); tokenInfo != nil {
+			// SessionMiddleware validated the token without the route's audiences, so it is
+			// validated again with them; validations are cached per audience set
+			if sess := GetSession(c); sess != nil && options.audienceResolver != nil {
+				ctx := provider.WithExpectedAudiences(c.Request.Context(), options.audienceResolver(c.Request))
+				validated, err := iamProvider.ValidateToken(ctx, sess.AccessToken)
+				if err != nil {
+					auditAuthentication(c, options, nil, err)
+					unauthenticated(c, options, err)
+					return
+				}
+				tokenInfo = validated
+				setTokenInfo(c, tokenInfo)
+			}
+			if checkToken(c, options, "", "", tokenInfo, now()) {
+				auditAuthentication(c, options, tokenInfo, nil)
+				c.Next()
+			}
+			return
+		}
+
+		scheme, token, err := options.tokenExtractor.Extract(c)
+		if err != nil {
+			auditAuthentication(c, options, nil, err)
+			unauthenticated(c, options, err)
+			return
+		}
+		if token == "" || (scheme == schemeDPoP && !options.dpop) {
+			auditAuthentication(c, options, nil, ErrAuthenticationRequired)
+			unauthenticated(c, options, ErrAuthenticationRequired)
+			return
+		}
+
+		ctx := c.Request.Context()
+		if options.audienceResolver != nil {
+			ctx = provider.WithExpectedAudiences(ctx, options.audienceResolver(c.Request))
+		}
+
+		tokenInfo, err := iamProvider.ValidateToken(ctx, token)
+		if err != nil {
+			auditAuthentication(c, options, nil, err)
+			unauthenticated(c, options, err)
+			return
+		}
+
+		if !checkToken(c, options, scheme, token, tokenInfo, now()) {
+			return
+		}
+
+		setTokenInfo(c, tokenInfo)
+		auditAuthentication(c, options, tokenInfo, nil)
+
+		c.Next()
+	}
+}
+
+// checkToken runs the checks of a validated token: its binding to the request, the allowed
+// clients and the client's rate tier. Rejected requests are aborted and false is returned.
+func checkToken(c *gin.Context, options *authOptions, scheme, token string, tokenInfo *provider.TokenInfo, now time.Time) bool {
+	if options.dpop {
+		if err := checkDPoP(c, scheme, token, tokenInfo, now); err != nil {
+			auditAuthentication(c, options, tokenInfo, err)
+			abortWithError(c, err)
+			return false
+		}
+	}
+
+	if options.mtlsBoundTokens {
+		if err := provider.VerifyCertificateBinding(clientCertificate(c), tokenInfo); err != nil {
+			auditAuthentication(c, options, tokenInfo, err)
+			abortWithError(c, err)
+			return false
+		}
+	}
+
+	if options.allowedClients != nil {
+		if _, ok := options.allowedClients[tokenInfo.ClientID()]; !ok {
+			auditAuthentication(c, options, tokenInfo, ErrClientNotAllowed)
+			abortWithError(c, ErrClientNotAllowed)
+			return false
+		}
+	}
+
+	return options.clientRateLimits == nil || options.clientRateLimits.allow(c, tokenInfo.ClientID())
+}
+
+// RequireRoles allows the request only if the token carries all given roles.
+// It responds 401 when no token information is present and 403 when roles are missing.
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorization := GetAuthorization(c)
+		if authorization == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		for _, role := range roles {
+			if !authorization.HasRole(role) {
+				abortWithError(c, ErrInsufficientPermissions)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireGroups allows the request only if the token's subject is a member of all given
+// groups, where membership of a nested group such as /org/team counts for /org.
+// It responds 401 when no token information is present and 403 when groups are missing.
+func RequireGroups(groups ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorization := GetAuthorization(c)
+		if authorization == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		for _, group := range groups {
+			if !authorization.HasGroup(group) {
+				abortWithError(c, ErrInsufficientPermissions)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScopes allows the request only if the token was granted all given scopes.
+// It responds 401 when no token information is present and 403 when scopes are missing.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authorization := GetAuthorization(c)
+		if authorization == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		for _, scope := range scopes {
+			if !authorization.HasScope(scope) {
+				abortWithError(c, ErrInsufficientPermissions)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRecentAuth allows the request only if the user authenticated at the IdP within
+// maxAge, according to the token's auth_time claim, for step-up authentication of sensitive
+// operations. Tokens without auth_time are rejected. Stale authentications get a 401 whose
+// challenge (RFC 9470) asks the client to sign the user in again with the given max_age.
+func RequireRecentAuth(maxAge time.Duration, opts ...AuthOption) gin.HandlerFunc {
+	options := &authOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	now := clock.OrSystem(options.clock).Now
+
+	challenge := fmt.Sprintf(`Bearer error="insufficient_user_authentication", `+
+		`error_description="A more recent authentication is required", max_age=%d`, int64(maxAge/time.Second))
+
+	return func(c *gin.Context) {
+		tokenInfo := GetTokenInfo(c)
+		if tokenInfo == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		if tokenInfo.AuthTime == 0 || now().Sub(time.Unix(tokenInfo.AuthTime, 0)) > maxAge {
+			c.Header("WWW-Authenticate", challenge)
+			abortWithError(c, ErrReauthenticationRequired)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireACR allows the request only if the user authenticated with one of the given
+// authentication context classes, according to the token's acr claim, such as the level of
+// assurance of an MFA login. Tokens without acr are rejected. Other classes get a 401 whose
+// challenge (RFC 9470) asks the client to sign the user in again with one of the values.
+func RequireACR(values ...string) gin.HandlerFunc {
+	challenge := fmt.Sprintf(`Bearer error="insufficient_user_authentication", `+
+		`error_description="A different authentication level is required", acr_values=%q`, strings.Join(values, " "))
+
+	return func(c *gin.Context) {
+		tokenInfo := GetTokenInfo(c)
+		if tokenInfo == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		acr := tokenInfo.ACR()
+		if acr == "" || !slices.Contains(values, acr) {
+			c.Header("WWW-Authenticate", challenge)
+			abortWithError(c, ErrInsufficientAuthenticationLevel)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAMR allows the request only if the user authenticated with all given methods,
+// according to the token's amr claim, e.g. RequireAMR("mfa") on admin routes. Tokens without
+// amr are rejected. It responds 401 when no token information is present and 403 when a
+// method is missing.
+func RequireAMR(methods ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenInfo := GetTokenInfo(c)
+		if tokenInfo == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		amr := tokenInfo.AMR()
+		for _, method := range methods {
+			if !slices.Contains(amr, method) {
+				abortWithError(c, ErrAuthenticationMethodRequired)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAuthorizedParty allows the request only if the token was issued to one of the
+// given clients (azp claim). It responds 401 when no token information is present and
+// 403 for tokens of other clients.
+func RequireAuthorizedParty(clients ...string) gin.HandlerFunc {
+	allowed := make(map[string]struct{}, len(clients))
+	for _, client := range clients {
+		allowed[client] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		tokenInfo := GetTokenInfo(c)
+		if tokenInfo == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		if _, ok := allowed[tokenInfo.AuthorizedParty]; !ok {
+			abortWithError(c, ErrInsufficientPermissions)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireIssuer allows the request only if the token was issued by one of the given
+// issuers (iss claim), e.g. to keep internal routes to internally issued tokens when the
+// provider accepts several issuers. Trailing slashes are ignored, as by the provider. It
+// responds 401 when no token information is present and 403 for tokens of other issuers.
+func RequireIssuer(issuers ...string) gin.HandlerFunc {
+	allowed := make(map[string]struct{}, len(issuers))
+	for _, issuer := range issuers {
+		allowed[strings.TrimRight(issuer, "/")] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		authorization := GetAuthorization(c)
+		if authorization == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		if _, ok := allowed[strings.TrimRight(authorization.Issuer(), "/")]; !ok {
+			abortWithError(c, ErrInsufficientPermissions)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// setTokenInfo stores the validated token information, its authorization context, subject
+// and metadata in the request context
+func setTokenInfo(c *gin.Context, tokenInfo *provider.TokenInfo) {
+	ctx := context.WithValue(c.Request.Context(), tokenInfoContextKey{}, tokenInfo)
+	ctx = context.WithValue(ctx, authorizationContextKey{}, NewAuthorizationContext(tokenInfo))
+	ctx = logger.WithSubject(ctx, tokenInfo.Principal)
+	c.Request = c.Request.WithContext(provider.WithTokenMetadata(ctx, tokenInfo.Metadata))
+}
+
+// GetTokenInfo retrieves the validated token information from the context
+func GetTokenInfo(c *gin.Context) *provider.TokenInfo {
+	return TokenInfoFromContext(c.Request.Context())
+}
+
+// TokenInfoFromContext returns the validated token information of the request ctx belongs
+// to, or nil when the request is not authenticated. It serves code that is handed the
+// request context rather than the gin context.
+func TokenInfoFromContext(ctx context.Context) *provider.TokenInfo {
+	tokenInfo, _ := ctx.Value(tokenInfoContextKey{}).(*provider.TokenInfo)
+	return tokenInfo
+}
+
+// GetLocale returns the locale of the authenticated subject as a BCP 47 tag, e.g. for the
+// Content-Language header, or "" when the request is not authenticated or its token has none
+func GetLocale(c *gin.Context) string {
+	if tokenInfo := GetTokenInfo(c); tokenInfo != nil {
+		return tokenInfo.Locale
+	}
+	return ""
+}
+
+// GetZoneInfo returns the IANA time zone of the authenticated subject, or "" when the
+// request is not authenticated or its token has none
+func GetZoneInfo(c *gin.Context) string {
+	if tokenInfo := GetTokenInfo(c); tokenInfo != nil {
+		return tokenInfo.ZoneInfo
+	}
+	return ""
+}
+
+// ExtractToken returns the token from the Authorization header, without its scheme prefix
+func ExtractToken(c *gin.Context) string {
+	_, token := extractAuthorization(c)
+	return token
+}
+
+// extractAuthorization splits the Authorization header into its Bearer or DPoP scheme and token.
+// Headers without a known scheme are returned whole with an empty scheme.
+func extractAuthorization(c *gin.Context) (scheme, token string) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return "", ""
+	}
+
+	if i := strings.IndexByte(header, ' '); i > 0 {
+		switch prefix := header[:i]; {
+		case strings.EqualFold(prefix, schemeBearer):
+			return schemeBearer, header[i+1:]
+		case strings.EqualFold(prefix, schemeDPoP):
+			return schemeDPoP, header[i+1:]
+		}
+	}
+
+	return "", header
+}
+
+// checkDPoP enforces the DPoP proof for DPoP-scheme requests and stops bound tokens
+// from being downgraded to the Bearer scheme
+func checkDPoP(c *gin.Context, scheme, token string, tokenInfo *provider.TokenInfo, now time.Time) error {
+	if scheme != schemeDPoP {
+		if tokenInfo.ConfirmationJKT() != "" {
+			return provider.ErrDPoPProofInvalid
+		}
+		return nil
+	}
+
+	proofs := c.Request.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return provider.ErrDPoPProofInvalid
+	}
+
+	return provider.VerifyDPoPProof(proofs[0], c.Request.Method, requestURL(c), tokenInfo, token, now)
+}
+
+// clientCertificate returns the leaf certificate the client presented over mutual TLS, if any
+func clientCertificate(c *gin.Context) *x509.Certificate {
+	if c.Request.TLS == nil || len(c.Request.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return c.Request.TLS.PeerCertificates[0]
+}
+
+// requestURL reconstructs the absolute URL of the request without query or fragment
+func requestURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + c.Request.URL.Path
+}
+
+// abortWithError records the error for ErrorHandlerMiddleware and stops the chain
+func abortWithError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
@@ -0,0 +1,370 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+	"github.com/zahidhasanpapon/iam-bridge/internal/session"
+)
+
+// unusedProvider fails the test when a token is validated through it
+type unusedProvider struct {
+	provider.IAMProvider
+}
+
+func TestAuthMiddlewareChecksSessionTokens(t *testing.T) {
+	sessionToken := &provider.TokenInfo{UserID: "user-1", AuthorizedParty: "web"}
+
+	tests := []struct {
+		name       string
+		opts       []AuthOption
+		tokenInfo  *provider.TokenInfo
+		headers    []string
+		wantStatus int
+	}{
+		{"allowed client", []AuthOption{WithAllowedClients("web")}, sessionToken, nil, http.StatusNoContent},
+		{"client not allowed", []AuthOption{WithAllowedClients("mobile")}, sessionToken, nil, http.StatusForbidden},
+		{"multiple authorization headers", nil, sessionToken, []string{"Bearer a", "Bearer b"}, http.StatusBadRequest},
+		{"mtls bound tokens without a certificate", []AuthOption{WithMTLSBoundTokens()}, sessionToken, nil, http.StatusUnauthorized},
+		{
+			name:       "dpop-bound token without a proof",
+			opts:       []AuthOption{WithDPoP()},
+			tokenInfo:  &provider.TokenInfo{UserID: "user-1", Claims: provider.Claims{"cnf": map[string]interface{}{"jkt": "thumbprint"}}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{"unbound token with dpop", []AuthOption{WithDPoP()}, sessionToken, nil, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware())
+			router.Use(func(c *gin.Context) { setTokenInfo(c, tt.tokenInfo) })
+			router.GET("/", AuthMiddleware(unusedProvider{}, tt.opts...), func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, header := range tt.headers {
+				req.Header.Add("Authorization", header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+// stubProvider accepts only its token, returning its token information
+type stubProvider struct {
+	provider.IAMProvider
+	token     string
+	tokenInfo *provider.TokenInfo
+}
+
+func (s stubProvider) ValidateToken(_ context.Context, token string) (*provider.TokenInfo, error) {
+	if token != s.token {
+		return nil, provider.ErrTokenInvalid
+	}
+	return s.tokenInfo, nil
+}
+
+func TestRequireRolesAndScopesStatus(t *testing.T) {
+	p := stubProvider{token: "valid", tokenInfo: &provider.TokenInfo{
+		UserID: "user-1",
+		Roles:  []string{"user"},
+		Scopes: []string{"orders:read"},
+	}}
+
+	tests := []struct {
+		name       string
+		require    gin.HandlerFunc
+		header     string
+		wantStatus int
+	}{
+		{"roles without a token", RequireRoles("user"), "", http.StatusUnauthorized},
+		{"roles with an invalid token", RequireRoles("user"), "Bearer forged", http.StatusUnauthorized},
+		{"missing role", RequireRoles("user", "admin"), "Bearer valid", http.StatusForbidden},
+		{"granted role", RequireRoles("user"), "Bearer valid", http.StatusNoContent},
+		{"scopes without a token", RequireScopes("orders:read"), "", http.StatusUnauthorized},
+		{"scopes with an invalid token", RequireScopes("orders:read"), "Bearer forged", http.StatusUnauthorized},
+		{"missing scope", RequireScopes("orders:write"), "Bearer valid", http.StatusForbidden},
+		{"granted scope", RequireScopes("orders:read"), "Bearer valid", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware())
+			router.GET("/", AuthMiddleware(p), tt.require, func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestRequireRolesWithoutAuthMiddleware(t *testing.T) {
+	router := gin.New()
+	router.Use(ErrorHandlerMiddleware())
+	router.GET("/", RequireRoles("user"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+// stringKey is a string context key, as other middleware might use
+type stringKey string
+
+func TestTokenInfoKeysDontCollide(t *testing.T) {
+	forged := &provider.TokenInfo{UserID: "attacker", Roles: []string{"admin"}}
+	p := stubProvider{token: "valid", tokenInfo: &provider.TokenInfo{UserID: "user-1", Roles: []string{"user"}}}
+	// forge stores a token under the names the bridge once used, in the gin and the request context
+	forge := func(c *gin.Context) {
+		for _, name := range []string{"token_info", "authorization"} {
+			c.Set(name, forged)
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), stringKey(name), forged))
+		}
+	}
+
+	tests := []struct {
+		name       string
+		handlers   []gin.HandlerFunc
+		header     string
+		wantStatus int
+		wantUser   string
+	}{
+		{"forged before authentication", []gin.HandlerFunc{forge, AuthMiddleware(p)}, "", http.StatusUnauthorized, ""},
+		{"forged role before the role check", []gin.HandlerFunc{AuthMiddleware(p), forge, RequireRoles("admin")}, "Bearer valid", http.StatusForbidden, ""},
+		{"forged after authentication", []gin.HandlerFunc{AuthMiddleware(p), forge}, "Bearer valid", http.StatusOK, "user-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware())
+			handlers := append(tt.handlers, func(c *gin.Context) {
+				c.String(http.StatusOK, GetTokenInfo(c).UserID)
+			})
+			router.GET("/", handlers...)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantUser != "" && rec.Body.String() != tt.wantUser {
+				t.Errorf("GetTokenInfo().UserID = %q, want %q", rec.Body, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestRequireAuthorizedParty(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokenInfo  *provider.TokenInfo
+		wantStatus int
+	}{
+		{"no token", nil, http.StatusUnauthorized},
+		{"allowed client", &provider.TokenInfo{AuthorizedParty: "web"}, http.StatusNoContent},
+		{"other client", &provider.TokenInfo{AuthorizedParty: "mobile"}, http.StatusForbidden},
+		{"no azp", &provider.TokenInfo{}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware())
+			router.Use(func(c *gin.Context) {
+				if tt.tokenInfo != nil {
+					setTokenInfo(c, tt.tokenInfo)
+				}
+			})
+			router.GET("/", RequireAuthorizedParty("web", "cli"), func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequireIssuer(t *testing.T) {
+	const (
+		internal = "https://kc.example.com/realms/internal"
+		partners = "https://kc.example.com/realms/partners"
+		other    = "https://kc.example.com/realms/other"
+	)
+	issuedBy := func(issuer string) *provider.TokenInfo {
+		return &provider.TokenInfo{Metadata: provider.TokenMetadata{Issuer: issuer}}
+	}
+
+	tests := []struct {
+		name       string
+		tokenInfo  *provider.TokenInfo
+		wantStatus int
+	}{
+		{"no token", nil, http.StatusUnauthorized},
+		{"allowed issuer", issuedBy(internal), http.StatusNoContent},
+		{"allowed issuer with a trailing slash", issuedBy(internal + "/"), http.StatusNoContent},
+		{"issuer allowed with a trailing slash", issuedBy(partners), http.StatusNoContent},
+		{"other issuer", issuedBy(other), http.StatusForbidden},
+		{"issuer prefix", issuedBy(internal + "-staging"), http.StatusForbidden},
+		{"no iss", issuedBy(""), http.StatusForbidden},
+		{"only a slash", issuedBy("/"), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware())
+			router.Use(func(c *gin.Context) {
+				if tt.tokenInfo != nil {
+					setTokenInfo(c, tt.tokenInfo)
+				}
+			})
+			router.GET("/", RequireIssuer(internal, partners+"/"), func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareMultipleAuthorizationHeaders(t *testing.T) {
+	p := stubProvider{token: "valid", tokenInfo: &provider.TokenInfo{UserID: "user-1"}}
+
+	tests := []struct {
+		name       string
+		opts       []AuthOption
+		headers    []string
+		wantStatus int
+	}{
+		{"one header", nil, []string{"Bearer valid"}, http.StatusNoContent},
+		{"two headers", nil, []string{"Bearer valid", "Bearer forged"}, http.StatusBadRequest},
+		{"the same header twice", nil, []string{"Bearer valid", "Bearer valid"}, http.StatusBadRequest},
+		{"an empty second header", nil, []string{"Bearer valid", ""}, http.StatusBadRequest},
+		{"allowed, first is valid", []AuthOption{WithMultipleAuthHeaders()}, []string{"Bearer valid", "Bearer forged"}, http.StatusNoContent},
+		{"allowed, first is forged", []AuthOption{WithMultipleAuthHeaders()}, []string{"Bearer forged", "Bearer valid"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware())
+			router.GET("/", AuthMiddleware(p, tt.opts...), func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for _, header := range tt.headers {
+				req.Header.Add("Authorization", header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "MULTIPLE_AUTHORIZATION_HEADERS") {
+				t.Errorf("body = %s, want the MULTIPLE_AUTHORIZATION_HEADERS code", rec.Body)
+			}
+		})
+	}
+}
+
+// sessionStore is a session store holding one session for every request
+type sessionStore struct {
+	session.Store
+	sess *session.Session
+}
+
+func (s sessionStore) Get(*gin.Context) (*session.Session, error) {
+	return s.sess, nil
+}
+
+// audienceProvider accepts tokens named after their audience, when expected
+type audienceProvider struct {
+	provider.IAMProvider
+}
+
+func (audienceProvider) ValidateToken(ctx context.Context, token string) (*provider.TokenInfo, error) {
+	if expected, ok := provider.ExpectedAudiences(ctx); ok && !slices.Contains(expected, token) {
+		return nil, provider.ErrInvalidAudience
+	}
+	return &provider.TokenInfo{UserID: "user-1", Audience: []string{token}}, nil
+}
+
+func TestAuthMiddlewareResolvesSessionAudiences(t *testing.T) {
+	resolver := func(r *http.Request) []string {
+		return []string{strings.TrimPrefix(r.URL.Path, "/")}
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		wantStatus int
+	}{
+		{"session of the route's audience", "orders", "/orders", http.StatusNoContent},
+		{"session of another audience", "billing", "/orders", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware())
+			router.Use(SessionMiddleware(sessionStore{sess: &session.Session{
+				AccessToken: tt.token,
+				ExpiresAt:   time.Now().Add(time.Hour),
+			}}, audienceProvider{}, nil))
+			router.GET("/:service", AuthMiddleware(audienceProvider{}, WithAudienceResolver(resolver)), func(c *gin.Context) {
+				c.Status(http.StatusNoContent)
+			})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
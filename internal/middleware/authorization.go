@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// AuthorizationContext holds what authorization middleware check of the request's token,
+// extracted into sets once when the token is validated, so routes with several checks
+// don't scan the token's claims again for each: every lookup is constant time.
+type AuthorizationContext struct {
+	tokenInfo *provider.TokenInfo
+	roles     map[string]struct{}
+	scopes    map[string]struct{}
+	groups    map[string]struct{}
+	// groupParents holds the parent paths of nested groups, as matched by HasGroup
+	groupParents map[string]struct{}
+}
+
+// NewAuthorizationContext extracts the authorization context of a token
+func NewAuthorizationContext(tokenInfo *provider.TokenInfo) *AuthorizationContext {
+	a := &AuthorizationContext{
+		tokenInfo:    tokenInfo,
+		roles:        stringSet(tokenInfo.Roles),
+		scopes:       stringSet(tokenInfo.Scopes),
+		groups:       stringSet(tokenInfo.Groups),
+		groupParents: make(map[string]struct{}),
+	}
+	for _, group := range tokenInfo.Groups {
+		if !strings.HasPrefix(group, "/") {
+			continue
+		}
+		for i := 0; i < len(group); i++ {
+			if group[i] == '/' {
+				a.groupParents[group[:i]] = struct{}{}
+			}
+		}
+	}
+	return a
+}
+
+// GetAuthorization returns the authorization context of the request's token, or nil when
+// the request is not authenticated
+func GetAuthorization(c *gin.Context) *AuthorizationContext {
+	ctx := c.Request.Context()
+	if a, ok := ctx.Value(authorizationContextKey{}).(*AuthorizationContext); ok {
+		return a
+	}
+
+	tokenInfo := TokenInfoFromContext(ctx)
+	if tokenInfo == nil {
+		return nil
+	}
+	a := NewAuthorizationContext(tokenInfo)
+	c.Request = c.Request.WithContext(context.WithValue(ctx, authorizationContextKey{}, a))
+	return a
+}
+
+// TokenInfo returns the token the context was extracted from
+func (a *AuthorizationContext) TokenInfo() *provider.TokenInfo {
+	return a.tokenInfo
+}
+
+// HasRole reports whether the token carries the given role
+func (a *AuthorizationContext) HasRole(role string) bool {
+	_, ok := a.roles[role]
+	return ok
+}
+
+// HasScope reports whether the token was granted the given scope
+func (a *AuthorizationContext) HasScope(scope string) bool {
+	_, ok := a.scopes[scope]
+	return ok
+}
+
+// HasGroup reports whether the token's subject is a member of the given group, with the
+// nested group semantics of provider.TokenInfo.HasGroup
+func (a *AuthorizationContext) HasGroup(group string) bool {
+	if _, ok := a.groups[group]; ok {
+		return true
+	}
+	if !strings.HasPrefix(group, "/") {
+		return false
+	}
+	_, ok := a.groupParents[strings.TrimSuffix(group, "/")]
+	return ok
+}
+
+// Issuer returns the token's issuer (iss claim)
+func (a *AuthorizationContext) Issuer() string {
+	return a.tokenInfo.Metadata.Issuer
+}
+
+// stringSet returns the set of the given values
+func stringSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
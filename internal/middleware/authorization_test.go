@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+func TestAuthorizationContextMatchesTokenInfo(t *testing.T) {
+	tokenInfo := &provider.TokenInfo{
+		Roles:  []string{"admin", "user"},
+		Scopes: []string{"openid", "profile"},
+		Groups: []string{"/org/team/backend", "/ops", "flat"},
+	}
+	a := NewAuthorizationContext(tokenInfo)
+
+	for _, role := range []string{"admin", "user", "viewer", ""} {
+		if got, want := a.HasRole(role), tokenInfo.HasRole(role); got != want {
+			t.Errorf("HasRole(%q) = %v, want %v", role, got, want)
+		}
+	}
+	for _, scope := range []string{"openid", "profile", "email", ""} {
+		if got, want := a.HasScope(scope), tokenInfo.HasScope(scope); got != want {
+			t.Errorf("HasScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+	for _, group := range []string{
+		"/org/team/backend", "/org/team", "/org", "/org/", "/org/team/",
+		"/org/te", "/org/team/backend/db", "/ops", "/ops/", "flat", "/flat", "fla", "/",
+	} {
+		if got, want := a.HasGroup(group), tokenInfo.HasGroup(group); got != want {
+			t.Errorf("HasGroup(%q) = %v, want %v", group, got, want)
+		}
+	}
+}
+
+func TestGetAuthorization(t *testing.T) {
+	tokenInfo := &provider.TokenInfo{Roles: []string{"admin"}}
+
+	tests := []struct {
+		name string
+		ctx  func(context.Context) context.Context
+		want bool
+	}{
+		{"unauthenticated", func(ctx context.Context) context.Context { return ctx }, false},
+		{"token info only", func(ctx context.Context) context.Context {
+			return context.WithValue(ctx, tokenInfoContextKey{}, tokenInfo)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req.WithContext(tt.ctx(req.Context()))
+
+			first := GetAuthorization(c)
+			if (first != nil) != tt.want {
+				t.Fatalf("GetAuthorization() = %v, want present %v", first, tt.want)
+			}
+			if first == nil {
+				return
+			}
+			if first.TokenInfo() != tokenInfo || !first.HasRole("admin") {
+				t.Errorf("GetAuthorization() is not the context of the request's token")
+			}
+			// The context is extracted once, then reused by later checks
+			if second := GetAuthorization(c); second != first {
+				t.Errorf("GetAuthorization() extracted the context again")
+			}
+		})
+	}
+}
+
+// routeToken is a token of a realm with many roles and groups, as checked by routes
+// stacking RequireRoles, RequireScopes and RequireGroups
+func routeToken() *provider.TokenInfo {
+	tokenInfo := &provider.TokenInfo{Scopes: []string{"openid", "profile", "email", "orders:read", "orders:write"}}
+	for i := 0; i < 50; i++ {
+		tokenInfo.Roles = append(tokenInfo.Roles, fmt.Sprintf("role-%d", i))
+	}
+	for i := 0; i < 20; i++ {
+		tokenInfo.Groups = append(tokenInfo.Groups, fmt.Sprintf("/org/dept-%d/team-%d", i, i))
+	}
+	return tokenInfo
+}
+
+// authorizer is checked by a route's authorization middleware
+type authorizer interface {
+	HasRole(string) bool
+	HasScope(string) bool
+	HasGroup(string) bool
+}
+
+// checkRoute runs the checks of a route with three role, two scope and two group checks
+func checkRoute(b *testing.B, a authorizer) {
+	if !a.HasRole("role-49") || !a.HasRole("role-25") || !a.HasRole("role-0") ||
+		!a.HasScope("orders:write") || !a.HasScope("openid") ||
+		!a.HasGroup("/org/dept-19") || !a.HasGroup("/org/dept-10/team-10") {
+		b.Fatal("route checks failed")
+	}
+}
+
+func BenchmarkRouteChecks(b *testing.B) {
+	tokenInfo := routeToken()
+
+	b.Run("TokenInfo", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			checkRoute(b, tokenInfo)
+		}
+	})
+	b.Run("AuthorizationContext", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			checkRoute(b, NewAuthorizationContext(tokenInfo))
+		}
+	})
+	b.Run("AuthorizationContext reused", func(b *testing.B) {
+		a := NewAuthorizationContext(tokenInfo)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			checkRoute(b, a)
+		}
+	})
+}
+
+func BenchmarkRequireChain(b *testing.B) {
+	tokenInfo := routeToken()
+	router := gin.New()
+	router.Use(ErrorHandlerMiddleware())
+	router.Use(func(c *gin.Context) {
+		setTokenInfo(c, tokenInfo)
+	})
+	router.GET("/",
+		RequireRoles("role-49", "role-25", "role-0"),
+		RequireScopes("orders:write", "openid"),
+		RequireGroups("/org/dept-19", "/org/dept-10/team-10"),
+		func(c *gin.Context) {
+			c.Status(http.StatusNoContent)
+		})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			b.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+		}
+	}
+}
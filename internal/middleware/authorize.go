@@ -0,0 +1,397 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// Rule is a compiled authorization expression over a token's claims. The grammar is:
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | primary
+//	primary    = "(" expr ")" | "true" | "false" | check | comparison
+//	check      = ("has_role" | "has_scope" | "has_group") "(" string ")" | "claim" "(" string ")"
+//	comparison = "claim" "(" string ")" ("==" | "!=") string
+//
+// has_role, has_scope and has_group test the token's roles, scopes and groups the way
+// RequireRoles, RequireScopes and RequireGroups do. claim compares a claim, addressed by a
+// path as taken by provider.Claims.Get, such as "realm_access.roles" or a URL-style claim
+// name; list claims equal a string they contain. A bare
+// claim(...) is true when the claim is present and neither false nor empty. Strings are
+// double-quoted with Go escaping.
+type Rule struct {
+	expr string
+	root ruleNode
+}
+
+// CompileRule parses an authorization expression
+func CompileRule(expr string) (*Rule, error) {
+	p := &ruleParser{lexer: ruleLexer{input: expr}}
+	if err := p.advance(); err != nil {
+		return nil, p.errorf("%v", err)
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return &Rule{expr: expr, root: root}, nil
+}
+
+// String returns the expression the rule was compiled from
+func (r *Rule) String() string {
+	return r.expr
+}
+
+// Allows evaluates the rule against the token
+func (r *Rule) Allows(tokenInfo *provider.TokenInfo) bool {
+	return r.root.eval(tokenInfo)
+}
+
+// Authorize allows the request only if the token satisfies the authorization expression,
+// such as `has_role("admin") || (has_scope("write") && claim("dept") == "eng")`. See Rule for
+// the grammar. The expression is compiled once; an invalid one panics at setup, like
+// regexp.MustCompile. It responds 401 when no token information is present and 403 when
+// the expression is not satisfied.
+func Authorize(expr string) gin.HandlerFunc {
+	rule, err := CompileRule(expr)
+	if err != nil {
+		panic(err)
+	}
+	return AuthorizeRule(rule)
+}
+
+// AuthorizeRule is Authorize with a rule compiled by CompileRule
+func AuthorizeRule(rule *Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenInfo := GetTokenInfo(c)
+		if tokenInfo == nil {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		if !rule.Allows(tokenInfo) {
+			abortWithError(c, ErrInsufficientPermissions)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// ruleNode is a node of a compiled rule
+type ruleNode interface {
+	eval(tokenInfo *provider.TokenInfo) bool
+}
+
+type (
+	orNode    struct{ left, right ruleNode }
+	andNode   struct{ left, right ruleNode }
+	notNode   struct{ operand ruleNode }
+	constNode bool
+	roleNode  string
+	scopeNode string
+	groupNode string
+	claimNode struct {
+		path string
+	}
+	compareNode struct {
+		path   string
+		value  string
+		negate bool
+	}
+)
+
+func (n orNode) eval(t *provider.TokenInfo) bool  { return n.left.eval(t) || n.right.eval(t) }
+func (n andNode) eval(t *provider.TokenInfo) bool { return n.left.eval(t) && n.right.eval(t) }
+func (n notNode) eval(t *provider.TokenInfo) bool { return !n.operand.eval(t) }
+func (n constNode) eval(*provider.TokenInfo) bool { return bool(n) }
+
+func (n roleNode) eval(t *provider.TokenInfo) bool  { return t.HasRole(string(n)) }
+func (n scopeNode) eval(t *provider.TokenInfo) bool { return t.HasScope(string(n)) }
+func (n groupNode) eval(t *provider.TokenInfo) bool { return t.HasGroup(string(n)) }
+
+func (n claimNode) eval(t *provider.TokenInfo) bool {
+	v, _ := t.Claims.Get(n.path)
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	default:
+		return true
+	}
+}
+
+func (n compareNode) eval(t *provider.TokenInfo) bool {
+	v, _ := t.Claims.Get(n.path)
+	return claimEquals(v, n.value) != n.negate
+}
+
+// claimEquals reports whether the claim value equals s, or contains it for lists
+func claimEquals(v interface{}, s string) bool {
+	switch v := v.(type) {
+	case string:
+		return v == s
+	case bool:
+		return strconv.FormatBool(v) == s
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64) == s
+	case []interface{}:
+		for _, e := range v {
+			if claimEquals(e, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ruleParser is a recursive descent parser over the tokens of an expression
+type ruleParser struct {
+	lexer ruleLexer
+	tok   ruleToken
+}
+
+func (p *ruleParser) advance() error {
+	// On error the token only carries the position, for errorf
+	tok, err := p.lexer.next()
+	p.tok = tok
+	return err
+}
+
+func (p *ruleParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid authorization rule %q at offset %d: %s",
+		p.lexer.input, p.tok.pos, fmt.Sprintf(format, args...))
+}
+
+// expect consumes a token of the given kind
+func (p *ruleParser) expect(kind ruleTokenKind) (ruleToken, error) {
+	tok := p.tok
+	if tok.kind != kind {
+		return tok, p.errorf("expected %s, got %s", kind, tok)
+	}
+	if err := p.advance(); err != nil {
+		return tok, p.errorf("%v", err)
+	}
+	return tok, nil
+}
+
+func (p *ruleParser) parseOr() (ruleNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOr {
+		if _, err := p.expect(tokOr); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokAnd {
+		if _, err := p.expect(tokAnd); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseUnary() (ruleNode, error) {
+	if p.tok.kind == tokNot {
+		if _, err := p.expect(tokNot); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *ruleParser) parsePrimary() (ruleNode, error) {
+	switch p.tok.kind {
+	case tokLParen:
+		if _, err := p.expect(tokLParen); err != nil {
+			return nil, err
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case tokIdent:
+	default:
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+
+	ident, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	switch ident.text {
+	case "true", "false":
+		return constNode(ident.text == "true"), nil
+	}
+
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	arg, err := p.expect(tokString)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+
+	switch ident.text {
+	case "has_role":
+		return roleNode(arg.text), nil
+	case "has_scope":
+		return scopeNode(arg.text), nil
+	case "has_group":
+		return groupNode(arg.text), nil
+	case "claim":
+		path := arg.text
+		if p.tok.kind != tokEq && p.tok.kind != tokNeq {
+			return claimNode{path: path}, nil
+		}
+		op, err := p.expect(p.tok.kind)
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.expect(tokString)
+		if err != nil {
+			return nil, err
+		}
+		return compareNode{path: path, value: value.text, negate: op.kind == tokNeq}, nil
+	default:
+		p.tok = ident
+		return nil, p.errorf("unknown function %q", ident.text)
+	}
+}
+
+type ruleTokenKind int
+
+const (
+	tokEOF ruleTokenKind = iota
+	tokIdent
+	tokString
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokEq
+	tokNeq
+)
+
+// String names the token kind in error messages
+func (k ruleTokenKind) String() string {
+	return [...]string{"end of expression", "identifier", "string", `"("`, `")"`, `"&&"`, `"||"`, `"!"`, `"=="`, `"!="`}[k]
+}
+
+type ruleToken struct {
+	kind ruleTokenKind
+	text string
+	pos  int
+}
+
+// String describes the token in error messages
+func (t ruleToken) String() string {
+	switch t.kind {
+	case tokIdent, tokString:
+		return fmt.Sprintf("%s %q", t.kind, t.text)
+	default:
+		return t.kind.String()
+	}
+}
+
+// ruleLexer splits an expression into tokens
+type ruleLexer struct {
+	input string
+	pos   int
+}
+
+// ruleOperators maps the punctuation of the grammar to its tokens, longest first
+var ruleOperators = []struct {
+	text string
+	kind ruleTokenKind
+}{
+	{"&&", tokAnd}, {"||", tokOr}, {"==", tokEq}, {"!=", tokNeq},
+	{"!", tokNot}, {"(", tokLParen}, {")", tokRParen},
+}
+
+func (l *ruleLexer) next() (ruleToken, error) {
+	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.input) {
+		return ruleToken{kind: tokEOF, pos: start}, nil
+	}
+
+	rest := l.input[l.pos:]
+	for _, op := range ruleOperators {
+		if strings.HasPrefix(rest, op.text) {
+			l.pos += len(op.text)
+			return ruleToken{kind: op.kind, text: op.text, pos: start}, nil
+		}
+	}
+
+	switch c := rest[0]; {
+	case c == '"':
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return ruleToken{pos: start}, errors.New("unterminated or malformed string")
+		}
+		text, _ := strconv.Unquote(quoted)
+		l.pos += len(quoted)
+		return ruleToken{kind: tokString, text: text, pos: start}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		end := l.pos
+		for end < len(l.input) && (l.input[end] == '_' || unicode.IsLetter(rune(l.input[end])) || unicode.IsDigit(rune(l.input[end]))) {
+			end++
+		}
+		l.pos = end
+		return ruleToken{kind: tokIdent, text: l.input[start:end], pos: start}, nil
+	default:
+		return ruleToken{pos: start}, fmt.Errorf("unexpected character %q", c)
+	}
+}
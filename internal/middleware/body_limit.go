@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unlimitedBodyKey holds the request body as it was before LimitBody wrapped it
+const unlimitedBodyKey = "unlimited_body"
+
+// LimitBody returns a middleware that caps request bodies at maxBytes. Reading past the limit
+// fails with an *http.MaxBytesError, which the error handler reports as a 413. A LimitBody
+// on a route overrides the one applied to all routes, in either direction. A maxBytes of 0
+// or less removes the limit.
+func LimitBody(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		// Limit the original body, so a route override replaces the outer limit rather
+		// than being capped by it
+		body, ok := c.Get(unlimitedBodyKey)
+		if !ok {
+			body = c.Request.Body
+			c.Set(unlimitedBodyKey, body)
+		}
+		original := body.(io.ReadCloser)
+
+		if maxBytes <= 0 {
+			c.Request.Body = original
+			c.Next()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, original, maxBytes)
+		c.Next()
+	}
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type clientIPContextKey struct{}
+
+// TrustedProxies are the networks of the proxies in front of the bridge, whose
+// X-Forwarded-For entries are believed
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses CIDRs such as 10.0.0.0/8. Plain IP addresses are accepted
+// as single-address networks.
+func ParseTrustedProxies(cidrs []string) (TrustedProxies, error) {
+	proxies := make(TrustedProxies, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		network, err := parseNetwork(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %w", err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// parseNetwork parses a CIDR, or a plain IP address as a single-address network
+func parseNetwork(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("%q", cidr)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			bits = 32
+		}
+		cidr = fmt.Sprintf("%s/%d", cidr, bits)
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", cidr, err)
+	}
+	return network, nil
+}
+
+// Contains reports whether ip belongs to a trusted proxy
+func (p TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromProxy reports whether r was sent by a trusted proxy itself, judged by its peer address
+// alone, as forwarded headers can be set by anyone
+func (p TrustedProxies) FromProxy(r *http.Request) bool {
+	ip := remoteIP(r)
+	return ip != nil && p.Contains(ip)
+}
+
+// ClientIP determines the client IP of r. When the peer is a trusted proxy, X-Forwarded-For
+// is walked from right to left, skipping trusted proxies, and the first other address is the
+// client. Without trusted proxies forwarded headers are ignored, so they can't be spoofed.
+func (p TrustedProxies) ClientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !p.Contains(ip) {
+		return ip
+	}
+
+	hops := forwardedFor(r)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		if hop == nil {
+			// Entries left of a malformed one can't be attributed to anyone
+			return ip
+		}
+		ip = hop
+		if !p.Contains(ip) {
+			return ip
+		}
+	}
+	// Every hop is a trusted proxy, so the leftmost one sent the request
+	return ip
+}
+
+// ClientIPMiddleware determines the client IP of each request with proxies and keeps it for
+// ClientIP, so rate limiting and logging agree on who the client is
+func ClientIPMiddleware(proxies TrustedProxies) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ip := proxies.ClientIP(c.Request); ip != nil {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPContextKey{}, ip))
+		}
+		c.Next()
+	}
+}
+
+// ClientIP returns the client IP determined by ClientIPMiddleware, or the peer's address
+// when the middleware did not run. It returns nil when neither is known.
+func ClientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPContextKey{}).(net.IP); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// remoteIP returns the IP of the peer that sent the request, or nil when RemoteAddr is not
+// an IP address
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// forwardedFor returns the addresses of X-Forwarded-For, over all its header lines, in
+// the order the hops appended them
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	for _, line := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(line, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// ipPrefixKey returns the network of ip with the given prefix lengths, such as
+// 2001:db8:1:2::/64, so clients rotating through the addresses of their allocation are
+// counted together. Prefix lengths of 0 select /32 and /64.
+func ipPrefixKey(ip net.IP, ipv4Prefix, ipv6Prefix int) string {
+	if ipv4Prefix <= 0 {
+		ipv4Prefix = 32
+	}
+	if ipv6Prefix <= 0 {
+		ipv6Prefix = 64
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(ipv4Prefix, 32)), Mask: net.CIDRMask(ipv4Prefix, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6Prefix, 128)), Mask: net.CIDRMask(ipv6Prefix, 128)}).String()
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesFromProxy(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		wantFromProxy bool
+	}{
+		{"proxy network", "10.1.2.3:4567", "", true},
+		{"single proxy address", "192.0.2.1:80", "203.0.113.9", true},
+		{"client", "203.0.113.9:4567", "", false},
+		{"client spoofing a proxy in X-Forwarded-For", "203.0.113.9:4567", "10.1.2.3", false},
+		{"unparseable peer", "pipe", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if got := proxies.FromProxy(req); got != tt.wantFromProxy {
+				t.Errorf("FromProxy() = %v, want %v", got, tt.wantFromProxy)
+			}
+		})
+	}
+
+	if TrustedProxies(nil).FromProxy(httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Error("FromProxy() without trusted proxies = true")
+	}
+}
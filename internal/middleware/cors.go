@@ -1,13 +1,40 @@
 package middleware
 
 import (
+	"sync/atomic"
+
 	"github.com/gin-gonic/gin"
 	"github.com/zahidhasanpapon/iam-bridge/internal/config"
 )
 
+// CORSPolicy holds the CORS settings and lets them be changed while the middleware is
+// serving, e.g. when the config is reloaded
+type CORSPolicy struct {
+	current atomic.Pointer[config.CORSConfig]
+}
+
+// NewCORSPolicy creates a new CORSPolicy instance
+func NewCORSPolicy(cfg *config.CORSConfig) *CORSPolicy {
+	p := &CORSPolicy{}
+	p.Set(cfg)
+	return p
+}
+
+// Set replaces the CORS settings for new requests
+func (p *CORSPolicy) Set(cfg *config.CORSConfig) {
+	p.current.Store(cfg)
+}
+
 // CORSMiddleware returns a middleware handler for CORS
 func CORSMiddleware(cfg *config.CORSConfig) gin.HandlerFunc {
+	return CORSPolicyMiddleware(NewCORSPolicy(cfg))
+}
+
+// CORSPolicyMiddleware returns a middleware handler for CORS applying the current settings
+// of policy
+func CORSPolicyMiddleware(policy *CORSPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		cfg := policy.current.Load()
 		origin := c.Request.Header.Get("Origin")
 
 		// Check if the origin is allowed
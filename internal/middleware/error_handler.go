@@ -4,34 +4,100 @@ import (
 	"errors"
 	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 // APIError represents a standardized API error response
 type APIError struct {
-	Code      string `json:"code"`
-	Message   string `json:"message"`
-	RequestID string `json:"request_id,omitempty"`
+	Code             string `json:"code"`
+	Message          string `json:"message"`
+	Reason           string `json:"reason,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+	RequestID        string `json:"request_id,omitempty"`
+}
+
+// ErrorHandlerOption customizes the error handling middleware
+type ErrorHandlerOption func(*errorHandlerOptions)
+
+type errorHandlerOptions struct {
+	verbose bool
+}
+
+// WithVerboseErrors sets whether responses to rejected tokens say why they were rejected,
+// which helps debugging but tells attackers which checks a forged token failed. Opaque
+// responses answer every rejected token with the same INVALID_TOKEN 401, whether it expired,
+// was revoked or failed another check. The request log records the reason either way.
+// Responses are verbose unless set otherwise.
+func WithVerboseErrors(verbose bool) ErrorHandlerOption {
+	return func(o *errorHandlerOptions) {
+		o.verbose = verbose
+	}
 }
 
 // ErrorHandlerMiddleware handles errors in a standardized way
-func ErrorHandlerMiddleware() gin.HandlerFunc {
+func ErrorHandlerMiddleware(opts ...ErrorHandlerOption) gin.HandlerFunc {
+	options := &errorHandlerOptions{verbose: true}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	return func(c *gin.Context) {
 		c.Next()
 
 		// Check if there are any errors
 		if len(c.Errors) > 0 {
 			err := c.Errors.Last().Err
-			handleError(c, err)
+			handleError(c, err, options)
+		}
+	}
+}
+
+// tokenRejections are the errors of tokens that were presented but not accepted
+var tokenRejections = []error{
+	provider.ErrTokenExpired, provider.ErrTokenRevoked, provider.ErrTokenInvalid,
+	provider.ErrInvalidAudience, provider.ErrInvalidIssuer, provider.ErrKeyNotFound,
+	provider.ErrTokenLifetime, provider.ErrNoExpiry, provider.ErrWrongTokenType,
+	provider.ErrCertificateBindingInvalid,
+}
+
+// isTokenRejection reports whether err rejects the presented token
+func isTokenRejection(err error) bool {
+	for _, target := range tokenRejections {
+		if errors.Is(err, target) {
+			return true
 		}
 	}
+	return false
 }
 
 // handleError processes different types of errors and returns appropriate responses
-func handleError(c *gin.Context, err error) {
+func handleError(c *gin.Context, err error, options *errorHandlerOptions) {
 	requestID := GetRequestID(c)
 
+	// Opaque responses don't tell which check a rejected token failed
+	if !options.verbose && isTokenRejection(err) {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "INVALID_TOKEN",
+			Message:   "Invalid authentication token",
+			Reason:    "invalid_token",
+			RequestID: requestID,
+		})
+		return
+	}
+
+	// Token validation failures carry a reason, which is reported to the caller in the
+	// WWW-Authenticate challenge and the body
+	var validationErr *provider.ValidationError
+	var reason, description string
+	if errors.As(err, &validationErr) {
+		reason = string(validationErr.Reason)
+		description = validationErr.Description()
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token", error_description=`+strconv.Quote(description))
+	}
+
 	// Map common errors to HTTP status codes and error codes
 	switch {
 	case errors.Is(err, provider.ErrInvalidCredentials):
@@ -43,15 +109,138 @@ func handleError(c *gin.Context, err error) {
 
 	case errors.Is(err, provider.ErrTokenExpired):
 		c.JSON(http.StatusUnauthorized, APIError{
-			Code:      "TOKEN_EXPIRED",
-			Message:   "Authentication token has expired",
+			Code:             "TOKEN_EXPIRED",
+			Message:          "Authentication token has expired",
+			Reason:           reason,
+			ErrorDescription: description,
+			RequestID:        requestID,
+		})
+
+	case errors.Is(err, provider.ErrTokenRevoked):
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "TOKEN_REVOKED",
+			Message:   "Authentication token has been revoked",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, provider.ErrAccessTokenHashMismatch), errors.Is(err, provider.ErrNonceMismatch):
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "INVALID_ID_TOKEN",
+			Message:   "The ID token does not match the authentication request",
+			Reason:    "invalid_token",
 			RequestID: requestID,
 		})
 
 	case errors.Is(err, provider.ErrTokenInvalid):
 		c.JSON(http.StatusUnauthorized, APIError{
-			Code:      "INVALID_TOKEN",
-			Message:   "Invalid authentication token",
+			Code:             "INVALID_TOKEN",
+			Message:          "Invalid authentication token",
+			Reason:           reason,
+			ErrorDescription: description,
+			RequestID:        requestID,
+		})
+
+	case errors.Is(err, provider.ErrInvalidAudience):
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:             "INVALID_AUDIENCE",
+			Message:          "Authentication token was not issued for this service",
+			Reason:           reason,
+			ErrorDescription: description,
+			RequestID:        requestID,
+		})
+
+	case errors.Is(err, provider.ErrInvalidIssuer), errors.Is(err, provider.ErrKeyNotFound),
+		errors.Is(err, provider.ErrTokenLifetime), errors.Is(err, provider.ErrNoExpiry):
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:             "INVALID_TOKEN",
+			Message:          "Invalid authentication token",
+			Reason:           reason,
+			ErrorDescription: description,
+			RequestID:        requestID,
+		})
+
+	case errors.Is(err, provider.ErrWrongTokenType):
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:             "INVALID_TOKEN_TYPE",
+			Message:          "Authentication token is not an access token",
+			Reason:           reason,
+			ErrorDescription: description,
+			RequestID:        requestID,
+		})
+
+	case errors.Is(err, provider.ErrDPoPProofInvalid):
+		c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "INVALID_DPOP_PROOF",
+			Message:   "Invalid or missing DPoP proof",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, provider.ErrCertificateBindingInvalid):
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "INVALID_TOKEN_BINDING",
+			Message:   "Token is not bound to the client certificate",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrAuthenticationRequired):
+		c.Header("WWW-Authenticate", "Bearer")
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "AUTHENTICATION_REQUIRED",
+			Message:   "Authentication is required",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrReauthenticationRequired):
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "REAUTHENTICATION_REQUIRED",
+			Message:   "A more recent authentication is required, please sign in again",
+			Reason:    "insufficient_user_authentication",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrInsufficientAuthenticationLevel):
+		c.JSON(http.StatusUnauthorized, APIError{
+			Code:      "INSUFFICIENT_AUTHENTICATION_LEVEL",
+			Message:   "A different authentication level is required, please sign in again",
+			Reason:    "insufficient_user_authentication",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrAuthenticationMethodRequired):
+		c.JSON(http.StatusForbidden, APIError{
+			Code:      "AUTHENTICATION_METHOD_REQUIRED",
+			Message:   "This operation requires signing in with a stronger authentication method",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrInsufficientPermissions):
+		c.JSON(http.StatusForbidden, APIError{
+			Code:      "FORBIDDEN",
+			Message:   "Insufficient permissions",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrClientNotAllowed):
+		c.JSON(http.StatusForbidden, APIError{
+			Code:      "CLIENT_NOT_ALLOWED",
+			Message:   "The client the token was issued to is not allowed to call this API",
+			Reason:    "client_not_allowed",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrRateLimited):
+		c.JSON(http.StatusTooManyRequests, APIError{
+			Code:      "RATE_LIMITED",
+			Message:   "Too many requests",
+			RequestID: requestID,
+		})
+
+	case errors.As(err, new(*http.MaxBytesError)):
+		c.JSON(http.StatusRequestEntityTooLarge, APIError{
+			Code:      "PAYLOAD_TOO_LARGE",
+			Message:   "Request body is too large",
 			RequestID: requestID,
 		})
 
@@ -62,6 +251,55 @@ func handleError(c *gin.Context, err error) {
 			RequestID: requestID,
 		})
 
+	case errors.Is(err, provider.ErrInvalidCodeVerifier):
+		c.JSON(http.StatusBadRequest, APIError{
+			Code:      "INVALID_CODE_VERIFIER",
+			Message:   "Invalid PKCE code verifier",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, provider.ErrInvalidGrant):
+		c.JSON(http.StatusBadRequest, APIError{
+			Code:      "INVALID_GRANT",
+			Message:   "Authorization code is invalid or expired",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, ErrMultipleAuthorizationHeaders):
+		c.JSON(http.StatusBadRequest, APIError{
+			Code:      "MULTIPLE_AUTHORIZATION_HEADERS",
+			Message:   "The request carries more than one Authorization header",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, provider.ErrUnknownTenant):
+		c.JSON(http.StatusBadRequest, APIError{
+			Code:      "UNKNOWN_TENANT",
+			Message:   "The request does not match a configured tenant",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, provider.ErrProviderUnavailable):
+		c.JSON(http.StatusServiceUnavailable, APIError{
+			Code:      "PROVIDER_UNAVAILABLE",
+			Message:   "The IAM provider is temporarily unavailable",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, provider.ErrAdminAccessDenied):
+		c.JSON(http.StatusBadGateway, APIError{
+			Code:      "ADMIN_ACCESS_DENIED",
+			Message:   "The IAM provider denied the admin access this operation needs",
+			RequestID: requestID,
+		})
+
+	case errors.Is(err, provider.ErrNotSupported):
+		c.JSON(http.StatusNotImplemented, APIError{
+			Code:      "NOT_SUPPORTED",
+			Message:   "Operation not supported by the IAM provider",
+			RequestID: requestID,
+		})
+
 	default:
 		// Handle any other errors as internal server errors
 		c.JSON(http.StatusInternalServerError, APIError{
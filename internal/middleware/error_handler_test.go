@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// respond runs err through the error handler and returns the response
+func respond(t *testing.T, err error, opts ...ErrorHandlerOption) (*httptest.ResponseRecorder, APIError) {
+	t.Helper()
+	router := gin.New()
+	router.Use(ErrorHandlerMiddleware(opts...))
+	router.GET("/", func(c *gin.Context) { abortWithError(c, err) })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body APIError
+	if jsonErr := json.Unmarshal(rec.Body.Bytes(), &body); jsonErr != nil {
+		t.Fatalf("response is not an APIError: %s", rec.Body)
+	}
+	return rec, body
+}
+
+func TestErrorHandlerOpaqueTokenRejections(t *testing.T) {
+	rejections := []error{
+		provider.ErrTokenExpired,
+		provider.ErrTokenRevoked,
+		provider.ErrTokenInvalid,
+		provider.ErrInvalidAudience,
+		provider.ErrInvalidIssuer,
+		provider.ErrKeyNotFound,
+		provider.ErrTokenLifetime,
+		provider.ErrNoExpiry,
+		provider.ErrWrongTokenType,
+		provider.ErrCertificateBindingInvalid,
+		fmt.Errorf("wrapped: %w", provider.ErrTokenExpired),
+	}
+
+	var want string
+	for _, err := range rejections {
+		t.Run(err.Error(), func(t *testing.T) {
+			rec, body := respond(t, err, WithVerboseErrors(false))
+			if rec.Code != http.StatusUnauthorized || body.Code != "INVALID_TOKEN" {
+				t.Errorf("response = %d %s, want 401 INVALID_TOKEN", rec.Code, body.Code)
+			}
+			if challenge := rec.Header().Get("WWW-Authenticate"); challenge != `Bearer error="invalid_token"` {
+				t.Errorf("WWW-Authenticate = %q", challenge)
+			}
+			// Every rejection must read the same
+			if want == "" {
+				want = rec.Body.String()
+			} else if rec.Body.String() != want {
+				t.Errorf("body = %s, want the same as the other rejections %s", rec.Body, want)
+			}
+		})
+	}
+}
+
+func TestErrorHandlerVerboseTokenRejections(t *testing.T) {
+	tests := []struct {
+		err      error
+		wantCode string
+	}{
+		{provider.ErrTokenExpired, "TOKEN_EXPIRED"},
+		{provider.ErrTokenRevoked, "TOKEN_REVOKED"},
+		{provider.ErrInvalidAudience, "INVALID_AUDIENCE"},
+		{provider.ErrWrongTokenType, "INVALID_TOKEN_TYPE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			rec, body := respond(t, tt.err, WithVerboseErrors(true))
+			if rec.Code != http.StatusUnauthorized || body.Code != tt.wantCode {
+				t.Errorf("response = %d %s, want 401 %s", rec.Code, body.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestErrorHandlerStatusCodes(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+	}{
+		{ErrAuthenticationRequired, http.StatusUnauthorized},
+		{ErrInsufficientPermissions, http.StatusForbidden},
+		{ErrClientNotAllowed, http.StatusForbidden},
+		{ErrRateLimited, http.StatusTooManyRequests},
+		{ErrMultipleAuthorizationHeaders, http.StatusBadRequest},
+		{provider.ErrProviderUnavailable, http.StatusServiceUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			// Opaque mode only changes token rejections
+			if rec, _ := respond(t, tt.err, WithVerboseErrors(false)); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
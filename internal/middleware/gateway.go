@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// GatewayAuthMiddleware authenticates requests with the JWT an API gateway forwards in the
+// given header, in place of AuthMiddleware. The token information is stored in the context
+// just like AuthMiddleware does, so the Require* middlewares work unchanged.
+func GatewayAuthMiddleware(validator *provider.GatewayValidator, header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if GetTokenInfo(c) != nil {
+			c.Next()
+			return
+		}
+
+		token := c.GetHeader(header)
+		if token == "" {
+			abortWithError(c, ErrAuthenticationRequired)
+			return
+		}
+
+		tokenInfo, err := validator.ValidateToken(c.Request.Context(), token)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		setTokenInfo(c, tokenInfo)
+
+		c.Next()
+	}
+}
@@ -20,17 +20,49 @@ func (w *responseWriter) Write(b []byte) (int, error) {
 	return w.ResponseWriter.Write(b)
 }
 
+// maxLoggedBodySize is the size from which request and response bodies are left out of logs
+const maxLoggedBodySize = 1024
+
+// bodyRecorder keeps the start of a request body as it is read, for logging
+type bodyRecorder struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	size int
+}
+
+func (r *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if keep := min(n, maxLoggedBodySize-r.buf.Len()); keep > 0 {
+		r.buf.Write(p[:keep])
+	}
+	r.size += n
+	return n, err
+}
+
+// sensitiveBodyKey marks requests whose body must not be logged
+const sensitiveBodyKey = "sensitive_body"
+
+// SensitiveBody keeps LoggerMiddleware from logging the request body, for routes
+// receiving secrets such as tokens
+func SensitiveBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(sensitiveBodyKey, true)
+		c.Next()
+	}
+}
+
 // LoggerMiddleware returns a middleware for logging HTTP requests
 func LoggerMiddleware(log logger.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Start timer
 		start := time.Now()
 
-		// Create a copy of the request body for logging
-		var requestBody []byte
+		// Record the request body as the handler reads it, so bodies are never buffered
+		// whole and body size limits further down still apply
+		var requestBody *bodyRecorder
 		if c.Request.Body != nil {
-			requestBody, _ = io.ReadAll(c.Request.Body)
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+			requestBody = &bodyRecorder{ReadCloser: c.Request.Body}
+			c.Request.Body = requestBody
 		}
 
 		// Create a custom response writer
@@ -48,7 +80,7 @@ func LoggerMiddleware(log logger.Logger) gin.HandlerFunc {
 
 		// Log request details
 		fields := map[string]interface{}{
-			"client_ip":     c.ClientIP(),
+			"client_ip":     ClientIP(c.Request).String(),
 			"method":        c.Request.Method,
 			"path":          c.Request.URL.Path,
 			"status":        c.Writer.Status(),
@@ -59,13 +91,13 @@ func LoggerMiddleware(log logger.Logger) gin.HandlerFunc {
 			"response_size": c.Writer.Size(),
 		}
 
-		// Add request body if present and not too large
-		if len(requestBody) > 0 && len(requestBody) < 1024 {
-			fields["request_body"] = string(requestBody)
+		// Add request body if present, not too large and not marked as sensitive
+		if requestBody != nil && requestBody.size > 0 && requestBody.size < maxLoggedBodySize && !c.GetBool(sensitiveBodyKey) {
+			fields["request_body"] = requestBody.buf.String()
 		}
 
 		// Add response body if present and not too large
-		if w.body.Len() > 0 && w.body.Len() < 1024 {
+		if w.body.Len() > 0 && w.body.Len() < maxLoggedBodySize {
 			fields["response_body"] = w.body.String()
 		}
 
@@ -83,3 +115,12 @@ func LoggerMiddleware(log logger.Logger) gin.HandlerFunc {
 		}
 	}
 }
+
+// LogContextMiddleware attaches the IAM provider name to the request context so
+// context-aware log calls include it
+func LogContextMiddleware(providerName string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(logger.WithProvider(c.Request.Context(), providerName))
+		c.Next()
+	}
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+	"github.com/zahidhasanpapon/iam-bridge/internal/session"
+)
+
+// LoginURLFunc returns the URL an unauthenticated browser request is redirected to
+type LoginURLFunc func(c *gin.Context) (string, error)
+
+// BrowserDetector reports whether a request comes from a browser expecting a page rather
+// than a JSON error
+type BrowserDetector func(*http.Request) bool
+
+// WithLoginRedirect redirects unauthenticated browser requests to the URL returned by
+// loginURL instead of responding 401. Which requests are browser requests is decided by
+// PrefersHTML unless WithBrowserDetector sets otherwise; API clients still get a 401.
+// When loginURL fails, the request gets the 401.
+func WithLoginRedirect(loginURL LoginURLFunc) AuthOption {
+	return func(o *authOptions) {
+		o.loginURL = loginURL
+	}
+}
+
+// WithBrowserDetector sets how WithLoginRedirect tells browser requests from API clients
+func WithBrowserDetector(detect BrowserDetector) AuthOption {
+	return func(o *authOptions) {
+		if detect != nil {
+			o.isBrowser = detect
+		}
+	}
+}
+
+// PrefersHTML is the default BrowserDetector: it reports whether a GET or HEAD request's
+// Accept header ranks text/html above application/json. Wildcards don't count, so clients
+// sending */* or no Accept header at all are treated as API clients.
+func PrefersHTML(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	var html, json float64
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "text/html", "application/xhtml+xml":
+			html = max(html, q)
+		case "application/json":
+			json = max(json, q)
+		}
+	}
+	return html > json
+}
+
+// StaticLoginURL redirects to a fixed login page, passing the requested URL in the
+// return_to query parameter so the page can send the user back after signing in
+func StaticLoginURL(loginURL string) LoginURLFunc {
+	return func(c *gin.Context) (string, error) {
+		u, err := url.Parse(loginURL)
+		if err != nil {
+			return "", err
+		}
+
+		query := u.Query()
+		query.Set("return_to", c.Request.URL.RequestURI())
+		u.RawQuery = query.Encode()
+		return u.String(), nil
+	}
+}
+
+// LoginOption customizes how StartLogin starts logins
+type LoginOption func(*loginOptions)
+
+type loginOptions struct {
+	nonces provider.NonceGenerator
+}
+
+// WithNonceGenerator sets how login nonces are generated. Without it, provider.RandomNonce
+// is used.
+func WithNonceGenerator(nonces provider.NonceGenerator) LoginOption {
+	return func(o *loginOptions) {
+		o.nonces = nonces
+	}
+}
+
+// AuthCodeLoginURL redirects straight to the IdP's authorization endpoint, starting the
+// code flow with PKCE. The verifier and nonce are saved in store under the state for ttl,
+// for the callback to consume when it exchanges the code.
+func AuthCodeLoginURL(iamProvider provider.IAMProvider, store session.StateStore, ttl time.Duration, opts ...LoginOption) LoginURLFunc {
+	return func(c *gin.Context) (string, error) {
+		flow, ok := provider.As[provider.AuthCodeFlowProvider](iamProvider)
+		if !ok {
+			return "", provider.ErrNotSupported
+		}
+
+		authURL, _, err := StartLogin(c, flow, store, ttl, opts...)
+		return authURL, err
+	}
+}
+
+// StartLogin starts a code flow login: it generates the state, PKCE verifier and nonce,
+// saves them in store for ttl and returns the authorization URL and the state
+func StartLogin(c *gin.Context, flow provider.AuthCodeFlowProvider, store session.StateStore, ttl time.Duration, opts ...LoginOption) (string, string, error) {
+	options := &loginOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	nonce, err := provider.GenerateNonce(options.nonces)
+	if err != nil {
+		return "", "", err
+	}
+
+	// A further verifier serves as the state, being just as random and URL safe
+	state, _ := provider.GeneratePKCE()
+	verifier, challenge := provider.GeneratePKCE()
+
+	if err := store.Save(c, state, &session.FlowState{CodeVerifier: verifier, Nonce: nonce}, ttl); err != nil {
+		return "", "", err
+	}
+	return flow.AuthCodeURL(state, challenge, provider.WithNonce(nonce)), state, nil
+}
+
+// unauthenticated rejects a request that carries no valid credentials: browser requests
+// are redirected to the login URL when WithLoginRedirect is set, others get err
+func unauthenticated(c *gin.Context, options *authOptions, err error) {
+	if options.loginURL != nil && isAuthenticationError(err) && options.isBrowser(c.Request) {
+		if target, urlErr := options.loginURL(c); urlErr == nil {
+			c.Redirect(http.StatusFound, target)
+			c.Abort()
+			return
+		}
+	}
+	abortWithError(c, err)
+}
+
+// isAuthenticationError reports whether err means the request must authenticate (anew),
+// as opposed to, say, the IAM provider being unavailable
+func isAuthenticationError(err error) bool {
+	return errors.Is(err, ErrAuthenticationRequired) ||
+		errors.Is(err, provider.ErrTokenExpired) ||
+		errors.Is(err, provider.ErrTokenInvalid) ||
+		errors.Is(err, provider.ErrTokenRevoked)
+}
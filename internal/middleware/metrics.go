@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/metrics"
+)
+
+// unmatchedPathLabel is the path label of requests that match no route
+const unmatchedPathLabel = "unmatched"
+
+// otherMethodLabel is the method label of requests with a non-standard method
+const otherMethodLabel = "OTHER"
+
+// standardMethods are the methods recorded under their own label
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// PathNormalizer maps a request to the path label its metrics are recorded under.
+// Labels must come from a bounded set, so IDs in paths have to be collapsed.
+type PathNormalizer func(*http.Request) string
+
+// MetricsOption configures MetricsMiddleware
+type MetricsOption func(*metricsOptions)
+
+type metricsOptions struct {
+	normalizer PathNormalizer
+}
+
+// WithPathNormalizer records metrics under the labels computed by normalizer for every
+// request, e.g. to reuse the route templates of another router:
+//
+//	middleware.WithPathNormalizer(func(r *http.Request) string {
+//		return chi.RouteContext(r.Context()).RoutePattern()
+//	})
+func WithPathNormalizer(normalizer PathNormalizer) MetricsOption {
+	return func(o *metricsOptions) {
+		o.normalizer = normalizer
+	}
+}
+
+// MetricsMiddleware records request counts and durations per method, path and status.
+// The path label is the matched gin route pattern, such as /api/v1/users/:id, and requests
+// matching no route share the "unmatched" label so probing can't inflate cardinality.
+// WithPathNormalizer replaces both, e.g. with DefaultPathNormalizer. Likewise, requests
+// with a method other than the standard ones share the "OTHER" method label.
+func MetricsMiddleware(opts ...MetricsOption) gin.HandlerFunc {
+	options := &metricsOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		var path string
+		switch {
+		case options.normalizer != nil:
+			path = options.normalizer(c.Request)
+		case c.FullPath() != "":
+			path = c.FullPath()
+		default:
+			path = unmatchedPathLabel
+		}
+
+		key := methodLabel(c.Request.Method) + " " + path + " " + strconv.Itoa(c.Writer.Status())
+		metrics.HTTPRequests.Add(key, 1)
+		metrics.HTTPRequestSeconds.AddFloat(key, time.Since(start).Seconds())
+	}
+}
+
+// methodLabel is the method label of requests with method
+func methodLabel(method string) string {
+	if standardMethods[method] {
+		return method
+	}
+	return otherMethodLabel
+}
+
+// uuidPattern matches canonical UUIDs
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// DefaultPathNormalizer collapses numeric and UUID path segments into :id, so /users/42
+// and /users/6f1c...-... are both recorded as /users/:id. It suits routers without route
+// templates; other path segments are kept as they are.
+func DefaultPathNormalizer(r *http.Request) string {
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		if isNumeric(segment) || uuidPattern.MatchString(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isNumeric reports whether s is a non-empty string of ASCII digits
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/metrics"
+)
+
+func TestMetricsMiddlewareLabels(t *testing.T) {
+	router := gin.New()
+	router.Use(MetricsMiddleware())
+	router.Any("/metrics-test/users/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		wantKey string
+	}{
+		{"route pattern", http.MethodGet, "/metrics-test/users/42", "GET /metrics-test/users/:id 204"},
+		{"standard method", http.MethodPatch, "/metrics-test/users/42", "PATCH /metrics-test/users/:id 204"},
+		{"made-up method", "PROBE1", "/metrics-test/users/42", "OTHER unmatched 404"},
+		{"another made-up method", "PROBE2", "/metrics-test/users/42", "OTHER unmatched 404"},
+		{"no route", http.MethodGet, "/metrics-test/elsewhere", "GET unmatched 404"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := counter(tt.wantKey)
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+			if got := counter(tt.wantKey); got != before+1 {
+				t.Errorf("%q counted %d requests, want %d", tt.wantKey, got, before+1)
+			}
+		})
+	}
+
+	for _, method := range []string{"PROBE1", "PROBE2"} {
+		if metrics.HTTPRequests.Get(method+" unmatched 404") != nil {
+			t.Errorf("the made-up method %s got its own label", method)
+		}
+	}
+}
+
+// counter reads the request count recorded under key
+func counter(key string) int64 {
+	v, ok := metrics.HTTPRequests.Get(key).(interface{ Value() int64 })
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
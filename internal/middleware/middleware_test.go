@@ -0,0 +1,7 @@
+package middleware
+
+import "github.com/gin-gonic/gin"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
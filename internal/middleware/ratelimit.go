@@ -0,0 +1,379 @@
+package middleware
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+// ErrRateLimited is reported when a client exceeds its request rate
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// rateLimitWindow is the length of a rate limiting window
+const rateLimitWindow = time.Second
+
+// RateLimitOption customizes the rate limiting middleware
+type RateLimitOption func(*rateLimitOptions)
+
+type rateLimitOptions struct {
+	response func(w http.ResponseWriter, r *http.Request)
+	rate     *RateLimit
+	limiter  *RateLimiter
+	clock    clock.Clock
+	cost     func(r *http.Request) int
+	log      logger.Logger
+}
+
+// RateLimit is a requests-per-second rate that can be changed while the middleware
+// is serving, e.g. when the config is reloaded
+type RateLimit struct {
+	rps atomic.Int64
+}
+
+// NewRateLimit creates a new RateLimit instance
+func NewRateLimit(rps int) *RateLimit {
+	r := &RateLimit{}
+	r.Set(rps)
+	return r
+}
+
+// Set changes the rate. Clients keep their counts for the current window, which the new
+// rate applies to right away, so lowering the rate never grants a fresh burst.
+func (r *RateLimit) Set(rps int) {
+	r.rps.Store(int64(rps))
+}
+
+// Get returns the current rate
+func (r *RateLimit) Get() int {
+	return int(r.rps.Load())
+}
+
+// WithRate makes the middleware read its rate from rate instead of cfg.RequestsPerSecond,
+// so it can be adjusted live
+func WithRate(rate *RateLimit) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.rate = rate
+	}
+}
+
+// WithLimiter makes the middleware count requests in limiter, whose state can then be
+// inspected with Snapshot. The limiter's rate takes precedence over WithRate.
+func WithLimiter(limiter *RateLimiter) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.limiter = limiter
+	}
+}
+
+// WithRateLimitClock sets the time source rate limit windows are measured with.
+// Without it, the system clock is used.
+func WithRateLimitClock(c clock.Clock) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.clock = c
+	}
+}
+
+// WithRateLimitCost makes each request count cost(r) against the limit instead of 1, so
+// expensive endpoints can be limited more aggressively under one limiter. A request costing
+// more than remains in the window is rejected, without using any of it up. Costs below 0
+// count as 0.
+func WithRateLimitCost(cost func(r *http.Request) int) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.cost = cost
+	}
+}
+
+// WithRateLimitLogger logs the requests let through by an exemption at debug level
+func WithRateLimitLogger(log logger.Logger) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.log = log
+	}
+}
+
+// WithRateLimitResponse replaces the default 429 response for rejected requests.
+// Rate limit headers are set before the writer is called.
+func WithRateLimitResponse(fn func(w http.ResponseWriter, r *http.Request)) RateLimitOption {
+	return func(o *rateLimitOptions) {
+		o.response = fn
+	}
+}
+
+// RateLimitMiddleware limits each client network to cfg.RequestsPerSecond requests per second.
+// Clients are identified by ClientIP, so X-Forwarded-For is only believed when
+// ClientIPMiddleware runs first with the trusted proxies, and counted per /cfg.IPv4Prefix or
+// /cfg.IPv6Prefix network.
+// Rejected requests get a 429 with Retry-After unless a custom response is configured;
+// with cfg.Headers the X-RateLimit-* headers are set on every response. Clients in
+// cfg.ExemptIPs are not limited or counted; entries that don't parse, which config
+// validation reports, are ignored. The middleware runs before authentication and never
+// validates tokens, so cfg.ExemptClients don't apply here: ClientRateLimits exempts them
+// from their tier once AuthMiddleware has validated their token.
+func RateLimitMiddleware(cfg *config.RateLimitConfig, opts ...RateLimitOption) gin.HandlerFunc {
+	options := &rateLimitOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	limiter := options.limiter
+	if limiter == nil {
+		rate := options.rate
+		if rate == nil {
+			rate = NewRateLimit(cfg.RequestsPerSecond)
+		}
+		limiter = NewRateLimiter(rate)
+	}
+
+	exempt := make([]*net.IPNet, 0, len(cfg.ExemptIPs))
+	for _, cidr := range cfg.ExemptIPs {
+		if network, err := parseNetwork(cidr); err == nil {
+			exempt = append(exempt, network)
+		}
+	}
+
+	return func(c *gin.Context) {
+		key := c.Request.RemoteAddr
+		if ip := ClientIP(c.Request); ip != nil {
+			if network := exemptNetwork(exempt, ip); network != nil {
+				logExemption(c, options.log, "client_ip", ip.String(), "exempt_ips", network.String())
+				c.Next()
+				return
+			}
+			key = ipPrefixKey(ip, cfg.IPv4Prefix, cfg.IPv6Prefix)
+		}
+		if limitRequest(c, limiter, key, cfg.Headers, options) {
+			c.Next()
+		}
+	}
+}
+
+// ClientRateLimits limits authenticated requests per client ID, at the rate of the tier the
+// client is assigned to. Each client has its own count. Clients without a tier, and exempt
+// clients, are only subject to the per-IP limit, which is counted before the client is known.
+type ClientRateLimits struct {
+	headers bool
+	clients map[string]*RateLimiter
+	exempt  map[string]struct{}
+	options *rateLimitOptions
+}
+
+// NewClientRateLimits creates a new ClientRateLimits instance from the configured tiers.
+// Of the options, only the clock, cost and logger apply; rejections always get the default
+// response.
+func NewClientRateLimits(cfg *config.RateLimitConfig, opts ...RateLimitOption) *ClientRateLimits {
+	options := &rateLimitOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	limits := &ClientRateLimits{
+		headers: cfg.Headers,
+		clients: make(map[string]*RateLimiter),
+		exempt:  stringSet(cfg.ExemptClients),
+		options: &rateLimitOptions{clock: options.clock, cost: options.cost, log: options.log},
+	}
+	for _, tier := range cfg.Tiers {
+		limiter := NewRateLimiter(NewRateLimit(tier.RequestsPerSecond))
+		for _, client := range tier.Clients {
+			limits.clients[client] = limiter
+		}
+	}
+	return limits
+}
+
+// allow records a request of client and reports whether it may proceed. Rejected
+// requests are aborted with ErrRateLimited.
+func (l *ClientRateLimits) allow(c *gin.Context, client string) bool {
+	if _, ok := l.exempt[client]; ok {
+		logExemption(c, l.options.log, "client_id", client)
+		return true
+	}
+	limiter, ok := l.clients[client]
+	if !ok {
+		return true
+	}
+	return limitRequest(c, limiter, client, l.headers, l.options)
+}
+
+// exemptNetwork returns the exempt network ip belongs to, or nil
+func exemptNetwork(exempt []*net.IPNet, ip net.IP) *net.IPNet {
+	for _, network := range exempt {
+		if network.Contains(ip) {
+			return network
+		}
+	}
+	return nil
+}
+
+// logExemption logs a request let through without rate limiting, when a logger logging at
+// debug level is set
+func logExemption(c *gin.Context, log logger.Logger, keysAndValues ...interface{}) {
+	debug, ok := log.(logger.DebugLogger)
+	if !ok {
+		return
+	}
+	debug.DebugContext(c.Request.Context(), "Rate limit exemption applied",
+		append(keysAndValues, "path", c.Request.URL.Path)...)
+}
+
+// limitRequest counts the request's cost under key and reports whether it is within the
+// limit. It sets the rate limit headers, and on rejection Retry-After and the response.
+func limitRequest(c *gin.Context, limiter *RateLimiter, key string, headers bool, options *rateLimitOptions) bool {
+	cost := 1
+	if options.cost != nil {
+		cost = max(options.cost(c.Request), 0)
+	}
+
+	now := clock.OrSystem(options.clock).Now()
+	allowed, limit, remaining, reset := limiter.allow(key, cost, now)
+
+	if headers {
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}
+
+	if allowed {
+		return true
+	}
+
+	retryAfter := int(reset.Sub(now).Round(time.Second) / time.Second)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+	if options.response != nil {
+		options.response(c.Writer, c.Request)
+		c.Abort()
+		return false
+	}
+
+	abortWithError(c, ErrRateLimited)
+	return false
+}
+
+// RateLimiter counts the cost of requests per key in fixed windows
+type RateLimiter struct {
+	rate   *RateLimit
+	window time.Duration
+
+	mu        sync.Mutex
+	counters  map[string]*rateCounter
+	nextSweep time.Time
+}
+
+type rateCounter struct {
+	count    int
+	reset    time.Time
+	lastSeen time.Time
+}
+
+// NewRateLimiter creates a new RateLimiter instance allowing rate requests per second
+// and key. Pass it to RateLimitMiddleware with WithLimiter to inspect it while serving.
+func NewRateLimiter(rate *RateLimit) *RateLimiter {
+	return newRateLimiter(rate, rateLimitWindow)
+}
+
+func newRateLimiter(rate *RateLimit, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		rate:     rate,
+		window:   window,
+		counters: make(map[string]*rateCounter),
+	}
+}
+
+// allow records a request of the given cost for key and reports whether it is within the
+// current limit, the limit, how much remains in the current window and when the window resets
+func (l *RateLimiter) allow(key string, cost int, now time.Time) (bool, int, int, time.Time) {
+	limit := l.rate.Get()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	counter, ok := l.counters[key]
+	if !ok || !now.Before(counter.reset) {
+		counter = &rateCounter{reset: now.Add(l.window)}
+		l.counters[key] = counter
+	}
+
+	counter.lastSeen = now
+
+	if counter.count+cost > limit {
+		return false, limit, max(limit-counter.count, 0), counter.reset
+	}
+
+	counter.count += cost
+	return true, limit, limit - counter.count, counter.reset
+}
+
+// sweep drops counters of finished windows so idle clients don't accumulate
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+
+	for key, counter := range l.counters {
+		if !now.Before(counter.reset) {
+			delete(l.counters, key)
+		}
+	}
+	l.nextSweep = now.Add(l.window)
+}
+
+// maxSnapshotBuckets caps the buckets Snapshot returns, for high-cardinality keyspaces
+const maxSnapshotBuckets = 1000
+
+// BucketState is the state of one key's counter in the current window
+type BucketState struct {
+	Key       string    `json:"key"`
+	Limit     int       `json:"limit"`
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Snapshot returns the state of the keys being counted, most recently seen first, capped at
+// maxSnapshotBuckets. Keys whose window has reset are only dropped by the next sweep, so
+// compare Reset with the current time. Size tells whether the snapshot was truncated.
+func (l *RateLimiter) Snapshot() []BucketState {
+	limit := l.rate.Get()
+
+	l.mu.Lock()
+	buckets := make([]BucketState, 0, min(len(l.counters), maxSnapshotBuckets))
+	for key, counter := range l.counters {
+		buckets = append(buckets, BucketState{
+			Key:       key,
+			Limit:     limit,
+			Remaining: max(limit-counter.count, 0),
+			Reset:     counter.reset,
+			LastSeen:  counter.lastSeen,
+		})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].LastSeen.After(buckets[j].LastSeen)
+	})
+	if len(buckets) > maxSnapshotBuckets {
+		buckets = buckets[:maxSnapshotBuckets]
+	}
+	return buckets
+}
+
+// Size returns the number of keys being counted
+func (l *RateLimiter) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.counters)
+}
@@ -0,0 +1,294 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+// debugLogger records the messages logged at debug level
+type debugLogger struct {
+	logger.Logger
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *debugLogger) DebugContext(_ context.Context, msg string, _ ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, msg)
+}
+
+func TestRateLimitExemptions(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 1,
+		ExemptIPs:         []string{"10.0.0.0/8"},
+		ExemptClients:     []string{"monitoring"},
+	}
+	proxies, err := ParseTrustedProxies([]string{"198.51.100.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		wantStatus   []int
+	}{
+		{"limited", "192.0.2.1:1234", "", []int{200, 429, 429}},
+		{"exempt ip", "10.1.2.3:1234", "", []int{200, 200, 200}},
+		{"exempt ip behind trusted proxy", "198.51.100.1:1234", "10.1.2.3", []int{200, 200, 200}},
+		// Clients connecting directly can't claim an exempt address
+		{"spoofed exempt ip", "192.0.2.1:1234", "10.1.2.3", []int{200, 429, 429}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &debugLogger{}
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware(), ClientIPMiddleware(proxies), RateLimitMiddleware(cfg, WithRateLimitLogger(log)))
+			router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, want := range tt.wantStatus {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = tt.remoteAddr
+				if tt.forwardedFor != "" {
+					req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, w.Code, want)
+				}
+			}
+			if exempt := tt.wantStatus[1] == 200; exempt != (len(log.messages) > 0) {
+				t.Errorf("logged %d exemptions, want them logged only when applied", len(log.messages))
+			}
+		})
+	}
+}
+
+// clientProvider accepts any token named after a client, issued to that client, counting
+// the validations
+type clientProvider struct {
+	provider.IAMProvider
+	calls atomic.Int64
+}
+
+func (p *clientProvider) ValidateToken(_ context.Context, token string) (*provider.TokenInfo, error) {
+	p.calls.Add(1)
+	tokenInfo := &provider.TokenInfo{UserID: "user-1", AuthorizedParty: token}
+	if token == "bound-monitoring" {
+		tokenInfo.AuthorizedParty = "monitoring"
+		tokenInfo.Claims = provider.Claims{"cnf": map[string]interface{}{"jkt": "thumbprint"}}
+	}
+	return tokenInfo, nil
+}
+
+func TestRateLimitDoesNotValidateTokens(t *testing.T) {
+	p := &clientProvider{}
+	cfg := &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 1, ExemptClients: []string{"monitoring"}}
+	router := gin.New()
+	router.Use(ErrorHandlerMiddleware(), RateLimitMiddleware(cfg),
+		AuthMiddleware(p, WithClientRateLimits(NewClientRateLimits(cfg))))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// Requests over the per-IP limit are rejected before their token is looked at, be it
+	// unknown or of an exempt client
+	for i, token := range []string{"first", "unknown-1", "unknown-2", "monitoring"} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		want := http.StatusTooManyRequests
+		if i == 0 {
+			want = http.StatusOK
+		}
+		if w.Code != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, w.Code, want)
+		}
+	}
+	if n := p.calls.Load(); n != 1 {
+		t.Errorf("the provider validated %d tokens, want only the one within the limit", n)
+	}
+}
+
+func TestRateLimitExemptClientAfterAuth(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 100,
+		Tiers:             []config.RateLimitTier{{Name: "partners", RequestsPerSecond: 1, Clients: []string{"partner", "monitoring"}}},
+		ExemptClients:     []string{"monitoring"},
+	}
+
+	tests := []struct {
+		name       string
+		opts       []AuthOption
+		token      string
+		wantStatus []int
+	}{
+		{"tier limited", nil, "partner", []int{200, 429, 429}},
+		{"exempt client", nil, "monitoring", []int{200, 200, 200}},
+		{"exempt client's bound token without a proof", []AuthOption{WithDPoP()}, "bound-monitoring", []int{401, 401, 401}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &clientProvider{}
+			log := &debugLogger{}
+			opts := append([]AuthOption{WithClientRateLimits(NewClientRateLimits(cfg, WithRateLimitLogger(log)))}, tt.opts...)
+			router := gin.New()
+			router.Use(ErrorHandlerMiddleware(), RateLimitMiddleware(cfg), AuthMiddleware(p, opts...))
+			router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			for i, want := range tt.wantStatus {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				if w.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, w.Code, want)
+				}
+			}
+			// Each request is validated once, by the auth middleware
+			if n := p.calls.Load(); n != int64(len(tt.wantStatus)) {
+				t.Errorf("the provider validated %d tokens for %d requests", n, len(tt.wantStatus))
+			}
+			if exempt := tt.wantStatus[1] == 200; exempt != (len(log.messages) > 0) {
+				t.Errorf("logged %d exemptions, want them logged only when applied", len(log.messages))
+			}
+		})
+	}
+}
+
+func TestClientRateLimitsExemptWithoutTiers(t *testing.T) {
+	log := &debugLogger{}
+	limits := NewClientRateLimits(&config.RateLimitConfig{ExemptClients: []string{"monitoring"}}, WithRateLimitLogger(log))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if !limits.allow(c, "monitoring") {
+		t.Fatal("exempt client was limited")
+	}
+	if len(log.messages) != 1 {
+		t.Errorf("logged %d exemptions, want 1", len(log.messages))
+	}
+}
+
+func TestLogExemptionWithoutDebugLogger(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	// Loggers without debug logging, and no logger at all, are skipped
+	logExemption(c, struct{ logger.Logger }{}, "client_id", "monitoring")
+	logExemption(c, nil, "client_id", "monitoring")
+}
+
+func TestRateLimitReloadKeepsCounts(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rate := NewRateLimit(2)
+	limiter := NewRateLimiter(rate)
+	cfg := &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 2}
+	router := gin.New()
+	router.Use(ErrorHandlerMiddleware(), RateLimitMiddleware(cfg, WithLimiter(limiter),
+		WithRateLimitClock(clock.Func(func() time.Time { return now }))))
+	router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	expect := func(step string, want ...int) {
+		t.Helper()
+		for i, code := range want {
+			if got := get(); got != code {
+				t.Errorf("%s, request %d: status = %d, want %d", step, i+1, got, code)
+			}
+		}
+	}
+
+	expect("at 2 rps", 200, 200, 429)
+
+	// Raising the rate extends the current window by the difference
+	rate.Set(4)
+	expect("raised to 4 rps", 200, 200, 429)
+	if n := limiter.Size(); n != 1 {
+		t.Errorf("Size() = %d after the reload, want the bucket kept", n)
+	}
+
+	// Lowering it doesn't grant a fresh burst to a client already over the new rate
+	rate.Set(1)
+	expect("lowered to 1 rps", 429)
+
+	// The next window starts counting at the new rate
+	now = now.Add(time.Second)
+	expect("next window at 1 rps", 200, 429)
+}
+
+func TestRateLimitCost(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cfg := &config.RateLimitConfig{Enabled: true, RequestsPerSecond: 10, Headers: true}
+	costs := map[string]int{"/export": 5, "/bulk": 11, "/free": -3}
+	router := gin.New()
+	router.Use(ErrorHandlerMiddleware(), RateLimitMiddleware(cfg,
+		WithRateLimitClock(clock.Func(func() time.Time { return now })),
+		WithRateLimitCost(func(r *http.Request) int {
+			if cost, ok := costs[r.URL.Path]; ok {
+				return cost
+			}
+			return 1
+		})))
+	router.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	steps := []struct {
+		path          string
+		wantStatus    int
+		wantRemaining string
+	}{
+		{"/export", 200, "5"},
+		{"/bulk", 429, "5"}, // costs more than remains, and uses none of it
+		{"/free", 200, "5"}, // negative costs count as 0
+		{"/export", 200, "0"},
+		{"/free", 200, "0"},
+		{"/cheap", 429, "0"},
+	}
+	for i, step := range steps {
+		req := httptest.NewRequest(http.MethodGet, step.path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != step.wantStatus {
+			t.Errorf("step %d %s: status = %d, want %d", i+1, step.path, w.Code, step.wantStatus)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != step.wantRemaining {
+			t.Errorf("step %d %s: X-RateLimit-Remaining = %s, want %s", i+1, step.path, got, step.wantRemaining)
+		}
+	}
+
+	// A request costing more than the whole limit never passes
+	now = now.Add(time.Second)
+	req := httptest.NewRequest(http.MethodGet, "/bulk", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Remaining") != "10" {
+		t.Errorf("/bulk in a fresh window: status = %d, remaining %s, want 429 with all 10 left",
+			w.Code, w.Header().Get("X-RateLimit-Remaining"))
+	}
+}
@@ -9,29 +9,36 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// RecoveryMiddleware returns a middleware that recovers from panics
+// RecoveryMiddleware returns a middleware that recovers from panics, logs them with their
+// stack trace and responds with a 500. http.ErrAbortHandler is re-panicked, since it is
+// the way handlers abort a response on purpose and net/http handles it silently.
 func RecoveryMiddleware(log logger.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
 				// Get stack trace
 				stack := debug.Stack()
 
 				// Log the error with structured fields
-				log.Error(
+				log.ErrorContext(
+					c.Request.Context(),
 					"Panic recovered",
 					"error", err,
 					"stack", string(stack),
-					"request_id", GetRequestID(c),
 					"path", c.Request.URL.Path,
 					"method", c.Request.Method,
+					"request_id", GetRequestID(c),
 				)
 
 				// Return error response
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
-					"error":      "Internal Server Error",
-					"code":       "INTERNAL_SERVER_ERROR",
-					"request_id": GetRequestID(c),
+				c.AbortWithStatusJSON(http.StatusInternalServerError, APIError{
+					Code:      "INTERNAL_SERVER_ERROR",
+					Message:   "An unexpected error occurred",
+					RequestID: GetRequestID(c),
 				})
 			}
 		}()
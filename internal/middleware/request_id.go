@@ -3,6 +3,7 @@ package middleware
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
 )
 
 const (
@@ -25,6 +26,7 @@ func RequestIDMiddleware() gin.HandlerFunc {
 
 		// Set request ID in context for logging
 		c.Set("request_id", requestID)
+		c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), requestID))
 
 		c.Next()
 	}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+	"github.com/zahidhasanpapon/iam-bridge/internal/session"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+const (
+	// sessionRefreshThreshold is how close to expiry an access token gets refreshed
+	sessionRefreshThreshold = 30 * time.Second
+
+	sessionKey = "session"
+)
+
+// SessionMiddleware loads the server-side session, refreshes the access token when it is
+// near expiry and exposes the validated token information to handlers.
+// Requests without a session pass through unauthenticated.
+func SessionMiddleware(store session.Store, iamProvider provider.IAMProvider, log logger.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sess, err := store.Get(c)
+		if err != nil {
+			if errors.Is(err, session.ErrSessionInvalid) {
+				_ = store.Delete(c)
+			}
+			c.Next()
+			return
+		}
+
+		if sess.ExpiresWithin(sessionRefreshThreshold) {
+			if sess, err = refreshSession(c, store, iamProvider, sess); err != nil {
+				log.InfoContext(c.Request.Context(), "Dropping session after failed refresh", "error", err)
+				_ = store.Delete(c)
+				c.Next()
+				return
+			}
+		}
+
+		tokenInfo, err := iamProvider.ValidateToken(c.Request.Context(), sess.AccessToken)
+		if err != nil {
+			_ = store.Delete(c)
+			c.Next()
+			return
+		}
+
+		c.Set(sessionKey, sess)
+		setTokenInfo(c, tokenInfo)
+
+		c.Next()
+	}
+}
+
+// refreshSession exchanges the session's refresh token for new tokens and persists them
+func refreshSession(c *gin.Context, store session.Store, iamProvider provider.IAMProvider, sess *session.Session) (*session.Session, error) {
+	flow, ok := provider.As[provider.AuthCodeFlowProvider](iamProvider)
+	if !ok || sess.RefreshToken == "" {
+		return nil, provider.ErrTokenExpired
+	}
+
+	tokens, err := flow.RefreshTokens(c.Request.Context(), sess.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed := session.FromTokens(tokens)
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = sess.RefreshToken
+	}
+	if refreshed.IDToken == "" {
+		refreshed.IDToken = sess.IDToken
+	}
+
+	if err := store.Set(c, refreshed); err != nil {
+		return nil, err
+	}
+
+	return refreshed, nil
+}
+
+// GetSession retrieves the session loaded by SessionMiddleware from the context
+func GetSession(c *gin.Context) *session.Session {
+	if sess, exists := c.Get(sessionKey); exists {
+		if s, ok := sess.(*session.Session); ok {
+			return s
+		}
+	}
+	return nil
+}
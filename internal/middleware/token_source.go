@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// Token sources of iam.token_sources
+const (
+	TokenSourceHeader = "header"
+	TokenSourceCookie = "cookie"
+	TokenSourceQuery  = "query"
+)
+
+// TokenExtractor finds the token of a request in one place, such as a header or cookie.
+// It returns an empty token when the request doesn't use the place, and an error when it
+// does but holds no usable token; AuthMiddleware rejects the request then rather than
+// looking elsewhere. The scheme is Bearer or DPoP, or empty when unknown.
+type TokenExtractor interface {
+	Extract(c *gin.Context) (scheme, token string, err error)
+}
+
+// TokenExtractorFunc adapts a function to a TokenExtractor
+type TokenExtractorFunc func(c *gin.Context) (scheme, token string, err error)
+
+// Extract calls f
+func (f TokenExtractorFunc) Extract(c *gin.Context) (string, string, error) {
+	return f(c)
+}
+
+// TokenExtractors tries each extractor in order and returns the first token found. An
+// extractor failing stops the search, so a malformed token is never masked by another source.
+type TokenExtractors []TokenExtractor
+
+// Extract returns the token of the first extractor that finds one
+func (e TokenExtractors) Extract(c *gin.Context) (string, string, error) {
+	for _, extractor := range e {
+		scheme, token, err := extractor.Extract(c)
+		if err != nil || token != "" {
+			return scheme, token, err
+		}
+	}
+	return "", "", nil
+}
+
+// HeaderTokenExtractor extracts the token from the Authorization header, with its Bearer or
+// DPoP scheme. Headers with another scheme are returned whole, for validation to reject.
+func HeaderTokenExtractor() TokenExtractor {
+	return TokenExtractorFunc(func(c *gin.Context) (string, string, error) {
+		if _, present := c.Request.Header["Authorization"]; !present {
+			return "", "", nil
+		}
+		scheme, token := extractAuthorization(c)
+		if strings.TrimSpace(token) == "" {
+			return "", "", fmt.Errorf("%w: empty Authorization header", provider.ErrTokenInvalid)
+		}
+		return scheme, token, nil
+	})
+}
+
+// CookieTokenExtractor extracts a bearer token from the cookie with the given name. Browsers
+// send cookies along cross-site requests, so on methods other than GET, HEAD and OPTIONS the
+// token is only accepted with the csrfHeader header set, which cross-origin pages can't send
+// without CORS allowing it.
+func CookieTokenExtractor(name, csrfHeader string) TokenExtractor {
+	return TokenExtractorFunc(func(c *gin.Context) (string, string, error) {
+		cookie, err := c.Request.Cookie(name)
+		if err != nil {
+			return "", "", nil
+		}
+		if cookie.Value == "" {
+			return "", "", fmt.Errorf("%w: empty %s cookie", provider.ErrTokenInvalid, name)
+		}
+		if !safeMethod(c.Request.Method) && c.GetHeader(csrfHeader) == "" {
+			return "", "", fmt.Errorf("%w: %s cookie sent on %s without the %s header",
+				provider.ErrTokenInvalid, name, c.Request.Method, csrfHeader)
+		}
+		return schemeBearer, cookie.Value, nil
+	})
+}
+
+// safeMethod reports whether method is read-only (RFC 9110, section 9.2.1)
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// QueryTokenExtractor extracts a bearer token from the query parameter with the given name,
+// as by RFC 6750, section 2.3. Tokens in URLs end up in access logs and browser history, so
+// the config only allows it in debug mode.
+func QueryTokenExtractor(param string) TokenExtractor {
+	return TokenExtractorFunc(func(c *gin.Context) (string, string, error) {
+		values, present := c.Request.URL.Query()[param]
+		if !present {
+			return "", "", nil
+		}
+		if len(values) != 1 || values[0] == "" {
+			return "", "", fmt.Errorf("%w: %s query parameter must be given once and not be empty",
+				provider.ErrTokenInvalid, param)
+		}
+		return schemeBearer, values[0], nil
+	})
+}
+
+// NewTokenExtractor builds the extractor chain of the given sources, in order: header,
+// cookie (named cookieName, with csrfHeader on unsafe methods) or query (parameter queryParam)
+func NewTokenExtractor(sources []string, cookieName, csrfHeader, queryParam string) (TokenExtractor, error) {
+	extractors := make(TokenExtractors, 0, len(sources))
+	for _, source := range sources {
+		switch strings.ToLower(source) {
+		case TokenSourceHeader:
+			extractors = append(extractors, HeaderTokenExtractor())
+		case TokenSourceCookie:
+			extractors = append(extractors, CookieTokenExtractor(cookieName, csrfHeader))
+		case TokenSourceQuery:
+			extractors = append(extractors, QueryTokenExtractor(queryParam))
+		default:
+			return nil, fmt.Errorf("unknown token source %q", source)
+		}
+	}
+	return extractors, nil
+}
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+func TestCookieTokenExtractorCSRF(t *testing.T) {
+	extractor := CookieTokenExtractor("access_token", "X-Requested-With")
+
+	tests := []struct {
+		name      string
+		method    string
+		csrf      bool
+		wantToken bool
+	}{
+		{"get", http.MethodGet, false, true},
+		{"head", http.MethodHead, false, true},
+		{"post without csrf header", http.MethodPost, false, false},
+		{"delete without csrf header", http.MethodDelete, false, false},
+		{"post with csrf header", http.MethodPost, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(tt.method, "/", nil)
+			c.Request.AddCookie(&http.Cookie{Name: "access_token", Value: "token"})
+			if tt.csrf {
+				c.Request.Header.Set("X-Requested-With", "XMLHttpRequest")
+			}
+
+			_, token, err := extractor.Extract(c)
+			if tt.wantToken {
+				if err != nil || token != "token" {
+					t.Errorf("Extract() = %q, %v, want the cookie token", token, err)
+				}
+			} else if !errors.Is(err, provider.ErrTokenInvalid) {
+				t.Errorf("Extract() = %q, %v, want ErrTokenInvalid", token, err)
+			}
+		})
+	}
+}
+
+func TestTokenExtractorsOrder(t *testing.T) {
+	extractor, err := NewTokenExtractor([]string{"header", "cookie", "query"}, "access_token", "X-Requested-With", "access_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		header    string
+		cookie    string
+		query     string
+		wantToken string
+		wantErr   bool
+	}{
+		{name: "header first", header: "Bearer from-header", cookie: "from-cookie", wantToken: "from-header"},
+		{name: "cookie before query", cookie: "from-cookie", query: "from-query", wantToken: "from-cookie"},
+		{name: "query", query: "from-query", wantToken: "from-query"},
+		{name: "malformed header isn't masked", header: " ", cookie: "from-cookie", wantErr: true},
+		{name: "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/"
+			if tt.query != "" {
+				target += "?access_token=" + tt.query
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+			if tt.header != "" {
+				c.Request.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: "access_token", Value: tt.cookie})
+			}
+
+			_, token, err := extractor.Extract(c)
+			if (err != nil) != tt.wantErr || token != tt.wantToken {
+				t.Errorf("Extract() = %q, %v, want %q (error %v)", token, err, tt.wantToken, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewTokenExtractor([]string{"carrier-pigeon"}, "", "", ""); err == nil {
+		t.Error("NewTokenExtractor() accepted an unknown source")
+	}
+}
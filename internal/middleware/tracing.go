@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// TraceContext continues the trace of the caller: it extracts the trace context of incoming
+// requests with propagator, so the provider's validation spans are children of the caller's
+// span. Register it before the auth middleware.
+func TraceContext(propagator provider.TracePropagator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(propagator.Extract(c.Request.Context(), c.Request.Header))
+		c.Next()
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type traceparentKey struct{}
+
+// headerPropagator carries the traceparent header in the request context
+type headerPropagator struct{}
+
+func (headerPropagator) Inject(ctx context.Context, header http.Header) {
+	if traceparent, ok := ctx.Value(traceparentKey{}).(string); ok {
+		header.Set("traceparent", traceparent)
+	}
+}
+
+func (headerPropagator) Extract(ctx context.Context, header http.Header) context.Context {
+	if traceparent := header.Get("traceparent"); traceparent != "" {
+		return context.WithValue(ctx, traceparentKey{}, traceparent)
+	}
+	return ctx
+}
+
+func TestTraceContext(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"caller's trace", traceparent},
+		{"no trace", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			router := gin.New()
+			router.Use(TraceContext(headerPropagator{}))
+			router.GET("/", func(c *gin.Context) {
+				got, _ = c.Request.Context().Value(traceparentKey{}).(string)
+				c.Status(http.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("traceparent", tt.header)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.header {
+				t.Errorf("trace context of the request = %q, want %q", got, tt.header)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// DefaultTrustedHeaderPrefix is the prefix of the trusted headers the bridge sets itself,
+// such as X-User-Id
+const DefaultTrustedHeaderPrefix = "X-User-"
+
+// forwardedClaimsHeader is stripped from inbound requests along with the trusted prefix, and
+// set to the forwarded claims of the validated token
+const forwardedClaimsHeader = "X-Forwarded-Claims"
+
+// DefaultForwardedClaims are the claims forwarded unless WithForwardedClaims is used: just
+// enough for backends to identify the user and authorize them
+var DefaultForwardedClaims = []string{"sub", "roles"}
+
+// headerClaims names the claim each default trusted header carries, so headers of claims
+// that aren't forwarded are dropped. principal, roles and groups stand for the values
+// extracted into provider.TokenInfo.
+var headerClaims = map[string]string{
+	"Id":        "sub",
+	"Principal": "principal",
+	"Name":      "preferred_username",
+	"Email":     "email",
+	"Roles":     "roles",
+	"Groups":    "groups",
+}
+
+// TrustedHeaderFunc computes the value of a trusted header from the validated token;
+// an empty value omits the header
+type TrustedHeaderFunc func(*provider.TokenInfo) string
+
+// TrustedHeadersOption configures TrustedHeaders
+type TrustedHeadersOption func(*trustedHeadersOptions)
+
+type trustedHeadersOptions struct {
+	headers         map[string]TrustedHeaderFunc
+	forwardedClaims []string
+	minter          *provider.InternalTokenMinter
+	minterHeader    string
+}
+
+// WithTrustedHeaderSet replaces the injected headers. Keys are header name suffixes that
+// are appended to the prefix, e.g. "Tenant" becomes X-User-Tenant.
+func WithTrustedHeaderSet(headers map[string]TrustedHeaderFunc) TrustedHeadersOption {
+	return func(o *trustedHeadersOptions) {
+		o.headers = headers
+	}
+}
+
+// WithForwardedClaims sets the claims passed on to backends, replacing DefaultForwardedClaims;
+// all others are dropped. The headers of the default set carrying other claims, such as
+// X-User-Email, are omitted, and X-Forwarded-Claims holds the forwarded claims as
+// base64url-encoded JSON. Claims are addressed as by provider.Claims.Get; principal, roles
+// and groups forward the values extracted into provider.TokenInfo.
+func WithForwardedClaims(claims ...string) TrustedHeadersOption {
+	return func(o *trustedHeadersOptions) {
+		o.forwardedClaims = claims
+	}
+}
+
+// WithInternalToken sets header to an internal token minted by minter for the validated
+// token, replacing any the client sent, so upstream services need not trust the IdP
+func WithInternalToken(minter *provider.InternalTokenMinter, header string) TrustedHeadersOption {
+	return func(o *trustedHeadersOptions) {
+		o.minter = minter
+		o.minterHeader = header
+	}
+}
+
+// defaultTrustedHeaders are injected unless WithTrustedHeaderSet is used
+func defaultTrustedHeaders() map[string]TrustedHeaderFunc {
+	return map[string]TrustedHeaderFunc{
+		"Id":        func(t *provider.TokenInfo) string { return t.UserID },
+		"Principal": func(t *provider.TokenInfo) string { return t.Principal },
+		"Name":      func(t *provider.TokenInfo) string { return t.Username },
+		"Email":     func(t *provider.TokenInfo) string { return t.Email },
+		"Roles":     func(t *provider.TokenInfo) string { return strings.Join(t.Roles, ",") },
+		"Groups":    func(t *provider.TokenInfo) string { return strings.Join(t.Groups, ",") },
+	}
+}
+
+// TrustedHeaderWriter writes the trusted headers of validated tokens: headers starting with
+// the prefix, such as X-User-Id and X-User-Roles, limited to the forwarded claims, plus
+// X-Forwarded-Claims and the internal token header. TrustedHeaders sets them on requests
+// passed on to backends; forward-auth endpoints set them on responses, for the reverse proxy
+// to copy onto the upstream request.
+type TrustedHeaderWriter struct {
+	prefix          string
+	canonicalPrefix string
+	headers         map[string]TrustedHeaderFunc
+	forwardedClaims []string
+	minter          *provider.InternalTokenMinter
+	minterHeader    string
+}
+
+// NewTrustedHeaderWriter creates a TrustedHeaderWriter for headers starting with prefix
+// (e.g. "X-User-")
+func NewTrustedHeaderWriter(prefix string, opts ...TrustedHeadersOption) *TrustedHeaderWriter {
+	options := &trustedHeadersOptions{headers: defaultTrustedHeaders(), forwardedClaims: DefaultForwardedClaims}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	forwarded := stringSet(options.forwardedClaims)
+	headers := make(map[string]TrustedHeaderFunc, len(options.headers))
+	for name, value := range options.headers {
+		if claim, ok := headerClaims[name]; ok {
+			if _, allowed := forwarded[claim]; !allowed {
+				continue
+			}
+		}
+		headers[name] = value
+	}
+
+	return &TrustedHeaderWriter{
+		prefix:          prefix,
+		canonicalPrefix: http.CanonicalHeaderKey(prefix),
+		headers:         headers,
+		forwardedClaims: options.forwardedClaims,
+		minter:          options.minter,
+		minterHeader:    options.minterHeader,
+	}
+}
+
+// Strip removes the trusted headers from header, so clients can't supply their own
+func (w *TrustedHeaderWriter) Strip(header http.Header) {
+	for name := range header {
+		if w.canonicalPrefix != "" && strings.HasPrefix(http.CanonicalHeaderKey(name), w.canonicalPrefix) {
+			header.Del(name)
+		}
+	}
+	header.Del(forwardedClaimsHeader)
+	if w.minter != nil {
+		header.Del(w.minterHeader)
+	}
+}
+
+// Set sets the prefixed headers and X-Forwarded-Claims of the validated token
+func (w *TrustedHeaderWriter) Set(header http.Header, tokenInfo *provider.TokenInfo) {
+	for name, value := range w.headers {
+		if v := value(tokenInfo); v != "" {
+			header.Set(w.prefix+name, v)
+		}
+	}
+	if claims := forwardedClaims(tokenInfo, w.forwardedClaims); claims != "" {
+		header.Set(forwardedClaimsHeader, claims)
+	}
+}
+
+// SetInternalToken sets the internal token header to a token minted for the validated
+// token. It does nothing without WithInternalToken.
+func (w *TrustedHeaderWriter) SetInternalToken(ctx context.Context, header http.Header, tokenInfo *provider.TokenInfo) error {
+	if w.minter == nil {
+		return nil
+	}
+	token, err := w.minter.MintInternalToken(ctx, tokenInfo)
+	if err != nil {
+		return err
+	}
+	header.Set(w.minterHeader, token)
+	return nil
+}
+
+// TrustedHeaders removes client-supplied headers starting with prefix (e.g. "X-User-") and
+// X-Forwarded-Claims, then sets trusted versions from the validated token, such as
+// X-User-Id and X-User-Roles, limited to the forwarded claims. Place it after
+// AuthMiddleware; unauthenticated requests only have the headers stripped.
+func TrustedHeaders(prefix string, opts ...TrustedHeadersOption) gin.HandlerFunc {
+	writer := NewTrustedHeaderWriter(prefix, opts...)
+
+	return func(c *gin.Context) {
+		header := c.Request.Header
+		writer.Strip(header)
+
+		if tokenInfo := GetTokenInfo(c); tokenInfo != nil {
+			writer.Set(header, tokenInfo)
+			if err := writer.SetInternalToken(c.Request.Context(), header, tokenInfo); err != nil {
+				abortWithError(c, err)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// forwardedClaims encodes the given claims of the token that are present as base64url JSON,
+// or returns "" when there are none
+func forwardedClaims(tokenInfo *provider.TokenInfo, names []string) string {
+	claims := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		switch name {
+		case "principal":
+			claims[name] = tokenInfo.Principal
+		case "roles":
+			claims[name] = tokenInfo.Roles
+		case "groups":
+			claims[name] = tokenInfo.Groups
+		default:
+			if v, ok := tokenInfo.Claims.Get(name); ok {
+				claims[name] = v
+			}
+		}
+	}
+	if len(claims) == 0 {
+		return ""
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+func TestTrustedHeaders(t *testing.T) {
+	tokenInfo := &provider.TokenInfo{
+		UserID:   "user-1",
+		Username: "alice",
+		Email:    "alice@example.com",
+		Roles:    []string{"admin", "user"},
+		Claims:   provider.Claims{"sub": "user-1", "email": "alice@example.com", "tenant": "acme"},
+	}
+
+	tests := []struct {
+		name        string
+		opts        []TrustedHeadersOption
+		wantHeaders map[string]string
+		wantClaims  map[string]interface{}
+	}{
+		{
+			name:        "default claims",
+			wantHeaders: map[string]string{"X-User-Id": "user-1", "X-User-Roles": "admin,user"},
+			wantClaims:  map[string]interface{}{"sub": "user-1", "roles": []interface{}{"admin", "user"}},
+		},
+		{
+			name:        "email and custom claim",
+			opts:        []TrustedHeadersOption{WithForwardedClaims("email", "tenant")},
+			wantHeaders: map[string]string{"X-User-Email": "alice@example.com"},
+			wantClaims:  map[string]interface{}{"email": "alice@example.com", "tenant": "acme"},
+		},
+		{
+			name:        "nothing forwarded",
+			opts:        []TrustedHeadersOption{WithForwardedClaims()},
+			wantHeaders: map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			router := gin.New()
+			router.Use(func(c *gin.Context) { setTokenInfo(c, tokenInfo) })
+			router.Use(TrustedHeaders(DefaultTrustedHeaderPrefix, tt.opts...))
+			router.GET("/", func(c *gin.Context) { got = c.Request.Header.Clone() })
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-User-Id", "spoofed")
+			req.Header.Set("X-User-Email", "spoofed@example.com")
+			req.Header.Set("X-Forwarded-Claims", "spoofed")
+			router.ServeHTTP(httptest.NewRecorder(), req)
+
+			for name := range got {
+				if want, ok := tt.wantHeaders[name]; ok {
+					if got.Get(name) != want {
+						t.Errorf("%s = %q, want %q", name, got.Get(name), want)
+					}
+				} else if strings.HasPrefix(name, DefaultTrustedHeaderPrefix) {
+					t.Errorf("unexpected header %s = %q", name, got.Get(name))
+				}
+			}
+			for name := range tt.wantHeaders {
+				if got.Get(name) == "" {
+					t.Errorf("missing header %s", name)
+				}
+			}
+
+			encoded := got.Get("X-Forwarded-Claims")
+			if tt.wantClaims == nil {
+				if encoded != "" {
+					t.Errorf("X-Forwarded-Claims = %q, want none", encoded)
+				}
+				return
+			}
+			data, err := base64.RawURLEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatalf("X-Forwarded-Claims is not base64url: %v", err)
+			}
+			var claims map[string]interface{}
+			if err := json.Unmarshal(data, &claims); err != nil {
+				t.Fatalf("X-Forwarded-Claims is not JSON: %v", err)
+			}
+			if len(claims) != len(tt.wantClaims) {
+				t.Errorf("X-Forwarded-Claims = %v, want %v", claims, tt.wantClaims)
+			}
+			for name, want := range tt.wantClaims {
+				wantJSON, _ := json.Marshal(want)
+				gotJSON, _ := json.Marshal(claims[name])
+				if string(gotJSON) != string(wantJSON) {
+					t.Errorf("claim %s = %s, want %s", name, gotJSON, wantJSON)
+				}
+			}
+		})
+	}
+}
+
+func TestTrustedHeadersStripsUnauthenticated(t *testing.T) {
+	var got http.Header
+	router := gin.New()
+	router.Use(TrustedHeaders(DefaultTrustedHeaderPrefix))
+	router.GET("/", func(c *gin.Context) { got = c.Request.Header.Clone() })
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User-Roles", "admin")
+	req.Header.Set("X-Forwarded-Claims", "spoofed")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got.Get("X-User-Roles") != "" || got.Get("X-Forwarded-Claims") != "" {
+		t.Errorf("client-supplied trusted headers were passed on: %v", got)
+	}
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// AtomicProvider holds the current IAM provider and lets it be swapped while the server
+// is running, e.g. on config reload. Each call uses the provider current at the time it
+// starts, so in-flight requests finish on the provider they began with.
+type AtomicProvider struct {
+	current atomic.Pointer[providerRef]
+}
+
+// providerRef boxes an IAMProvider, since atomic.Pointer needs a concrete type
+type providerRef struct {
+	IAMProvider
+}
+
+// NewAtomicProvider creates an AtomicProvider starting with p
+func NewAtomicProvider(p IAMProvider) *AtomicProvider {
+	a := &AtomicProvider{}
+	a.Swap(p)
+	return a
+}
+
+// Load returns the current provider
+func (a *AtomicProvider) Load() IAMProvider {
+	return a.current.Load().IAMProvider
+}
+
+// Swap installs p for new calls and returns the previous provider
+func (a *AtomicProvider) Swap(p IAMProvider) IAMProvider {
+	old := a.current.Swap(&providerRef{p})
+	if old == nil {
+		return nil
+	}
+	return old.IAMProvider
+}
+
+// Close closes the current provider
+func (a *AtomicProvider) Close() error {
+	return Close(a.Load())
+}
+
+// Unwrap returns the current provider, keeping its capabilities reachable through As
+func (a *AtomicProvider) Unwrap() IAMProvider {
+	return a.Load()
+}
+
+func (a *AtomicProvider) Login(ctx context.Context, username, password string) (string, error) {
+	return a.Load().Login(ctx, username, password)
+}
+
+func (a *AtomicProvider) Logout(ctx context.Context, token string) error {
+	return a.Load().Logout(ctx, token)
+}
+
+func (a *AtomicProvider) ValidateToken(ctx context.Context, token string) (*TokenInfo, error) {
+	return a.Load().ValidateToken(ctx, token)
+}
+
+func (a *AtomicProvider) RefreshToken(ctx context.Context, token string) (string, error) {
+	return a.Load().RefreshToken(ctx, token)
+}
+
+func (a *AtomicProvider) GetUserInfo(ctx context.Context, userID string) (*UserInfo, error) {
+	return a.Load().GetUserInfo(ctx, userID)
+}
+
+func (a *AtomicProvider) UpdateUserInfo(ctx context.Context, userID string, userInfo *UserInfo) error {
+	return a.Load().UpdateUserInfo(ctx, userID, userInfo)
+}
+
+func (a *AtomicProvider) AssignRole(ctx context.Context, userID, role string) error {
+	return a.Load().AssignRole(ctx, userID, role)
+}
+
+func (a *AtomicProvider) RemoveRole(ctx context.Context, userID, role string) error {
+	return a.Load().RemoveRole(ctx, userID, role)
+}
+
+func (a *AtomicProvider) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	return a.Load().GetUserRoles(ctx, userID)
+}
+
+func (a *AtomicProvider) HealthCheck(ctx context.Context) error {
+	return a.Load().HealthCheck(ctx)
+}
@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// namedProvider accepts every token as its own user, holding validations while hold is set
+type namedProvider struct {
+	IAMProvider
+	name    string
+	hold    chan struct{}
+	started chan struct{}
+	closed  bool
+}
+
+func (p *namedProvider) ValidateToken(context.Context, string) (*TokenInfo, error) {
+	if p.hold != nil {
+		p.started <- struct{}{}
+		<-p.hold
+	}
+	return &TokenInfo{UserID: p.name}, nil
+}
+
+func (p *namedProvider) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestAtomicProviderSwapKeepsInFlightCalls(t *testing.T) {
+	previous := &namedProvider{name: "previous", hold: make(chan struct{}), started: make(chan struct{})}
+	next := &namedProvider{name: "next"}
+	p := NewAtomicProvider(previous)
+
+	done := make(chan string)
+	go func() {
+		info, _ := p.ValidateToken(context.Background(), "token")
+		done <- info.UserID
+	}()
+	<-previous.started
+
+	if old := p.Swap(next); old != previous {
+		t.Fatalf("Swap() returned %v, want the previous provider", old)
+	}
+	if info, _ := p.ValidateToken(context.Background(), "token"); info.UserID != "next" {
+		t.Errorf("ValidateToken() after the swap ran on %q, want next", info.UserID)
+	}
+
+	close(previous.hold)
+	if user := <-done; user != "previous" {
+		t.Errorf("ValidateToken() in flight during the swap ran on %q, want previous", user)
+	}
+
+	if err := p.Close(); err != nil || !next.closed || previous.closed {
+		t.Errorf("Close() = %v closed next %v, previous %v, want only the current provider closed", err, next.closed, previous.closed)
+	}
+	if current, ok := As[*namedProvider](p); !ok || current != next {
+		t.Errorf("As() = %v, %v, want the current provider", current, ok)
+	}
+}
+
+func TestAtomicProviderConcurrentSwaps(t *testing.T) {
+	providers := []*namedProvider{{name: "a"}, {name: "b"}}
+	p := NewAtomicProvider(providers[0])
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				info, err := p.ValidateToken(context.Background(), "token")
+				if err != nil || (info.UserID != "a" && info.UserID != "b") {
+					t.Errorf("ValidateToken() = %v, %v, want a token of a or b", info, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 1000; j++ {
+			p.Swap(providers[j%2])
+		}
+	}()
+	wg.Wait()
+}
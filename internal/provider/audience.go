@@ -0,0 +1,40 @@
+package provider
+
+import (
+	"context"
+	"strings"
+)
+
+type audienceContextKey struct{}
+
+// WithExpectedAudiences returns a copy of ctx carrying the audiences a token must be issued for.
+// They override the provider's statically configured audiences for that validation.
+func WithExpectedAudiences(ctx context.Context, audiences []string) context.Context {
+	return context.WithValue(ctx, audienceContextKey{}, audiences)
+}
+
+// ExpectedAudiences returns the audiences set by WithExpectedAudiences, if any
+func ExpectedAudiences(ctx context.Context) ([]string, bool) {
+	audiences, ok := ctx.Value(audienceContextKey{}).([]string)
+	return audiences, ok
+}
+
+// checkAudience verifies the token was issued for at least one of the expected audiences.
+// Audiences from ctx take precedence over the static list; an empty expectation accepts any audience.
+func checkAudience(ctx context.Context, tokenInfo *TokenInfo, static []string) error {
+	expected, ok := ExpectedAudiences(ctx)
+	if !ok {
+		expected = static
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+
+	for _, aud := range tokenInfo.Audience {
+		if containsString(expected, aud) {
+			return nil
+		}
+	}
+	return newValidationError(ErrInvalidAudience, ReasonWrongAudience, "token audience not accepted").
+		withClaim("aud", strings.Join(tokenInfo.Audience, " "))
+}
@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"context"
+	"sync"
+)
+
+// maxBatchParallelism bounds the validations ValidateTokens runs at once
+const maxBatchParallelism = 8
+
+// ValidateTokens validates several tokens concurrently, such as a request's delegated tokens,
+// with at most maxBatchParallelism validations in flight. Results are returned in the order of
+// tokens: for each token either its TokenInfo or its error is set. Tokens sharing the same
+// JWKS are verified against the cached keys, so a batch costs little more than one token.
+func ValidateTokens(ctx context.Context, p IAMProvider, tokens []string) ([]*TokenInfo, []error) {
+	infos := make([]*TokenInfo, len(tokens))
+	errs := make([]error, len(tokens))
+
+	sem := make(chan struct{}, maxBatchParallelism)
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			// Tokens not started yet fail with the context error
+			for j := i; j < len(tokens); j++ {
+				errs[j] = ctx.Err()
+			}
+			wg.Wait()
+			return infos, errs
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			infos[i], errs[i] = p.ValidateToken(ctx, token)
+		}()
+	}
+
+	wg.Wait()
+	return infos, errs
+}
@@ -0,0 +1,111 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingProvider accepts tokens named ok-*, recording how many validations ran at once
+type countingProvider struct {
+	IAMProvider
+	delay time.Duration
+
+	inFlight atomic.Int64
+	peak     atomic.Int64
+}
+
+func (p *countingProvider) ValidateToken(_ context.Context, token string) (*TokenInfo, error) {
+	n := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for peak := p.peak.Load(); n > peak && !p.peak.CompareAndSwap(peak, n); peak = p.peak.Load() {
+	}
+	time.Sleep(p.delay)
+
+	if len(token) < 3 || token[:3] != "ok-" {
+		return nil, ErrTokenInvalid
+	}
+	return &TokenInfo{UserID: token[3:]}, nil
+}
+
+func TestValidateTokens(t *testing.T) {
+	p := &countingProvider{delay: 5 * time.Millisecond}
+	var tokens []string
+	for i := 0; i < 30; i++ {
+		if i%3 == 0 {
+			tokens = append(tokens, "bad-"+strconv.Itoa(i))
+		} else {
+			tokens = append(tokens, "ok-"+strconv.Itoa(i))
+		}
+	}
+
+	infos, errs := ValidateTokens(context.Background(), p, tokens)
+	if len(infos) != len(tokens) || len(errs) != len(tokens) {
+		t.Fatalf("ValidateTokens() returned %d infos and %d errors for %d tokens", len(infos), len(errs), len(tokens))
+	}
+	for i := range tokens {
+		if i%3 == 0 {
+			if infos[i] != nil || !errors.Is(errs[i], ErrTokenInvalid) {
+				t.Errorf("token %d: %v, %v, want ErrTokenInvalid", i, infos[i], errs[i])
+			}
+			continue
+		}
+		if errs[i] != nil || infos[i] == nil || infos[i].UserID != strconv.Itoa(i) {
+			t.Errorf("token %d: %v, %v, want its own token info", i, infos[i], errs[i])
+		}
+	}
+	if peak := p.peak.Load(); peak > maxBatchParallelism {
+		t.Errorf("%d validations ran at once, want at most %d", peak, maxBatchParallelism)
+	}
+}
+
+func TestValidateTokensCanceled(t *testing.T) {
+	p := &countingProvider{delay: 50 * time.Millisecond}
+	tokens := make([]string, 3*maxBatchParallelism)
+	for i := range tokens {
+		tokens[i] = "ok-" + strconv.Itoa(i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for p.inFlight.Load() < maxBatchParallelism {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	infos, errs := ValidateTokens(ctx, p, tokens)
+	cancel()
+
+	// The first batch was started before the cancellation and finishes; the rest fail
+	for i := 0; i < maxBatchParallelism; i++ {
+		if errs[i] != nil || infos[i] == nil {
+			t.Errorf("token %d, started before the cancellation: %v, %v, want validated", i, infos[i], errs[i])
+		}
+	}
+	if last := len(tokens) - 1; !errors.Is(errs[last], context.Canceled) {
+		t.Errorf("token %d, not started: error = %v, want context.Canceled", last, errs[last])
+	}
+}
+
+// A batch of 16 tokens costs 16 times BenchmarkValidateToken_CacheMiss in CPU, spread over
+// up to maxBatchParallelism cores
+func BenchmarkValidateTokens(b *testing.B) {
+	p, token := benchmarkProvider(b)
+	tokens := make([]string, 16)
+	for i := range tokens {
+		tokens[i] = token
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, errs := ValidateTokens(ctx, p, tokens)
+		if errs[0] != nil {
+			b.Fatal(errs[0])
+		}
+	}
+}
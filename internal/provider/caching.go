@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/metrics"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+// defaultCacheMaxEntries bounds the cache when no size is configured
+const defaultCacheMaxEntries = 10000
+
+// CachingProvider wraps an IAM provider and caches successful token validations
+// in a TokenCache until the configured TTL or the token expiry, whichever comes first.
+// Cached TokenInfo values are shared between requests and must be treated as read-only.
+//
+// In degraded mode, entries past their TTL are kept for a grace period and served when
+// the wrapped provider is unavailable, as long as the token itself has not expired.
+type CachingProvider struct {
+	IAMProvider
+
+	cache    TokenCache
+	salt     [sha256.Size]byte
+	ttl      time.Duration
+	grace    int64
+	logger   *logger.Logger
+	clock    clock.Clock
+	degraded atomic.Bool
+}
+
+// NewCachingProvider creates a new CachingProvider instance. A nil cache selects an
+// in-process LRUTokenCache sized by cfg.MaxEntries.
+func NewCachingProvider(next IAMProvider, cache TokenCache, cfg *config.CacheConfig,
+	degraded *config.DegradedModeConfig, log *logger.Logger) *CachingProvider {
+	if cache == nil {
+		cache = NewLRUTokenCache(cfg.MaxEntries, nil)
+	}
+
+	var grace int64
+	if degraded.Enabled {
+		grace = int64(degraded.GracePeriod / time.Second)
+	}
+
+	return &CachingProvider{
+		IAMProvider: next,
+		cache:       cache,
+		salt:        cacheKeySalt(cfg.KeySalt),
+		ttl:         cfg.TTL,
+		grace:       grace,
+		logger:      log,
+		clock:       clock.System(),
+	}
+}
+
+// cacheKeySalt derives the salt mixed into cache keys. Without a configured salt a random
+// one is used, which is fine for a cache that is not shared with other instances.
+func cacheKeySalt(configured string) [sha256.Size]byte {
+	if configured != "" {
+		return sha256.Sum256([]byte(configured))
+	}
+
+	var salt [sha256.Size]byte
+	_, _ = rand.Read(salt[:])
+	return salt
+}
+
+// ValidateToken returns the cached validation result for the token or delegates to the
+// wrapped provider on a miss
+func (p *CachingProvider) ValidateToken(ctx context.Context, token string) (*TokenInfo, error) {
+	key := p.cacheKey(ctx, token)
+	now := p.clock.Now().Unix()
+
+	cached, fresh := p.get(ctx, key, now)
+	if fresh {
+		return cached, nil
+	}
+
+	tokenInfo, err := p.IAMProvider.ValidateToken(ctx, token)
+	if err != nil {
+		if cached != nil && errors.Is(err, ErrProviderUnavailable) {
+			p.enterDegradedMode(ctx, err)
+			metrics.DegradedValidations.Add(1)
+			return cached, nil
+		}
+		if cached != nil {
+			p.delete(ctx, key)
+		}
+		return nil, err
+	}
+	p.leaveDegradedMode(ctx)
+
+	p.set(ctx, key, tokenInfo, now)
+
+	return tokenInfo, nil
+}
+
+// enterDegradedMode records that validations are being served from the cache and logs
+// the transition once
+func (p *CachingProvider) enterDegradedMode(ctx context.Context, err error) {
+	if p.degraded.Swap(true) {
+		return
+	}
+	metrics.DegradedMode.Set(1)
+	if p.logger != nil {
+		(*p.logger).WarnContext(ctx, "IAM provider unavailable, serving cached token validations in degraded mode",
+			"grace_period", time.Duration(p.grace)*time.Second, "error", err)
+	}
+}
+
+// leaveDegradedMode logs the recovery once the wrapped provider validates tokens again
+func (p *CachingProvider) leaveDegradedMode(ctx context.Context) {
+	if !p.degraded.Swap(false) {
+		return
+	}
+	metrics.DegradedMode.Set(0)
+	if p.logger != nil {
+		(*p.logger).InfoContext(ctx, "IAM provider recovered, leaving degraded mode")
+	}
+}
+
+// Close drops the cached validations held in process memory and closes the wrapped
+// provider. Other cache backends may be shared and are left to their owner to close.
+func (p *CachingProvider) Close() error {
+	if lru, ok := p.cache.(*LRUTokenCache); ok {
+		lru.Clear()
+	}
+	return Close(p.IAMProvider)
+}
+
+// Unwrap returns the wrapped provider
+func (p *CachingProvider) Unwrap() IAMProvider {
+	return p.IAMProvider
+}
+
+// cacheKey computes the salted cache key of a token as SHA-256(salt || SHA-256(token) ||
+// audiences), where audiences digests the expected audiences set on ctx. A token accepted
+// for one audience must not be served from the cache to a request expecting another.
+// The token is hashed in place instead of being copied into a []byte and the salted input
+// lives on the stack, which keeps cache hits free of heap allocations.
+func (p *CachingProvider) cacheKey(ctx context.Context, token string) CacheKey {
+	var input [3 * sha256.Size]byte
+	copy(input[:sha256.Size], p.salt[:])
+	tokenHash := sha256.Sum256(unsafe.Slice(unsafe.StringData(token), len(token)))
+	copy(input[sha256.Size:], tokenHash[:])
+	audiencesDigest(ctx, input[2*sha256.Size:])
+	return sha256.Sum256(input[:])
+}
+
+// audiencesDigest writes a digest of the expected audiences on ctx to out, independent of
+// their order and duplicates. out is left zeroed when ctx carries none, since the provider's
+// static audiences are the same for every request.
+func audiencesDigest(ctx context.Context, out []byte) {
+	audiences, ok := ExpectedAudiences(ctx)
+	if !ok {
+		return
+	}
+
+	sorted := slices.Compact(slices.Sorted(slices.Values(audiences)))
+	h := sha256.New()
+	// The marker keeps an explicit empty expectation, which accepts any audience, apart
+	// from no expectation
+	h.Write([]byte{1})
+	for _, aud := range sorted {
+		h.Write([]byte(aud))
+		h.Write([]byte{0})
+	}
+	h.Sum(out[:0])
+}
+
+// get returns the cached validation result for key and whether it is still fresh. Entries
+// past their TTL are returned as stale during the degraded-mode grace period, unless the
+// token expired. Cache failures are logged and treated as misses.
+func (p *CachingProvider) get(ctx context.Context, key CacheKey, now int64) (*TokenInfo, bool) {
+	entry, err := p.cache.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			p.logCacheError(ctx, "read", err)
+		}
+		return nil, false
+	}
+
+	if entry.FreshUntil > now {
+		return entry.TokenInfo, true
+	}
+
+	tokenExpiresAt := entry.TokenInfo.ExpiresAt
+	if entry.FreshUntil+p.grace > now && (tokenExpiresAt == 0 || tokenExpiresAt > now) {
+		return entry.TokenInfo, false
+	}
+
+	p.delete(ctx, key)
+	return nil, false
+}
+
+// set caches a validation result until the TTL or the token expiry, whichever comes first,
+// keeping it in the backend for the degraded-mode grace period beyond that
+func (p *CachingProvider) set(ctx context.Context, key CacheKey, tokenInfo *TokenInfo, now int64) {
+	freshUntil := now + int64(p.ttl/time.Second)
+	if tokenInfo.ExpiresAt > 0 && tokenInfo.ExpiresAt < freshUntil {
+		freshUntil = tokenInfo.ExpiresAt
+	}
+	if freshUntil <= now {
+		return
+	}
+
+	keepUntil := freshUntil + p.grace
+	if tokenInfo.ExpiresAt > 0 && tokenInfo.ExpiresAt < keepUntil {
+		keepUntil = tokenInfo.ExpiresAt
+	}
+
+	entry := &CacheEntry{TokenInfo: tokenInfo, FreshUntil: freshUntil}
+	if err := p.cache.Set(ctx, key, entry, time.Duration(keepUntil-now)*time.Second); err != nil {
+		p.logCacheError(ctx, "write", err)
+	}
+}
+
+// delete removes the entry for key, if any
+func (p *CachingProvider) delete(ctx context.Context, key CacheKey) {
+	if err := p.cache.Delete(ctx, key); err != nil {
+		p.logCacheError(ctx, "delete", err)
+	}
+}
+
+// logCacheError reports a failing cache backend; validation carries on without the cache
+func (p *CachingProvider) logCacheError(ctx context.Context, op string, err error) {
+	if p.logger != nil {
+		(*p.logger).WarnContext(ctx, "Token cache "+op+" failed", "error", err)
+	}
+}
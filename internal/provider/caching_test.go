@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"context"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// benchmarkProvider returns a provider verifying tokens locally against an inline JWKS, and
+// a token it accepts
+func benchmarkProvider(b *testing.B) (IAMProvider, string) {
+	b.Helper()
+	key, err := NewSigningKey(mustGenerateECKey(b, elliptic.P256()), "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys{key})
+	if err != nil {
+		b.Fatal(err)
+	}
+	jwks, err := json.Marshal(set)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	p, err := NewKeycloakProvider(config.KeycloakConfig{
+		BaseURL:         "https://kc.example.com",
+		Realm:           "test",
+		ClientID:        "bridge",
+		ClientSecret:    "secret",
+		TokenValidation: "jwks",
+		JWKSInline:      string(jwks),
+	}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.ID}, map[string]interface{}{
+		"sub":   "user-1",
+		"iss":   "https://kc.example.com/realms/test",
+		"azp":   "web",
+		"scope": "openid profile email",
+		"realm_access": map[string]interface{}{
+			"roles": []string{"user", "admin"},
+		},
+		"iat": float64(time.Now().Unix()),
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	}, key.Key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return p, token
+}
+
+// Cache hits run without allocating; misses verify the token's signature, and pooling the
+// buffers segments are decoded into took them from 106 to 100 allocs/op.
+func BenchmarkValidateToken_CacheHit(b *testing.B) {
+	next, token := benchmarkProvider(b)
+	p := NewCachingProvider(next, nil, &config.CacheConfig{TTL: time.Minute, MaxEntries: 100},
+		&config.DegradedModeConfig{}, nil)
+	ctx := context.Background()
+	if _, err := p.ValidateToken(ctx, token); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ValidateToken(ctx, token); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateToken_CacheMiss(b *testing.B) {
+	p, token := benchmarkProvider(b)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ValidateToken(ctx, token); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCacheKeyAudiences(t *testing.T) {
+	p := NewCachingProvider(nil, nil, &config.CacheConfig{KeySalt: "salt"}, &config.DegradedModeConfig{}, nil)
+	key := func(token string, audiences []string) CacheKey {
+		ctx := context.Background()
+		if audiences != nil {
+			ctx = WithExpectedAudiences(ctx, audiences)
+		}
+		return p.cacheKey(ctx, token)
+	}
+
+	base := key("token", []string{"orders", "billing"})
+	tests := []struct {
+		name      string
+		token     string
+		audiences []string
+		wantSame  bool
+	}{
+		{"same audiences", "token", []string{"orders", "billing"}, true},
+		{"other order", "token", []string{"billing", "orders"}, true},
+		{"duplicates", "token", []string{"billing", "orders", "billing"}, true},
+		{"other audiences", "token", []string{"orders"}, false},
+		{"other token", "other", []string{"orders", "billing"}, false},
+		{"joined audience", "token", []string{"ordersbilling"}, false},
+		{"no expectation", "token", nil, false},
+		{"empty expectation", "token", []string{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := key(tt.token, tt.audiences) == base; same != tt.wantSame {
+				t.Errorf("key equals the base key = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+
+	if key("token", nil) == key("token", []string{}) {
+		t.Error("no expectation and an empty expectation share a key")
+	}
+	other := NewCachingProvider(nil, nil, &config.CacheConfig{KeySalt: "other"}, &config.DegradedModeConfig{}, nil)
+	if other.cacheKey(context.Background(), "token") == p.cacheKey(context.Background(), "token") {
+		t.Error("keys with different salts are equal")
+	}
+}
+
+// audienceProvider accepts tokens for the audience "orders" only, counting validations
+type audienceProvider struct {
+	IAMProvider
+	calls int
+}
+
+func (a *audienceProvider) ValidateToken(ctx context.Context, _ string) (*TokenInfo, error) {
+	a.calls++
+	info := &TokenInfo{UserID: "user-1", Audience: []string{"orders"}, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	if err := checkAudience(ctx, info, nil); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func TestCachingProviderKeysOnResolvedAudiences(t *testing.T) {
+	next := &audienceProvider{}
+	p := NewCachingProvider(next, nil, &config.CacheConfig{TTL: time.Minute, MaxEntries: 10}, &config.DegradedModeConfig{}, nil)
+	orders := WithExpectedAudiences(context.Background(), []string{"orders"})
+	billing := WithExpectedAudiences(context.Background(), []string{"billing"})
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.ValidateToken(orders, "token"); err != nil {
+			t.Fatalf("ValidateToken() for orders error = %v", err)
+		}
+	}
+	if next.calls != 1 {
+		t.Errorf("validated %d times for the same audience, want the second served from cache", next.calls)
+	}
+
+	// A route resolving another audience doesn't get the cached result
+	if _, err := p.ValidateToken(billing, "token"); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("ValidateToken() for billing error = %v, want ErrInvalidAudience", err)
+	}
+	if next.calls != 2 {
+		t.Errorf("validated %d times, want the other audience validated again", next.calls)
+	}
+}
+
+func TestLRUTokenCacheExpiresByClock(t *testing.T) {
+	clk := newFakeClock()
+	cache := NewLRUTokenCache(10, clk)
+	ctx := context.Background()
+	key := CacheKey{1}
+	if err := cache.Set(ctx, key, &CacheEntry{TokenInfo: &TokenInfo{UserID: "user-1"}}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(time.Minute - time.Second)
+	if _, err := cache.Get(ctx, key); err != nil {
+		t.Fatalf("Get() within the TTL error = %v", err)
+	}
+	clk.Advance(time.Second)
+	if _, err := cache.Get(ctx, key); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Get() once the TTL elapsed error = %v, want ErrCacheMiss", err)
+	}
+}
@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ChainProvider validates tokens against several providers in order, for running two IdPs
+// side by side during a migration. A token is passed on to the next provider only when the
+// previous one doesn't know its issuer or signing key; any other failure, such as an
+// expired token or a bad signature, is definitive. All other calls go to the first provider.
+type ChainProvider struct {
+	providers []IAMProvider
+}
+
+// NewChainProvider creates a new ChainProvider trying providers in the given order
+func NewChainProvider(providers ...IAMProvider) (*ChainProvider, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("provider chain needs at least one provider")
+	}
+	return &ChainProvider{providers: providers}, nil
+}
+
+// Unwrap returns the primary provider, keeping its capabilities reachable through As
+func (c *ChainProvider) Unwrap() IAMProvider {
+	return c.providers[0]
+}
+
+// ValidateToken returns the result of the first provider that accepts the token. When every
+// provider rejects it as unknown, their errors are joined.
+func (c *ChainProvider) ValidateToken(ctx context.Context, token string) (*TokenInfo, error) {
+	var errs []error
+	for _, p := range c.providers {
+		tokenInfo, err := p.ValidateToken(ctx, token)
+		if err == nil {
+			return tokenInfo, nil
+		}
+		if !isUnknownToken(err) {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// isUnknownToken reports whether err means the provider doesn't know where the token comes
+// from, so another provider may still accept it
+func isUnknownToken(err error) bool {
+	return errors.Is(err, ErrInvalidIssuer) || errors.Is(err, ErrKeyNotFound)
+}
+
+func (c *ChainProvider) Login(ctx context.Context, username, password string) (string, error) {
+	return c.providers[0].Login(ctx, username, password)
+}
+
+func (c *ChainProvider) Logout(ctx context.Context, token string) error {
+	return c.providers[0].Logout(ctx, token)
+}
+
+func (c *ChainProvider) RefreshToken(ctx context.Context, token string) (string, error) {
+	return c.providers[0].RefreshToken(ctx, token)
+}
+
+func (c *ChainProvider) GetUserInfo(ctx context.Context, userID string) (*UserInfo, error) {
+	return c.providers[0].GetUserInfo(ctx, userID)
+}
+
+func (c *ChainProvider) UpdateUserInfo(ctx context.Context, userID string, userInfo *UserInfo) error {
+	return c.providers[0].UpdateUserInfo(ctx, userID, userInfo)
+}
+
+func (c *ChainProvider) AssignRole(ctx context.Context, userID, role string) error {
+	return c.providers[0].AssignRole(ctx, userID, role)
+}
+
+func (c *ChainProvider) RemoveRole(ctx context.Context, userID, role string) error {
+	return c.providers[0].RemoveRole(ctx, userID, role)
+}
+
+func (c *ChainProvider) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	return c.providers[0].GetUserRoles(ctx, userID)
+}
+
+// HealthCheck checks every provider in the chain and reports all failures, since tokens of
+// any of them may need validating
+func (c *ChainProvider) HealthCheck(ctx context.Context) error {
+	var failures []string
+	for i, p := range c.providers {
+		if err := p.HealthCheck(ctx); err != nil {
+			failures = append(failures, fmt.Sprintf("provider %d: %v", i, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("health check failed for chained providers: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Warmup warms up every provider in the chain and reports all failures
+func (c *ChainProvider) Warmup(ctx context.Context) error {
+	var failures []string
+	for i, p := range c.providers {
+		if w, ok := As[Warmer](p); ok {
+			if err := w.Warmup(ctx); err != nil {
+				failures = append(failures, fmt.Sprintf("provider %d: %v", i, err))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("warmup failed for chained providers: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// SelfTest tests every provider in the chain and reports all failures
+func (c *ChainProvider) SelfTest(ctx context.Context) error {
+	var failures []string
+	for i, p := range c.providers {
+		if t, ok := As[SelfTester](p); ok {
+			if err := t.SelfTest(ctx); err != nil {
+				failures = append(failures, fmt.Sprintf("provider %d: %v", i, err))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("self test failed for chained providers: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Close closes every provider in the chain
+func (c *ChainProvider) Close() error {
+	return closeAll(c.providers...)
+}
@@ -0,0 +1,175 @@
+package provider
+
+import (
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// Claims is a token's decoded claim set. It is keyed by the claim names of the token, so it
+// serializes to JSON under the standard names (sub, email, realm_access, ...) and
+// round-trips unchanged, apart from numbers, which decode as float64.
+type Claims map[string]interface{}
+
+// Get returns the claim at path, with nested objects addressed by dotted paths such as
+// "org.department". Keys containing dots, such as URL-style claim names like
+// "https://myapp.example.com/roles", are matched whole, preferring the longest key, so they
+// can be addressed as such or as part of a path. It reports false when any part is missing.
+func (c Claims) Get(path string) (interface{}, bool) {
+	if v, ok := c[path]; ok {
+		return v, true
+	}
+
+	for i := strings.LastIndexByte(path, '.'); i > 0; i = strings.LastIndexByte(path[:i], '.') {
+		child, ok := c[path[:i]].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if v, ok := Claims(child).Get(path[i+1:]); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// GetString returns the string claim at path, as addressed by Get
+func (c Claims) GetString(path string) (string, bool) {
+	v, ok := c.Get(path)
+	if !ok {
+		return "", false
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+// GetStringSlice returns the string array claim at path, as addressed by Get, ignoring
+// non-string items. A single string, as IdPs send for one-element lists such as aud, is
+// returned as a slice of one.
+func (c Claims) GetStringSlice(path string) ([]string, bool) {
+	v, ok := c.Get(path)
+	if !ok {
+		return nil, false
+	}
+
+	switch v := v.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		result := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result, true
+	default:
+		return nil, false
+	}
+}
+
+// RolesExtractor returns the roles carried by a token's claim set
+type RolesExtractor func(claims map[string]interface{}) []string
+
+// ClaimRolesExtractor returns a RolesExtractor that reads roles from a top-level
+// string array claim, such as "groups"
+func ClaimRolesExtractor(name string) RolesExtractor {
+	return func(claims map[string]interface{}) []string {
+		return claimStringSlice(claims, name)
+	}
+}
+
+// newTokenInfo builds the token information from a decoded claim set
+func newTokenInfo(claims map[string]interface{}, opts *options) *TokenInfo {
+	info := &TokenInfo{
+		UserID:          claimString(claims, "sub"),
+		Username:        claimString(claims, "preferred_username"),
+		Email:           claimString(claims, "email"),
+		Roles:           opts.rolesExtractor(claims),
+		Groups:          claimStringSlice(claims, opts.groupsClaim),
+		Scopes:          strings.Fields(claimString(claims, "scope")),
+		Audience:        claimAudience(claims),
+		AuthorizedParty: claimString(claims, "azp"),
+		Locale:          claimLocale(claims),
+		ZoneInfo:        claimString(claims, "zoneinfo"),
+		Claims:          claims,
+	}
+
+	info.Principal = claimString(claims, opts.principalClaim)
+	if info.Principal == "" {
+		info.Principal = info.UserID
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		info.ExpiresAt = int64(exp)
+	}
+	if authTime, ok := claims["auth_time"].(float64); ok {
+		info.AuthTime = int64(authTime)
+	}
+	info.Metadata = newTokenMetadata(claims, info.ClientID())
+
+	return info
+}
+
+// KeycloakRealmRoles extracts the realm roles from Keycloak's realm_access claim.
+// It is the default RolesExtractor.
+func KeycloakRealmRoles(claims map[string]interface{}) []string {
+	realmAccess, ok := claims["realm_access"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return claimStringSlice(realmAccess, "roles")
+}
+
+// claimAudience returns the aud claim, which may be a single string or an array
+func claimAudience(claims map[string]interface{}) []string {
+	if aud, ok := claims["aud"].(string); ok {
+		return []string{aud}
+	}
+	return claimStringSlice(claims, "aud")
+}
+
+// claimLocale returns the locale claim as a canonical BCP 47 tag. Malformed locales are
+// ignored rather than failing the token, as the claim is only informational.
+func claimLocale(claims map[string]interface{}) string {
+	locale := claimString(claims, "locale")
+	if locale == "" {
+		return ""
+	}
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return ""
+	}
+	return tag.String()
+}
+
+// claimString returns the string claim with the given name, or "" if absent
+func claimString(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimStringSlice returns the string array claim with the given name, ignoring non-string items
+func claimStringSlice(claims map[string]interface{}, name string) []string {
+	values, ok := claims[name].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAuthorizedParty(t *testing.T) {
+	tests := []struct {
+		name         string
+		claims       map[string]interface{}
+		wantAZP      string
+		wantClientID string
+	}{
+		{"azp", map[string]interface{}{"azp": "web"}, "web", "web"},
+		{"azp over client_id", map[string]interface{}{"azp": "web", "client_id": "mobile"}, "web", "web"},
+		{"client_id of an RFC 9068 token", map[string]interface{}{"client_id": "mobile"}, "", "mobile"},
+		{"non-string azp", map[string]interface{}{"azp": []interface{}{"web"}}, "", ""},
+		{"neither", map[string]interface{}{"sub": "user-1"}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := newTokenInfo(tt.claims, newOptions(nil))
+			if info.AuthorizedParty != tt.wantAZP {
+				t.Errorf("AuthorizedParty = %q, want %q", info.AuthorizedParty, tt.wantAZP)
+			}
+			if got := info.ClientID(); got != tt.wantClientID {
+				t.Errorf("ClientID() = %q, want %q", got, tt.wantClientID)
+			}
+		})
+	}
+}
+
+func TestClaimsJSON(t *testing.T) {
+	key := mustSigningKey(t)
+	p := localProvider(t, key)
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iat":                float64(now.Unix()),
+		"exp":                float64(now.Add(time.Hour).Unix()),
+		"email":              "jane@example.com",
+		"email_verified":     true,
+		"preferred_username": "jane",
+		"realm_access":       map[string]interface{}{"roles": []interface{}{"admin", "user"}},
+		"resource_access": map[string]interface{}{
+			"bridge": map[string]interface{}{"roles": []interface{}{"reader"}},
+		},
+		"scope": "openid email",
+	}
+	tokenInfo, err := p.ValidateToken(context.Background(), signClaims(t, key, claims))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(tokenInfo.Claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The claims serialize exactly as the token carried them, under their claim names
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"sub": "user-1", "iss": "https://kc.example.com/realms/test"}
+	for name, value := range claims {
+		want[name] = value
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("serialized claims = %s, want the claims of the token %v", data, want)
+	}
+
+	// and decode back into Claims unchanged
+	var decoded Claims
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, tokenInfo.Claims) {
+		t.Errorf("round-tripped claims = %v, want %v", decoded, tokenInfo.Claims)
+	}
+	if roles, _ := decoded.GetStringSlice("realm_access.roles"); !reflect.DeepEqual(roles, []string{"admin", "user"}) {
+		t.Errorf("round-tripped realm_access.roles = %v, want [admin user]", roles)
+	}
+}
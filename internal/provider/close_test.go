@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"go.uber.org/goleak"
+)
+
+// failingCloser fails to close with err
+type failingCloser struct {
+	IAMProvider
+	err    error
+	closed bool
+}
+
+func (f *failingCloser) Close() error {
+	f.closed = true
+	return f.err
+}
+
+func TestCloseWrappers(t *testing.T) {
+	inner := &namedProvider{name: "inner"}
+	cache := NewLRUTokenCache(10, nil)
+	caching := NewCachingProvider(NewDenylistProvider(inner, nil), cache, &config.CacheConfig{TTL: time.Minute},
+		&config.DegradedModeConfig{}, nil)
+	if _, err := caching.ValidateToken(context.Background(), "token"); err != nil {
+		t.Fatal(err)
+	}
+	key := caching.cacheKey(context.Background(), "token")
+	if _, err := cache.Get(context.Background(), key); err != nil {
+		t.Fatalf("cache Get() before Close() error = %v, want the validation cached", err)
+	}
+
+	if err := Close(NewAtomicProvider(caching)); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !inner.closed {
+		t.Error("Close() didn't reach the provider wrapped by the cache and denylist")
+	}
+	if _, err := cache.Get(context.Background(), key); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("cache Get() after Close() error = %v, want ErrCacheMiss", err)
+	}
+}
+
+func TestCloseJoinsErrors(t *testing.T) {
+	errA, errB := errors.New("a failed"), errors.New("b failed")
+	a, b, c := &failingCloser{err: errA}, &failingCloser{err: errB}, &failingCloser{}
+	chain, err := NewChainProvider(a, b, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Close(chain)
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("Close() error = %v, want both failures", err)
+	}
+	if !a.closed || !b.closed || !c.closed {
+		t.Errorf("closed a, b, c = %v, %v, %v, want every provider closed despite failures", a.closed, b.closed, c.closed)
+	}
+}
+
+func TestSwapAndCloseLeaveNoGoroutines(t *testing.T) {
+	key := mustSigningKey(t)
+	idp := newTracedIdP(t, key, http.StatusOK)
+	// The IdP keeps serving, so connections the providers left open would leak
+	ignored := goleak.IgnoreCurrent()
+	keycloak := func() IAMProvider {
+		p, err := NewKeycloakProvider(config.KeycloakConfig{
+			BaseURL:         idp.URL,
+			Realm:           "test",
+			ClientID:        "bridge",
+			ClientSecret:    "secret",
+			TokenValidation: "jwks",
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// Each validation fetches the JWKS in the background and keeps an idle connection
+	validate := func(p IAMProvider) {
+		token := mustSignIssuedToken(t, key, idp.URL+"/realms/test", time.Hour)
+		if _, err := p.ValidateToken(context.Background(), token); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current := NewAtomicProvider(keycloak())
+	validate(current)
+	old := current.Swap(keycloak())
+	validate(current)
+	if err := Close(old); err != nil {
+		t.Fatalf("Close() of the swapped out provider error = %v", err)
+	}
+	if err := current.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	goleak.VerifyNone(t, ignored)
+}
+
+func TestCloseWithoutCloser(t *testing.T) {
+	if err := Close(unclosable{}); err != nil {
+		t.Errorf("Close() of a provider without Close error = %v", err)
+	}
+}
+
+// unclosable is a provider holding nothing to release
+type unclosable struct {
+	IAMProvider
+}
@@ -0,0 +1,121 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/metrics"
+)
+
+// ErrTooManyIdPCalls is returned, wrapped with ErrProviderUnavailable, when a call to the IdP
+// finds the concurrency limit reached and no slot frees up in time
+var ErrTooManyIdPCalls = errors.New("too many concurrent IdP calls")
+
+// callLimiter bounds how many calls to the IdP run at once, so a traffic spike on a cold
+// cache or JWKS doesn't turn into a flood of introspection, discovery and JWKS requests.
+// Tokens verified locally against cached keys take no slot. Rejections count as an outage
+// in any CachingProvider, served from stale entries in degraded mode.
+type callLimiter struct {
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// newCallLimiter creates a callLimiter running at most limit calls at once. Calls over the
+// limit wait up to maxWait for a slot, then fail with ErrTooManyIdPCalls; a maxWait of 0
+// fails them at once.
+func newCallLimiter(limit int, maxWait time.Duration) *callLimiter {
+	return &callLimiter{
+		slots:   make(chan struct{}, limit),
+		maxWait: maxWait,
+	}
+}
+
+// acquire takes a slot, waiting up to maxWait or until ctx is done
+func (l *callLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		metrics.IdPCallsInFlight.Add(1)
+		return nil
+	default:
+	}
+
+	if l.maxWait > 0 {
+		timer := time.NewTimer(l.maxWait)
+		defer timer.Stop()
+
+		select {
+		case l.slots <- struct{}{}:
+			metrics.IdPCallsInFlight.Add(1)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	metrics.IdPCallsRejected.Add(1)
+	return fmt.Errorf("%w: %w", ErrProviderUnavailable, ErrTooManyIdPCalls)
+}
+
+// release frees the slot taken by acquire
+func (l *callLimiter) release() {
+	<-l.slots
+	metrics.IdPCallsInFlight.Add(-1)
+}
+
+// limitedTransport holds a slot of the limiter for every call to the IdP, from sending the
+// request until its response body is closed
+type limitedTransport struct {
+	next    http.RoundTripper
+	limiter *callLimiter
+}
+
+// withCallLimit wraps next to run its calls within limiter. Without a limiter, next is left
+// as it is.
+func withCallLimit(next http.RoundTripper, limiter *callLimiter) http.RoundTripper {
+	if limiter == nil {
+		return next
+	}
+	return &limitedTransport{next: next, limiter: limiter}
+}
+
+// RoundTrip performs the call once a slot is free
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.limiter.release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: t.limiter.release}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport
+func (t *limitedTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// releasingBody frees the slot of its call when closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+// Close closes the body and frees the slot
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"context"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/metrics"
+)
+
+// slowIdP is a Keycloak stub answering introspection after a delay, or once released when
+// holding, recording how many calls it served at once
+type slowIdP struct {
+	*httptest.Server
+	delay   time.Duration
+	jwks    []byte
+	hold    atomic.Bool
+	release chan struct{}
+
+	inFlight atomic.Int64
+	peak     atomic.Int64
+	calls    atomic.Int64
+}
+
+func newSlowIdP(t *testing.T, delay time.Duration) *slowIdP {
+	t.Helper()
+	idp := &slowIdP{delay: delay, release: make(chan struct{})}
+	idp.Server = httptest.NewServer(http.HandlerFunc(idp.serve))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (s *slowIdP) serve(w http.ResponseWriter, r *http.Request) {
+	s.calls.Add(1)
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for peak := s.peak.Load(); n > peak && !s.peak.CompareAndSwap(peak, n); peak = s.peak.Load() {
+	}
+
+	if s.hold.Load() {
+		<-s.release
+	}
+	time.Sleep(s.delay)
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/realms/test/protocol/openid-connect/certs" {
+		_, _ = w.Write(s.jwks)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"active": true,
+		"sub":    "user-1",
+		"exp":    float64(time.Now().Add(time.Hour).Unix()),
+	})
+}
+
+func (s *slowIdP) provider(t *testing.T, validation string, opts ...Option) IAMProvider {
+	t.Helper()
+	p, err := NewKeycloakProvider(config.KeycloakConfig{
+		BaseURL:         s.URL,
+		Realm:           "test",
+		ClientID:        "bridge",
+		ClientSecret:    "secret",
+		TokenValidation: validation,
+	}, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMaxConcurrentCallsBoundsIdPLoad(t *testing.T) {
+	const (
+		limit       = 4
+		validations = 50
+	)
+	idp := newSlowIdP(t, 20*time.Millisecond)
+	p := idp.provider(t, "introspection", WithMaxConcurrentCalls(limit, 5*time.Second))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, validations)
+	for i := 0; i < validations; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.ValidateToken(context.Background(), "token"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("ValidateToken() error = %v", err)
+	}
+	if peak := idp.peak.Load(); peak > limit {
+		t.Errorf("the IdP served %d calls at once, want at most %d", peak, limit)
+	}
+	if calls := idp.calls.Load(); calls != validations {
+		t.Errorf("the IdP served %d calls, want %d", calls, validations)
+	}
+	if n := metrics.IdPCallsInFlight.Value(); n != 0 {
+		t.Errorf("IdPCallsInFlight = %d after the calls finished, want 0", n)
+	}
+}
+
+func TestMaxConcurrentCallsRejectsWithoutWait(t *testing.T) {
+	idp := newSlowIdP(t, 0)
+	idp.hold.Store(true)
+	p := idp.provider(t, "introspection", WithMaxConcurrentCalls(1, 0))
+
+	done := make(chan error)
+	go func() {
+		_, err := p.ValidateToken(context.Background(), "token")
+		done <- err
+	}()
+	for idp.inFlight.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := p.ValidateToken(context.Background(), "token")
+	if !errors.Is(err, ErrTooManyIdPCalls) || !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("ValidateToken() over the limit error = %v, want ErrTooManyIdPCalls and ErrProviderUnavailable", err)
+	}
+
+	close(idp.release)
+	if err := <-done; err != nil {
+		t.Errorf("ValidateToken() holding the slot error = %v", err)
+	}
+}
+
+func TestMaxConcurrentCallsSkipsLocalVerification(t *testing.T) {
+	key, err := NewSigningKey(mustGenerateECKey(t, elliptic.P256()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := newSlowIdP(t, 0)
+	if idp.jwks, err = json.Marshal(set); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both providers share the one slot
+	limit := WithMaxConcurrentCalls(1, 0)
+	local := idp.provider(t, "jwks", limit)
+	remote := idp.provider(t, "introspection", limit)
+
+	token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.ID}, map[string]interface{}{
+		"sub": "user-1",
+		"iss": idp.URL + "/realms/test",
+		"iat": float64(time.Now().Unix()),
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	}, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first validation fetches the JWKS, taking the slot for the fetch
+	if _, err := local.ValidateToken(context.Background(), token); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	idp.hold.Store(true)
+	done := make(chan error)
+	go func() {
+		_, err := remote.ValidateToken(context.Background(), "token")
+		done <- err
+	}()
+	for idp.inFlight.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// With the slot taken by the introspection, tokens verified against cached keys pass
+	if _, err := local.ValidateToken(context.Background(), token); err != nil {
+		t.Errorf("ValidateToken() with cached keys error = %v, want no slot needed", err)
+	}
+
+	close(idp.release)
+	if err := <-done; err != nil {
+		t.Errorf("introspection error = %v", err)
+	}
+}
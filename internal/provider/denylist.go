@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+)
+
+// denylistSweepInterval is how often MemoryJTIDenylist drops expired entries
+const denylistSweepInterval = time.Minute
+
+// JTIDenylist defines the interface stores of revoked token IDs (jti) must implement.
+// A jti only needs to be kept until the token it identifies expires, since the token is
+// rejected from then on anyway. Implementations must be safe for concurrent use.
+type JTIDenylist interface {
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	Revoke(ctx context.Context, jti string, exp time.Time) error
+}
+
+// MemoryJTIDenylist is the default JTIDenylist, held in process memory. Revocations are not
+// shared with other instances; use a shared backend when running several.
+type MemoryJTIDenylist struct {
+	clock clock.Clock
+
+	mu        sync.Mutex
+	revoked   map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewMemoryJTIDenylist creates a new MemoryJTIDenylist. A nil clock selects the system clock.
+func NewMemoryJTIDenylist(c clock.Clock) *MemoryJTIDenylist {
+	return &MemoryJTIDenylist{
+		clock:   clock.OrSystem(c),
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// IsRevoked reports whether jti was revoked and its token has not expired yet
+func (d *MemoryJTIDenylist) IsRevoked(_ context.Context, jti string) (bool, error) {
+	now := d.clock.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	exp, ok := d.revoked[jti]
+	return ok && now.Before(exp), nil
+}
+
+// Revoke denies jti until exp, dropping the revocations that expired in passing
+func (d *MemoryJTIDenylist) Revoke(_ context.Context, jti string, exp time.Time) error {
+	now := d.clock.Now()
+	if !now.Before(exp) {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if now.After(d.nextSweep) {
+		for k, e := range d.revoked {
+			if !now.Before(e) {
+				delete(d.revoked, k)
+			}
+		}
+		d.nextSweep = now.Add(denylistSweepInterval)
+	}
+
+	if e, ok := d.revoked[jti]; !ok || exp.After(e) {
+		d.revoked[jti] = exp
+	}
+	return nil
+}
+
+// TokenRevoker is implemented by providers that can revoke individual tokens before they expire
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, tokenInfo *TokenInfo) error
+}
+
+// DenylistProvider wraps an IAM provider and rejects tokens whose jti is in a JTIDenylist
+// with ErrTokenRevoked. It sits outside any CachingProvider, so a revocation also applies
+// to validations already cached. Tokens without a jti can't be revoked and are accepted.
+type DenylistProvider struct {
+	IAMProvider
+
+	denylist JTIDenylist
+}
+
+// NewDenylistProvider creates a new DenylistProvider instance
+func NewDenylistProvider(next IAMProvider, denylist JTIDenylist) *DenylistProvider {
+	return &DenylistProvider{
+		IAMProvider: next,
+		denylist:    denylist,
+	}
+}
+
+// ValidateToken validates the token with the wrapped provider, then checks its jti. Tokens
+// are rejected when the denylist can't be checked, as a revoked token must never pass.
+func (p *DenylistProvider) ValidateToken(ctx context.Context, token string) (*TokenInfo, error) {
+	tokenInfo, err := p.IAMProvider.ValidateToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	jti := tokenInfo.Metadata.JTI
+	if jti == "" {
+		return tokenInfo, nil
+	}
+
+	revoked, err := p.denylist.IsRevoked(ctx, jti)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to check jti denylist: %v", ErrProviderUnavailable, err)
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return tokenInfo, nil
+}
+
+// RevokeToken denies the token's jti until the token expires, so it is rejected from now
+// on, such as after logout or a one-time use. Tokens without a jti or expiry can't be revoked
+// and are ignored.
+func (p *DenylistProvider) RevokeToken(ctx context.Context, tokenInfo *TokenInfo) error {
+	md := tokenInfo.Metadata
+	if md.JTI == "" || md.ExpiresAt.IsZero() {
+		return nil
+	}
+	return p.denylist.Revoke(ctx, md.JTI, md.ExpiresAt)
+}
+
+// Close closes the wrapped provider. The denylist may be shared and is left to its owner.
+func (p *DenylistProvider) Close() error {
+	return Close(p.IAMProvider)
+}
+
+// Unwrap returns the wrapped provider
+func (p *DenylistProvider) Unwrap() IAMProvider {
+	return p.IAMProvider
+}
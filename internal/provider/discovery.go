@@ -0,0 +1,172 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+	"golang.org/x/sync/singleflight"
+)
+
+// defaultDiscoveryCacheTTL is used when no discovery cache TTL is configured
+const defaultDiscoveryCacheTTL = time.Hour
+
+// DiscoveryDocument holds the OpenID Provider metadata the bridge uses
+// (.well-known/openid-configuration)
+type DiscoveryDocument struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	IntrospectionEndpoint string   `json:"introspection_endpoint,omitempty"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint,omitempty"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint,omitempty"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported,omitempty"`
+}
+
+// DiscoveryProvider is implemented by providers that publish OpenID Provider metadata
+type DiscoveryProvider interface {
+	Discover(ctx context.Context) (*DiscoveryDocument, error)
+}
+
+// discoveryCache caches discovery documents per issuer. Expired entries are revalidated
+// with If-None-Match, so an unchanged document costs a 304 and no re-parsing.
+type discoveryCache struct {
+	client   *http.Client
+	ttl      time.Duration
+	maxBytes int64
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*discoveryEntry
+	// fetches shares a fetch per issuer between concurrent callers, made without holding mu
+	// so a slow issuer doesn't hold up the cached documents of others
+	fetches singleflight.Group
+}
+
+type discoveryEntry struct {
+	doc       *DiscoveryDocument
+	etag      string
+	expiresAt time.Time
+}
+
+func newDiscoveryCache(client *http.Client, ttl time.Duration, maxBytes int64, c clock.Clock) *discoveryCache {
+	if ttl <= 0 {
+		ttl = defaultDiscoveryCacheTTL
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultJWKSMaxBytes
+	}
+	return &discoveryCache{
+		client:   client,
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		clock:    clock.OrSystem(c),
+		entries:  make(map[string]*discoveryEntry),
+	}
+}
+
+// get returns the discovery document of the issuer, fetching or revalidating it when
+// the cached copy expired. As with key set loads, the fetch isn't canceled with the caller
+// that started it, as others may be waiting on it; each waits only as long as its own
+// context allows.
+func (d *discoveryCache) get(ctx context.Context, issuer string) (*DiscoveryDocument, error) {
+	if doc := d.cached(issuer); doc != nil {
+		return doc, nil
+	}
+
+	fetch := d.fetches.DoChan(issuer, func() (interface{}, error) {
+		return d.fetch(context.WithoutCancel(ctx), issuer)
+	})
+	select {
+	case result := <-fetch:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*DiscoveryDocument), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// cached returns the issuer's cached document, or nil when there is none or it expired
+func (d *discoveryCache) cached(issuer string) *DiscoveryDocument {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry := d.entries[issuer]; entry != nil && d.clock.Now().Before(entry.expiresAt) {
+		return entry.doc
+	}
+	return nil
+}
+
+// fetch fetches or revalidates the issuer's document and caches it
+func (d *discoveryCache) fetch(ctx context.Context, issuer string) (*DiscoveryDocument, error) {
+	d.mu.Lock()
+	entry := d.entries[issuer]
+	d.mu.Unlock()
+	// A fetch that finished just before this one started already refreshed the entry
+	if entry != nil && d.clock.Now().Before(entry.expiresAt) {
+		return entry.doc, nil
+	}
+
+	discoveryURL := issuer + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, "GET", discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if entry != nil && entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to execute request: %w", ErrProviderUnavailable, err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			_ = fmt.Errorf("failed to close response body: %w", err)
+		}
+	}(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		d.store(issuer, &discoveryEntry{doc: entry.doc, etag: entry.etag, expiresAt: d.clock.Now().Add(d.ttl)})
+		return entry.doc, nil
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: unexpected status code: %d", ErrProviderUnavailable, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	data, err := readLimited(resp.Body, d.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc DiscoveryDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	d.store(issuer, &discoveryEntry{
+		doc:       &doc,
+		etag:      resp.Header.Get("ETag"),
+		expiresAt: d.clock.Now().Add(d.ttl),
+	})
+	return &doc, nil
+}
+
+// store caches the issuer's entry
+func (d *discoveryCache) store(issuer string, entry *discoveryEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[issuer] = entry
+}
@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// discoveryIdP serves the discovery documents of any issuer path, holding the requests of
+// the slow one until released
+type discoveryIdP struct {
+	*httptest.Server
+	release chan struct{}
+	fetches atomic.Int64
+}
+
+func newDiscoveryIdP(t *testing.T) *discoveryIdP {
+	t.Helper()
+	idp := &discoveryIdP{release: make(chan struct{})}
+	idp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.fetches.Add(1)
+		issuer := idp.URL + strings.TrimSuffix(r.URL.Path, "/.well-known/openid-configuration")
+		if strings.HasSuffix(issuer, "/slow") {
+			<-idp.release
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_ = json.NewEncoder(w).Encode(DiscoveryDocument{Issuer: issuer})
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func TestDiscoveryCacheSharesFetches(t *testing.T) {
+	const callers = 20
+	idp := newDiscoveryIdP(t)
+	cache := newDiscoveryCache(idp.Client(), time.Hour, 0, nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, err := cache.get(context.Background(), idp.URL+"/slow")
+			if err == nil && doc.Issuer != idp.URL+"/slow" {
+				err = errors.New("unexpected issuer " + doc.Issuer)
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	for idp.fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// With the slow issuer's fetch in flight, other issuers are served
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cache.get(ctx, idp.URL+"/fast"); err != nil {
+		t.Errorf("get() of another issuer during a slow fetch error = %v", err)
+	}
+
+	close(idp.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("get() error = %v", err)
+	}
+	if n := idp.fetches.Load(); n != 2 {
+		t.Errorf("the IdP served %d fetches, want one per issuer", n)
+	}
+}
+
+func TestDiscoveryCacheCallerGivesUp(t *testing.T) {
+	idp := newDiscoveryIdP(t)
+	cache := newDiscoveryCache(idp.Client(), time.Hour, 0, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.get(ctx, idp.URL+"/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("get() past the deadline error = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The fetch carries on for the callers after it, and its result is cached
+	close(idp.release)
+	if _, err := cache.get(context.Background(), idp.URL+"/slow"); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if _, err := cache.get(context.Background(), idp.URL+"/slow"); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("the IdP served %d fetches, want 1", n)
+	}
+}
+
+func TestDiscoveryCacheRevalidates(t *testing.T) {
+	idp := newDiscoveryIdP(t)
+	close(idp.release)
+	clk := newFakeClock()
+	cache := newDiscoveryCache(idp.Client(), time.Minute, 0, clk)
+
+	first, err := cache.get(context.Background(), idp.URL+"/realm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(59 * time.Second)
+	if _, err := cache.get(context.Background(), idp.URL+"/realm"); err != nil {
+		t.Fatal(err)
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("the IdP served %d fetches within the TTL, want 1", n)
+	}
+	clk.Advance(time.Second)
+
+	// The expired entry is revalidated, and the 304 keeps the document
+	second, err := cache.get(context.Background(), idp.URL+"/realm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Error("get() after a 304 returned a new document, want the cached one")
+	}
+	if n := idp.fetches.Load(); n != 2 {
+		t.Errorf("the IdP served %d fetches, want 2", n)
+	}
+}
+
+func TestDiscoveryCacheMaxBytes(t *testing.T) {
+	idp := newDiscoveryIdP(t)
+	close(idp.release)
+	doc, err := json.Marshal(DiscoveryDocument{Issuer: idp.URL + "/realm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The encoder ends the document with a newline
+	size := int64(len(doc)) + 1
+
+	for _, tt := range []struct {
+		limit   int64
+		wantErr bool
+	}{
+		{size, false},
+		{size - 1, true},
+	} {
+		cache := newDiscoveryCache(idp.Client(), time.Hour, tt.limit, nil)
+		_, err := cache.get(context.Background(), idp.URL+"/realm")
+		if got := errors.Is(err, ErrResponseTooLarge); got != tt.wantErr {
+			t.Errorf("get() with a limit of %d bytes for %d error = %v, want ErrResponseTooLarge %v", tt.limit, size, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrDPoPProofInvalid is returned when a DPoP proof is missing, malformed or doesn't match the request
+var ErrDPoPProofInvalid = errors.New("invalid DPoP proof")
+
+// dpopProofMaxAge bounds how far a proof's iat may be from now
+const dpopProofMaxAge = 60 * time.Second
+
+// dpopHeader is the JOSE header of a DPoP proof, which embeds the public key
+type dpopHeader struct {
+	Typ string     `json:"typ"`
+	Alg string     `json:"alg"`
+	JWK JSONWebKey `json:"jwk"`
+}
+
+// VerifyDPoPProof verifies a DPoP proof (RFC 9449) for the given request method and URL
+// and checks it is bound to the access token through the token's cnf.jkt confirmation.
+// The proof's iat must be within the accepted window around now.
+func VerifyDPoPProof(proof, method, requestURL string, tokenInfo *TokenInfo, accessToken string, now time.Time) error {
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: malformed proof", ErrDPoPProofInvalid)
+	}
+
+	var header dpopHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return fmt.Errorf("%w: malformed header", ErrDPoPProofInvalid)
+	}
+	if header.Typ != "dpop+jwt" {
+		return fmt.Errorf("%w: unexpected typ %q", ErrDPoPProofInvalid, header.Typ)
+	}
+
+	key, err := header.JWK.publicKey()
+	if err != nil || key == nil {
+		return fmt.Errorf("%w: unsupported or invalid jwk", ErrDPoPProofInvalid)
+	}
+
+	jwt, err := parseJWT(proof)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDPoPProofInvalid, err)
+	}
+	if err := verifySignature(header.Alg, key, jwt.signingInput, jwt.signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrDPoPProofInvalid, err)
+	}
+
+	if htm := claimString(jwt.claims, "htm"); htm != method {
+		return fmt.Errorf("%w: htm does not match request method", ErrDPoPProofInvalid)
+	}
+	if htu := claimString(jwt.claims, "htu"); !sameHTU(htu, requestURL) {
+		return fmt.Errorf("%w: htu does not match request URL", ErrDPoPProofInvalid)
+	}
+	if claimString(jwt.claims, "jti") == "" {
+		return fmt.Errorf("%w: missing jti", ErrDPoPProofInvalid)
+	}
+
+	iat, ok := jwt.claims["iat"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing iat", ErrDPoPProofInvalid)
+	}
+	if age := now.Sub(time.Unix(int64(iat), 0)); age > dpopProofMaxAge || age < -dpopProofMaxAge {
+		return fmt.Errorf("%w: iat outside the accepted window", ErrDPoPProofInvalid)
+	}
+
+	ath := sha256.Sum256([]byte(accessToken))
+	if claimString(jwt.claims, "ath") != base64.RawURLEncoding.EncodeToString(ath[:]) {
+		return fmt.Errorf("%w: ath does not match access token", ErrDPoPProofInvalid)
+	}
+
+	thumbprint, err := header.JWK.Thumbprint()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDPoPProofInvalid, err)
+	}
+	if jkt := tokenInfo.ConfirmationJKT(); jkt == "" || jkt != thumbprint {
+		return fmt.Errorf("%w: proof key is not bound to the access token", ErrDPoPProofInvalid)
+	}
+
+	return nil
+}
+
+// Thumbprint computes the RFC 7638 SHA-256 thumbprint of the key, base64url-encoded
+func (k *JSONWebKey) Thumbprint() (string, error) {
+	var members interface{}
+	switch k.Kty {
+	case "RSA":
+		// Members must be in lexicographic order, which encoding/json does for structs in field order
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// ConfirmationJKT returns the JWK thumbprint the token is bound to (cnf.jkt), if any
+func (t *TokenInfo) ConfirmationJKT() string {
+	cnf, ok := t.Claims["cnf"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	return claimString(cnf, "jkt")
+}
+
+// sameHTU compares a proof's htu with the request URL, ignoring query and fragment
+func sameHTU(htu, requestURL string) bool {
+	a, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(requestURL)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Host, b.Host) &&
+		a.EscapedPath() == b.EscapedPath()
+}
@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// GatewayValidator verifies JWTs minted by an API gateway that already authenticated the
+// user. It is independent of the IAM provider: tokens are checked against the gateway's
+// public key, issuer and audiences only.
+type GatewayValidator struct {
+	config *config.GatewayConfig
+	keys   *keySet
+	opts   *options
+}
+
+// NewGatewayValidator creates a new GatewayValidator from the iam.gateway settings. Claims
+// are read with the same settings as provider tokens, such as iam.principal_claim.
+func NewGatewayValidator(cfg *config.IAMConfig, opts ...Option) (*GatewayValidator, error) {
+	key, err := loadPublicKey(cfg.Gateway.PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gateway public key: %w", err)
+	}
+
+	return &GatewayValidator{
+		config: &cfg.Gateway,
+		keys:   &keySet{single: key},
+		opts:   newOptions(append(claimOptions(cfg), opts...)),
+	}, nil
+}
+
+// ValidateToken verifies the gateway token and returns its token information. Tokens
+// without exp are rejected with ErrNoExpiry unless WithTokensWithoutExpiry is given;
+// iam.allow_tokens_without_expiry only applies to provider tokens.
+func (g *GatewayValidator) ValidateToken(ctx context.Context, token string) (*TokenInfo, error) {
+	jwt, err := verifyJWT(ctx, token, g.keys, g.opts.clock.Now(), g.opts.clockSkew)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := jwt.claims["exp"].(float64); !ok && !g.opts.allowNoExpiry {
+		return nil, newValidationError(ErrNoExpiry, ReasonNoExpiry, "token has no exp claim")
+	}
+
+	if iss := claimString(jwt.claims, "iss"); iss != g.config.Issuer {
+		return nil, invalidIssuer(iss)
+	}
+
+	tokenInfo := newTokenInfo(jwt.claims, g.opts)
+	// Audiences requested for provider tokens don't apply to the gateway's own tokens
+	if err := checkAudience(context.Background(), tokenInfo, g.config.Audiences); err != nil {
+		return nil, err
+	}
+
+	return tokenInfo, nil
+}
+
+// loadPublicKey reads a PEM-encoded public key (PKIX or PKCS #1) or certificate
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+
+	var key crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, errors.New("unsupported key type, must be RSA or EC")
+	}
+}
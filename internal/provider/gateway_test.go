@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"context"
+	"crypto/elliptic"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGatewayValidatorValidateToken(t *testing.T) {
+	key := mustGenerateECKey(t, elliptic.P256())
+	cfg := internalTokenConfig(writePublicKey(t, t.TempDir(), key))
+	clk := newFakeClock()
+	now := clk.Now().Unix()
+
+	claims := func(modify func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "user-1",
+			"iss": "https://bridge.internal",
+			"aud": "orders",
+			"iat": float64(now),
+			"exp": float64(now + 60),
+		}
+		modify(c)
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		opts    []Option
+		wantErr error
+	}{
+		{"valid", claims(func(map[string]interface{}) {}), nil, nil},
+		{"no expiry", claims(func(c map[string]interface{}) { delete(c, "exp") }), nil, ErrNoExpiry},
+		{"no expiry allowed", claims(func(c map[string]interface{}) { delete(c, "exp") }),
+			[]Option{WithTokensWithoutExpiry()}, nil},
+		{"expired", claims(func(c map[string]interface{}) { c["exp"] = float64(now - 3600) }), nil, ErrTokenExpired},
+		{"wrong issuer", claims(func(c map[string]interface{}) { c["iss"] = "https://elsewhere" }), nil, ErrInvalidIssuer},
+		{"wrong audience", claims(func(c map[string]interface{}) { c["aud"] = "billing" }), nil, ErrInvalidAudience},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := NewGatewayValidator(cfg, append([]Option{WithClock(clk)}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT"}, tt.claims, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = validator.ValidateToken(context.Background(), token)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("ValidateToken() error = %v", err)
+			} else if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGatewayValidatorIgnoresProviderExpiryOption(t *testing.T) {
+	key := mustGenerateECKey(t, elliptic.P256())
+	cfg := internalTokenConfig(writePublicKey(t, t.TempDir(), key))
+	// allow_tokens_without_expiry is for IdPs that can't set exp, not for the gateway
+	cfg.AllowTokensWithoutExpiry = true
+
+	validator, err := NewGatewayValidator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT"}, map[string]interface{}{
+		"sub": "user-1",
+		"iss": "https://bridge.internal",
+		"aud": "orders",
+		"iat": float64(time.Now().Unix()),
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validator.ValidateToken(context.Background(), token); !errors.Is(err, ErrNoExpiry) {
+		t.Errorf("ValidateToken() error = %v, want ErrNoExpiry", err)
+	}
+}
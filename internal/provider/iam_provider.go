@@ -2,7 +2,15 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
 	"github.com/zahidhasanpapon/iam-bridge/internal/config"
 	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
 )
@@ -12,16 +20,83 @@ var (
 	ErrUserNotFound       = errors.New("user not found")
 	ErrTokenExpired       = errors.New("token expired")
 	ErrTokenInvalid       = errors.New("token invalid")
+
+	ErrInvalidCodeVerifier = errors.New("invalid code verifier")
+	ErrInvalidGrant        = errors.New("invalid grant")
+	ErrNotSupported        = errors.New("operation not supported by provider")
+	ErrInvalidAudience     = errors.New("token audience not accepted")
+	ErrInvalidIssuer       = errors.New("token issuer not accepted")
+	ErrProviderUnavailable = errors.New("IAM provider unavailable")
+	ErrWrongTokenType      = errors.New("token type not accepted")
+	ErrTokenRevoked        = errors.New("token revoked")
+	ErrAdminAccessDenied   = errors.New("admin access denied")
+	ErrResponseTooLarge    = errors.New("IAM provider response too large")
+	ErrTokenLifetime       = errors.New("token lifetime not accepted")
+	ErrNoExpiry            = errors.New("token has no expiry")
 )
 
 // TokenInfo represents the information extracted from a token
 type TokenInfo struct {
-	UserID    string                 `json:"user_id"`
-	Username  string                 `json:"username"`
-	Email     string                 `json:"email"`
-	Roles     []string               `json:"roles"`
-	Claims    map[string]interface{} `json:"claims"`
-	ExpiresAt int64                  `json:"expires_at"`
+	UserID          string        `json:"user_id"`
+	Principal       string        `json:"principal"`
+	Username        string        `json:"username"`
+	Email           string        `json:"email"`
+	Roles           []string      `json:"roles"`
+	Groups          []string      `json:"groups"`
+	Scopes          []string      `json:"scopes"`
+	Audience        []string      `json:"audience"`
+	AuthorizedParty string        `json:"authorized_party"`
+	Claims          Claims        `json:"claims"`
+	ExpiresAt       int64         `json:"expires_at"`
+	AuthTime        int64         `json:"auth_time,omitempty"`
+	Locale          string        `json:"locale,omitempty"`
+	ZoneInfo        string        `json:"zoneinfo,omitempty"`
+	Metadata        TokenMetadata `json:"metadata"`
+}
+
+// HasRole reports whether the token carries the given role
+func (t *TokenInfo) HasRole(role string) bool {
+	return containsString(t.Roles, role)
+}
+
+// HasGroup reports whether the token's subject is a member of the given group. Groups may be
+// nested paths such as /org/team, in which case membership of a subgroup implies membership
+// of its parents: a token carrying /org/team is a member of /org.
+func (t *TokenInfo) HasGroup(group string) bool {
+	for _, g := range t.Groups {
+		if g == group {
+			return true
+		}
+		if strings.HasPrefix(group, "/") && strings.HasPrefix(g, strings.TrimSuffix(group, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the token was granted the given scope
+func (t *TokenInfo) HasScope(scope string) bool {
+	return containsString(t.Scopes, scope)
+}
+
+// ClientID returns the client the token was issued to: the azp claim, or the client_id
+// claim of RFC 9068 access tokens
+func (t *TokenInfo) ClientID() string {
+	if t.AuthorizedParty != "" {
+		return t.AuthorizedParty
+	}
+	return claimString(t.Claims, "client_id")
+}
+
+// ACR returns the authentication context class the user authenticated with (acr claim),
+// such as a level of assurance defined by the IdP
+func (t *TokenInfo) ACR() string {
+	return claimString(t.Claims, "acr")
+}
+
+// AMR returns the methods the user authenticated with (amr claim), such as pwd, otp or mfa
+func (t *TokenInfo) AMR() []string {
+	return claimStringSlice(t.Claims, "amr")
 }
 
 // UserInfo represents the information of a user
@@ -32,6 +107,42 @@ type UserInfo struct {
 	Roles    []string `json:"roles"`
 }
 
+// TokenResponse represents the tokens returned by the IAM provider's token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+
+	// Scopes are the granted scopes, parsed from Scope
+	Scopes []string `json:"-"`
+	// Audiences is the aud claim of the access token, decoded without verification, so it
+	// is informational only. It is empty for opaque access tokens.
+	Audiences []string `json:"-"`
+}
+
+// HasScope reports whether the scope was granted
+func (t *TokenResponse) HasScope(scope string) bool {
+	return containsString(t.Scopes, scope)
+}
+
+// decodeTokenResponse decodes a token endpoint response, parsing the granted scopes and
+// the access token's audiences
+func decodeTokenResponse(body io.Reader) (*TokenResponse, error) {
+	var result TokenResponse
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	result.Scopes = strings.Fields(result.Scope)
+	if _, claims, err := DecodeToken(result.AccessToken); err == nil {
+		result.Audiences = claimAudience(claims)
+	}
+	return &result, nil
+}
+
 // IAMProvider defines the interface for all IAM providers must implement
 type IAMProvider interface {
 	Login(ctx context.Context, username, password string) (string, error)
@@ -49,12 +160,434 @@ type IAMProvider interface {
 	HealthCheck(ctx context.Context) error
 }
 
+// AuthCodeFlowProvider is implemented by providers that support the
+// authorization code flow with PKCE
+type AuthCodeFlowProvider interface {
+	AuthCodeURL(state, codeChallenge string, opts ...AuthCodeURLOption) string
+	ExchangeCode(ctx context.Context, code, codeVerifier string) (*TokenResponse, error)
+	RefreshTokens(ctx context.Context, refreshToken string) (*TokenResponse, error)
+}
+
+// AuthCodeURLOption adds optional parameters to an authorization URL
+type AuthCodeURLOption func(url.Values)
+
+// WithNonce binds the ID token to the authentication request: the IdP copies the nonce into
+// the ID token, to be checked by ValidateIDToken
+func WithNonce(nonce string) AuthCodeURLOption {
+	return func(params url.Values) {
+		params.Set("nonce", nonce)
+	}
+}
+
+// Warmer is implemented by providers that can fetch the metadata and keys token validation
+// needs ahead of time, so the first validations don't pay for the round trips
+type Warmer interface {
+	Warmup(ctx context.Context) error
+}
+
+// SelfTester is implemented by providers that can check their configuration end-to-end
+// against the live IdP, e.g. that the client credentials are accepted
+type SelfTester interface {
+	SelfTest(ctx context.Context) error
+}
+
+// EndSessionProvider is implemented by providers that support RP-initiated logout, where
+// the browser is redirected to the IdP to end the user's session there as well
+type EndSessionProvider interface {
+	LogoutURL(idTokenHint, postLogoutRedirectURI string, opts ...LogoutURLOption) string
+}
+
+// LogoutURLOption adds optional parameters to a logout URL
+type LogoutURLOption func(url.Values)
+
+// WithLogoutState passes state through the logout, to be returned to the post logout redirect URI
+func WithLogoutState(state string) LogoutURLOption {
+	return func(params url.Values) {
+		params.Set("state", state)
+	}
+}
+
+// WithLogoutClientID identifies the client to the IdP, which it needs to accept the post
+// logout redirect URI when no ID token hint is given
+func WithLogoutClientID(clientID string) LogoutURLOption {
+	return func(params url.Values) {
+		params.Set("client_id", clientID)
+	}
+}
+
+// Role is a role defined in the IAM provider
+type Role struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// RoleLister is implemented by providers that can enumerate the roles of their realm
+type RoleLister interface {
+	ListRealmRoles(ctx context.Context) ([]Role, error)
+}
+
+// User is a user account as held by the IdP
+type User struct {
+	ID         string              `json:"id"`
+	Username   string              `json:"username"`
+	Email      string              `json:"email,omitempty"`
+	Enabled    bool                `json:"enabled"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+// UserDirectory is implemented by providers that can look users up through the IdP's admin
+// API, e.g. to resolve a token's subject to a username for display
+type UserDirectory interface {
+	GetUser(ctx context.Context, userID string) (*User, error)
+}
+
+// Close releases the resources held by p, such as idle connections to the IdP and cached
+// token validations, if p implements io.Closer. Providers wrapping others close them too.
+// Calls still in flight may complete after Close.
+func Close(p IAMProvider) error {
+	if closer, ok := p.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// closeAll closes every provider and joins their errors
+func closeAll(providers ...IAMProvider) error {
+	var errs []error
+	for _, p := range providers {
+		if err := Close(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// As finds the first provider in the wrapper chain of p that implements T,
+// so capabilities stay reachable through wrappers such as CachingProvider
+func As[T any](p IAMProvider) (T, bool) {
+	for p != nil {
+		if t, ok := p.(T); ok {
+			return t, true
+		}
+		wrapper, ok := p.(interface{ Unwrap() IAMProvider })
+		if !ok {
+			break
+		}
+		p = wrapper.Unwrap()
+	}
+
+	var zero T
+	return zero, false
+}
+
+// Claims read unless configured otherwise
+const (
+	defaultGroupsClaim    = "groups"
+	defaultPrincipalClaim = "sub"
+)
+
+// Option customizes how a provider interprets tokens
+type Option func(*options)
+
+type options struct {
+	rolesExtractor        RolesExtractor
+	groupsClaim           string
+	principalClaim        string
+	discoveryCacheTTL     time.Duration
+	clockSkew             time.Duration
+	allowedIssuerPatterns []string
+	accessTokenTypes      []string
+	tokenCache            TokenCache
+	httpConfig            config.HTTPConfig
+	clock                 clock.Clock
+	slowCallThreshold     time.Duration
+	callLimiter           *callLimiter
+	jtiDenylist           JTIDenylist
+	tracer                Tracer
+	jwksMaxBytes          int64
+	jwksRefreshMax        int
+	jwksRefreshWindow     time.Duration
+	keyRetirementGrace    time.Duration
+	maxTokenLifetime      time.Duration
+	allowNoExpiry         bool
+	warmupConcurrency     int
+	signingKeys           SigningKeyProvider
+}
+
+// WithRolesExtractor sets where the provider reads roles from in a token's claims,
+// for token layouts other than Keycloak's realm_access.roles
+func WithRolesExtractor(extractor RolesExtractor) Option {
+	return func(o *options) {
+		if extractor != nil {
+			o.rolesExtractor = extractor
+		}
+	}
+}
+
+// WithGroupsClaim sets the claim the provider reads group memberships from
+func WithGroupsClaim(name string) Option {
+	return func(o *options) {
+		if name != "" {
+			o.groupsClaim = name
+		}
+	}
+}
+
+// WithPrincipalClaim sets the claim used as the canonical user identifier (TokenInfo.Principal),
+// such as email or preferred_username. Tokens without the claim fall back to sub.
+func WithPrincipalClaim(name string) Option {
+	return func(o *options) {
+		if name != "" {
+			o.principalClaim = name
+		}
+	}
+}
+
+// WithDiscoveryCacheTTL sets how long OpenID discovery documents are cached before
+// they are revalidated
+func WithDiscoveryCacheTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.discoveryCacheTTL = ttl
+	}
+}
+
+// WithClockSkew sets the leeway granted on exp and nbf for clock differences with the issuer
+func WithClockSkew(skew time.Duration) Option {
+	return func(o *options) {
+		o.clockSkew = skew
+	}
+}
+
+// WithAllowedIssuerPatterns accepts tokens from further realms whose issuer matches one of
+// the patterns, e.g. https://kc.example.com/realms/*, fetching each realm's JWKS on demand.
+// It requires JWKS token validation.
+func WithAllowedIssuerPatterns(patterns ...string) Option {
+	return func(o *options) {
+		o.allowedIssuerPatterns = patterns
+	}
+}
+
+// WithAccessTokenTypes makes ValidateToken accept only tokens of the given types, declared
+// in the typ header or typ claim, e.g. at+jwt or Keycloak's Bearer. This keeps ID and
+// refresh tokens from being used as access tokens. Without types, any type is accepted.
+func WithAccessTokenTypes(types ...string) Option {
+	return func(o *options) {
+		o.accessTokenTypes = types
+	}
+}
+
+// WithHTTPConfig tunes the connection pool the provider uses for calls to the IdP.
+// Zero values keep the Go defaults.
+func WithHTTPConfig(cfg config.HTTPConfig) Option {
+	return func(o *options) {
+		o.httpConfig = cfg
+	}
+}
+
+// WithClock sets the time source for expiry, clock skew and cache freshness checks.
+// Without it, the system clock is used.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// WithSlowCallThreshold logs calls to the IdP taking longer than threshold at warn level,
+// with their endpoint and duration. A threshold of 0 disables the logging.
+func WithSlowCallThreshold(threshold time.Duration) Option {
+	return func(o *options) {
+		o.slowCallThreshold = threshold
+	}
+}
+
+// WithMaxConcurrentCalls bounds how many calls to the IdP, such as introspection, discovery
+// and JWKS fetches, run at once. Calls over the limit wait up to maxWait for a slot, then fail
+// with ErrTooManyIdPCalls. Providers built with the same Option share the limit, like the
+// realms of a MultiTenantProvider. A limit of 0 disables it.
+func WithMaxConcurrentCalls(limit int, maxWait time.Duration) Option {
+	var limiter *callLimiter
+	if limit > 0 {
+		limiter = newCallLimiter(limit, maxWait)
+	}
+	return func(o *options) {
+		o.callLimiter = limiter
+	}
+}
+
+// WithJWKSMaxBytes caps the size of the JWKS and discovery documents read from the IdP, so a
+// compromised or buggy IdP can't exhaust memory. Larger responses fail with
+// ErrResponseTooLarge. A limit of 0 keeps the default of 1 MiB.
+func WithJWKSMaxBytes(limit int64) Option {
+	return func(o *options) {
+		if limit > 0 {
+			o.jwksMaxBytes = limit
+		}
+	}
+}
+
+// WithJWKSRefreshRate allows at most max JWKS fetches per window for each of the provider's
+// key sets, so a stream of tokens with unknown kids can't flood the IdP. The first fetches of
+// realms matching the allowed issuer patterns share one more budget. Once a budget is used
+// up, tokens with unknown kids are rejected right away until the window resets. A max of 0
+// allows any number of fetches.
+func WithJWKSRefreshRate(max int, window time.Duration) Option {
+	return func(o *options) {
+		o.jwksRefreshMax = max
+		o.jwksRefreshWindow = window
+	}
+}
+
+// WithMaxTokenLifetime makes ValidateToken reject tokens whose lifetime, exp - iat, exceeds
+// max, regardless of their expiry, guarding against an IdP minting overly long-lived tokens.
+// Tokens lacking either claim are rejected too. A max of 0 disables the check.
+func WithMaxTokenLifetime(max time.Duration) Option {
+	return func(o *options) {
+		o.maxTokenLifetime = max
+	}
+}
+
+// WithWarmupConcurrency bounds how many realms MultiTenantProvider.Warmup warms up at once.
+// Values below 1 are ignored.
+func WithWarmupConcurrency(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.warmupConcurrency = n
+		}
+	}
+}
+
+// WithTokensWithoutExpiry makes ValidateToken accept tokens lacking an exp claim, which are
+// otherwise rejected with ErrNoExpiry as they would never expire. Only use it for IdPs that
+// can't be made to set exp.
+func WithTokensWithoutExpiry() Option {
+	return func(o *options) {
+		o.allowNoExpiry = true
+	}
+}
+
+// WithKeyRetirementGrace keeps signing keys that disappear from the JWKS for the given grace
+// period, so tokens signed before a key rotation still validate until they expire. Without
+// it, retired keys are dropped on the next JWKS fetch, which is what revoking a leaked key
+// needs: during the grace period, tokens forged with a removed key are still accepted.
+func WithKeyRetirementGrace(grace time.Duration) Option {
+	return func(o *options) {
+		o.keyRetirementGrace = grace
+	}
+}
+
+// WithTokenCache sets the backend NewIAMProvider caches token validations in, such as a
+// cache shared between instances. Without it, validations are cached in process memory.
+func WithTokenCache(cache TokenCache) Option {
+	return func(o *options) {
+		o.tokenCache = cache
+	}
+}
+
+// WithJTIDenylist sets the store NewIAMProvider checks token IDs against when
+// iam.check_jti_denylist is set, such as one shared between instances. Without it,
+// revocations are kept in process memory.
+func WithJTIDenylist(denylist JTIDenylist) Option {
+	return func(o *options) {
+		o.jtiDenylist = denylist
+	}
+}
+
+// WithSigningKeyProvider sets the keys NewInternalTokenMinter signs with, such as ones held
+// in a KMS. Without it, they come from iam.internal_token.key_source.
+func WithSigningKeyProvider(keys SigningKeyProvider) Option {
+	return func(o *options) {
+		o.signingKeys = keys
+	}
+}
+
+// newOptions applies opts over the defaults
+func newOptions(opts []Option) *options {
+	o := &options{
+		rolesExtractor:    KeycloakRealmRoles,
+		groupsClaim:       defaultGroupsClaim,
+		principalClaim:    defaultPrincipalClaim,
+		clock:             clock.System(),
+		tracer:            noopTracer{},
+		jwksMaxBytes:      defaultJWKSMaxBytes,
+		warmupConcurrency: defaultWarmupConcurrency,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// claimOptions returns the options for how token claims are read and checked, shared by
+// the provider and the gateway validator
+func claimOptions(cfg *config.IAMConfig) []Option {
+	return []Option{
+		WithGroupsClaim(cfg.GroupsClaim),
+		WithPrincipalClaim(cfg.PrincipalClaim),
+		WithClockSkew(time.Duration(cfg.ClockSkewSeconds) * time.Second),
+	}
+}
+
 // NewIAMProvider creates a new IAM provider based on the given configuration
-func NewIAMProvider(cfg *config.IAMConfig, log *logger.Logger) (IAMProvider, error) {
+func NewIAMProvider(cfg *config.IAMConfig, log *logger.Logger, opts ...Option) (IAMProvider, error) {
+	var (
+		p   IAMProvider
+		err error
+	)
+
 	switch cfg.CurrentProvider() {
 	case "keycloak":
-		return NewKeycloakProvider(cfg.Keycloak, log)
+		opts = append(append(claimOptions(cfg), []Option{
+			WithDiscoveryCacheTTL(cfg.DiscoveryCacheTTL),
+			WithAllowedIssuerPatterns(cfg.AllowedIssuerPatterns...),
+			WithHTTPConfig(cfg.HTTP),
+			WithSlowCallThreshold(cfg.SlowCallThreshold),
+			WithJWKSMaxBytes(cfg.JWKSMaxBytes),
+			WithJWKSRefreshRate(cfg.JWKSRefreshRate.MaxRefreshes, cfg.JWKSRefreshRate.Window),
+			WithKeyRetirementGrace(cfg.KeyRetirementGrace),
+			WithMaxTokenLifetime(cfg.MaxTokenLifetime),
+			WithWarmupConcurrency(cfg.WarmupConcurrency),
+			WithMaxConcurrentCalls(cfg.MaxConcurrentValidations, cfg.ValidationWaitTimeout),
+		}...), opts...)
+		if cfg.EnforceTokenType {
+			opts = append([]Option{WithAccessTokenTypes(cfg.AccessTokenTypes...)}, opts...)
+		}
+		if cfg.AllowTokensWithoutExpiry {
+			opts = append([]Option{WithTokensWithoutExpiry()}, opts...)
+		}
+		if len(cfg.Keycloak.Realms) > 0 {
+			p, err = NewMultiTenantProvider(cfg.Keycloak.RealmConfigs(), log, opts...)
+		} else {
+			p, err = NewKeycloakProvider(cfg.Keycloak, log, opts...)
+		}
 	default:
 		return nil, errors.New("invalid IAM provider")
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Cache.Enabled {
+		o := newOptions(opts)
+		cache := o.tokenCache
+		if cache == nil {
+			// The default cache expires entries by the same clock as their freshness
+			cache = NewLRUTokenCache(cfg.Cache.MaxEntries, o.clock)
+		}
+		cp := NewCachingProvider(p, cache, &cfg.Cache, &cfg.DegradedMode, log)
+		cp.clock = o.clock
+		p = cp
+	}
+
+	if cfg.CheckJTIDenylist {
+		o := newOptions(opts)
+		denylist := o.jtiDenylist
+		if denylist == nil {
+			denylist = NewMemoryJTIDenylist(o.clock)
+		}
+		p = NewDenylistProvider(p, denylist)
+	}
+
+	return p, nil
 }
@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+)
+
+var (
+	// ErrAccessTokenHashMismatch is returned when an ID token's at_hash doesn't match the access
+	// token received with it, which means one of them was substituted
+	ErrAccessTokenHashMismatch = errors.New("access token does not match the ID token at_hash")
+	// ErrNonceMismatch is returned when an ID token doesn't carry the nonce of the authentication
+	// request, which means it was replayed or issued for another request
+	ErrNonceMismatch = errors.New("ID token nonce mismatch")
+)
+
+// IDTokenValidator is implemented by providers that can validate OpenID Connect ID tokens
+type IDTokenValidator interface {
+	ValidateIDToken(ctx context.Context, idToken, nonce, accessToken string) (*TokenInfo, error)
+}
+
+// ValidateIDToken validates an ID token received from the realm's token endpoint, as an
+// OpenID Connect relying party must (OIDC Core, section 3.1.3.7): its signature against the
+// realm's keys, whatever the token validation mode, its issuer, its audience including the
+// client, and its expiry. A non-empty nonce must equal the token's nonce claim. A non-empty
+// accessToken, received along with the ID token, must match the token's at_hash claim when
+// present (section 3.1.3.8); at_hash is optional in the code flow.
+func (k *KeycloakProvider) ValidateIDToken(ctx context.Context, idToken, nonce, accessToken string) (*TokenInfo, error) {
+	jwt, err := verifyJWT(ctx, idToken, k.idTokenKeys, k.opts.clock.Now(), k.opts.clockSkew)
+	if err != nil {
+		return nil, err
+	}
+
+	if iss := claimString(jwt.claims, "iss"); !k.issuerMatches(iss) {
+		return nil, invalidIssuer(iss)
+	}
+
+	tokenInfo := newTokenInfo(jwt.claims, k.opts)
+	if !containsString(tokenInfo.Audience, k.config.ClientID) {
+		return nil, newValidationError(ErrInvalidAudience, ReasonWrongAudience, "ID token not issued for this client")
+	}
+	// With several audiences, the authorized party must be this client
+	if len(tokenInfo.Audience) > 1 && tokenInfo.AuthorizedParty != k.config.ClientID {
+		return nil, newValidationError(ErrInvalidAudience, ReasonWrongAudience, "ID token authorized for another client").
+			withClaim("azp", tokenInfo.AuthorizedParty)
+	}
+
+	if nonce != "" && subtle.ConstantTimeCompare([]byte(claimString(jwt.claims, "nonce")), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	if atHash := claimString(jwt.claims, "at_hash"); accessToken != "" && atHash != "" {
+		expected, ok := tokenHash(jwt.header.Alg, accessToken)
+		if !ok || subtle.ConstantTimeCompare([]byte(atHash), []byte(expected)) != 1 {
+			return nil, ErrAccessTokenHashMismatch
+		}
+	}
+
+	return tokenInfo, nil
+}
+
+// tokenHash computes an at_hash or c_hash value: the base64url encoding of the left-most
+// half of the token's hash, using the hash function of the ID token's signing algorithm
+func tokenHash(alg, token string) (string, bool) {
+	var hash crypto.Hash
+	if ec, ok := ecAlgorithms[alg]; ok {
+		hash = ec.hash
+	} else if hash, ok = rsaAlgorithms[alg]; !ok {
+		return "", false
+	}
+
+	h := hash.New()
+	h.Write([]byte(token))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), true
+}
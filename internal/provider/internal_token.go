@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// Key sources of iam.internal_token.key_source
+const (
+	KeySourceFile   = "file"
+	KeySourceMemory = "memory"
+)
+
+// InternalTokenMinter mints short-lived internal JWTs for validated tokens, signed with the
+// bridge's own key and carrying a minimal claim set: sub, azp, roles and groups besides the
+// registered claims. Upstream services verify them cheaply, without depending on the IdP,
+// against the JWKS of SigningKeys, e.g. served from /.well-known/jwks.json, or with a
+// GatewayValidator using the public key and WithRolesExtractor(ClaimRolesExtractor("roles")).
+type InternalTokenMinter struct {
+	config *config.InternalTokenConfig
+	keys   SigningKeyProvider
+	opts   *options
+}
+
+// NewInternalTokenMinter creates an InternalTokenMinter from the iam.internal_token settings,
+// signing with the keys of WithSigningKeyProvider or else those of iam.internal_token.key_source
+func NewInternalTokenMinter(cfg *config.IAMConfig, opts ...Option) (*InternalTokenMinter, error) {
+	o := newOptions(opts)
+	keys := o.signingKeys
+	if keys == nil {
+		var err error
+		if keys, err = newSigningKeyProvider(&cfg.InternalToken, opts); err != nil {
+			return nil, fmt.Errorf("failed to create internal token signing keys: %w", err)
+		}
+	}
+
+	return &InternalTokenMinter{
+		config: &cfg.InternalToken,
+		keys:   keys,
+		opts:   o,
+	}, nil
+}
+
+// newSigningKeyProvider creates the SigningKeyProvider of the configured key source
+func newSigningKeyProvider(cfg *config.InternalTokenConfig, opts []Option) (SigningKeyProvider, error) {
+	switch strings.ToLower(cfg.KeySource) {
+	case "", KeySourceFile:
+		return NewFileSigningKeyProvider(cfg.PrivateKeyFile, cfg.KeyID, cfg.JWKSMaxAge, cfg.KeyOverlap, opts...)
+	case KeySourceMemory:
+		return NewMemorySigningKeyProvider(cfg.RotationInterval, cfg.JWKSMaxAge, cfg.KeyOverlap, opts...)
+	default:
+		return nil, fmt.Errorf("unknown key source %q", cfg.KeySource)
+	}
+}
+
+// SigningKeys returns the provider of the keys internal tokens are signed with, whose
+// verification keys upstream services must accept
+func (m *InternalTokenMinter) SigningKeys() SigningKeyProvider {
+	return m.keys
+}
+
+// MintInternalToken mints an internal token for the subject of a validated token
+func (m *InternalTokenMinter) MintInternalToken(ctx context.Context, tokenInfo *TokenInfo) (string, error) {
+	key, err := m.keys.SigningKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get internal token signing key: %w", err)
+	}
+	if key == nil || key.Key == nil {
+		return "", errors.New("no internal token signing key")
+	}
+
+	now := m.opts.clock.Now()
+	claims := map[string]interface{}{
+		"iss": m.config.Issuer,
+		"aud": m.config.Audiences,
+		"sub": tokenInfo.UserID,
+		"iat": now.Unix(),
+		"exp": now.Add(m.config.TTL).Unix(),
+		"jti": uuid.NewString(),
+	}
+	if clientID := tokenInfo.ClientID(); clientID != "" {
+		claims["azp"] = clientID
+	}
+	if len(tokenInfo.Roles) > 0 {
+		claims["roles"] = tokenInfo.Roles
+	}
+	if len(tokenInfo.Groups) > 0 {
+		claims["groups"] = tokenInfo.Groups
+	}
+
+	header := map[string]string{"alg": key.Algorithm, "typ": "JWT", "kid": key.ID}
+	return signJWT(header, claims, key.Key)
+}
+
+// signJWT encodes and signs a JWT with key, by the alg of header. The signing goes through
+// key's Sign method, so keys held in a KMS or HSM work as well as in-process ones; alg must
+// be the one signingAlgorithm picks for the key.
+func signJWT(header map[string]string, claims map[string]interface{}, key crypto.Signer) (string, error) {
+	alg, err := signingAlgorithm(key)
+	if err != nil {
+		return "", err
+	}
+	if header["alg"] != alg {
+		return "", fmt.Errorf("signing algorithm %q does not match the %s signing key", header["alg"], alg)
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	hashFunc := crypto.SHA256
+	switch alg {
+	case "ES384":
+		hashFunc = crypto.SHA384
+	case "ES512":
+		hashFunc = crypto.SHA512
+	}
+	h := hashFunc.New()
+	h.Write([]byte(signingInput))
+	digest := h.Sum(nil)
+
+	signature, err := key.Sign(rand.Reader, digest, hashFunc)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign internal token: %w", err)
+	}
+	if ecKey, ok := key.Public().(*ecdsa.PublicKey); ok {
+		if signature, err = jwsECDSASignature(ecKey, signature); err != nil {
+			return "", fmt.Errorf("failed to sign internal token: %w", err)
+		}
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// jwsECDSASignature converts the ASN.1 signature crypto.Signer returns for ECDSA keys into a
+// JWS one: the fixed-size big-endian r and s values (RFC 7518, section 3.4)
+func jwsECDSASignature(key *ecdsa.PublicKey, der []byte) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if rest, err := asn1.Unmarshal(der, &sig); err != nil || len(rest) > 0 {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.BitLen() > 8*size || sig.S.BitLen() > 8*size {
+		return nil, errors.New("malformed ECDSA signature")
+	}
+	signature := make([]byte, 2*size)
+	sig.R.FillBytes(signature[:size])
+	sig.S.FillBytes(signature[size:])
+	return signature, nil
+}
+
+// signingAlgorithm returns the JWS algorithm internal tokens are signed with for key, by the
+// type of its public key, so opaque signers such as KMS keys are supported too
+func signingAlgorithm(key crypto.Signer) (string, error) {
+	switch key := key.Public().(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+	case *ecdsa.PublicKey:
+		switch key.Curve.Params().Name {
+		case "P-256":
+			return "ES256", nil
+		case "P-384":
+			return "ES384", nil
+		case "P-521":
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	default:
+		return "", errors.New("unsupported key type, must be RSA or EC")
+	}
+}
+
+// loadPrivateKey reads a PEM-encoded private key (PKCS #8, PKCS #1 or SEC 1)
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s contains no PEM data", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	default:
+		return nil, errors.New("unsupported key type, must be RSA or EC")
+	}
+}
@@ -0,0 +1,223 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// opaqueSigner hides the concrete key type, like a KMS or HSM backed crypto.Signer
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+// staticSigningKeys publishes fixed keys, signing with the first
+type staticSigningKeys []*SigningKey
+
+func (s staticSigningKeys) SigningKey(context.Context) (*SigningKey, error) {
+	return s[0], nil
+}
+
+func (s staticSigningKeys) VerificationKeys(context.Context) ([]*SigningKey, error) {
+	return s, nil
+}
+
+// writePEM writes a PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writePublicKey writes the public half of key as a PKIX PEM file to dir
+func writePublicKey(t *testing.T, dir string, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writePEM(t, dir, "public.pem", "PUBLIC KEY", der)
+}
+
+func mustGenerateECKey(t testing.TB, curve elliptic.Curve) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func mustGenerateRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func internalTokenConfig(publicKeyFile string) *config.IAMConfig {
+	return &config.IAMConfig{
+		InternalToken: config.InternalTokenConfig{
+			Issuer:    "https://bridge.internal",
+			Audiences: []string{"orders"},
+			TTL:       time.Minute,
+		},
+		Gateway: config.GatewayConfig{
+			PublicKeyFile: publicKeyFile,
+			Issuer:        "https://bridge.internal",
+			Audiences:     []string{"orders"},
+		},
+	}
+}
+
+func TestMintInternalTokenRoundTrip(t *testing.T) {
+	rsaKey := mustGenerateRSAKey(t)
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		wantAlg string
+	}{
+		{"rsa", rsaKey, "RS256"},
+		{"p256", mustGenerateECKey(t, elliptic.P256()), "ES256"},
+		{"p384", mustGenerateECKey(t, elliptic.P384()), "ES384"},
+		{"p521", mustGenerateECKey(t, elliptic.P521()), "ES512"},
+		{"opaque rsa", opaqueSigner{rsaKey}, "RS256"},
+		{"opaque ec", opaqueSigner{mustGenerateECKey(t, elliptic.P256())}, "ES256"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := internalTokenConfig(writePublicKey(t, t.TempDir(), tt.key))
+			key, err := NewSigningKey(tt.key, "")
+			if err != nil {
+				t.Fatalf("NewSigningKey() error = %v", err)
+			}
+			minter, err := NewInternalTokenMinter(cfg, WithSigningKeyProvider(staticSigningKeys{key}))
+			if err != nil {
+				t.Fatalf("NewInternalTokenMinter() error = %v", err)
+			}
+
+			token, err := minter.MintInternalToken(context.Background(), &TokenInfo{
+				UserID:          "user-1",
+				Roles:           []string{"admin", "user"},
+				Groups:          []string{"/ops"},
+				AuthorizedParty: "web",
+			})
+			if err != nil {
+				t.Fatalf("MintInternalToken() error = %v", err)
+			}
+
+			header, claims, err := DecodeToken(token)
+			if err != nil {
+				t.Fatalf("DecodeToken() error = %v", err)
+			}
+			if header.Alg != tt.wantAlg || header.Kid != key.ID {
+				t.Errorf("header = %+v, want alg %s and kid %s", header, tt.wantAlg, key.ID)
+			}
+			if claims["jti"] == "" {
+				t.Error("internal token has no jti")
+			}
+
+			validator, err := NewGatewayValidator(cfg, WithRolesExtractor(ClaimRolesExtractor("roles")))
+			if err != nil {
+				t.Fatalf("NewGatewayValidator() error = %v", err)
+			}
+			info, err := validator.ValidateToken(context.Background(), token)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+			if info.UserID != "user-1" || info.ClientID() != "web" {
+				t.Errorf("ValidateToken() subject = %q, client = %q", info.UserID, info.ClientID())
+			}
+			if !slices.Equal(info.Roles, []string{"admin", "user"}) || !slices.Equal(info.Groups, []string{"/ops"}) {
+				t.Errorf("ValidateToken() roles = %v, groups = %v", info.Roles, info.Groups)
+			}
+		})
+	}
+}
+
+func TestMintInternalTokenFromKeyFile(t *testing.T) {
+	key := mustGenerateECKey(t, elliptic.P256())
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	cfg := internalTokenConfig(writePublicKey(t, dir, key))
+	cfg.InternalToken.PrivateKeyFile = writePEM(t, dir, "private.pem", "EC PRIVATE KEY", der)
+	cfg.InternalToken.KeyOverlap = 10 * time.Minute
+
+	minter, err := NewInternalTokenMinter(cfg)
+	if err != nil {
+		t.Fatalf("NewInternalTokenMinter() error = %v", err)
+	}
+	token, err := minter.MintInternalToken(context.Background(), &TokenInfo{UserID: "user-1"})
+	if err != nil {
+		t.Fatalf("MintInternalToken() error = %v", err)
+	}
+
+	validator, err := NewGatewayValidator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := validator.ValidateToken(context.Background(), token); err != nil {
+		t.Errorf("ValidateToken() error = %v", err)
+	}
+}
+
+func TestSignJWTRejectsMismatchedKeys(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{"sub": "user-1"}
+
+	tests := []struct {
+		name string
+		alg  string
+		key  crypto.Signer
+	}{
+		{"rsa key with ES256", "ES256", mustGenerateRSAKey(t)},
+		{"p256 key with ES384", "ES384", mustGenerateECKey(t, elliptic.P256())},
+		{"ec key with none", "none", mustGenerateECKey(t, elliptic.P256())},
+		{"ed25519 key", "EdDSA", edKey},
+		{"opaque ed25519 key", "EdDSA", opaqueSigner{edKey}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := signJWT(map[string]string{"alg": tt.alg, "typ": "JWT"}, claims, tt.key)
+			if err == nil {
+				t.Errorf("signJWT() = %q, want an error", token)
+			}
+		})
+	}
+}
+
+func TestNewSigningKeyRejectsUnsupportedKeys(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSigningKey(edKey, "kid"); err == nil {
+		t.Error("NewSigningKey() accepted an Ed25519 key")
+	}
+}
@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+)
+
+// issuerFailureTTL is how long a failed first load of an issuer's key set is remembered,
+// answering further tokens of that issuer without calling the IdP again
+const issuerFailureTTL = 30 * time.Second
+
+// issuerPattern matches token issuers such as https://kc.example.com/realms/*, where
+// the wildcard stands for exactly one path segment (the realm)
+type issuerPattern struct {
+	prefix   string
+	suffix   string
+	wildcard bool
+}
+
+// parseIssuerPattern parses an issuer pattern containing at most one '*' wildcard
+func parseIssuerPattern(pattern string) (issuerPattern, error) {
+	switch strings.Count(pattern, "*") {
+	case 0:
+		return issuerPattern{prefix: pattern}, nil
+	case 1:
+		i := strings.IndexByte(pattern, '*')
+		return issuerPattern{prefix: pattern[:i], suffix: pattern[i+1:], wildcard: true}, nil
+	default:
+		return issuerPattern{}, fmt.Errorf("issuer pattern %q must contain at most one '*'", pattern)
+	}
+}
+
+// match reports whether iss matches the pattern and returns its realm: the segment
+// matched by the wildcard, or the segment after /realms/ for exact issuers
+func (p issuerPattern) match(iss string) (string, bool) {
+	if !p.wildcard {
+		if iss != p.prefix {
+			return "", false
+		}
+		i := strings.LastIndex(iss, "/realms/")
+		if i < 0 {
+			return "", false
+		}
+		realm := iss[i+len("/realms/"):]
+		return realm, realm != "" && !strings.Contains(realm, "/")
+	}
+	if len(iss) <= len(p.prefix)+len(p.suffix) ||
+		!strings.HasPrefix(iss, p.prefix) || !strings.HasSuffix(iss, p.suffix) {
+		return "", false
+	}
+
+	segment := iss[len(p.prefix) : len(iss)-len(p.suffix)]
+	if strings.ContainsAny(segment, "/?#") {
+		return "", false
+	}
+	return segment, true
+}
+
+// issuerKeySets resolves the signing keys of dynamically onboarded realms whose issuer
+// matches one of the allowed patterns. Each realm's JWKS is fetched from its issuer on first
+// use and cached; failed loads are remembered for issuerFailureTTL.
+type issuerKeySets struct {
+	patterns []issuerPattern
+	fetch    func(ctx context.Context, iss, realm string) ([]byte, error)
+	clock    clock.Clock
+
+	mu   sync.RWMutex
+	sets map[string]*keySet
+	// pending holds the key sets still loading, shared by concurrent first tokens of an issuer
+	pending map[string]*keySet
+	// failures holds the issuers whose first load failed recently
+	failures map[string]issuerFailure
+	// budget limits the first loads of issuers' key sets together, so made-up realms
+	// can't flood the IdP; once loaded, each key set gets its own budget from newBudget
+	budget    *refreshBudget
+	newBudget func(iss string) *refreshBudget
+	// retirement is the grace period of the issuers' retired keys
+	retirement *keyRetirement
+}
+
+// issuerFailure is a failed first load of an issuer's key set
+type issuerFailure struct {
+	err   error
+	until time.Time
+}
+
+// newIssuerKeySets creates the key sets for the given issuer patterns, fetching the JWKS of
+// each with fetch
+func newIssuerKeySets(patterns []string, fetch func(ctx context.Context, iss, realm string) ([]byte, error),
+	c clock.Clock) (*issuerKeySets, error) {
+	s := &issuerKeySets{
+		fetch:    fetch,
+		clock:    clock.OrSystem(c),
+		sets:     make(map[string]*keySet),
+		pending:  make(map[string]*keySet),
+		failures: make(map[string]issuerFailure),
+	}
+	for _, pattern := range patterns {
+		p, err := parseIssuerPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, p)
+	}
+	return s, nil
+}
+
+// match returns the realm of iss when it matches one of the patterns
+func (s *issuerKeySets) match(iss string) (string, bool) {
+	for _, p := range s.patterns {
+		if realm, ok := p.match(iss); ok {
+			return realm, true
+		}
+	}
+	return "", false
+}
+
+// keySet returns the key set of an allowed issuer. Key sets are only kept once they
+// loaded successfully, so tokens naming unknown realms can't grow the cache. Concurrent
+// first tokens of an issuer share one key set and its load.
+func (s *issuerKeySets) keySet(ctx context.Context, iss string) (*keySet, error) {
+	realm, ok := s.match(iss)
+	if !ok {
+		return nil, invalidIssuer(iss)
+	}
+
+	s.mu.RLock()
+	set, found := s.sets[iss]
+	failure, failed := s.failures[iss]
+	s.mu.RUnlock()
+	if found {
+		return set, nil
+	}
+	if failed && s.clock.Now().Before(failure.until) {
+		return nil, failure.err
+	}
+
+	s.mu.Lock()
+	if set, found = s.sets[iss]; found {
+		s.mu.Unlock()
+		return set, nil
+	}
+	set, found = s.pending[iss]
+	if !found {
+		set = newRemoteKeySet(func(ctx context.Context) ([]byte, error) {
+			return s.fetch(ctx, iss, realm)
+		})
+		set.budget = s.budget
+		set.retirement = s.retirement
+		s.pending[iss] = set
+	}
+	s.mu.Unlock()
+
+	// Version 0 is the set before its first successful load
+	if err := set.refreshSince(ctx, 0); err != nil {
+		// A realm the IdP doesn't know is an untrusted issuer, not a server error
+		if !errors.Is(err, ErrProviderUnavailable) {
+			err = fmt.Errorf("%w: %w", invalidIssuer(iss), err)
+		}
+
+		s.mu.Lock()
+		if s.pending[iss] == set {
+			delete(s.pending, iss)
+		}
+		// Only failures of the IdP are remembered, not a used-up budget or a caller giving up
+		if !errors.Is(err, errRefreshBudgetExhausted) && ctx.Err() == nil {
+			s.rememberFailure(iss, err)
+		}
+		s.mu.Unlock()
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending[iss] == set {
+		delete(s.pending, iss)
+	}
+	delete(s.failures, iss)
+	if existing, ok := s.sets[iss]; ok {
+		return existing, nil
+	}
+	if s.newBudget != nil {
+		set.mu.Lock()
+		set.budget = s.newBudget(iss)
+		set.mu.Unlock()
+	}
+	s.sets[iss] = set
+	return set, nil
+}
+
+// rememberFailure records the failed first load of iss, dropping the failures that expired
+// so made-up issuers can't grow the map beyond the loads of one issuerFailureTTL. The caller
+// holds s.mu.
+func (s *issuerKeySets) rememberFailure(iss string, err error) {
+	now := s.clock.Now()
+	for other, failure := range s.failures {
+		if !now.Before(failure.until) {
+			delete(s.failures, other)
+		}
+	}
+	s.failures[iss] = issuerFailure{err: err, until: now.Add(issuerFailureTTL)}
+}
+
+// invalidIssuer reports that the token's issuer is not accepted
+func invalidIssuer(iss string) error {
+	return newValidationError(ErrInvalidIssuer, ReasonWrongIssuer, "token issuer not accepted").
+		withClaim("iss", iss)
+}
@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// patternProvider creates a provider of realm test on base, also accepting the realms of
+// pattern
+func patternProvider(t *testing.T, base *realmsIdP, pattern string, opts ...Option) IAMProvider {
+	t.Helper()
+	p, err := NewKeycloakProvider(config.KeycloakConfig{
+		BaseURL:         base.URL,
+		Realm:           "test",
+		ClientID:        "bridge",
+		ClientSecret:    "secret",
+		TokenValidation: "jwks",
+	}, nil, append([]Option{WithAllowedIssuerPatterns(pattern)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestIssuerKeysFetchedFromIssuer(t *testing.T) {
+	base, tenants := newRealmsIdP(t), newRealmsIdP(t)
+	key := mustSigningKey(t)
+	// The configured IdP has a realm of the same name but other keys
+	base.publish("acme", mustSigningKey(t))
+	tenants.publish("acme", key)
+	p := patternProvider(t, base, tenants.URL+"/realms/*")
+
+	if _, err := p.ValidateToken(context.Background(), tenants.token(t, "acme", key, key.ID)); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+	if n := tenants.fetchCount("acme"); n != 1 {
+		t.Errorf("the issuer's JWKS was fetched %d times, want 1", n)
+	}
+	if n := base.fetchCount("acme"); n != 0 {
+		t.Errorf("the configured IdP was asked for the issuer's JWKS %d times, want 0", n)
+	}
+}
+
+func TestIssuerFailuresRemembered(t *testing.T) {
+	ctx := context.Background()
+	clk := newFakeClock()
+	idp := newRealmsIdP(t)
+	p := patternProvider(t, idp, idp.URL+"/realms/*", WithClock(clk))
+	token := idp.token(t, "unknown", mustSigningKey(t), "kid")
+
+	tests := []struct {
+		name        string
+		advance     time.Duration
+		wantFetches int
+	}{
+		{"first token fetches", 0, 1},
+		{"failure remembered", time.Second, 1},
+		{"still remembered", issuerFailureTTL - 2*time.Second, 1},
+		{"fetched again after the TTL", time.Second, 2},
+		{"new failure remembered", time.Second, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk.Advance(tt.advance)
+			if _, err := p.ValidateToken(ctx, token); !errors.Is(err, ErrInvalidIssuer) {
+				t.Errorf("ValidateToken() error = %v, want ErrInvalidIssuer", err)
+			}
+			if n := idp.fetchCount("unknown"); n != tt.wantFetches {
+				t.Errorf("the JWKS was fetched %d times, want %d", n, tt.wantFetches)
+			}
+		})
+	}
+}
+
+func TestIssuerFailuresExpire(t *testing.T) {
+	clk := newFakeClock()
+	s, err := newIssuerKeySets([]string{"https://kc.example.com/realms/*"},
+		func(context.Context, string, string) ([]byte, error) {
+			return nil, errors.New("realm not found")
+		}, clk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, realm := range []string{"a", "b", "c"} {
+		_, _ = s.keySet(context.Background(), "https://kc.example.com/realms/"+realm)
+	}
+	clk.Advance(issuerFailureTTL)
+	_, _ = s.keySet(context.Background(), "https://kc.example.com/realms/d")
+	if len(s.failures) != 1 {
+		t.Errorf("%d failures remembered after the others expired, want 1", len(s.failures))
+	}
+}
+
+func TestIssuerFirstTokensShareKeySet(t *testing.T) {
+	const callers = 20
+	idp := newGatedJWKS(t, mustSigningKey(t))
+	s, err := newIssuerKeySets([]string{"https://kc.example.com/realms/*"},
+		func(ctx context.Context, _, _ string) ([]byte, error) {
+			return idp.fetch(ctx)
+		}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	sets := make(chan *keySet, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			set, err := s.keySet(context.Background(), "https://kc.example.com/realms/acme")
+			if err != nil {
+				t.Errorf("keySet() error = %v", err)
+			}
+			sets <- set
+		}()
+	}
+	<-idp.started
+	// Give the other callers time to join the load in flight
+	time.Sleep(20 * time.Millisecond)
+	close(idp.release)
+	wg.Wait()
+	close(sets)
+
+	first := <-sets
+	for set := range sets {
+		if set != first {
+			t.Fatal("concurrent first tokens of an issuer got different key sets")
+		}
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("the issuer's JWKS was fetched %d times, want 1", n)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("%d key sets still pending after the load", len(s.pending))
+	}
+}
+
+func TestIssuerMatching(t *testing.T) {
+	const public = "https://auth.example.com/realms/test"
+	key := mustSigningKey(t)
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		baseURL  string
+		override string
+		iss      string
+		wantErr  error
+	}{
+		{"realm issuer", "https://kc.example.com", "", "https://kc.example.com/realms/test", nil},
+		{"trailing slash", "https://kc.example.com", "", "https://kc.example.com/realms/test/", nil},
+		{"base URL with a trailing slash", "https://kc.example.com/", "", "https://kc.example.com/realms/test", nil},
+		{"other realm", "https://kc.example.com", "", "https://kc.example.com/realms/other", ErrInvalidIssuer},
+		{"realm prefix", "https://kc.example.com", "", "https://kc.example.com/realms/tes", ErrInvalidIssuer},
+		{"no iss", "https://kc.example.com", "", "", ErrInvalidIssuer},
+		{"override", "http://keycloak:8080", public, public, nil},
+		{"override with a trailing slash", "http://keycloak:8080", public + "/", public, nil},
+		{"internal issuer with an override", "http://keycloak:8080", public, "http://keycloak:8080/realms/test", ErrInvalidIssuer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewKeycloakProvider(config.KeycloakConfig{
+				BaseURL:         tt.baseURL,
+				Realm:           "test",
+				ClientID:        "bridge",
+				ClientSecret:    "secret",
+				TokenValidation: "jwks",
+				JWKSInline:      string(jwks),
+				IssuerOverride:  tt.override,
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims := map[string]interface{}{
+				"sub": "user-1",
+				"iat": float64(time.Now().Unix()),
+				"exp": float64(time.Now().Add(time.Hour).Unix()),
+			}
+			if tt.iss != "" {
+				claims["iss"] = tt.iss
+			}
+			token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.ID}, claims, key.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.ValidateToken(context.Background(), token)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("ValidateToken() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
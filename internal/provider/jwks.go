@@ -0,0 +1,435 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+)
+
+// ErrKeyNotFound is returned when no key in the JWKS matches the token's kid
+var ErrKeyNotFound = errors.New("signing key not found")
+
+// JSONWebKey is a single key of a JSON Web Key Set (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a JSON Web Key Set document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// parseJWKS decodes a JWKS document into its usable signature verification keys, keyed by kid.
+// Encryption keys and unsupported key types or curves are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", jwk.Kid, err)
+		}
+		if key != nil {
+			keys[jwk.Kid] = key
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+
+	return keys, nil
+}
+
+// publicKey converts the JWK into a public key, or nil for unsupported key types and curves
+func (k *JSONWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		return k.ecPublicKey()
+	default:
+		return nil, nil
+	}
+}
+
+// ecCurves maps the supported JWK curve names to their curves
+var ecCurves = map[string]struct {
+	curve elliptic.Curve
+	ecdh  ecdh.Curve
+}{
+	"P-256": {elliptic.P256(), ecdh.P256()},
+	"P-384": {elliptic.P384(), ecdh.P384()},
+	"P-521": {elliptic.P521(), ecdh.P521()},
+}
+
+// ecPublicKey converts an EC JWK into an ECDSA public key, checking the point is on the curve,
+// or returns nil for unsupported curves such as secp256k1
+func (k *JSONWebKey) ecPublicKey() (crypto.PublicKey, error) {
+	c, ok := ecCurves[k.Crv]
+	if !ok {
+		return nil, nil
+	}
+
+	size := (c.curve.Params().BitSize + 7) / 8
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil || len(x) != size {
+		return nil, errors.New("invalid x coordinate")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil || len(y) != size {
+		return nil, errors.New("invalid y coordinate")
+	}
+
+	// The uncompressed point encoding lets crypto/ecdh validate the point
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := c.ecdh.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid point: %w", err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: c.curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
+
+// decodeBigInt decodes a base64url-encoded unsigned big-endian integer
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// keySet resolves signing keys by kid. A key set with a fetch function refreshes itself
+// from the network when an unknown kid shows up; without one it is static.
+type keySet struct {
+	fetch func(ctx context.Context) ([]byte, error)
+	// single, when set, is the only key and verifies tokens of any kid
+	single crypto.PublicKey
+
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+	// version counts successful loads, so callers that missed a key can tell whether the
+	// set was reloaded since they looked
+	version uint64
+	// loading is the load in flight, which concurrent refreshes wait for instead of
+	// fetching again
+	loading *keyLoad
+	// budget limits how many loads are started, nil for no limit
+	budget *refreshBudget
+	// retirement keeps keys that left the JWKS for a grace period, nil to drop them at once
+	retirement *keyRetirement
+	// retired holds when each retained key was first missing from the JWKS
+	retired map[string]time.Time
+}
+
+// keyLoad is a key set load shared by the callers waiting for it
+type keyLoad struct {
+	done chan struct{}
+	err  error
+}
+
+// newRemoteKeySet creates a key set that is fetched lazily on first use
+func newRemoteKeySet(fetch func(ctx context.Context) ([]byte, error)) *keySet {
+	return &keySet{fetch: fetch}
+}
+
+// newFileKeySet loads a static key set from a JWKS file
+func newFileKeySet(path string) (*keySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load JWKS file %s: %w", path, err)
+	}
+
+	return &keySet{keys: keys}, nil
+}
+
+// newInlineKeySet loads a static key set from a JWKS document given in the config
+func newInlineKeySet(jwks string) (*keySet, error) {
+	keys, err := parseJWKS([]byte(jwks))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load inline JWKS: %w", err)
+	}
+
+	return &keySet{keys: keys}, nil
+}
+
+// key returns the key with the given kid, refreshing the set once if it is unknown
+func (s *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if s.single != nil {
+		return s.single, nil
+	}
+
+	s.mu.RLock()
+	key, ok := s.lookup(kid)
+	version := s.version
+	s.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if s.fetch == nil {
+		return nil, keyNotFound(kid)
+	}
+
+	if err := s.refreshSince(ctx, version); err != nil {
+		// With the refresh budget used up, the keys at hand are all there is
+		if errors.Is(err, errRefreshBudgetExhausted) && version > 0 {
+			return nil, keyNotFound(kid)
+		}
+		return nil, err
+	}
+
+	s.mu.RLock()
+	key, ok = s.lookup(kid)
+	s.mu.RUnlock()
+	if !ok {
+		return nil, keyNotFound(kid)
+	}
+	return key, nil
+}
+
+// lookup returns the key with the given kid, unless it was retired longer than the grace
+// period ago. The caller holds s.mu.
+func (s *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, false
+	}
+	if since, retired := s.retired[kid]; retired && !s.retirement.retains(since) {
+		return nil, false
+	}
+	return key, true
+}
+
+// keyNotFound reports that no key matches kid
+func keyNotFound(kid string) error {
+	return newValidationError(ErrKeyNotFound, ReasonKeyNotFound, "no signing key matches the token").
+		withClaim("kid", kid)
+}
+
+// refresh fetches and replaces the key set. On failure the current keys are kept.
+func (s *keySet) refresh(ctx context.Context) error {
+	s.mu.RLock()
+	version := s.version
+	s.mu.RUnlock()
+	return s.refreshSince(ctx, version)
+}
+
+// refreshSince reloads the key set unless it was reloaded since version. Concurrent callers,
+// such as the first validations of a lazily fetched set, share a single fetch and its result;
+// a failed load isn't remembered, so the next caller fetches again. The fetch isn't canceled
+// with the caller that started it, as others may be waiting on it; each waits only as long
+// as its own context allows.
+func (s *keySet) refreshSince(ctx context.Context, version uint64) error {
+	s.mu.Lock()
+	if s.version != version {
+		s.mu.Unlock()
+		return nil
+	}
+	load := s.loading
+	if load == nil {
+		if !s.budget.take() {
+			s.mu.Unlock()
+			return fmt.Errorf("%w: %w", ErrProviderUnavailable, errRefreshBudgetExhausted)
+		}
+		load = &keyLoad{done: make(chan struct{})}
+		s.loading = load
+		go s.load(context.WithoutCancel(ctx), load)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-load.done:
+		return load.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// load fetches the key set for a shared load and installs it on success
+func (s *keySet) load(ctx context.Context, load *keyLoad) {
+	keys, err := s.fetchKeys(ctx)
+
+	s.mu.Lock()
+	if err == nil {
+		s.keys = s.retain(keys)
+		s.version++
+	}
+	s.loading = nil
+	s.mu.Unlock()
+
+	load.err = err
+	close(load.done)
+}
+
+// keyRetirement is the grace period for which keys that disappeared from the JWKS still
+// verify tokens, so tokens signed with a retiring key keep validating until they expire
+// rather than failing as soon as the IdP rotates its keys
+type keyRetirement struct {
+	grace time.Duration
+	clock clock.Clock
+}
+
+// newKeyRetirement returns the retirement policy of the given grace period, or nil, which
+// drops keys as soon as they leave the JWKS, for a grace period of 0
+func newKeyRetirement(grace time.Duration, c clock.Clock) *keyRetirement {
+	if grace <= 0 {
+		return nil
+	}
+	return &keyRetirement{grace: grace, clock: clock.OrSystem(c)}
+}
+
+// retains reports whether a key retired at since is still within the grace period
+func (r *keyRetirement) retains(since time.Time) bool {
+	return r != nil && r.clock.Now().Sub(since) < r.grace
+}
+
+// retain adds the keys of the current set missing from a freshly fetched one to it, as long
+// as they are within the grace period, and forgets keys that came back. The caller holds s.mu.
+func (s *keySet) retain(keys map[string]crypto.PublicKey) map[string]crypto.PublicKey {
+	if s.retirement == nil {
+		return keys
+	}
+
+	for kid := range s.retired {
+		if _, ok := keys[kid]; ok {
+			delete(s.retired, kid)
+		}
+	}
+
+	now := s.retirement.clock.Now()
+	for kid, key := range s.keys {
+		if _, ok := keys[kid]; ok {
+			continue
+		}
+		since, ok := s.retired[kid]
+		if !ok {
+			if s.retired == nil {
+				s.retired = make(map[string]time.Time)
+			}
+			since = now
+			s.retired[kid] = since
+		}
+		if !s.retirement.retains(since) {
+			delete(s.retired, kid)
+			continue
+		}
+		keys[kid] = key
+	}
+	return keys
+}
+
+// errRefreshBudgetExhausted is returned when a key set may not be reloaded before the
+// refresh budget's window resets
+var errRefreshBudgetExhausted = errors.New("JWKS refresh budget exhausted")
+
+// refreshBudget bounds the loads of a key set to max per window, so tokens with made-up
+// kids can't make the bridge flood the IdP with JWKS requests. Each key set has its own, so
+// such tokens for one realm don't keep the others from picking up rotated keys.
+type refreshBudget struct {
+	max    int
+	window time.Duration
+	clock  clock.Clock
+	// exhausted is called once per window when the budget runs out
+	exhausted func()
+
+	mu       sync.Mutex
+	start    time.Time
+	used     int
+	reported bool
+}
+
+// newRefreshBudget creates a budget of max loads per window, or returns nil, which allows
+// any number of loads, when either is 0
+func newRefreshBudget(max int, window time.Duration, c clock.Clock, exhausted func()) *refreshBudget {
+	if max <= 0 || window <= 0 {
+		return nil
+	}
+	return &refreshBudget{max: max, window: window, clock: clock.OrSystem(c), exhausted: exhausted}
+}
+
+// take uses up one load of the current window and reports whether one was left
+func (b *refreshBudget) take() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	if !now.Before(b.start.Add(b.window)) {
+		b.start, b.used, b.reported = now, 0, false
+	}
+	if b.used >= b.max {
+		if !b.reported && b.exhausted != nil {
+			b.exhausted()
+		}
+		b.reported = true
+		return false
+	}
+	b.used++
+	return true
+}
+
+// fetchKeys fetches and parses the key set
+func (s *keySet) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := s.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	return parseJWKS(data)
+}
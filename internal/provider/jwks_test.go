@@ -0,0 +1,494 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// rotatingIdP serves the JWKS of its current keys, like an IdP rotating its signing keys
+type rotatingIdP struct {
+	current atomic.Pointer[[]*SigningKey]
+	fetches atomic.Int64
+}
+
+func (r *rotatingIdP) publish(keys ...*SigningKey) {
+	r.current.Store(&keys)
+}
+
+func (r *rotatingIdP) fetch(ctx context.Context) ([]byte, error) {
+	r.fetches.Add(1)
+	keys := *r.current.Load()
+	set, err := SigningKeysJWKS(ctx, staticSigningKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(set)
+}
+
+func mustSigningKey(t *testing.T) *SigningKey {
+	t.Helper()
+	key, err := NewSigningKey(mustGenerateECKey(t, elliptic.P256()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// mustSignToken signs a token with key, expiring after ttl from now
+func mustSignToken(t *testing.T, key *SigningKey, now time.Time, ttl time.Duration) string {
+	t.Helper()
+	token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.ID}, map[string]interface{}{
+		"sub": "user-1",
+		"iat": float64(now.Unix()),
+		"exp": float64(now.Add(ttl).Unix()),
+	}, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestKeyRotationWithOutstandingTokens(t *testing.T) {
+	const grace = 15 * time.Minute
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		grace         time.Duration
+		wantOldWithin bool
+	}{
+		{"grace keeps the old key", grace, true},
+		{"no grace drops the old key", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := newFakeClock()
+			idp := &rotatingIdP{}
+			oldKey, newKey := mustSigningKey(t), mustSigningKey(t)
+			idp.publish(oldKey)
+
+			keys := newRemoteKeySet(idp.fetch)
+			keys.retirement = newKeyRetirement(tt.grace, clk)
+
+			outstanding := mustSignToken(t, oldKey, clk.Now(), time.Hour)
+			if _, err := verifyJWT(ctx, outstanding, keys, clk.Now(), 0); err != nil {
+				t.Fatalf("verifyJWT() before the rotation error = %v", err)
+			}
+
+			// The IdP rotates, removing the old key; the first token of the new key
+			// refreshes the set
+			idp.publish(newKey)
+			clk.Advance(time.Minute)
+			if _, err := verifyJWT(ctx, mustSignToken(t, newKey, clk.Now(), time.Hour), keys, clk.Now(), 0); err != nil {
+				t.Fatalf("verifyJWT() of the new key error = %v", err)
+			}
+
+			_, err := verifyJWT(ctx, outstanding, keys, clk.Now(), 0)
+			if tt.wantOldWithin && err != nil {
+				t.Errorf("verifyJWT() of an outstanding token within the grace error = %v", err)
+			}
+			if !tt.wantOldWithin && !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("verifyJWT() of an outstanding token without grace error = %v, want ErrKeyNotFound", err)
+			}
+
+			// After the grace period, the old key is gone for good
+			clk.Advance(grace)
+			if _, err := verifyJWT(ctx, outstanding, keys, clk.Now(), 0); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("verifyJWT() after the grace error = %v, want ErrKeyNotFound", err)
+			}
+		})
+	}
+}
+
+// realmsIdP is a Keycloak stub serving the JWKS of several realms, counting fetches per realm
+type realmsIdP struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    map[string][]*SigningKey
+	fetches map[string]int
+}
+
+func newRealmsIdP(t *testing.T) *realmsIdP {
+	t.Helper()
+	idp := &realmsIdP{keys: make(map[string][]*SigningKey), fetches: make(map[string]int)}
+	idp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		realm := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/realms/"), "/protocol/openid-connect/certs")
+		idp.mu.Lock()
+		keys, ok := idp.keys[realm]
+		idp.fetches[realm]++
+		idp.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		set, _ := SigningKeysJWKS(r.Context(), staticSigningKeys(keys))
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (r *realmsIdP) publish(realm string, keys ...*SigningKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[realm] = keys
+}
+
+func (r *realmsIdP) fetchCount(realm string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fetches[realm]
+}
+
+// token signs a token of realm with key, advertising kid
+func (r *realmsIdP) token(t *testing.T, realm string, key *SigningKey, kid string) string {
+	t.Helper()
+	token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": kid}, map[string]interface{}{
+		"sub": "user-1",
+		"iss": r.URL + "/realms/" + realm,
+		"iat": float64(time.Now().Unix()),
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	}, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWKSRefreshBudgetPerKeySet(t *testing.T) {
+	const maxRefreshes = 2
+	ctx := context.Background()
+	idp := newRealmsIdP(t)
+	primary, tenant := mustSigningKey(t), mustSigningKey(t)
+	idp.publish("test", primary)
+	idp.publish("acme", tenant)
+
+	p, err := NewKeycloakProvider(config.KeycloakConfig{
+		BaseURL:         idp.URL,
+		Realm:           "test",
+		ClientID:        "bridge",
+		ClientSecret:    "secret",
+		TokenValidation: "jwks",
+	}, nil, WithJWKSRefreshRate(maxRefreshes, time.Hour), WithAllowedIssuerPatterns(idp.URL+"/realms/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{idp.token(t, "test", primary, primary.ID), idp.token(t, "acme", tenant, tenant.ID)} {
+		if _, err := p.ValidateToken(ctx, token); err != nil {
+			t.Fatalf("ValidateToken() error = %v", err)
+		}
+	}
+
+	// Made-up kids for the tenant use up its budget, and no more
+	for i := 0; i < 5; i++ {
+		_, err := p.ValidateToken(ctx, idp.token(t, "acme", tenant, "made-up"))
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Fatalf("ValidateToken() with a made-up kid error = %v, want ErrKeyNotFound", err)
+		}
+	}
+	if n := idp.fetchCount("acme"); n != 1+maxRefreshes {
+		t.Errorf("acme JWKS fetched %d times, want %d", n, 1+maxRefreshes)
+	}
+
+	// The primary realm still picks up its rotated key
+	rotated := mustSigningKey(t)
+	idp.publish("test", rotated)
+	if _, err := p.ValidateToken(ctx, idp.token(t, "test", rotated, rotated.ID)); err != nil {
+		t.Errorf("ValidateToken() after the primary realm rotated error = %v", err)
+	}
+}
+
+func TestMixedKeyTypeJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []*SigningKey
+	for _, signer := range []crypto.Signer{
+		rsaKey,
+		mustGenerateECKey(t, elliptic.P256()),
+		mustGenerateECKey(t, elliptic.P384()),
+		mustGenerateECKey(t, elliptic.P521()),
+	} {
+		key, err := NewSigningKey(signer, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Encryption keys, unknown key types and unsupported curves are skipped, not fatal
+	set.Keys = append(set.Keys,
+		JSONWebKey{Kty: "RSA", Kid: "enc", Use: "enc", N: set.Keys[0].N, E: set.Keys[0].E},
+		JSONWebKey{Kty: "OKP", Kid: "ed25519", Crv: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		JSONWebKey{Kty: "EC", Kid: "secp256k1", Use: "sig", Crv: "secp256k1",
+			X: "eb5mfvncu6xVoGKVzocLBwKb_NstzijZWfKBWxb4F5g", Y: "SDradyajxGVdpPv8DhEIqP0XtEimhVQZnEfQj_sQ1Lg"},
+	)
+	jwks, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewKeycloakProvider(config.KeycloakConfig{
+		BaseURL:         "https://kc.example.com",
+		Realm:           "test",
+		ClientID:        "bridge",
+		ClientSecret:    "secret",
+		TokenValidation: "jwks",
+		JWKSInline:      string(jwks),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := map[string]interface{}{
+		"sub": "user-1",
+		"iss": "https://kc.example.com/realms/test",
+		"iat": float64(time.Now().Unix()),
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	}
+	tokens := make(map[string]string, len(keys))
+	for _, key := range keys {
+		token, err := signJWT(map[string]string{"alg": key.Algorithm, "typ": "JWT", "kid": key.ID}, claims, key.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[key.Algorithm] = token
+	}
+
+	// Tokens of every key type validate side by side
+	var wg sync.WaitGroup
+	for alg, token := range tokens {
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := p.ValidateToken(context.Background(), token); err != nil {
+					t.Errorf("ValidateToken() of an %s token error = %v", alg, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	// A token naming an EC key but claiming RS256 is rejected
+	forged, err := signJWT(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keys[1].ID}, claims, rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ValidateToken(context.Background(), forged); !errors.Is(err, ErrTokenInvalid) {
+		t.Errorf("ValidateToken() with mismatched key type error = %v, want ErrTokenInvalid", err)
+	}
+	for _, kid := range []string{"enc", "ed25519"} {
+		token, err := signJWT(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid}, claims, rsaKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.ValidateToken(context.Background(), token); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("ValidateToken() with the skipped key %s error = %v, want ErrKeyNotFound", kid, err)
+		}
+	}
+}
+
+// gatedJWKS serves a JWKS once released, counting fetches and recording whether the
+// context of any fetch was canceled
+type gatedJWKS struct {
+	jwks     []byte
+	started  chan struct{}
+	release  chan struct{}
+	fetches  atomic.Int64
+	canceled atomic.Bool
+}
+
+func newGatedJWKS(t *testing.T, keys ...*SigningKey) *gatedJWKS {
+	t.Helper()
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &gatedJWKS{jwks: jwks, started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (g *gatedJWKS) fetch(ctx context.Context) ([]byte, error) {
+	g.fetches.Add(1)
+	g.started <- struct{}{}
+	<-g.release
+	if ctx.Err() != nil {
+		g.canceled.Store(true)
+	}
+	return g.jwks, nil
+}
+
+func TestKeySetSharesFirstLoad(t *testing.T) {
+	const callers = 20
+	key := mustSigningKey(t)
+	idp := newGatedJWKS(t, key)
+	keys := newRemoteKeySet(idp.fetch)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := keys.key(context.Background(), key.ID); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	<-idp.started
+	// Give the other callers time to join the load in flight
+	time.Sleep(20 * time.Millisecond)
+	close(idp.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("key() error = %v", err)
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("the JWKS was fetched %d times by %d first lookups, want 1", n, callers)
+	}
+}
+
+func TestKeySetFailedLoadNotShared(t *testing.T) {
+	key := mustSigningKey(t)
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fetches atomic.Int64
+	keys := newRemoteKeySet(func(context.Context) ([]byte, error) {
+		if fetches.Add(1) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return jwks, nil
+	})
+
+	if _, err := keys.key(context.Background(), key.ID); err == nil {
+		t.Fatal("key() with the IdP down error = nil")
+	}
+	if _, err := keys.key(context.Background(), key.ID); err != nil {
+		t.Errorf("key() after the IdP recovered error = %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("the JWKS was fetched %d times, want 2", n)
+	}
+}
+
+func TestKeySetLoadOutlivesCanceledCaller(t *testing.T) {
+	key := mustSigningKey(t)
+	idp := newGatedJWKS(t, key)
+	keys := newRemoteKeySet(idp.fetch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := keys.key(ctx, key.ID)
+		first <- err
+	}()
+	<-idp.started
+
+	second := make(chan error)
+	go func() {
+		_, err := keys.key(context.Background(), key.ID)
+		second <- err
+	}()
+
+	// The caller that started the load gives up; the one waiting with it still gets the keys
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("key() of the canceled caller error = %v, want context.Canceled", err)
+	}
+	close(idp.release)
+	if err := <-second; err != nil {
+		t.Errorf("key() of the waiting caller error = %v", err)
+	}
+	if idp.canceled.Load() {
+		t.Error("the shared load was canceled with the caller that started it")
+	}
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("the JWKS was fetched %d times, want 1", n)
+	}
+}
+
+func TestJWKSMaxBytes(t *testing.T) {
+	key := mustSigningKey(t)
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unknown members pad the JWKS to a size of its own
+	jwks, err := json.Marshal(map[string]interface{}{"keys": set.Keys, "padding": strings.Repeat("a", 4096)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(jwks)
+	}))
+	t.Cleanup(idp.Close)
+
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr bool
+	}{
+		{"default limit", 0, false},
+		{"at the size", int64(len(jwks)), false},
+		{"under the size", int64(len(jwks)) - 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewKeycloakProvider(config.KeycloakConfig{
+				BaseURL:         idp.URL,
+				Realm:           "test",
+				ClientID:        "bridge",
+				ClientSecret:    "secret",
+				TokenValidation: "jwks",
+			}, nil, WithJWKSMaxBytes(tt.limit))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = p.ValidateToken(context.Background(), mustSignIssuedToken(t, key, idp.URL+"/realms/test", time.Hour))
+			if tt.wantErr {
+				if !errors.Is(err, ErrResponseTooLarge) || !errors.Is(err, ErrProviderUnavailable) {
+					t.Errorf("ValidateToken() error = %v, want ErrResponseTooLarge and ErrProviderUnavailable", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ValidateToken() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// segmentBuffers pools the buffers JWT segments are base64-decoded into before their JSON
+// is parsed. Decoded claims don't refer to the buffer, so it is reused by the next token.
+var segmentBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
+// jwtHeader holds the JOSE header fields the bridge inspects
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// TokenHeader holds the JOSE header fields of a token, as reported by DecodeToken
+type TokenHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// DecodeToken decodes the header and claims of a JWT without verifying it. The result
+// must not be trusted; it is meant for diagnostics.
+func DecodeToken(token string) (TokenHeader, map[string]interface{}, error) {
+	jwt, err := parseJWT(token)
+	if err != nil {
+		return TokenHeader{}, nil, err
+	}
+	return TokenHeader(jwt.header), jwt.claims, nil
+}
+
+// parsedJWT is a decoded, not yet verified, compact JWS
+type parsedJWT struct {
+	header       jwtHeader
+	claims       map[string]interface{}
+	signingInput string
+	signature    []byte
+}
+
+// rsaAlgorithms maps the supported RSASSA-PKCS1-v1_5 algorithms to their hash functions
+var rsaAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// parseJWT decodes the header, claims and signature of a compact JWS without verifying it
+func parseJWT(token string) (*parsedJWT, error) {
+	header, rest, ok := strings.Cut(token, ".")
+	claims, signature, ok2 := strings.Cut(rest, ".")
+	if !ok || !ok2 || strings.Contains(signature, ".") {
+		return nil, newValidationError(ErrTokenInvalid, ReasonMalformed, "malformed token")
+	}
+
+	jwt := &parsedJWT{}
+	if err := decodeSegment(header, &jwt.header); err != nil {
+		return nil, newValidationError(ErrTokenInvalid, ReasonMalformed, "malformed header")
+	}
+	if err := decodeSegment(claims, &jwt.claims); err != nil {
+		return nil, newValidationError(ErrTokenInvalid, ReasonMalformed, "malformed claims")
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, newValidationError(ErrTokenInvalid, ReasonMalformed, "malformed signature")
+	}
+
+	jwt.signingInput = token[:len(header)+1+len(claims)]
+	jwt.signature = sig
+	return jwt, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT into v, in a pooled buffer
+func decodeSegment(segment string, v interface{}) error {
+	bufp := segmentBuffers.Get().(*[]byte)
+	defer segmentBuffers.Put(bufp)
+
+	buf := slices.Grow((*bufp)[:0], base64.RawURLEncoding.DecodedLen(len(segment)))
+	n, err := base64.RawURLEncoding.Decode(buf[:cap(buf)], unsafe.Slice(unsafe.StringData(segment), len(segment)))
+	*bufp = buf
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf[:n], v)
+}
+
+// verifyJWT parses the token, verifies its signature with the matching key from keys
+// and checks its time-based claims at now, allowing for the given clock skew
+func verifyJWT(ctx context.Context, token string, keys *keySet, now time.Time, skew time.Duration) (*parsedJWT, error) {
+	jwt, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keys.key(ctx, jwt.header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(jwt.header.Alg, key, jwt.signingInput, jwt.signature); err != nil {
+		return nil, err
+	}
+
+	if err := checkTimeClaims(jwt.claims, now, skew); err != nil {
+		return nil, err
+	}
+
+	return jwt, nil
+}
+
+// ecAlgorithms maps the supported ECDSA algorithms to their hash functions and curves
+var ecAlgorithms = map[string]struct {
+	hash  crypto.Hash
+	curve string
+}{
+	"ES256": {crypto.SHA256, "P-256"},
+	"ES384": {crypto.SHA384, "P-384"},
+	"ES512": {crypto.SHA512, "P-521"},
+}
+
+// verifySignature checks the signature over signingInput for the given algorithm and key
+func verifySignature(alg string, key crypto.PublicKey, signingInput string, signature []byte) error {
+	if ec, ok := ecAlgorithms[alg]; ok {
+		return verifyECDSA(alg, ec.hash, ec.curve, key, signingInput, signature)
+	}
+
+	hash, ok := rsaAlgorithms[alg]
+	if !ok {
+		return newValidationError(ErrTokenInvalid, ReasonBadSignature, "unsupported signing algorithm").
+			withClaim("alg", alg)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return newValidationError(ErrTokenInvalid, ReasonBadSignature, "key type does not match algorithm").
+			withClaim("alg", alg)
+	}
+
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	if err := rsa.VerifyPKCS1v15(rsaKey, hash, h.Sum(nil), signature); err != nil {
+		return newValidationError(ErrTokenInvalid, ReasonBadSignature, "signature verification failed")
+	}
+
+	return nil
+}
+
+// verifyECDSA checks a JWS ECDSA signature, which is the concatenation of the fixed-size
+// big-endian r and s values (RFC 7518, section 3.4) rather than ASN.1
+func verifyECDSA(alg string, hash crypto.Hash, curve string, key crypto.PublicKey, signingInput string,
+	signature []byte) error {
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok || ecKey.Curve.Params().Name != curve {
+		return newValidationError(ErrTokenInvalid, ReasonBadSignature, "key type does not match algorithm").
+			withClaim("alg", alg)
+	}
+
+	size := (ecKey.Curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return newValidationError(ErrTokenInvalid, ReasonBadSignature, "signature verification failed")
+	}
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+
+	h := hash.New()
+	h.Write([]byte(signingInput))
+	if !ecdsa.Verify(ecKey, h.Sum(nil), r, s) {
+		return newValidationError(ErrTokenInvalid, ReasonBadSignature, "signature verification failed")
+	}
+
+	return nil
+}
+
+// checkTokenLifetime rejects tokens whose lifetime, from iat to exp, exceeds max, and tokens
+// lacking either claim, whose lifetime can't be told
+func checkTokenLifetime(claims map[string]interface{}, max time.Duration) error {
+	iat, hasIat := claims["iat"].(float64)
+	exp, hasExp := claims["exp"].(float64)
+	if !hasIat || !hasExp {
+		return newValidationError(ErrTokenLifetime, ReasonLifetime, "token lifetime unknown, iat and exp are required")
+	}
+
+	lifetime := time.Unix(int64(exp), 0).Sub(time.Unix(int64(iat), 0))
+	if lifetime > max {
+		return newValidationError(ErrTokenLifetime, ReasonLifetime, "token lifetime exceeds the allowed maximum").
+			withClaim("lifetime", lifetime.String())
+	}
+	return nil
+}
+
+// checkTimeClaims rejects tokens that are expired or not yet valid. skew is the leeway
+// granted for clock differences between the issuer and the bridge.
+func checkTimeClaims(claims map[string]interface{}, now time.Time, skew time.Duration) error {
+	if exp, ok := claims["exp"].(float64); ok && !now.Add(-skew).Before(time.Unix(int64(exp), 0)) {
+		return newValidationError(ErrTokenExpired, ReasonExpired, "token expired").
+			withClaim("exp", strconv.FormatInt(int64(exp), 10))
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(skew).Before(time.Unix(int64(nbf), 0)) {
+		return newValidationError(ErrTokenInvalid, ReasonNotYetValid, "token not yet valid").
+			withClaim("nbf", strconv.FormatInt(int64(nbf), 10))
+	}
+	return nil
+}
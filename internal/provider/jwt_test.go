@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaxTokenLifetime(t *testing.T) {
+	const max = time.Hour
+	key := mustSigningKey(t)
+	iat := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name    string
+		max     time.Duration
+		claims  map[string]interface{}
+		wantErr error
+	}{
+		{"within the maximum", max, map[string]interface{}{"iat": iat, "exp": iat.Add(max / 2)}, nil},
+		{"exactly the maximum", max, map[string]interface{}{"iat": iat, "exp": iat.Add(max)}, nil},
+		{"a second over the maximum", max, map[string]interface{}{"iat": iat, "exp": iat.Add(max + time.Second)}, ErrTokenLifetime},
+		{"far over the maximum", max, map[string]interface{}{"iat": iat, "exp": iat.Add(365 * 24 * time.Hour)}, ErrTokenLifetime},
+		{"without iat", max, map[string]interface{}{"exp": iat.Add(max / 2)}, ErrTokenLifetime},
+		{"without exp", max, map[string]interface{}{"iat": iat}, ErrNoExpiry},
+		{"no maximum", 0, map[string]interface{}{"iat": iat, "exp": iat.Add(365 * 24 * time.Hour)}, nil},
+		{"no maximum without iat", 0, map[string]interface{}{"exp": iat.Add(max)}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := make(map[string]interface{})
+			for name, at := range tt.claims {
+				claims[name] = float64(at.(time.Time).Unix())
+			}
+			p := localProvider(t, key, WithMaxTokenLifetime(tt.max))
+
+			_, err := p.ValidateToken(context.Background(), signClaims(t, key, claims))
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("ValidateToken() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaxTokenLifetimeReason(t *testing.T) {
+	key := mustSigningKey(t)
+	now := time.Now()
+	p := localProvider(t, key, WithMaxTokenLifetime(time.Hour))
+
+	_, err := p.ValidateToken(context.Background(), signClaims(t, key, map[string]interface{}{
+		"iat": float64(now.Unix()),
+		"exp": float64(now.Add(2 * time.Hour).Unix()),
+	}))
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("ValidateToken() error = %v, want a ValidationError", err)
+	}
+	if verr.Reason != ReasonLifetime || verr.Claim != "lifetime" || verr.Value != "2h0m0s" {
+		t.Errorf("Reason, Claim, Value = %q, %q, %q, want %q, lifetime, 2h0m0s", verr.Reason, verr.Claim, verr.Value, ReasonLifetime)
+	}
+}
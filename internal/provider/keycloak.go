@@ -2,6 +2,7 @@ package provider
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/zahidhasanpapon/iam-bridge/internal/config"
@@ -13,10 +14,27 @@ import (
 	"time"
 )
 
+// defaultKeycloakTimeout is used when no timeout is configured
+const defaultKeycloakTimeout = 10 * time.Second
+
+// defaultJWKSMaxBytes is the size limit of JWKS and discovery documents when none is configured
+const defaultJWKSMaxBytes = 1 << 20
+
 type KeycloakProvider struct {
 	config *config.KeycloakConfig
 	logger *logger.Logger
 	client *http.Client
+	// keys verifies tokens locally; nil when validating through token introspection
+	keys *keySet
+	// userInfo validates tokens through the userinfo endpoint instead of introspection
+	userInfo bool
+	// idTokenKeys verifies ID tokens, which are always verified locally
+	idTokenKeys *keySet
+	// issuers resolves keys of other realms matching the allowed issuer patterns, if any
+	issuers   *issuerKeySets
+	opts      *options
+	discovery *discoveryCache
+	admin     keycloakAdmin
 }
 
 // Login authenticates a user and returns an access token
@@ -68,21 +86,113 @@ func (k *KeycloakProvider) Login(ctx context.Context, username, password string)
 	return result.AccessToken, nil
 }
 
-// ValidateToken validates the provided token and returns token information
-func (k *KeycloakProvider) ValidateToken(ctx context.Context, token string) (*TokenInfo, error) {
-	introspectionURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/userinfo",
+// ValidateToken validates the provided token and returns token information.
+// Tokens are verified locally against the realm's JWKS when configured, otherwise
+// through the token introspection endpoint, or the userinfo endpoint when configured.
+// Introspection is the default as it answers with the claims of the access token
+// itself, including the roles and scopes authorization checks rely on.
+func (k *KeycloakProvider) ValidateToken(ctx context.Context, token string) (tokenInfo *TokenInfo, err error) {
+	ctx, span := k.opts.tracer.Start(ctx, SpanValidateToken)
+	span.SetAttribute("iam.realm", k.config.Realm)
+	defer func() { endValidationSpan(span, err) }()
+
+	switch {
+	case k.keys != nil:
+		span.SetAttribute("iam.validation", "jwks")
+		tokenInfo, err = k.verifyLocally(ctx, token)
+	case k.userInfo:
+		span.SetAttribute("iam.validation", "userinfo")
+		tokenInfo, err = k.fetchUserInfo(ctx, token)
+	default:
+		span.SetAttribute("iam.validation", "introspection")
+		tokenInfo, err = k.introspect(ctx, token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k.opts.accessTokenTypes) > 0 {
+		if err := checkTokenType(token, tokenInfo.Claims, k.opts.accessTokenTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := tokenInfo.Claims["exp"].(float64); !ok && !k.opts.allowNoExpiry {
+		return nil, newValidationError(ErrNoExpiry, ReasonNoExpiry, "token has no exp claim")
+	}
+
+	if k.opts.maxTokenLifetime > 0 {
+		if err := checkTokenLifetime(tokenInfo.Claims, k.opts.maxTokenLifetime); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkAudience(ctx, tokenInfo, k.config.Audiences); err != nil {
+		return nil, err
+	}
+
+	return tokenInfo, nil
+}
+
+// verifyLocally verifies the token signature and claims against the realm's signing keys
+func (k *KeycloakProvider) verifyLocally(ctx context.Context, token string) (*TokenInfo, error) {
+	keys, err := k.issuerKeys(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := verifyJWT(ctx, token, keys, k.opts.clock.Now(), k.opts.clockSkew)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTokenInfo(jwt.claims, k.opts), nil
+}
+
+// issuerKeys selects the key set for the token's issuer: the configured realm's keys, or
+// those of a realm matching the allowed issuer patterns. The issuer is read before the
+// signature is checked, which then proves it, since each realm signs with its own keys.
+func (k *KeycloakProvider) issuerKeys(ctx context.Context, token string) (*keySet, error) {
+	jwt, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	iss := claimString(jwt.claims, "iss")
+	if k.issuerMatches(iss) {
+		return k.keys, nil
+	}
+	if k.issuers == nil {
+		return nil, invalidIssuer(iss)
+	}
+
+	return k.issuers.keySet(ctx, iss)
+}
+
+// introspect validates the token through Keycloak's token introspection endpoint
+func (k *KeycloakProvider) introspect(ctx context.Context, token string) (tokenInfo *TokenInfo, err error) {
+	ctx, span := k.opts.tracer.Start(ctx, SpanIntrospect)
+	defer func() { endCallSpan(span, err) }()
+
+	introspectionURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token/introspect",
 		k.config.BaseURL, k.config.Realm)
 
-	req, err := http.NewRequestWithContext(ctx, "GET", introspectionURL, nil)
+	data := url.Values{}
+	data.Set("client_id", k.config.ClientID)
+	data.Set("client_secret", k.config.ClientSecret)
+	data.Set("token", token)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", introspectionURL,
+		strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := k.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, fmt.Errorf("%w: failed to execute request: %w", ErrProviderUnavailable, err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -90,31 +200,198 @@ func (k *KeycloakProvider) ValidateToken(ctx context.Context, token string) (*To
 			_ = fmt.Errorf("failed to close response body: %w", err)
 		}
 	}(resp.Body)
+	span.SetAttribute("http.status_code", resp.StatusCode)
 
 	if resp.StatusCode != http.StatusOK {
 		if resp.StatusCode == http.StatusUnauthorized {
 			return nil, ErrTokenInvalid
 		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: unexpected status code: %d", ErrProviderUnavailable, resp.StatusCode)
+		}
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	var userInfo struct {
-		Sub        string   `json:"sub"`
-		Username   string   `json:"preferred_username"`
-		Email      string   `json:"email"`
-		RealmRoles []string `json:"realm_access"`
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
+	// Keycloak reports expired, revoked and unknown tokens alike as inactive
+	if active, _ := claims["active"].(bool); !active {
+		if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(k.opts.clock.Now()) {
+			return nil, newValidationError(ErrTokenExpired, ReasonExpired, "token expired")
+		}
+		return nil, ErrTokenInvalid
+	}
+
+	return newTokenInfo(claims, k.opts), nil
+}
+
+// fetchUserInfo validates the token by calling Keycloak's userinfo endpoint with it, for
+// clients without the credentials introspection needs. The endpoint only returns profile
+// claims, without exp, scope or, unless a mapper adds them, roles, so those are read from
+// the token itself, which Keycloak has just accepted.
+func (k *KeycloakProvider) fetchUserInfo(ctx context.Context, token string) (tokenInfo *TokenInfo, err error) {
+	ctx, span := k.opts.tracer.Start(ctx, SpanUserInfo)
+	defer func() { endCallSpan(span, err) }()
+
+	userInfoURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/userinfo",
+		k.config.BaseURL, k.config.Realm)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", userInfoURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to execute request: %w", ErrProviderUnavailable, err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			_ = fmt.Errorf("failed to close response body: %w", err)
+		}
+	}(resp.Body)
+	span.SetAttribute("http.status_code", resp.StatusCode)
+
+	claims := make(map[string]interface{})
+	if jwt, err := parseJWT(token); err == nil {
+		for name, value := range jwt.claims {
+			claims[name] = value
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(k.opts.clock.Now()) {
+				return nil, newValidationError(ErrTokenExpired, ReasonExpired, "token expired")
+			}
+			return nil, ErrTokenInvalid
+		}
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: unexpected status code: %d", ErrProviderUnavailable, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var profile map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return &TokenInfo{
-		UserID:   userInfo.Sub,
-		Username: userInfo.Username,
-		Email:    userInfo.Email,
-		Roles:    userInfo.RealmRoles,
-	}, nil
+	// The profile must be that of the token's subject
+	if sub := claimString(claims, "sub"); sub != "" && sub != claimString(profile, "sub") {
+		return nil, ErrTokenInvalid
+	}
+	for name, value := range profile {
+		claims[name] = value
+	}
+
+	return newTokenInfo(claims, k.opts), nil
+}
+
+// fetchJWKS downloads the configured realm's JSON Web Key Set
+func (k *KeycloakProvider) fetchJWKS(ctx context.Context) ([]byte, error) {
+	certsURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/certs",
+		k.config.BaseURL, url.PathEscape(k.config.Realm))
+	return k.fetchCerts(ctx, k.config.Realm, certsURL)
+}
+
+// fetchIssuerJWKS downloads the JSON Web Key Set of a realm matched by the allowed issuer
+// patterns from the issuer itself, so its keys come from the host the pattern allows
+// rather than from the configured realm's
+func (k *KeycloakProvider) fetchIssuerJWKS(ctx context.Context, iss, realm string) ([]byte, error) {
+	u, err := url.Parse(normalizeIssuer(iss))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("issuer %q is not a URL to fetch keys from", iss)
+	}
+	return k.fetchCerts(ctx, realm, u.String()+"/protocol/openid-connect/certs")
+}
+
+// fetchCerts downloads the JSON Web Key Set of realm from certsURL
+func (k *KeycloakProvider) fetchCerts(ctx context.Context, realm, certsURL string) (jwks []byte, err error) {
+	ctx, span := k.opts.tracer.Start(ctx, SpanFetchJWKS)
+	span.SetAttribute("iam.realm", realm)
+	defer func() { endCallSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", certsURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to execute request: %w", ErrProviderUnavailable, err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			_ = fmt.Errorf("failed to close response body: %w", err)
+		}
+	}(resp.Body)
+	span.SetAttribute("http.status_code", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return nil, fmt.Errorf("%w: unexpected status code: %d", ErrProviderUnavailable, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return readLimited(resp.Body, k.opts.jwksMaxBytes)
+}
+
+// readLimited reads r up to limit bytes, failing once it holds more without reading the rest
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %w: more than %d bytes", ErrProviderUnavailable, ErrResponseTooLarge, limit)
+	}
+	return data, nil
+}
+
+// Discover returns the realm's OpenID Provider metadata, cached for the discovery cache TTL
+func (k *KeycloakProvider) Discover(ctx context.Context) (*DiscoveryDocument, error) {
+	return k.discovery.get(ctx, k.issuer())
+}
+
+// Warmup fetches the realm's discovery document and, when validating against the remote
+// JWKS, its signing keys. Both are otherwise fetched on first use.
+func (k *KeycloakProvider) Warmup(ctx context.Context) error {
+	if _, err := k.Discover(ctx); err != nil {
+		return err
+	}
+	if k.keys != nil && k.keys.fetch != nil {
+		if err := k.keys.refresh(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issuer returns the expected issuer of the realm's tokens
+func (k *KeycloakProvider) issuer() string {
+	return fmt.Sprintf("%s/realms/%s", k.config.BaseURL, k.config.Realm)
+}
+
+// issuerMatches reports whether iss is the issuer the realm's tokens carry, ignoring trailing
+// slashes. That is iam.keycloak.issuer_override when set, which is accepted with the realm's
+// keys, so a wrong override trusts tokens the realm's keys sign under another name.
+func (k *KeycloakProvider) issuerMatches(iss string) bool {
+	return iss != "" && normalizeIssuer(iss) == k.config.Issuer()
+}
+
+// normalizeIssuer strips trailing slashes, which some IdPs and proxies add to issuers
+func normalizeIssuer(iss string) string {
+	return strings.TrimRight(iss, "/")
 }
 
 // Logout invalidates the provided token
@@ -155,6 +432,17 @@ func (k *KeycloakProvider) Logout(ctx context.Context, token string) error {
 
 // RefreshToken refreshes the provided token
 func (k *KeycloakProvider) RefreshToken(ctx context.Context, refreshToken string) (string, error) {
+	tokens, err := k.RefreshTokens(ctx, refreshToken)
+	if err != nil {
+		return "", err
+	}
+
+	return tokens.AccessToken, nil
+}
+
+// RefreshTokens refreshes the provided token and returns the full token response,
+// including the rotated refresh token
+func (k *KeycloakProvider) RefreshTokens(ctx context.Context, refreshToken string) (*TokenResponse, error) {
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("client_id", k.config.ClientID)
@@ -167,14 +455,14 @@ func (k *KeycloakProvider) RefreshToken(ctx context.Context, refreshToken string
 	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL,
 		strings.NewReader(data.Encode()))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := k.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute request: %w", err)
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer func(Body io.ReadCloser) {
 		err := Body.Close()
@@ -184,21 +472,101 @@ func (k *KeycloakProvider) RefreshToken(ctx context.Context, refreshToken string
 	}(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusUnauthorized {
-			return "", ErrTokenExpired
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusBadRequest {
+			return nil, ErrTokenExpired
 		}
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	var result struct {
-		AccessToken string `json:"access_token"`
+	return decodeTokenResponse(resp.Body)
+}
+
+// AuthCodeURL builds the authorization endpoint URL for the code flow with a S256 PKCE challenge
+func (k *KeycloakProvider) AuthCodeURL(state, codeChallenge string, opts ...AuthCodeURLOption) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", k.config.ClientID)
+	params.Set("redirect_uri", k.config.RedirectURL)
+	params.Set("scope", "openid")
+	params.Set("state", state)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", PKCEMethodS256)
+	for _, opt := range opts {
+		opt(params)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+	return fmt.Sprintf("%s/realms/%s/protocol/openid-connect/auth?%s",
+		k.config.BaseURL, k.config.Realm, params.Encode())
+}
+
+// LogoutURL builds the end session endpoint URL that logs the user out of their Keycloak
+// session. Empty arguments are left out; Keycloak asks the user to confirm the logout
+// when no ID token hint is given.
+func (k *KeycloakProvider) LogoutURL(idTokenHint, postLogoutRedirectURI string, opts ...LogoutURLOption) string {
+	params := url.Values{}
+	if idTokenHint != "" {
+		params.Set("id_token_hint", idTokenHint)
+	}
+	if postLogoutRedirectURI != "" {
+		params.Set("post_logout_redirect_uri", postLogoutRedirectURI)
+	}
+	for _, opt := range opts {
+		opt(params)
 	}
 
-	return result.AccessToken, nil
+	logoutURL := k.issuer() + "/protocol/openid-connect/logout"
+	if len(params) == 0 {
+		return logoutURL
+	}
+	return logoutURL + "?" + params.Encode()
+}
+
+// ExchangeCode exchanges an authorization code and its PKCE verifier for tokens
+func (k *KeycloakProvider) ExchangeCode(ctx context.Context, code, codeVerifier string) (*TokenResponse, error) {
+	if err := ValidateCodeVerifier(codeVerifier); err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("client_id", k.config.ClientID)
+	data.Set("client_secret", k.config.ClientSecret)
+	data.Set("code", code)
+	data.Set("code_verifier", codeVerifier)
+	data.Set("redirect_uri", k.config.RedirectURL)
+
+	tokenURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token",
+		k.config.BaseURL, k.config.Realm)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL,
+		strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			_ = fmt.Errorf("failed to close response body: %w", err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		// Keycloak answers 400 invalid_grant for unknown, expired or replayed codes
+		// and for verifiers that don't match the challenge
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+			return nil, ErrInvalidGrant
+		}
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return decodeTokenResponse(resp.Body)
 }
 
 // GetUserInfo retrieves user information
@@ -258,6 +626,11 @@ func (k *KeycloakProvider) GetUserRoles(ctx context.Context, userID string) ([]s
 }
 
 func (k *KeycloakProvider) HealthCheck(ctx context.Context) error {
+	// Offline mode never talks to the IdP, so its availability doesn't matter
+	if k.config.LocalKeys() {
+		return nil
+	}
+
 	healthURL := fmt.Sprintf("%s/health", k.config.BaseURL)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
@@ -283,17 +656,111 @@ func (k *KeycloakProvider) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
+// configurePool applies the connection pool settings to transport. All calls go to the one
+// Keycloak host, so the idle connections per host may use the whole idle pool rather than
+// the Go default of 2, which would close most connections between bursts.
+func configurePool(transport *http.Transport, cfg config.HTTPConfig) {
+	if cfg.MaxIdleConns > 0 {
+		transport.MaxIdleConns = cfg.MaxIdleConns
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConns
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+		if transport.MaxIdleConnsPerHost > cfg.MaxConnsPerHost {
+			transport.MaxIdleConnsPerHost = cfg.MaxConnsPerHost
+		}
+	}
+	if cfg.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+}
+
 // NewKeycloakProvider creates a new KeycloakProvider instance
-func NewKeycloakProvider(cfg config.KeycloakConfig, log *logger.Logger) (IAMProvider, error) {
+func NewKeycloakProvider(cfg config.KeycloakConfig, log *logger.Logger, opts ...Option) (IAMProvider, error) {
 	if cfg.BaseURL == "" || cfg.Realm == "" || cfg.ClientID == "" || cfg.ClientSecret == "" {
 		return nil, fmt.Errorf("missing required Keycloak configuration")
 	}
 
-	return &KeycloakProvider{
-		config: &cfg,
-		logger: log,
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = defaultKeycloakTimeout
+	}
+
+	minTLSVersion, err := cfg.TLS.MinTLSVersion()
+	if err != nil {
+		return nil, fmt.Errorf("invalid Keycloak TLS configuration: %w", err)
+	}
+
+	options := newOptions(opts)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		MinVersion: minTLSVersion,
+	}
+	configurePool(transport, options.httpConfig)
+	// Slow calls are timed without the wait for a slot of the concurrency limit
+	roundTripper := withCallLimit(withSlowCallLogging(transport, options.slowCallThreshold, log), options.callLimiter)
+
+	k := &KeycloakProvider{
+		config:   &cfg,
+		logger:   log,
+		userInfo: strings.ToLower(cfg.TokenValidation) == "userinfo",
 		client: &http.Client{
-			Timeout: time.Second * 10,
+			Timeout:   timeout,
+			Transport: withTracing(roundTripper, options.tracer),
 		},
-	}, nil
+		opts: options,
+	}
+	k.discovery = newDiscoveryCache(k.client, options.discoveryCacheTTL, options.jwksMaxBytes, options.clock)
+	newBudget := func(keySet string) *refreshBudget {
+		return newRefreshBudget(options.jwksRefreshMax, options.jwksRefreshWindow, options.clock, func() {
+			if log != nil {
+				(*log).WarnContext(context.Background(), "JWKS refresh budget exhausted, rejecting unknown signing keys until the window resets",
+					"key_set", keySet, "max_refreshes", options.jwksRefreshMax, "window", options.jwksRefreshWindow.String())
+			}
+		})
+	}
+
+	retirement := newKeyRetirement(options.keyRetirementGrace, options.clock)
+
+	// A JWKS file or inline JWKS enables offline validation and disables fetching keys over
+	// the network
+	switch {
+	case cfg.JWKSFile != "":
+		if k.keys, err = newFileKeySet(cfg.JWKSFile); err != nil {
+			return nil, err
+		}
+	case cfg.JWKSInline != "":
+		if k.keys, err = newInlineKeySet(cfg.JWKSInline); err != nil {
+			return nil, err
+		}
+	case strings.ToLower(cfg.TokenValidation) == "jwks":
+		k.keys = newRemoteKeySet(k.fetchJWKS)
+		k.keys.budget = newBudget(cfg.Realm)
+		k.keys.retirement = retirement
+
+		if len(options.allowedIssuerPatterns) > 0 {
+			if k.issuers, err = newIssuerKeySets(options.allowedIssuerPatterns, k.fetchIssuerJWKS, options.clock); err != nil {
+				return nil, err
+			}
+			k.issuers.budget = newBudget("new issuers")
+			k.issuers.newBudget = newBudget
+			k.issuers.retirement = retirement
+		}
+	}
+
+	k.idTokenKeys = k.keys
+	if k.idTokenKeys == nil {
+		k.idTokenKeys = newRemoteKeySet(k.fetchJWKS)
+		k.idTokenKeys.budget = newBudget(cfg.Realm)
+		k.idTokenKeys.retirement = retirement
+	}
+
+	return k, nil
+}
+
+// Close closes the provider's idle connections to Keycloak
+func (k *KeycloakProvider) Close() error {
+	k.client.CloseIdleConnections()
+	return nil
 }
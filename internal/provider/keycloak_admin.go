@@ -0,0 +1,248 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// realmRolesCacheTTL keeps role listings briefly to spare the admin API
+	realmRolesCacheTTL = 30 * time.Second
+	// realmRolesPageSize is the page size requested from the admin roles endpoint
+	realmRolesPageSize = 100
+	// adminTokenExpirySkew renews the admin token slightly before it expires
+	adminTokenExpirySkew = 10 * time.Second
+	// userCacheTTL keeps looked up users briefly, as they are typically resolved for display
+	userCacheTTL = 30 * time.Second
+)
+
+// keycloakAdmin caches the client-credentials token and the results of admin REST API calls
+type keycloakAdmin struct {
+	tokenMu        sync.Mutex
+	token          string
+	tokenExpiresAt time.Time
+
+	rolesMu        sync.Mutex
+	roles          []Role
+	rolesExpiresAt time.Time
+
+	usersMu sync.Mutex
+	users   map[string]cachedUser
+}
+
+type cachedUser struct {
+	user      User
+	expiresAt time.Time
+}
+
+// GetUser looks the user up through the admin REST API, with a client credentials token.
+// The client needs the realm-management view-users role; without it, ErrAdminAccessDenied
+// is returned. Results are cached for a short time.
+func (k *KeycloakProvider) GetUser(ctx context.Context, userID string) (*User, error) {
+	now := k.opts.clock.Now()
+	k.admin.usersMu.Lock()
+	cached, ok := k.admin.users[userID]
+	k.admin.usersMu.Unlock()
+	if ok && now.Before(cached.expiresAt) {
+		user := cached.user
+		return &user, nil
+	}
+
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userURL := fmt.Sprintf("%s/admin/realms/%s/users/%s",
+		k.config.BaseURL, url.PathEscape(k.config.Realm), url.PathEscape(userID))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", userURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to execute request: %w", ErrProviderUnavailable, err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			_ = fmt.Errorf("failed to close response body: %w", err)
+		}
+	}(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrUserNotFound
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%w: client %q needs the view-users role: unexpected status code: %d",
+			ErrAdminAccessDenied, k.config.ClientID, resp.StatusCode)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, fmt.Errorf("%w: unexpected status code: %d", ErrProviderUnavailable, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var user User
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	k.admin.usersMu.Lock()
+	if k.admin.users == nil {
+		k.admin.users = make(map[string]cachedUser)
+	}
+	for id, entry := range k.admin.users {
+		if now.After(entry.expiresAt) {
+			delete(k.admin.users, id)
+		}
+	}
+	k.admin.users[userID] = cachedUser{user: user, expiresAt: now.Add(userCacheTTL)}
+	k.admin.usersMu.Unlock()
+
+	return &user, nil
+}
+
+// ListRealmRoles returns the realm's roles through the admin REST API.
+// Results are cached for a short time; concurrent callers share a single fetch.
+func (k *KeycloakProvider) ListRealmRoles(ctx context.Context) ([]Role, error) {
+	k.admin.rolesMu.Lock()
+	defer k.admin.rolesMu.Unlock()
+
+	if k.admin.roles != nil && k.opts.clock.Now().Before(k.admin.rolesExpiresAt) {
+		return append([]Role(nil), k.admin.roles...), nil
+	}
+
+	token, err := k.adminToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := []Role{}
+	for first := 0; ; first += realmRolesPageSize {
+		page, err := k.fetchRealmRoles(ctx, token, first)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, page...)
+		if len(page) < realmRolesPageSize {
+			break
+		}
+	}
+
+	k.admin.roles = roles
+	k.admin.rolesExpiresAt = k.opts.clock.Now().Add(realmRolesCacheTTL)
+
+	return append([]Role(nil), roles...), nil
+}
+
+// fetchRealmRoles fetches one page of realm roles starting at offset first
+func (k *KeycloakProvider) fetchRealmRoles(ctx context.Context, token string, first int) ([]Role, error) {
+	params := url.Values{}
+	params.Set("first", fmt.Sprint(first))
+	params.Set("max", fmt.Sprint(realmRolesPageSize))
+	params.Set("briefRepresentation", "true")
+
+	rolesURL := fmt.Sprintf("%s/admin/realms/%s/roles?%s",
+		k.config.BaseURL, k.config.Realm, params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rolesURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			_ = fmt.Errorf("failed to close response body: %w", err)
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var roles []Role
+	if err := json.NewDecoder(resp.Body).Decode(&roles); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return roles, nil
+}
+
+// adminToken returns a client-credentials access token for the admin REST API,
+// reusing the previous one until shortly before it expires
+func (k *KeycloakProvider) adminToken(ctx context.Context) (string, error) {
+	k.admin.tokenMu.Lock()
+	defer k.admin.tokenMu.Unlock()
+
+	if k.admin.token != "" && k.opts.clock.Now().Before(k.admin.tokenExpiresAt) {
+		return k.admin.token, nil
+	}
+
+	result, err := k.clientCredentialsToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	k.admin.token = result.AccessToken
+	k.admin.tokenExpiresAt = k.opts.clock.Now().Add(time.Duration(result.ExpiresIn)*time.Second - adminTokenExpirySkew)
+
+	return k.admin.token, nil
+}
+
+// clientCredentialsToken acquires a new access token with the client credentials grant.
+// Rejected credentials are reported as ErrInvalidCredentials.
+func (k *KeycloakProvider) clientCredentialsToken(ctx context.Context) (*TokenResponse, error) {
+	data := url.Values{}
+	data.Set("grant_type", "client_credentials")
+	data.Set("client_id", k.config.ClientID)
+	data.Set("client_secret", k.config.ClientSecret)
+
+	tokenURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token",
+		k.config.BaseURL, k.config.Realm)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL,
+		strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			_ = fmt.Errorf("failed to close response body: %w", err)
+		}
+	}(resp.Body)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: unexpected status code: %d", ErrInvalidCredentials, resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return decodeTokenResponse(resp.Body)
+}
@@ -0,0 +1,265 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+func TestValidateTokenModes(t *testing.T) {
+	key := mustSigningKey(t)
+	token := func(t *testing.T, sub string, exp time.Time) string {
+		t.Helper()
+		token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.ID}, map[string]interface{}{
+			"sub":          sub,
+			"iat":          float64(time.Now().Unix()),
+			"exp":          float64(exp.Unix()),
+			"scope":        "openid orders:read",
+			"realm_access": map[string]interface{}{"roles": []interface{}{"admin"}},
+		}, key.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := token(t, "user-1", time.Now().Add(time.Hour))
+	expired := token(t, "user-1", time.Now().Add(-time.Hour))
+	otherUser := token(t, "user-2", time.Now().Add(time.Hour))
+
+	// The stub accepts only the valid token, answering introspection with the access
+	// token's claims and userinfo with the profile alone, as Keycloak does
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/test/protocol/openid-connect/token/introspect":
+			if r.FormValue("token") != valid {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+				return
+			}
+			jwt, _ := parseJWT(valid)
+			claims := map[string]interface{}{"active": true, "email": "user@example.com"}
+			for name, value := range jwt.claims {
+				claims[name] = value
+			}
+			_ = json.NewEncoder(w).Encode(claims)
+		case "/realms/test/protocol/openid-connect/userinfo":
+			switch r.Header.Get("Authorization") {
+			case "Bearer " + valid:
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"sub": "user-1", "email": "user@example.com"})
+			case "Bearer " + otherUser:
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"sub": "user-1"})
+			default:
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+
+	tests := []struct {
+		name    string
+		mode    string
+		token   string
+		wantErr error
+	}{
+		{"introspection", "introspection", valid, nil},
+		{"introspection of an inactive token", "introspection", expired, ErrTokenInvalid},
+		{"userinfo", "userinfo", valid, nil},
+		{"userinfo of an expired token", "userinfo", expired, ErrTokenExpired},
+		{"userinfo of a rejected token", "userinfo", "opaque", ErrTokenInvalid},
+		{"userinfo of another subject", "userinfo", otherUser, ErrTokenInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewKeycloakProvider(config.KeycloakConfig{
+				BaseURL:         idp.URL,
+				Realm:           "test",
+				ClientID:        "bridge",
+				ClientSecret:    "secret",
+				TokenValidation: tt.mode,
+			}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := p.ValidateToken(context.Background(), tt.token)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ValidateToken() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+			if info.UserID != "user-1" || info.Email != "user@example.com" {
+				t.Errorf("UserID, Email = %q, %q, want user-1, user@example.com", info.UserID, info.Email)
+			}
+			if !reflect.DeepEqual(info.Roles, []string{"admin"}) {
+				t.Errorf("Roles = %v, want [admin]", info.Roles)
+			}
+			if !reflect.DeepEqual(info.Scopes, []string{"openid", "orders:read"}) {
+				t.Errorf("Scopes = %v, want [openid orders:read]", info.Scopes)
+			}
+		})
+	}
+}
+
+// localProvider returns a provider verifying tokens against key alone, given inline
+func localProvider(t testing.TB, key *SigningKey, opts ...Option) IAMProvider {
+	t.Helper()
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewKeycloakProvider(config.KeycloakConfig{
+		BaseURL:         "https://kc.example.com",
+		Realm:           "test",
+		ClientID:        "bridge",
+		ClientSecret:    "secret",
+		TokenValidation: "jwks",
+		JWKSInline:      string(jwks),
+	}, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// signClaims signs a token of the local provider's realm with key, carrying claims
+func signClaims(t testing.TB, key *SigningKey, claims map[string]interface{}) string {
+	t.Helper()
+	all := map[string]interface{}{"sub": "user-1", "iss": "https://kc.example.com/realms/test"}
+	for name, value := range claims {
+		all[name] = value
+	}
+	token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.ID}, all, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// endlessReader reads as many bytes as asked for, counting them
+type endlessReader struct {
+	read int64
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestReadLimited(t *testing.T) {
+	const limit = 1024
+
+	tests := []struct {
+		name    string
+		size    int
+		wantErr bool
+	}{
+		{"empty", 0, false},
+		{"under the limit", limit - 1, false},
+		{"at the limit", limit, false},
+		{"one byte over", limit + 1, true},
+		{"far over", 100 * limit, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := readLimited(strings.NewReader(strings.Repeat("a", tt.size)), limit)
+			if tt.wantErr {
+				if !errors.Is(err, ErrResponseTooLarge) || !errors.Is(err, ErrProviderUnavailable) {
+					t.Errorf("readLimited() error = %v, want ErrResponseTooLarge and ErrProviderUnavailable", err)
+				}
+				return
+			}
+			if err != nil || len(data) != tt.size {
+				t.Errorf("readLimited() = %d bytes, %v, want %d bytes", len(data), err, tt.size)
+			}
+		})
+	}
+
+	// An endless response is read no further than one byte past the limit
+	r := &endlessReader{}
+	if _, err := readLimited(r, limit); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("readLimited() of an endless response error = %v, want ErrResponseTooLarge", err)
+	}
+	if r.read > 2*limit {
+		t.Errorf("readLimited() read %d bytes of an endless response, want about %d", r.read, limit+1)
+	}
+}
+
+func TestAdminCachesExpireByClock(t *testing.T) {
+	var tokens, roleFetches atomic.Int64
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/realms/test/protocol/openid-connect/token":
+			tokens.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "admin", "expires_in": 60})
+		case "/admin/realms/test/roles":
+			roleFetches.Add(1)
+			_ = json.NewEncoder(w).Encode([]Role{{Name: "admin"}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(idp.Close)
+
+	clk := newFakeClock()
+	p, err := NewKeycloakProvider(config.KeycloakConfig{
+		BaseURL:      idp.URL,
+		Realm:        "test",
+		ClientID:     "bridge",
+		ClientSecret: "secret",
+	}, nil, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The admin token is renewed adminTokenExpirySkew before its 60 seconds, the roles
+	// after realmRolesCacheTTL
+	tests := []struct {
+		name            string
+		advance         time.Duration
+		wantTokens      int64
+		wantRoleFetches int64
+	}{
+		{"first listing", 0, 1, 1},
+		{"roles cached", realmRolesCacheTTL - time.Second, 1, 1},
+		{"roles expired, token reused", time.Second, 1, 2},
+		{"both expired", realmRolesCacheTTL, 2, 3},
+	}
+
+	for _, tt := range tests {
+		clk.Advance(tt.advance)
+		roles, err := p.(RoleLister).ListRealmRoles(context.Background())
+		if err != nil || len(roles) != 1 {
+			t.Fatalf("%s: ListRealmRoles() = %v, %v", tt.name, roles, err)
+		}
+		if got := tokens.Load(); got != tt.wantTokens {
+			t.Errorf("%s: fetched %d admin tokens, want %d", tt.name, got, tt.wantTokens)
+		}
+		if got := roleFetches.Load(); got != tt.wantRoleFetches {
+			t.Errorf("%s: fetched the roles %d times, want %d", tt.name, got, tt.wantRoleFetches)
+		}
+	}
+}
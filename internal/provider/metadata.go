@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"context"
+	"time"
+)
+
+// TokenMetadata holds the registered claims describing a token itself rather than its subject,
+// for auditing and debugging
+type TokenMetadata struct {
+	Issuer    string    `json:"issuer,omitempty"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	JTI       string    `json:"jti,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+}
+
+// newTokenMetadata reads the token metadata from a claim set. Missing times are left zero.
+func newTokenMetadata(claims map[string]interface{}, clientID string) TokenMetadata {
+	return TokenMetadata{
+		Issuer:    claimString(claims, "iss"),
+		IssuedAt:  claimTime(claims, "iat"),
+		ExpiresAt: claimTime(claims, "exp"),
+		JTI:       claimString(claims, "jti"),
+		ClientID:  clientID,
+	}
+}
+
+// claimTime returns the NumericDate claim with the given name, or the zero time if absent
+func claimTime(claims map[string]interface{}, name string) time.Time {
+	if v, ok := claims[name].(float64); ok {
+		return time.Unix(int64(v), 0)
+	}
+	return time.Time{}
+}
+
+type tokenMetadataContextKey struct{}
+
+// WithTokenMetadata returns a copy of ctx carrying the metadata of the request's token
+func WithTokenMetadata(ctx context.Context, metadata TokenMetadata) context.Context {
+	return context.WithValue(ctx, tokenMetadataContextKey{}, metadata)
+}
+
+// TokenMetadataFromContext returns the metadata set by WithTokenMetadata, if any. The auth
+// middleware sets it on the request context of authenticated requests.
+func TokenMetadataFromContext(ctx context.Context) (TokenMetadata, bool) {
+	metadata, ok := ctx.Value(tokenMetadataContextKey{}).(TokenMetadata)
+	return metadata, ok
+}
@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrCertificateBindingInvalid is returned when a certificate-bound token is presented
+// without the client certificate it is bound to
+var ErrCertificateBindingInvalid = errors.New("token is not bound to the client certificate")
+
+// VerifyCertificateBinding checks that the token is bound to the client certificate
+// through its cnf.x5t#S256 confirmation (RFC 8705)
+func VerifyCertificateBinding(cert *x509.Certificate, tokenInfo *TokenInfo) error {
+	if cert == nil {
+		return fmt.Errorf("%w: no client certificate presented", ErrCertificateBindingInvalid)
+	}
+
+	x5t := tokenInfo.ConfirmationX5T()
+	if x5t == "" {
+		return fmt.Errorf("%w: token has no certificate confirmation", ErrCertificateBindingInvalid)
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(x5t), []byte(thumbprint)) != 1 {
+		return fmt.Errorf("%w: certificate thumbprint mismatch", ErrCertificateBindingInvalid)
+	}
+
+	return nil
+}
+
+// ConfirmationX5T returns the certificate thumbprint the token is bound to (cnf.x5t#S256), if any
+func (t *TokenInfo) ConfirmationX5T() string {
+	cnf, ok := t.Claims["cnf"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	return claimString(cnf, "x5t#S256")
+}
@@ -0,0 +1,273 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+// defaultWarmupConcurrency is the number of realms warmed up at once by default
+const defaultWarmupConcurrency = 8
+
+// ErrUnknownTenant is returned when a request can't be routed to a configured realm
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+type tenantContextKey struct{}
+
+// WithTenant returns a copy of ctx routing MultiTenantProvider calls to the given realm
+func WithTenant(ctx context.Context, realm string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, realm)
+}
+
+// TenantFromContext returns the realm set by WithTenant, if any
+func TenantFromContext(ctx context.Context) (string, bool) {
+	realm, ok := ctx.Value(tenantContextKey{}).(string)
+	return realm, ok && realm != ""
+}
+
+// MultiTenantProvider routes calls to one provider per realm. Tokens are routed by their
+// issuer, everything else by the realm set with WithTenant.
+type MultiTenantProvider struct {
+	tenants map[string]IAMProvider
+	issuers map[string]string
+	logger  *logger.Logger
+	// warmupConcurrency bounds the realms warmed up at once
+	warmupConcurrency int
+}
+
+// NewMultiTenantProvider creates a Keycloak provider for every realm config, keyed by realm name
+func NewMultiTenantProvider(realms []config.KeycloakConfig, log *logger.Logger,
+	opts ...Option) (*MultiTenantProvider, error) {
+	m := &MultiTenantProvider{
+		tenants:           make(map[string]IAMProvider, len(realms)),
+		issuers:           make(map[string]string, len(realms)),
+		logger:            log,
+		warmupConcurrency: newOptions(opts).warmupConcurrency,
+	}
+
+	for i, cfg := range realms {
+		p, err := NewKeycloakProvider(cfg, log, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("realm %d (%s): %w", i, cfg.Realm, err)
+		}
+		m.tenants[cfg.Realm] = p
+		m.issuers[cfg.Issuer()] = cfg.Realm
+	}
+
+	return m, nil
+}
+
+// Tenant returns the provider of the given realm
+func (m *MultiTenantProvider) Tenant(realm string) (IAMProvider, bool) {
+	p, ok := m.tenants[realm]
+	return p, ok
+}
+
+// tenant returns the provider of the realm set in ctx
+func (m *MultiTenantProvider) tenant(ctx context.Context) (IAMProvider, error) {
+	realm, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, fmt.Errorf("%w: no tenant in request context", ErrUnknownTenant)
+	}
+	p, ok := m.tenants[realm]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTenant, realm)
+	}
+	return p, nil
+}
+
+// ValidateToken validates the token with the provider of the realm in ctx, or of the
+// realm that issued it. The issuer is only used for routing; the realm's provider
+// verifies the token.
+func (m *MultiTenantProvider) ValidateToken(ctx context.Context, token string) (*TokenInfo, error) {
+	if _, ok := TenantFromContext(ctx); ok {
+		p, err := m.tenant(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return p.ValidateToken(ctx, token)
+	}
+
+	jwt, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+	iss := claimString(jwt.claims, "iss")
+	realm, ok := m.issuers[normalizeIssuer(iss)]
+	if !ok {
+		return nil, invalidIssuer(iss)
+	}
+
+	return m.tenants[realm].ValidateToken(ctx, token)
+}
+
+func (m *MultiTenantProvider) Login(ctx context.Context, username, password string) (string, error) {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.Login(ctx, username, password)
+}
+
+func (m *MultiTenantProvider) Logout(ctx context.Context, token string) error {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	return p.Logout(ctx, token)
+}
+
+func (m *MultiTenantProvider) RefreshToken(ctx context.Context, token string) (string, error) {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.RefreshToken(ctx, token)
+}
+
+func (m *MultiTenantProvider) GetUserInfo(ctx context.Context, userID string) (*UserInfo, error) {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetUserInfo(ctx, userID)
+}
+
+func (m *MultiTenantProvider) UpdateUserInfo(ctx context.Context, userID string, userInfo *UserInfo) error {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	return p.UpdateUserInfo(ctx, userID, userInfo)
+}
+
+func (m *MultiTenantProvider) AssignRole(ctx context.Context, userID, role string) error {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	return p.AssignRole(ctx, userID, role)
+}
+
+func (m *MultiTenantProvider) RemoveRole(ctx context.Context, userID, role string) error {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	return p.RemoveRole(ctx, userID, role)
+}
+
+func (m *MultiTenantProvider) GetUserRoles(ctx context.Context, userID string) ([]string, error) {
+	p, err := m.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.GetUserRoles(ctx, userID)
+}
+
+// HealthCheck checks every realm's provider and reports all failures
+func (m *MultiTenantProvider) HealthCheck(ctx context.Context) error {
+	var failures []string
+	for realm, p := range m.tenants {
+		if err := p.HealthCheck(ctx); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", realm, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("health check failed for realms: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Warmup warms up the realms' providers in parallel, at most warmupConcurrency at once, and
+// reports all failures, in realm order. A failing realm doesn't stop the others; once ctx is
+// done, the realms not yet started fail with its error.
+func (m *MultiTenantProvider) Warmup(ctx context.Context) error {
+	realms := make([]string, 0, len(m.tenants))
+	for realm, p := range m.tenants {
+		if _, ok := As[Warmer](p); ok {
+			realms = append(realms, realm)
+		}
+	}
+	sort.Strings(realms)
+
+	failures := make([]string, len(realms))
+	slots := make(chan struct{}, m.warmupConcurrency)
+	var wg sync.WaitGroup
+	for i, realm := range realms {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			failures[i] = fmt.Sprintf("%s: %v", realm, ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			if err := m.warmupRealm(ctx, realm); err != nil {
+				failures[i] = fmt.Sprintf("%s: %v", realm, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	failures = slices.DeleteFunc(failures, func(f string) bool { return f == "" })
+	if len(failures) > 0 {
+		return fmt.Errorf("warmup failed for %d of %d realms: %s", len(failures), len(realms),
+			strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// warmupRealm warms up the provider of realm, logging how long it took
+func (m *MultiTenantProvider) warmupRealm(ctx context.Context, realm string) error {
+	w, _ := As[Warmer](m.tenants[realm])
+	start := time.Now()
+	err := w.Warmup(ctx)
+
+	if m.logger != nil {
+		fields := []interface{}{"realm", realm, "duration", time.Since(start).String()}
+		if err != nil {
+			(*m.logger).WarnContext(ctx, "Realm warmup failed", append(fields, "error", err)...)
+		} else {
+			(*m.logger).InfoContext(ctx, "Realm warmed up", fields...)
+		}
+	}
+	return err
+}
+
+// SelfTest tests every realm's provider and reports all failures
+func (m *MultiTenantProvider) SelfTest(ctx context.Context) error {
+	var failures []string
+	for realm, p := range m.tenants {
+		if t, ok := As[SelfTester](p); ok {
+			if err := t.SelfTest(ctx); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", realm, err))
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("self test failed for realms: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Close closes every realm's provider
+func (m *MultiTenantProvider) Close() error {
+	providers := make([]IAMProvider, 0, len(m.tenants))
+	for _, p := range m.tenants {
+		providers = append(providers, p)
+	}
+	return closeAll(providers...)
+}
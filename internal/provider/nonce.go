@@ -0,0 +1,56 @@
+package provider
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// minNonceLength is the shortest nonce accepted from a NonceGenerator: 22 base64url
+// characters carry 128 bits
+const minNonceLength = 22
+
+// ErrWeakNonce is returned when a NonceGenerator produces a nonce too short to be unguessable
+var ErrWeakNonce = errors.New("nonce too short")
+
+// NonceGenerator generates the nonce a login binds its ID token to. The nonce is sent
+// with the authorization request and must come back in the ID token's nonce claim.
+type NonceGenerator interface {
+	GenerateNonce() (string, error)
+}
+
+// NonceGeneratorFunc adapts a function to a NonceGenerator
+type NonceGeneratorFunc func() (string, error)
+
+// GenerateNonce calls f
+func (f NonceGeneratorFunc) GenerateNonce() (string, error) {
+	return f()
+}
+
+// RandomNonce returns 32 bytes from crypto/rand, base64url-encoded. It is the default
+// NonceGenerator.
+func RandomNonce() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// GenerateNonce generates a nonce with g, or RandomNonce when g is nil, and rejects nonces
+// shorter than 22 characters
+func GenerateNonce(g NonceGenerator) (string, error) {
+	if g == nil {
+		g = NonceGeneratorFunc(RandomNonce)
+	}
+
+	nonce, err := g.GenerateNonce()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if len(nonce) < minNonceLength {
+		return "", fmt.Errorf("%w: %d characters, at least %d required", ErrWeakNonce, len(nonce), minNonceLength)
+	}
+	return nonce, nil
+}
@@ -0,0 +1,321 @@
+// Package rediscache implements a provider.TokenCache and a provider.JTIDenylist backed by
+// Redis, so token validations and revocations are shared between bridge instances. It speaks
+// the Redis protocol (RESP) directly, over TLS if configured, and only uses GET, SET with PX
+// and DEL.
+package rediscache
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// maxIdleConns bounds the connections kept open between commands
+const maxIdleConns = 16
+
+// errNil is the Redis nil reply, returned by GET for missing keys
+var errNil = errors.New("redis: nil")
+
+// macSize is the length of the hex-encoded MAC prefixed to stored entries
+const macSize = 2 * sha256.Size
+
+// Cache is a provider.TokenCache storing entries as JSON under the configured key prefix.
+// Entries expire in Redis with their TTL. Each entry is stored with an HMAC of its key and
+// value, so entries written to Redis by anyone not holding the MAC key, or moved to another
+// key, are ignored rather than authenticating a token.
+type Cache struct {
+	config *config.RedisConfig
+	tls    *tls.Config
+	macKey []byte
+	idle   chan *conn
+}
+
+// conn is a connection to Redis with its buffered reader
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// New creates a new Cache instance authenticating its entries with macKey, which instances
+// sharing the cache must agree on. Connections are opened on first use.
+func New(cfg *config.RedisConfig, macKey []byte) (*Cache, error) {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{
+		config: cfg,
+		tls:    tlsConfig,
+		macKey: macKey,
+		idle:   make(chan *conn, maxIdleConns),
+	}, nil
+}
+
+// newTLSConfig returns the TLS settings of connections to Redis, or nil without TLS
+func newTLSConfig(cfg *config.RedisConfig) (*tls.Config, error) {
+	if !cfg.TLS.Enabled {
+		return nil, nil
+	}
+
+	minVersion, err := cfg.TLS.MinTLSVersion()
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis TLS configuration: %w", err)
+	}
+	tlsConfig := &tls.Config{MinVersion: minVersion, ServerName: cfg.TLS.ServerName}
+	if tlsConfig.ServerName == "" {
+		if tlsConfig.ServerName, _, err = net.SplitHostPort(cfg.Address); err != nil {
+			return nil, fmt.Errorf("invalid redis address %q: %w", cfg.Address, err)
+		}
+	}
+
+	if cfg.TLS.CAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", cfg.TLS.CAFile)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// Get returns the entry for key, or provider.ErrCacheMiss when Redis has none
+func (c *Cache) Get(ctx context.Context, key provider.CacheKey) (*provider.CacheEntry, error) {
+	redisKey := c.key(key)
+	reply, err := c.do(ctx, "GET", redisKey)
+	if errors.Is(err, errNil) {
+		return nil, provider.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries failing the MAC check weren't written by an instance sharing the cache
+	if len(reply) < macSize || !hmac.Equal([]byte(reply[:macSize]), []byte(c.mac(redisKey, reply[macSize:]))) {
+		return nil, provider.ErrCacheMiss
+	}
+	var entry provider.CacheEntry
+	if err := json.Unmarshal([]byte(reply[macSize:]), &entry); err != nil || entry.TokenInfo == nil {
+		return nil, provider.ErrCacheMiss
+	}
+	return &entry, nil
+}
+
+// Set stores the entry, letting Redis expire it after ttl
+func (c *Cache) Set(ctx context.Context, key provider.CacheKey, entry *provider.CacheEntry, ttl time.Duration) error {
+	ms := ttl.Milliseconds()
+	if ms <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	redisKey := c.key(key)
+	value := c.mac(redisKey, string(data)) + string(data)
+	_, err = c.do(ctx, "SET", redisKey, value, "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+// mac returns the hex-encoded HMAC-SHA256 of an entry's value stored under redisKey
+func (c *Cache) mac(redisKey, value string) string {
+	h := hmac.New(sha256.New, c.macKey)
+	h.Write([]byte(redisKey))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Delete removes the entry for key, if any
+func (c *Cache) Delete(ctx context.Context, key provider.CacheKey) error {
+	_, err := c.do(ctx, "DEL", c.key(key))
+	return err
+}
+
+// Close closes the idle connections
+func (c *Cache) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// key returns the Redis key of a cache key
+func (c *Cache) key(key provider.CacheKey) string {
+	return c.config.KeyPrefix + key.String()
+}
+
+// do runs a command on an idle or new connection and returns its reply. Connections are
+// only reused after a complete reply, so a failed command never leaves a stray reply behind.
+func (c *Cache) do(ctx context.Context, args ...string) (string, error) {
+	cn, err := c.conn(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	reply, err := cn.command(ctx, c.timeout(), args...)
+	if err != nil && !errors.Is(err, errNil) && !isReplyError(err) {
+		_ = cn.Close()
+		return "", err
+	}
+
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+	return reply, err
+}
+
+// conn returns an idle connection, or dials and sets up a new one
+func (c *Cache) conn(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: c.timeout()}
+	nc, err := dialer.DialContext(ctx, "tcp", c.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	if c.tls != nil {
+		tc := tls.Client(nc, c.tls)
+		hsCtx, cancel := context.WithTimeout(ctx, c.timeout())
+		err := tc.HandshakeContext(hsCtx)
+		cancel()
+		if err != nil {
+			_ = nc.Close()
+			return nil, fmt.Errorf("failed to connect to redis: %w", err)
+		}
+		nc = tc
+	}
+	cn := &conn{Conn: nc, reader: bufio.NewReader(nc)}
+
+	if c.config.Password != "" {
+		auth := []string{"AUTH", c.config.Password}
+		if c.config.Username != "" {
+			auth = []string{"AUTH", c.config.Username, c.config.Password}
+		}
+		if _, err := cn.command(ctx, c.timeout(), auth...); err != nil {
+			_ = cn.Close()
+			return nil, fmt.Errorf("failed to authenticate to redis: %w", err)
+		}
+	}
+	if c.config.DB != 0 {
+		if _, err := cn.command(ctx, c.timeout(), "SELECT", strconv.Itoa(c.config.DB)); err != nil {
+			_ = cn.Close()
+			return nil, fmt.Errorf("failed to select redis db: %w", err)
+		}
+	}
+
+	return cn, nil
+}
+
+// timeout returns the per-command timeout
+func (c *Cache) timeout() time.Duration {
+	if c.config.Timeout > 0 {
+		return c.config.Timeout
+	}
+	return time.Second
+}
+
+// command writes a command as an array of bulk strings and reads its reply
+func (cn *conn) command(ctx context.Context, timeout time.Duration, args ...string) (string, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := cn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := cn.Write(buf); err != nil {
+		return "", fmt.Errorf("failed to write redis command: %w", err)
+	}
+
+	return cn.readReply()
+}
+
+// replyError is an error reply sent by Redis
+type replyError string
+
+// Error implements the error interface
+func (e replyError) Error() string {
+	return "redis: " + string(e)
+}
+
+// isReplyError reports whether err is an error reply, after which the connection is still usable
+func isReplyError(err error) bool {
+	var re replyError
+	return errors.As(err, &re)
+}
+
+// readReply reads a simple string, error, integer or bulk string reply
+func (cn *conn) readReply() (string, error) {
+	line, err := cn.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read redis reply: %w", err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("malformed redis reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", replyError(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("malformed redis reply %q", line)
+		}
+		if n < 0 {
+			return "", errNil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.reader, data); err != nil {
+			return "", fmt.Errorf("failed to read redis reply: %w", err)
+		}
+		return string(data[:n]), nil
+	default:
+		return "", fmt.Errorf("unexpected redis reply %q", line)
+	}
+}
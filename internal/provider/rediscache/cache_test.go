@@ -0,0 +1,310 @@
+package rediscache
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// fakeRedis is a Redis server answering AUTH, GET, SET and DEL from memory
+type fakeRedis struct {
+	listener net.Listener
+	// username and password are the credentials AUTH must carry, if password is set
+	username string
+	password string
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// newFakeRedis serves on a local port, over TLS if tlsConfig is set
+func newFakeRedis(t *testing.T, tlsConfig *tls.Config) *fakeRedis {
+	t.Helper()
+	return newAuthFakeRedis(t, tlsConfig, "", "")
+}
+
+// newAuthFakeRedis serves like newFakeRedis, requiring AUTH with the given credentials when
+// password is set
+func newAuthFakeRedis(t *testing.T, tlsConfig *tls.Config, username, password string) *fakeRedis {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	r := &fakeRedis{listener: l, username: username, password: password, values: make(map[string]string)}
+	go r.serve()
+	t.Cleanup(func() { _ = l.Close() })
+	return r
+}
+
+func (r *fakeRedis) serve() {
+	for {
+		nc, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(nc)
+	}
+}
+
+func (r *fakeRedis) handle(nc net.Conn) {
+	defer nc.Close()
+	reader := bufio.NewReader(nc)
+	authed := r.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			user, pass := "default", args[len(args)-1]
+			if len(args) == 3 {
+				user = args[1]
+			}
+			authed = pass == r.password && (r.username == "" && user == "default" || user == r.username)
+			reply = "+OK\r\n"
+			if !authed {
+				reply = "-WRONGPASS invalid username-password pair\r\n"
+			}
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "GET":
+			r.mu.Lock()
+			value, ok := r.values[args[1]]
+			r.mu.Unlock()
+			reply = "$-1\r\n"
+			if ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+			}
+		case args[0] == "SET":
+			r.set(args[1], args[2])
+			reply = "+OK\r\n"
+		case args[0] == "DEL":
+			r.mu.Lock()
+			delete(r.values, args[1])
+			r.mu.Unlock()
+			reply = ":1\r\n"
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := io.WriteString(nc, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (r *fakeRedis) set(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[key] = value
+}
+
+func (r *fakeRedis) get(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[key]
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil || n < 1 {
+		return nil, errors.New("malformed command")
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func newEntry(sub string) *provider.CacheEntry {
+	return &provider.CacheEntry{
+		TokenInfo:  &provider.TokenInfo{UserID: sub, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		FreshUntil: time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func mustNew(t *testing.T, cfg *config.RedisConfig, macKey string) *Cache {
+	t.Helper()
+	cache, err := New(cfg, []byte(macKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+	return cache
+}
+
+func TestCacheRejectsForgedEntries(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeRedis(t, nil)
+	cfg := &config.RedisConfig{Address: server.listener.Addr().String(), KeyPrefix: "test:"}
+	cache := mustNew(t, cfg, "salt")
+	alice, bob := provider.CacheKey{1}, provider.CacheKey{2}
+
+	if err := cache.Set(ctx, alice, newEntry("alice"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set(ctx, bob, newEntry("bob"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	stored := server.get(cache.key(alice))
+
+	tests := []struct {
+		name   string
+		value  string
+		cache  *Cache
+		wantOK bool
+	}{
+		{"written by the cache", stored, cache, true},
+		{"written by another instance with the key", stored, mustNew(t, cfg, "salt"), true},
+		{"read with another key", stored, mustNew(t, cfg, "other"), false},
+		{"written without the MAC", stored[macSize:], cache, false},
+		{"value changed", stored[:len(stored)-2] + `0}`, cache, false},
+		{"moved from another key", server.get(cache.key(bob)), cache, false},
+		{"too short", "{}", cache, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.set(cache.key(alice), tt.value)
+			entry, err := tt.cache.Get(ctx, alice)
+			if tt.wantOK && (err != nil || entry.TokenInfo.UserID != "alice") {
+				t.Errorf("Get() = %v, %v, want the entry of alice", entry, err)
+			}
+			if !tt.wantOK && !errors.Is(err, provider.ErrCacheMiss) {
+				t.Errorf("Get() error = %v, want ErrCacheMiss", err)
+			}
+		})
+	}
+}
+
+func TestCacheAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  bool
+	}{
+		{"acl user", "bridge", "secret", false},
+		{"wrong password", "bridge", "wrong", true},
+		{"default user", "", "secret", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newAuthFakeRedis(t, nil, "bridge", "secret")
+			cache := mustNew(t, &config.RedisConfig{
+				Address:  server.listener.Addr().String(),
+				Username: tt.username,
+				Password: tt.password,
+			}, "salt")
+
+			_, err := cache.Get(context.Background(), provider.CacheKey{})
+			if tt.wantErr && err == nil {
+				t.Error("Get() succeeded with wrong credentials")
+			}
+			if !tt.wantErr && !errors.Is(err, provider.ErrCacheMiss) {
+				t.Errorf("Get() error = %v, want ErrCacheMiss", err)
+			}
+		})
+	}
+}
+
+func TestCacheTLS(t *testing.T) {
+	cert, caFile := mustServerCert(t)
+	server := newFakeRedis(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+	ctx := context.Background()
+
+	cache := mustNew(t, &config.RedisConfig{
+		Address: server.listener.Addr().String(),
+		TLS:     config.RedisTLSConfig{Enabled: true, CAFile: caFile, ServerName: "redis.test"},
+	}, "salt")
+	if err := cache.Set(ctx, provider.CacheKey{}, newEntry("alice"), time.Minute); err != nil {
+		t.Fatalf("Set() over TLS error = %v", err)
+	}
+	if _, err := cache.Get(ctx, provider.CacheKey{}); err != nil {
+		t.Errorf("Get() over TLS error = %v", err)
+	}
+
+	// The server's certificate isn't trusted without the CA
+	untrusted := mustNew(t, &config.RedisConfig{
+		Address: server.listener.Addr().String(),
+		TLS:     config.RedisTLSConfig{Enabled: true, ServerName: "redis.test"},
+	}, "salt")
+	if _, err := untrusted.Get(ctx, provider.CacheKey{}); err == nil || errors.Is(err, provider.ErrCacheMiss) {
+		t.Errorf("Get() with an untrusted certificate error = %v, want a connection error", err)
+	}
+}
+
+// mustServerCert creates a self-signed certificate for redis.test, returning it and the path
+// of a CA file holding it
+func mustServerCert(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis.test"},
+		DNSNames:              []string{"redis.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
@@ -0,0 +1,60 @@
+package rediscache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// JTIDenylist is a provider.JTIDenylist storing revoked token IDs under the configured key
+// prefix, so revocations apply to every bridge instance. Entries expire in Redis with the
+// token they revoke.
+type JTIDenylist struct {
+	cache *Cache
+}
+
+// NewJTIDenylist creates a new JTIDenylist instance. Connections are opened on first use.
+func NewJTIDenylist(cfg *config.RedisConfig) (*JTIDenylist, error) {
+	// Revocations carry no data to authenticate, only their key
+	cache, err := New(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &JTIDenylist{cache: cache}, nil
+}
+
+// IsRevoked reports whether Redis holds a revocation for jti
+func (d *JTIDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	_, err := d.cache.do(ctx, "GET", d.key(jti))
+	if errors.Is(err, errNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Revoke stores a revocation for jti, letting Redis expire it at exp
+func (d *JTIDenylist) Revoke(ctx context.Context, jti string, exp time.Time) error {
+	ms := time.Until(exp).Milliseconds()
+	if ms <= 0 {
+		return nil
+	}
+
+	_, err := d.cache.do(ctx, "SET", d.key(jti), "1", "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+// Close closes the idle connections
+func (d *JTIDenylist) Close() error {
+	return d.cache.Close()
+}
+
+// key returns the Redis key of a jti
+func (d *JTIDenylist) key(jti string) string {
+	return d.cache.config.KeyPrefix + jti
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+)
+
+// Self test steps, as reported by SelfTestError
+const (
+	SelfTestDiscovery         = "discovery"
+	SelfTestJWKS              = "jwks"
+	SelfTestClientCredentials = "client_credentials"
+)
+
+// SelfTestError reports the step of a self test that failed. It wraps the step's error,
+// so errors.Is keeps working.
+type SelfTestError struct {
+	Step string
+	Err  error
+}
+
+// Error implements the error interface
+func (e *SelfTestError) Error() string {
+	return fmt.Sprintf("self test failed at %s: %v", e.Step, e.Err)
+}
+
+// Unwrap returns the error of the failed step
+func (e *SelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// SelfTest checks the provider's configuration against the live realm: it fetches the
+// discovery document and checks its issuer, fetches the signing keys and, for confidential
+// clients, acquires a client credentials token to confirm the client ID and secret work.
+// It stops at the first step that fails, returning a *SelfTestError.
+func (k *KeycloakProvider) SelfTest(ctx context.Context) error {
+	doc, err := k.Discover(ctx)
+	if err != nil {
+		return &SelfTestError{Step: SelfTestDiscovery, Err: err}
+	}
+	if !k.issuerMatches(doc.Issuer) {
+		return &SelfTestError{Step: SelfTestDiscovery, Err: fmt.Errorf(
+			"issuer %q doesn't match the configured realm's %q, check base_url, realm and issuer_override",
+			doc.Issuer, k.config.Issuer())}
+	}
+
+	// With a local JWKS file there are no keys to fetch
+	if k.idTokenKeys.fetch != nil {
+		if err := k.idTokenKeys.refresh(ctx); err != nil {
+			return &SelfTestError{Step: SelfTestJWKS, Err: err}
+		}
+	}
+
+	if k.config.ClientSecret != "" {
+		if _, err := k.clientCredentialsToken(ctx); err != nil {
+			return &SelfTestError{Step: SelfTestClientCredentials, Err: fmt.Errorf(
+				"client %q: %w", k.config.ClientID, err)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,442 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+)
+
+// fileKeyCheckInterval is how often a FileSigningKeyProvider checks its key file for changes
+const fileKeyCheckInterval = 10 * time.Second
+
+// SigningKey is a private key internal tokens are signed with, identified by its kid
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Key       crypto.Signer
+}
+
+// SigningKeyProvider supplies the keys internal tokens are signed with. SigningKey returns
+// the key to sign new tokens with; VerificationKeys returns every key verifiers must accept,
+// the current one and those rotated out recently enough that tokens they signed may still
+// be live.
+type SigningKeyProvider interface {
+	SigningKey(ctx context.Context) (*SigningKey, error)
+	VerificationKeys(ctx context.Context) ([]*SigningKey, error)
+}
+
+// NewSigningKey wraps an RSA or EC private key as a SigningKey. Without a kid, the key's
+// JWK thumbprint (RFC 7638) is used, so every key gets a distinct one.
+func NewSigningKey(key crypto.Signer, kid string) (*SigningKey, error) {
+	alg, err := signingAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+	if kid == "" {
+		jwk, err := publicJWK(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		if kid, err = jwk.thumbprint(); err != nil {
+			return nil, err
+		}
+	}
+	return &SigningKey{ID: kid, Algorithm: alg, Key: key}, nil
+}
+
+// JWK returns the public half of the key as a JSON Web Key
+func (k *SigningKey) JWK() (JSONWebKey, error) {
+	jwk, err := publicJWK(k.Key.Public())
+	if err != nil {
+		return JSONWebKey{}, err
+	}
+	jwk.Kid = k.ID
+	jwk.Use = "sig"
+	jwk.Alg = k.Algorithm
+	return jwk, nil
+}
+
+// SigningKeysJWKS returns the JWKS of the verification keys of p, for upstream services to
+// fetch, e.g. from /.well-known/jwks.json
+func SigningKeysJWKS(ctx context.Context, p SigningKeyProvider) (JSONWebKeySet, error) {
+	keys, err := p.VerificationKeys(ctx)
+	if err != nil {
+		return JSONWebKeySet{}, err
+	}
+
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(keys))}
+	for _, key := range keys {
+		jwk, err := key.JWK()
+		if err != nil {
+			return JSONWebKeySet{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// publicJWK encodes an RSA or EC public key as a JSON Web Key, without kid, use or alg
+func publicJWK(key crypto.PublicKey) (JSONWebKey, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JSONWebKey{
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+		return JSONWebKey{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(x),
+			Y:   base64.RawURLEncoding.EncodeToString(y),
+		}, nil
+	default:
+		return JSONWebKey{}, errors.New("unsupported key type, must be RSA or EC")
+	}
+}
+
+// thumbprint returns the RFC 7638 SHA-256 thumbprint of the key: the hash of its required
+// members, in lexicographic order
+func (k JSONWebKey) thumbprint() (string, error) {
+	var members interface{}
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// rotatingKeys holds the current signing key, the next one and the ones it replaced. A new
+// key is published for the prepublish period before it signs anything, so verifiers caching
+// the JWKS know it by the time its tokens arrive, and a replaced key stays published for the
+// overlap, so tokens it signed keep verifying until they expire.
+type rotatingKeys struct {
+	prepublish time.Duration
+	overlap    time.Duration
+	clock      clock.Clock
+
+	mu       sync.Mutex
+	current  *SigningKey
+	since    time.Time
+	next     *SigningKey
+	nextAt   time.Time
+	previous []retiredSigningKey
+}
+
+// retiredSigningKey is a key rotated out, still published until the given time
+type retiredSigningKey struct {
+	key   *SigningKey
+	until time.Time
+}
+
+// stage publishes key as the next signing key, taking over once the prepublish period has
+// passed and no earlier than at. It replaces a key staged before, which never signed
+// anything. A key sharing the current key's kid takes over at once, as verifiers couldn't
+// tell the two apart anyway. Callers must hold r.mu.
+func (r *rotatingKeys) stage(key *SigningKey, at time.Time) {
+	now := r.clock.Now()
+	if r.current == nil || r.current.ID == key.ID {
+		r.rotate(key, now)
+		return
+	}
+
+	if earliest := now.Add(r.prepublish); at.Before(earliest) {
+		at = earliest
+	}
+	r.next = key
+	r.nextAt = at
+}
+
+// promote makes the staged key the signing key once its time has come. Callers must hold r.mu.
+func (r *rotatingKeys) promote() {
+	if r.next != nil && !r.clock.Now().Before(r.nextAt) {
+		r.rotate(r.next, r.nextAt)
+	}
+}
+
+// rotate makes key the signing key as of since, keeping the one it replaces for the overlap.
+// A key sharing the new key's kid is dropped. Callers must hold r.mu.
+func (r *rotatingKeys) rotate(key *SigningKey, since time.Time) {
+	if r.current != nil && r.current.ID != key.ID && r.overlap > 0 {
+		r.previous = append(r.previous, retiredSigningKey{key: r.current, until: since.Add(r.overlap)})
+	}
+	r.current = key
+	r.since = since
+	r.next = nil
+
+	now := r.clock.Now()
+	kept := r.previous[:0]
+	for _, retired := range r.previous {
+		if retired.key.ID != key.ID && now.Before(retired.until) {
+			kept = append(kept, retired)
+		}
+	}
+	r.previous = kept
+}
+
+// signingKey returns the key to sign with. Callers must hold r.mu.
+func (r *rotatingKeys) signingKey() *SigningKey {
+	r.promote()
+	return r.current
+}
+
+// verificationKeys returns the current key, followed by the next one and those still within
+// the overlap. Callers must hold r.mu.
+func (r *rotatingKeys) verificationKeys() []*SigningKey {
+	r.promote()
+	now := r.clock.Now()
+	keys := []*SigningKey{r.current}
+	if r.next != nil {
+		keys = append(keys, r.next)
+	}
+	for _, retired := range r.previous {
+		if now.Before(retired.until) {
+			keys = append(keys, retired.key)
+		}
+	}
+	return keys
+}
+
+// FileSigningKeyProvider signs with a PEM private key file (PKCS #8, PKCS #1 or SEC 1),
+// picking up a new key when the file is replaced, at most 10s after. The new key is
+// published for the prepublish period before it signs, which must be at least the time
+// verifiers cache the JWKS; the previous key stays published for the overlap, which must
+// exceed that plus the internal token TTL. A file that fails to load keeps the current key
+// in use.
+type FileSigningKeyProvider struct {
+	path  string
+	kid   string
+	keys  *rotatingKeys
+	clock clock.Clock
+
+	mu        sync.Mutex
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewFileSigningKeyProvider creates a FileSigningKeyProvider reading path. Leave kid empty
+// to derive kids from key thumbprints: a fixed kid is shared by the old and new key, so
+// neither can be published alongside the other and a replaced key takes over at once.
+func NewFileSigningKeyProvider(path, kid string, prepublish, overlap time.Duration, opts ...Option) (*FileSigningKeyProvider, error) {
+	o := newOptions(opts)
+	p := &FileSigningKeyProvider{
+		path:  path,
+		kid:   kid,
+		keys:  &rotatingKeys{prepublish: prepublish, overlap: overlap, clock: o.clock},
+		clock: o.clock,
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SigningKey returns the key to sign with, reloading the file if it changed
+func (p *FileSigningKeyProvider) SigningKey(context.Context) (*SigningKey, error) {
+	p.check()
+	p.keys.mu.Lock()
+	defer p.keys.mu.Unlock()
+	return p.keys.signingKey(), nil
+}
+
+// VerificationKeys returns the key of the file, the one replacing it and those it replaced
+// within the overlap
+func (p *FileSigningKeyProvider) VerificationKeys(context.Context) ([]*SigningKey, error) {
+	p.check()
+	p.keys.mu.Lock()
+	defer p.keys.mu.Unlock()
+	return p.keys.verificationKeys(), nil
+}
+
+// check reloads the key file if it changed since the last load, at most every
+// fileKeyCheckInterval
+func (p *FileSigningKeyProvider) check() {
+	p.mu.Lock()
+	now := p.clock.Now()
+	if now.Sub(p.checkedAt) < fileKeyCheckInterval {
+		p.mu.Unlock()
+		return
+	}
+	p.checkedAt = now
+	p.mu.Unlock()
+
+	info, err := os.Stat(p.path)
+	if err != nil || info.ModTime().Equal(p.loadedModTime()) {
+		return
+	}
+	_ = p.load()
+}
+
+// loadedModTime returns the modification time of the key file when it was last loaded
+func (p *FileSigningKeyProvider) loadedModTime() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.modTime
+}
+
+// load reads the key file and stages its key if it is a new one
+func (p *FileSigningKeyProvider) load() error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to load signing key: %w", err)
+	}
+	signer, err := loadPrivateKey(p.path)
+	if err != nil {
+		return fmt.Errorf("failed to load signing key: %w", err)
+	}
+	key, err := NewSigningKey(signer, p.kid)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.modTime = info.ModTime()
+	p.mu.Unlock()
+
+	p.keys.mu.Lock()
+	defer p.keys.mu.Unlock()
+	known := p.keys.current
+	if p.keys.next != nil {
+		known = p.keys.next
+	}
+	if known == nil || !samePublicKey(known.Key, signer) {
+		p.keys.stage(key, p.clock.Now())
+	}
+	return nil
+}
+
+// samePublicKey tells whether two signers hold the same key pair
+func samePublicKey(a, b crypto.Signer) bool {
+	pub, ok := a.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(b.Public())
+}
+
+// MemorySigningKeyProvider signs with ECDSA P-256 keys generated in memory, replaced by a
+// new key every rotation interval. Each new key is published for the prepublish period
+// before it takes over, and the previous ones for the overlap. Keys don't survive restarts
+// and aren't shared between instances, so verifiers must fetch the JWKS of every instance;
+// deployments running several should share a key file instead.
+type MemorySigningKeyProvider struct {
+	rotation time.Duration
+	keys     *rotatingKeys
+}
+
+// NewMemorySigningKeyProvider creates a MemorySigningKeyProvider with a freshly generated key
+func NewMemorySigningKeyProvider(rotation, prepublish, overlap time.Duration, opts ...Option) (*MemorySigningKeyProvider, error) {
+	if rotation <= 0 {
+		return nil, errors.New("key rotation interval must be positive")
+	}
+
+	o := newOptions(opts)
+	p := &MemorySigningKeyProvider{
+		rotation: rotation,
+		keys:     &rotatingKeys{prepublish: prepublish, overlap: overlap, clock: o.clock},
+	}
+	if err := p.Rotate(context.Background()); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SigningKey returns the current key, staging the next one first when it is due
+func (p *MemorySigningKeyProvider) SigningKey(context.Context) (*SigningKey, error) {
+	p.keys.mu.Lock()
+	defer p.keys.mu.Unlock()
+	if err := p.stageIfDue(); err != nil {
+		return nil, err
+	}
+	return p.keys.signingKey(), nil
+}
+
+// VerificationKeys returns the current key, the next one and those the current one replaced
+// within the overlap, staging the next one first when it is due
+func (p *MemorySigningKeyProvider) VerificationKeys(context.Context) ([]*SigningKey, error) {
+	p.keys.mu.Lock()
+	defer p.keys.mu.Unlock()
+	if err := p.stageIfDue(); err != nil {
+		return nil, err
+	}
+	return p.keys.verificationKeys(), nil
+}
+
+// Rotate replaces the current key with a new one now, without prepublishing it, for a key
+// that may have leaked: verifiers reject the new key's tokens until they refetch the JWKS.
+func (p *MemorySigningKeyProvider) Rotate(context.Context) error {
+	key, err := generateSigningKey()
+	if err != nil {
+		return err
+	}
+	p.keys.mu.Lock()
+	defer p.keys.mu.Unlock()
+	p.keys.rotate(key, p.keys.clock.Now())
+	return nil
+}
+
+// stageIfDue stages the next key once the current one is within the prepublish period of the
+// end of its rotation interval, so it takes over when the interval ends. Callers must hold
+// p.keys.mu.
+func (p *MemorySigningKeyProvider) stageIfDue() error {
+	p.keys.promote()
+	if p.keys.next != nil {
+		return nil
+	}
+
+	rotateAt := p.keys.since.Add(p.rotation)
+	if p.keys.clock.Now().Before(rotateAt.Add(-p.keys.prepublish)) {
+		return nil
+	}
+
+	key, err := generateSigningKey()
+	if err != nil {
+		return err
+	}
+	p.keys.stage(key, rotateAt)
+	return nil
+}
+
+// generateSigningKey generates a P-256 key identified by its thumbprint
+func generateSigningKey() (*SigningKey, error) {
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return NewSigningKey(signer, "")
+}
@@ -0,0 +1,252 @@
+package provider
+
+import (
+	"context"
+	"crypto"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// keyIDs returns the kids of keys, in order
+func keyIDs(keys []*SigningKey) []string {
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = key.ID
+	}
+	return ids
+}
+
+func containsKeyID(keys []*SigningKey, id string) bool {
+	for _, key := range keys {
+		if key.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestJSONWebKeyThumbprint(t *testing.T) {
+	// The example of RFC 7638, section 3.1
+	key := JSONWebKey{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZC" +
+			"iFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c" +
+			"7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-c" +
+			"sFCur-kEgU8awapJzKnqDKgw",
+	}
+	got, err := key.thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint() = %s, want %s", got, want)
+	}
+}
+
+func TestMemorySigningKeyProviderRotation(t *testing.T) {
+	const (
+		rotation   = time.Hour
+		prepublish = 5 * time.Minute
+		overlap    = 10 * time.Minute
+	)
+	ctx := context.Background()
+	clk := newFakeClock()
+	p, err := NewMemorySigningKeyProvider(rotation, prepublish, overlap, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := p.SigningKey(ctx)
+	if keys, _ := p.VerificationKeys(ctx); len(keys) != 1 {
+		t.Fatalf("VerificationKeys() = %v, want only the first key", keyIDs(keys))
+	}
+
+	// Within the prepublish period of the rotation, the next key is published but doesn't sign
+	clk.Advance(rotation - prepublish)
+	keys, _ := p.VerificationKeys(ctx)
+	if len(keys) != 2 || keys[0] != first {
+		t.Fatalf("VerificationKeys() = %v, want the first key and the next one", keyIDs(keys))
+	}
+	next := keys[1]
+	if key, _ := p.SigningKey(ctx); key != first {
+		t.Error("the next key signs before it was published for the prepublish period")
+	}
+
+	// At the rotation the next key signs, and the first stays published for the overlap
+	clk.Advance(prepublish)
+	if key, _ := p.SigningKey(ctx); key != next {
+		t.Fatalf("SigningKey() = %s, want the next key %s", key.ID, next.ID)
+	}
+	keys, _ = p.VerificationKeys(ctx)
+	if keys[0] != next || !containsKeyID(keys, first.ID) {
+		t.Errorf("VerificationKeys() = %v, want the next key first and the first one still published", keyIDs(keys))
+	}
+
+	clk.Advance(overlap)
+	if keys, _ := p.VerificationKeys(ctx); containsKeyID(keys, first.ID) {
+		t.Errorf("VerificationKeys() = %v, first key still published after the overlap", keyIDs(keys))
+	}
+}
+
+func TestMemorySigningKeyProviderNeverSignsUnpublished(t *testing.T) {
+	ctx := context.Background()
+	clk := newFakeClock()
+	p, err := NewMemorySigningKeyProvider(time.Hour, 5*time.Minute, 10*time.Minute, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing asked for keys in the run-up to the rotation, so the next key was never
+	// published; it must be published for the prepublish period before it signs
+	first, _ := p.SigningKey(ctx)
+	clk.Advance(3 * time.Hour)
+	if key, _ := p.SigningKey(ctx); key != first {
+		t.Fatal("a key signed without being published first")
+	}
+	clk.Advance(5 * time.Minute)
+	if key, _ := p.SigningKey(ctx); key == first {
+		t.Error("the key wasn't rotated after the prepublish period")
+	}
+}
+
+func TestMemorySigningKeyProviderRotate(t *testing.T) {
+	ctx := context.Background()
+	p, err := NewMemorySigningKeyProvider(time.Hour, 5*time.Minute, 10*time.Minute, WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := p.SigningKey(ctx)
+	if err := p.Rotate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := p.SigningKey(ctx)
+	if second == first {
+		t.Fatal("Rotate() kept the key")
+	}
+	if keys, _ := p.VerificationKeys(ctx); !containsKeyID(keys, first.ID) {
+		t.Errorf("VerificationKeys() = %v, want the replaced key kept for the overlap", keyIDs(keys))
+	}
+}
+
+func TestFileSigningKeyProviderRotation(t *testing.T) {
+	const (
+		prepublish = 5 * time.Minute
+		overlap    = 10 * time.Minute
+	)
+	ctx := context.Background()
+	clk := newFakeClock()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "signing.pem")
+	modTime := time.Now()
+	writeKey := func() {
+		t.Helper()
+		der, err := x509.MarshalECPrivateKey(mustGenerateECKey(t, elliptic.P256()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmp := writePEM(t, dir, "signing.pem.tmp", "EC PRIVATE KEY", der)
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+		// File systems with coarse timestamps would otherwise hide the replacement
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeKey()
+	p, err := NewFileSigningKeyProvider(path, "", prepublish, overlap, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := p.SigningKey(ctx)
+
+	// The replaced file is picked up within the check interval and published, not signed with
+	writeKey()
+	clk.Advance(fileKeyCheckInterval)
+	keys, _ := p.VerificationKeys(ctx)
+	if len(keys) != 2 || keys[0] != first {
+		t.Fatalf("VerificationKeys() = %v, want the first key and the new one", keyIDs(keys))
+	}
+	next := keys[1]
+	if key, _ := p.SigningKey(ctx); key != first {
+		t.Error("the new key signs before it was published for the prepublish period")
+	}
+
+	clk.Advance(prepublish)
+	if key, _ := p.SigningKey(ctx); key != next {
+		t.Fatalf("SigningKey() = %s, want the new key %s", key.ID, next.ID)
+	}
+	if keys, _ := p.VerificationKeys(ctx); !containsKeyID(keys, first.ID) {
+		t.Errorf("VerificationKeys() = %v, want the first key kept for the overlap", keyIDs(keys))
+	}
+
+	// A file that fails to load keeps the current key
+	if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	modTime = modTime.Add(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(fileKeyCheckInterval)
+	if key, _ := p.SigningKey(ctx); key != next {
+		t.Errorf("SigningKey() = %s after a bad key file, want %s", key.ID, next.ID)
+	}
+
+	clk.Advance(overlap)
+	if keys, _ := p.VerificationKeys(ctx); containsKeyID(keys, first.ID) {
+		t.Errorf("VerificationKeys() = %v, first key still published after the overlap", keyIDs(keys))
+	}
+}
+
+func TestSigningKeysJWKS(t *testing.T) {
+	ctx := context.Background()
+	clk := newFakeClock()
+	p, err := NewMemorySigningKeyProvider(time.Hour, 5*time.Minute, 10*time.Minute, WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Hour - 5*time.Minute)
+
+	set, err := SigningKeysJWKS(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		t.Fatalf("parseJWKS() error = %v", err)
+	}
+
+	verification, _ := p.VerificationKeys(ctx)
+	if len(keys) != len(verification) {
+		t.Fatalf("JWKS holds %d keys, want %d", len(keys), len(verification))
+	}
+	for _, key := range verification {
+		public, ok := keys[key.ID]
+		if !ok {
+			t.Errorf("JWKS lacks key %s", key.ID)
+			continue
+		}
+		if pub, ok := key.Key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(public) {
+			t.Errorf("JWKS key %s doesn't match the signing key", key.ID)
+		}
+	}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "sig" || jwk.Alg != "ES256" {
+			t.Errorf("JWK %s has use %q and alg %q, want sig and ES256", jwk.Kid, jwk.Use, jwk.Alg)
+		}
+	}
+}
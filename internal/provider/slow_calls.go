@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+// slowCallTransport logs calls to the IdP that take longer than a threshold, to surface a
+// degrading IdP before calls start timing out
+type slowCallTransport struct {
+	next      http.RoundTripper
+	threshold time.Duration
+	logger    *logger.Logger
+}
+
+// withSlowCallLogging wraps next to log calls slower than threshold. A threshold of 0, or
+// no logger, leaves next as it is.
+func withSlowCallLogging(next http.RoundTripper, threshold time.Duration, log *logger.Logger) http.RoundTripper {
+	if threshold <= 0 || log == nil {
+		return next
+	}
+	return &slowCallTransport{next: next, threshold: threshold, logger: log}
+}
+
+// RoundTrip performs the call and logs it when it is slow. Only the method, host and path
+// are logged: tokens and credentials travel in headers, bodies and query strings.
+func (t *slowCallTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	duration := time.Since(start)
+
+	if duration >= t.threshold {
+		fields := []interface{}{
+			"method", req.Method,
+			"endpoint", req.URL.Host + req.URL.Path,
+			"duration", duration.String(),
+			"duration_ms", duration.Milliseconds(),
+			"threshold", t.threshold.String(),
+		}
+		if resp != nil {
+			fields = append(fields, "status", resp.StatusCode)
+		}
+		if err != nil {
+			fields = append(fields, "error", err)
+		}
+		(*t.logger).WarnContext(req.Context(), "Slow IAM provider call", fields...)
+	}
+
+	return resp, err
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport
+func (t *slowCallTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+)
+
+// ErrCacheMiss is returned by a TokenCache when it holds no entry for a key
+var ErrCacheMiss = errors.New("cache miss")
+
+// CacheKey identifies a token in a TokenCache. It is a salted hash of the token, so
+// backends never see the token itself.
+type CacheKey [sha256.Size]byte
+
+// String returns the hex encoding of the key, for backends keyed by strings
+func (k CacheKey) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// CacheEntry is a cached token validation result
+type CacheEntry struct {
+	TokenInfo *TokenInfo `json:"token_info"`
+	// FreshUntil is the Unix time after which the entry is only served in degraded mode
+	FreshUntil int64 `json:"fresh_until"`
+}
+
+// TokenCache defines the interface token cache backends must implement. Entries must be
+// dropped once their TTL elapses, and implementations must be safe for concurrent use.
+// Entries returned by Get are shared and must be treated as read-only.
+type TokenCache interface {
+	Get(ctx context.Context, key CacheKey) (*CacheEntry, error)
+	Set(ctx context.Context, key CacheKey, entry *CacheEntry, ttl time.Duration) error
+	Delete(ctx context.Context, key CacheKey) error
+}
+
+type lruEntry struct {
+	key       CacheKey
+	entry     *CacheEntry
+	expiresAt time.Time
+}
+
+// LRUTokenCache is the default TokenCache: an in-process LRU cache with a bounded size
+type LRUTokenCache struct {
+	maxEntries int
+	clock      clock.Clock
+
+	mu      sync.Mutex
+	entries map[CacheKey]*list.Element
+	lru     *list.List
+}
+
+// NewLRUTokenCache creates a new LRUTokenCache holding at most maxEntries entries,
+// or defaultCacheMaxEntries when maxEntries is not positive. TTLs elapse by c; a nil clock
+// selects the system clock.
+func NewLRUTokenCache(maxEntries int, c clock.Clock) *LRUTokenCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+
+	return &LRUTokenCache{
+		maxEntries: maxEntries,
+		clock:      clock.OrSystem(c),
+		entries:    make(map[CacheKey]*list.Element, maxEntries),
+		lru:        list.New(),
+	}
+}
+
+// Get returns the entry for key, or ErrCacheMiss when it is missing or its TTL elapsed
+func (c *LRUTokenCache) Get(_ context.Context, key CacheKey) (*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+
+	e := elem.Value.(*lruEntry)
+	if !c.clock.Now().Before(e.expiresAt) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, ErrCacheMiss
+	}
+
+	c.lru.MoveToFront(elem)
+	return e.entry, nil
+}
+
+// Set stores the entry for ttl, evicting the least recently used entry when full
+func (c *LRUTokenCache) Set(_ context.Context, key CacheKey, entry *CacheEntry, ttl time.Duration) error {
+	expiresAt := c.clock.Now().Add(ttl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		e := elem.Value.(*lruEntry)
+		e.entry = entry
+		e.expiresAt = expiresAt
+		c.lru.MoveToFront(elem)
+		return nil
+	}
+
+	if c.lru.Len() >= c.maxEntries {
+		if oldest := c.lru.Back(); oldest != nil {
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*lruEntry).key)
+		}
+	}
+
+	c.entries[key] = c.lru.PushFront(&lruEntry{
+		key:       key,
+		entry:     entry,
+		expiresAt: expiresAt,
+	})
+	return nil
+}
+
+// Delete removes the entry for key, if any
+func (c *LRUTokenCache) Delete(_ context.Context, key CacheKey) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+	return nil
+}
+
+// Clear removes every entry
+func (c *LRUTokenCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.lru.Init()
+}
@@ -0,0 +1,37 @@
+package provider
+
+import "strings"
+
+// checkTokenType rejects tokens whose type is not one of expected. The type may be declared
+// in the typ header or, as Keycloak does to tell access, refresh and ID tokens apart, in the
+// typ claim; the token passes when either matches. Matching ignores case and the media type
+// prefix, so application/at+jwt matches at+jwt.
+func checkTokenType(token string, claims map[string]interface{}, expected []string) error {
+	var headerType string
+	if jwt, err := parseJWT(token); err == nil {
+		headerType = jwt.header.Typ
+	}
+	claimType := claimString(claims, "typ")
+
+	for _, typ := range expected {
+		if tokenTypeMatches(headerType, typ) || tokenTypeMatches(claimType, typ) {
+			return nil
+		}
+	}
+
+	value := claimType
+	if value == "" {
+		value = headerType
+	}
+	return newValidationError(ErrWrongTokenType, ReasonWrongType, "token type not accepted").
+		withClaim("typ", value)
+}
+
+// tokenTypeMatches compares a declared token type with an expected one
+func tokenTypeMatches(declared, expected string) bool {
+	if declared == "" {
+		return false
+	}
+	declared = strings.TrimPrefix(strings.ToLower(declared), "application/")
+	return declared == strings.TrimPrefix(strings.ToLower(expected), "application/")
+}
@@ -0,0 +1,120 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Span names of the provider's traces
+const (
+	SpanValidateToken = "iam.validate_token"
+	SpanFetchJWKS     = "iam.fetch_jwks"
+	SpanIntrospect    = "iam.introspect"
+	SpanUserInfo      = "iam.userinfo"
+)
+
+// Tracer starts spans around token validation and the IdP calls it makes. It is the subset
+// of a tracing library the provider needs, so it can be backed by OpenTelemetry through a
+// small adapter without the provider depending on it. Spans are children of the span in ctx.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer. Attributes never carry token material.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// TracePropagator is implemented by tracers that carry the trace context across HTTP calls,
+// e.g. with W3C traceparent headers. The provider injects it into its calls to the IdP;
+// middleware.TraceContext extracts it from incoming requests.
+type TracePropagator interface {
+	Inject(ctx context.Context, header http.Header)
+	Extract(ctx context.Context, header http.Header) context.Context
+}
+
+// WithTracer records spans with tracer. Without it, no spans are recorded.
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		if tracer != nil {
+			o.tracer = tracer
+		}
+	}
+}
+
+// noopTracer starts spans that record nothing
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) RecordError(error)                {}
+func (noopSpan) End()                             {}
+
+// endCallSpan records the error of an IdP call on span, if any, and ends it
+func endCallSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// endValidationSpan records the outcome of a validation on span and ends it: valid, invalid
+// for tokens that were rejected, or error when the token couldn't be checked
+func endValidationSpan(span Span, err error) {
+	defer span.End()
+
+	switch {
+	case err == nil:
+		span.SetAttribute("iam.outcome", "valid")
+		return
+	case errors.Is(err, ErrProviderUnavailable) || errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded):
+		span.SetAttribute("iam.outcome", "error")
+	default:
+		span.SetAttribute("iam.outcome", "invalid")
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		span.SetAttribute("iam.reason", string(validationErr.Reason))
+	}
+	span.RecordError(err)
+}
+
+// tracingTransport injects the trace context into calls to the IdP
+type tracingTransport struct {
+	next       http.RoundTripper
+	propagator TracePropagator
+}
+
+// withTracing wraps next to propagate the trace context when tracer can, and leaves next as
+// it is otherwise
+func withTracing(next http.RoundTripper, tracer Tracer) http.RoundTripper {
+	propagator, ok := tracer.(TracePropagator)
+	if !ok {
+		return next
+	}
+	return &tracingTransport{next: next, propagator: propagator}
+}
+
+// RoundTrip performs the call with the trace context of its context in the headers
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	t.propagator.Inject(req.Context(), req.Header)
+	return t.next.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport
+func (t *tracingTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
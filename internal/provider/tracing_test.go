@@ -0,0 +1,287 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// recordedSpan is a span kept by a spanRecorder
+type recordedSpan struct {
+	id         int
+	name       string
+	parent     int
+	attributes map[string]interface{}
+	errs       []error
+	ended      bool
+}
+
+// spanRecorder is an in-memory Tracer and TracePropagator, propagating the ID of the current
+// span in a traceparent header
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanContextKey struct{}
+
+func (r *spanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	parent, _ := ctx.Value(spanContextKey{}).(int)
+	span := &recordedSpan{id: len(r.spans) + 1, name: name, parent: parent, attributes: make(map[string]interface{})}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanContextKey{}, span.id), &recorderSpan{r, span}
+}
+
+func (r *spanRecorder) Inject(ctx context.Context, header http.Header) {
+	if id, ok := ctx.Value(spanContextKey{}).(int); ok {
+		header.Set("traceparent", fmt.Sprint(id))
+	}
+}
+
+func (r *spanRecorder) Extract(ctx context.Context, _ http.Header) context.Context {
+	return ctx
+}
+
+// named returns the recorded spans of the given name
+func (r *spanRecorder) named(name string) []recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var spans []recordedSpan
+	for _, span := range r.spans {
+		if span.name == name {
+			spans = append(spans, *span)
+		}
+	}
+	return spans
+}
+
+// recorderSpan records what is set on a span of a spanRecorder
+type recorderSpan struct {
+	r    *spanRecorder
+	span *recordedSpan
+}
+
+func (s *recorderSpan) SetAttribute(key string, value interface{}) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.span.attributes[key] = value
+}
+
+func (s *recorderSpan) RecordError(err error) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.span.errs = append(s.span.errs, err)
+}
+
+func (s *recorderSpan) End() {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.span.ended = true
+}
+
+// tracedIdP is a Keycloak stub serving a JWKS and introspection with the given status,
+// recording the traceparent headers it was called with
+type tracedIdP struct {
+	*httptest.Server
+	jwks   []byte
+	status int
+
+	mu           sync.Mutex
+	traceparents map[string]string
+}
+
+func newTracedIdP(t *testing.T, key *SigningKey, status int) *tracedIdP {
+	t.Helper()
+	set, err := SigningKeysJWKS(context.Background(), staticSigningKeys{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &tracedIdP{status: status, traceparents: make(map[string]string)}
+	if idp.jwks, err = json.Marshal(set); err != nil {
+		t.Fatal(err)
+	}
+	idp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		idp.traceparents[r.URL.Path] = r.Header.Get("traceparent")
+		idp.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(idp.status)
+		if r.URL.Path == "/realms/test/protocol/openid-connect/certs" {
+			_, _ = w.Write(idp.jwks)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (i *tracedIdP) traceparent(path string) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.traceparents[path]
+}
+
+func TestValidateTokenSpans(t *testing.T) {
+	const (
+		certsPath      = "/realms/test/protocol/openid-connect/certs"
+		introspectPath = "/realms/test/protocol/openid-connect/token/introspect"
+	)
+	key := mustSigningKey(t)
+
+	tests := []struct {
+		name        string
+		validation  string
+		status      int
+		token       func(idp *tracedIdP) string
+		wantCall    string
+		callPath    string
+		wantOutcome string
+		wantReason  string
+	}{
+		{"valid jwks token", "jwks", http.StatusOK, func(idp *tracedIdP) string {
+			return mustSignIssuedToken(t, key, idp.URL+"/realms/test", time.Hour)
+		}, SpanFetchJWKS, certsPath, "valid", ""},
+		{"expired jwks token", "jwks", http.StatusOK, func(idp *tracedIdP) string {
+			return mustSignIssuedToken(t, key, idp.URL+"/realms/test", -time.Hour)
+		}, SpanFetchJWKS, certsPath, "invalid", string(ReasonExpired)},
+		{"inactive token", "introspection", http.StatusOK, func(*tracedIdP) string {
+			return "opaque-token"
+		}, SpanIntrospect, introspectPath, "invalid", ""},
+		{"IdP down", "introspection", http.StatusBadGateway, func(*tracedIdP) string {
+			return "opaque-token"
+		}, SpanIntrospect, introspectPath, "error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newTracedIdP(t, key, tt.status)
+			tracer := &spanRecorder{}
+			p, err := NewKeycloakProvider(config.KeycloakConfig{
+				BaseURL:         idp.URL,
+				Realm:           "test",
+				ClientID:        "bridge",
+				ClientSecret:    "secret",
+				TokenValidation: tt.validation,
+			}, nil, WithTracer(tracer))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token := tt.token(idp)
+			_, err = p.ValidateToken(context.Background(), token)
+			if (err == nil) != (tt.wantOutcome == "valid") {
+				t.Fatalf("ValidateToken() error = %v, want outcome %s", err, tt.wantOutcome)
+			}
+
+			validations := tracer.named(SpanValidateToken)
+			if len(validations) != 1 {
+				t.Fatalf("recorded %d %s spans, want 1", len(validations), SpanValidateToken)
+			}
+			validation := validations[0]
+			if !validation.ended || validation.parent != 0 {
+				t.Errorf("%s span ended = %v, parent = %d, want an ended root span", validation.name, validation.ended, validation.parent)
+			}
+			for attr, want := range map[string]interface{}{
+				"iam.outcome":    tt.wantOutcome,
+				"iam.validation": tt.validation,
+				"iam.realm":      "test",
+			} {
+				if got := validation.attributes[attr]; got != want {
+					t.Errorf("%s = %v, want %v", attr, got, want)
+				}
+			}
+			if got, _ := validation.attributes["iam.reason"].(string); got != tt.wantReason {
+				t.Errorf("iam.reason = %q, want %q", got, tt.wantReason)
+			}
+			if (len(validation.errs) > 0) != (err != nil) {
+				t.Errorf("%s recorded errors %v, want the validation error %v", validation.name, validation.errs, err)
+			}
+
+			calls := tracer.named(tt.wantCall)
+			if len(calls) != 1 {
+				t.Fatalf("recorded %d %s spans, want 1", len(calls), tt.wantCall)
+			}
+			call := calls[0]
+			if !call.ended || call.parent != validation.id {
+				t.Errorf("%s span ended = %v, parent = %d, want an ended child of %d", call.name, call.ended, call.parent, validation.id)
+			}
+			if got := call.attributes["http.status_code"]; got != tt.status {
+				t.Errorf("%s http.status_code = %v, want %d", call.name, got, tt.status)
+			}
+			// The IdP call carries the trace context of its span
+			if got := idp.traceparent(tt.callPath); got != fmt.Sprint(call.id) {
+				t.Errorf("traceparent of the IdP call = %q, want %d", got, call.id)
+			}
+
+			// No token material ends up in the spans
+			for _, span := range append(validations, calls...) {
+				for attr, value := range span.attributes {
+					if s, ok := value.(string); ok && strings.Contains(s, token) {
+						t.Errorf("%s attribute %s carries the token", span.name, attr)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTokenSpanContinuesCallerTrace(t *testing.T) {
+	key := mustSigningKey(t)
+	tracer := &spanRecorder{}
+	p := localProvider(t, key, WithTracer(tracer))
+
+	ctx, caller := tracer.Start(context.Background(), "http.request")
+	if _, err := p.ValidateToken(ctx, signClaims(t, key, map[string]interface{}{
+		"exp": float64(time.Now().Add(time.Hour).Unix()),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	caller.End()
+
+	validations := tracer.named(SpanValidateToken)
+	if len(validations) != 1 || validations[0].parent != 1 {
+		t.Errorf("%s spans = %+v, want one child of the caller's span", SpanValidateToken, validations)
+	}
+	// Tokens verified against inline keys make no IdP calls
+	if calls := tracer.named(SpanFetchJWKS); len(calls) != 0 {
+		t.Errorf("recorded %d %s spans for inline keys, want 0", len(calls), SpanFetchJWKS)
+	}
+}
+
+func TestNoopTracer(t *testing.T) {
+	key := mustSigningKey(t)
+	p := localProvider(t, key, WithTracer(nil))
+	_, err := p.ValidateToken(context.Background(), signClaims(t, key, nil))
+	if !errors.Is(err, ErrNoExpiry) {
+		t.Errorf("ValidateToken() without a tracer error = %v, want ErrNoExpiry", err)
+	}
+}
+
+// mustSignIssuedToken signs a token of iss with key, expiring after ttl from now
+func mustSignIssuedToken(t *testing.T, key *SigningKey, iss string, ttl time.Duration) string {
+	t.Helper()
+	now := time.Now()
+	token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": key.ID}, map[string]interface{}{
+		"sub": "user-1",
+		"iss": iss,
+		"iat": float64(now.Add(-2 * time.Hour).Unix()),
+		"exp": float64(now.Add(ttl).Unix()),
+	}, key.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
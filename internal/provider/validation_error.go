@@ -0,0 +1,61 @@
+package provider
+
+import "fmt"
+
+// ValidationReason classifies why a token failed validation
+type ValidationReason string
+
+const (
+	ReasonExpired       ValidationReason = "expired"
+	ReasonBadSignature  ValidationReason = "bad_signature"
+	ReasonWrongAudience ValidationReason = "wrong_audience"
+	ReasonWrongIssuer   ValidationReason = "wrong_issuer"
+	ReasonNotYetValid   ValidationReason = "not_yet_valid"
+	ReasonMalformed     ValidationReason = "malformed"
+	ReasonKeyNotFound   ValidationReason = "key_not_found"
+	ReasonWrongType     ValidationReason = "wrong_type"
+	ReasonLifetime      ValidationReason = "lifetime_exceeded"
+	ReasonNoExpiry      ValidationReason = "no_expiry"
+)
+
+// ValidationError describes a token validation failure. It wraps the matching sentinel
+// error (ErrTokenExpired, ErrTokenInvalid, ...), so errors.Is keeps working.
+// Claim and Value name the offending claim and its value, and are only set for values
+// that are safe to show the caller, never for the token itself.
+type ValidationError struct {
+	Reason ValidationReason
+	Claim  string
+	Value  string
+	Detail string
+	Err    error
+}
+
+// newValidationError creates a ValidationError wrapping err
+func newValidationError(err error, reason ValidationReason, detail string) *ValidationError {
+	return &ValidationError{Reason: reason, Detail: detail, Err: err}
+}
+
+// withClaim records the offending claim and its value
+func (e *ValidationError) withClaim(claim, value string) *ValidationError {
+	e.Claim = claim
+	e.Value = value
+	return e
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s", e.Err, e.Description())
+}
+
+// Unwrap returns the wrapped sentinel error
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Description returns a human-readable explanation suitable for an error_description
+func (e *ValidationError) Description() string {
+	if e.Claim != "" && e.Value != "" {
+		return fmt.Sprintf("%s (%s: %q)", e.Detail, e.Claim, e.Value)
+	}
+	return e.Detail
+}
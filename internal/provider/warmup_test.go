@@ -0,0 +1,158 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// warmupIdP serves the discovery documents and JWKS of any realm, counting requests per
+// path; realms named broken-* fail
+type warmupIdP struct {
+	*httptest.Server
+	key *SigningKey
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func newWarmupIdP(t *testing.T) *warmupIdP {
+	t.Helper()
+	idp := &warmupIdP{key: mustSigningKey(t), requests: make(map[string]int)}
+	idp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		idp.requests[r.URL.Path]++
+		idp.mu.Unlock()
+
+		if strings.HasPrefix(r.URL.Path, "/realms/broken-") {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/.well-known/openid-configuration"):
+			_ = json.NewEncoder(w).Encode(DiscoveryDocument{Issuer: idp.URL + strings.TrimSuffix(r.URL.Path, "/.well-known/openid-configuration")})
+		case strings.HasSuffix(r.URL.Path, "/protocol/openid-connect/certs"):
+			set, _ := SigningKeysJWKS(r.Context(), staticSigningKeys{idp.key})
+			_ = json.NewEncoder(w).Encode(set)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (w *warmupIdP) count(path string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.requests[path]
+}
+
+func (w *warmupIdP) realm(name, validation string) config.KeycloakConfig {
+	return config.KeycloakConfig{
+		BaseURL:         w.URL,
+		Realm:           name,
+		ClientID:        "bridge",
+		ClientSecret:    "secret",
+		TokenValidation: validation,
+	}
+}
+
+func TestKeycloakWarmup(t *testing.T) {
+	tests := []struct {
+		name       string
+		validation string
+		wantCerts  int
+	}{
+		{"jwks", "jwks", 1},
+		{"introspection", "introspection", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newWarmupIdP(t)
+			p, err := NewKeycloakProvider(idp.realm("test", tt.validation), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			warmer, _ := As[Warmer](p)
+			if err := warmer.Warmup(context.Background()); err != nil {
+				t.Fatalf("Warmup() error = %v", err)
+			}
+			if n := idp.count("/realms/test/.well-known/openid-configuration"); n != 1 {
+				t.Errorf("discovery fetched %d times, want 1", n)
+			}
+			if n := idp.count("/realms/test/protocol/openid-connect/certs"); n != tt.wantCerts {
+				t.Errorf("JWKS fetched %d times, want %d", n, tt.wantCerts)
+			}
+			if tt.validation != "jwks" {
+				return
+			}
+
+			// The first validation uses the warmed up keys
+			token, err := signJWT(map[string]string{"alg": "ES256", "typ": "JWT", "kid": idp.key.ID}, map[string]interface{}{
+				"sub": "user-1",
+				"iss": idp.URL + "/realms/test",
+				"iat": float64(time.Now().Unix()),
+				"exp": float64(time.Now().Add(time.Hour).Unix()),
+			}, idp.key.Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.ValidateToken(context.Background(), token); err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+			if n := idp.count("/realms/test/protocol/openid-connect/certs"); n != 1 {
+				t.Errorf("JWKS fetched %d times after a validation, want 1", n)
+			}
+		})
+	}
+}
+
+func TestKeycloakWarmupFailure(t *testing.T) {
+	idp := newWarmupIdP(t)
+	p, err := NewKeycloakProvider(idp.realm("broken-test", "jwks"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warmer, _ := As[Warmer](p)
+	if err := warmer.Warmup(context.Background()); !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("Warmup() error = %v, want ErrProviderUnavailable", err)
+	}
+}
+
+func TestMultiTenantWarmupReportsFailuresInOrder(t *testing.T) {
+	idp := newWarmupIdP(t)
+	var realms []config.KeycloakConfig
+	for _, name := range []string{"broken-b", "acme", "broken-a", "globex"} {
+		realms = append(realms, idp.realm(name, "jwks"))
+	}
+	m, err := NewMultiTenantProvider(realms, nil, WithWarmupConcurrency(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Warmup(context.Background())
+	if err == nil {
+		t.Fatal("Warmup() error = nil, want the broken realms reported")
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "warmup failed for 2 of 4 realms: broken-a: ") || !strings.Contains(msg, "; broken-b: ") {
+		t.Errorf("Warmup() error = %q, want broken-a then broken-b", msg)
+	}
+	for _, name := range []string{"acme", "globex"} {
+		if n := idp.count("/realms/" + name + "/protocol/openid-connect/certs"); n != 1 {
+			t.Errorf("%s JWKS fetched %d times, want 1 despite the failing realms", name, n)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/middleware"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// debugValidateRequest is the body of a dry-run token validation
+type debugValidateRequest struct {
+	Token string `json:"token"`
+}
+
+// debugValidateResponse reports the outcome of a dry-run token validation. Header and
+// Claims are decoded without verification, so they are shown for rejected tokens too.
+type debugValidateResponse struct {
+	Valid     bool                   `json:"valid"`
+	Error     string                 `json:"error,omitempty"`
+	Reason    string                 `json:"reason,omitempty"`
+	Claim     string                 `json:"claim,omitempty"`
+	Value     string                 `json:"value,omitempty"`
+	KeyID     string                 `json:"key_id,omitempty"`
+	Header    *provider.TokenHeader  `json:"header,omitempty"`
+	Claims    map[string]interface{} `json:"claims,omitempty"`
+	TokenInfo *provider.TokenInfo    `json:"token_info,omitempty"`
+}
+
+// DebugValidateHandler validates the token POSTed as {"token": "..."} and responds with a
+// detailed diagnostic: whether it passed, why it failed, its decoded header and claims and
+// the key it was signed with. The token cache is bypassed, so the dry run neither reads nor
+// stores cached results. The token itself is never logged.
+func DebugValidateHandler(p provider.IAMProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req debugValidateRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil || req.Token == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "request body must be {\"token\": \"...\"}"})
+			return
+		}
+
+		validator := p
+		if cache, ok := provider.As[*provider.CachingProvider](p); ok {
+			validator = cache.Unwrap()
+		}
+
+		resp := debugValidateResponse{}
+		if header, claims, err := provider.DecodeToken(req.Token); err == nil {
+			resp.Header = &header
+			resp.Claims = claims
+		}
+
+		tokenInfo, err := validator.ValidateToken(r.Context(), req.Token)
+		if err != nil {
+			resp.Error = err.Error()
+			var validationErr *provider.ValidationError
+			if errors.As(err, &validationErr) {
+				resp.Reason = string(validationErr.Reason)
+				resp.Claim = validationErr.Claim
+				resp.Value = validationErr.Value
+			}
+		} else {
+			resp.Valid = true
+			resp.TokenInfo = tokenInfo
+			if resp.Header != nil {
+				resp.KeyID = resp.Header.Kid
+			}
+		}
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// rateLimitStateResponse lists the per-client rate limit counters
+type rateLimitStateResponse struct {
+	Buckets   []middleware.BucketState `json:"buckets"`
+	Total     int                      `json:"total"`
+	Truncated bool                     `json:"truncated"`
+}
+
+// RateLimitStateHandler responds with the live per-client rate limit counters, most recently
+// seen first, to find out why a client is getting 429s
+func RateLimitStateHandler(l *middleware.RateLimiter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		buckets := l.Snapshot()
+		total := l.Size()
+		writeJSON(w, http.StatusOK, rateLimitStateResponse{
+			Buckets:   buckets,
+			Total:     total,
+			Truncated: len(buckets) < total,
+		})
+	}
+}
+
+// middlewareChainResponse lists the global middleware in the order it runs
+type middlewareChainResponse struct {
+	Middleware []string `json:"middleware"`
+}
+
+// MiddlewareChainHandler responds with the names of the middleware applied to every route,
+// in the order they run, to check what the config actually wired up
+func MiddlewareChainHandler(chain []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, middlewareChainResponse{Middleware: chain})
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+// JWKSHandler serves the JWKS of the keys internal tokens are verified with: the current
+// signing key, the next one and those rotated out within the overlap. Verifiers may cache it
+// for maxAge, which must not exceed the period new keys are published before they sign.
+func JWKSHandler(keys provider.SigningKeyProvider, maxAge time.Duration) http.HandlerFunc {
+	cacheControl := fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		set, err := provider.SigningKeysJWKS(r.Context(), keys)
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "signing keys unavailable"})
+			return
+		}
+
+		w.Header().Set("Cache-Control", cacheControl)
+		writeJSON(w, http.StatusOK, set)
+	}
+}
@@ -2,16 +2,27 @@ package server
 
 import (
 	"context"
+	"errors"
+	"expvar"
 	"fmt"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/zahidhasanpapon/iam-bridge/internal/audit"
 	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config/awssecrets"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config/gcpsecrets"
 	"github.com/zahidhasanpapon/iam-bridge/internal/middleware"
 	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider/rediscache"
+	"github.com/zahidhasanpapon/iam-bridge/internal/session"
 	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -23,29 +34,177 @@ type Server struct {
 	config      *config.Config
 	logger      logger.Logger
 	router      *gin.Engine
-	iamProvider provider.IAMProvider
+	iamProvider *provider.AtomicProvider
+	tokenCache  provider.TokenCache
+	jtiDenylist provider.JTIDenylist
+	rateLimit   *middleware.RateLimit
+	limiter     *middleware.RateLimiter
+	cors        *middleware.CORSPolicy
+	proxies     middleware.TrustedProxies
+	forwardAuth *middleware.TrustedHeaderWriter
+	clientRates *middleware.ClientRateLimits
+	internal    *provider.InternalTokenMinter
+	sessions    session.Store
+	loginStates session.StateStore
+	auditLog    *audit.AuditLogger
 	httpServer  *http.Server
+	// auth is the authentication built from the current config, swapped on reloads
+	auth atomic.Pointer[authSettings]
+	// inFlight counts requests currently being handled, for shutdown reporting
+	inFlight atomic.Int64
+	// middleware names the global middleware in the order it was applied
+	middleware []string
 }
 
+// defaultShutdownTimeout is used when app.shutdown_timeout is not configured
+const defaultShutdownTimeout = 15 * time.Second
+
+// ErrShutdownTimeout is returned when in-flight requests don't finish within the
+// shutdown timeout and the server is closed forcefully
+var ErrShutdownTimeout = errors.New("shutdown timed out")
+
+// secretResolvers are the secrets providers secret:// config values can be fetched from
+var secretResolvers = []config.LoadOption{
+	config.WithSecretResolver("aws", awssecrets.New),
+	config.WithSecretResolver("gcp", gcpsecrets.New),
+}
+
+// configBase64Env holds the whole config base64-encoded, replacing the config file
+const configBase64Env = "IAM_BRIDGE_CONFIG_BASE64"
+
 // NewServer creates a new server instance
 func NewServer() (*Server, error) {
-	// Load configuration
-	cfg, err := config.LoadConfig("config")
+	// Load configuration, from the environment when it carries the whole config
+	fromEnv := os.Getenv(configBase64Env) != ""
+	var (
+		cfg *config.Config
+		err error
+	)
+	if fromEnv {
+		cfg, err = config.LoadConfigFromBase64(configBase64Env, secretResolvers...)
+	} else {
+		cfg, err = config.LoadConfig("config", secretResolvers...)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// A config passed in the environment can't be watched for changes
+	return newServer(cfg, !fromEnv)
+}
+
+// Bootstrap creates a server from the config in configPath, the directory holding
+// config.yaml, in one call: it loads and validates the config, then builds the logger,
+// provider and middleware chain, and warms the provider up so the first requests don't pay
+// for fetching discovery metadata and signing keys. Like NewServer, it watches the config
+// file for changes. Deployments needing more control can use the config, provider and
+// middleware packages directly.
+func Bootstrap(configPath string) (*Server, error) {
+	cfg, err := config.LoadConfig(configPath, secretResolvers...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config from %s: %w", configPath, err)
+	}
+
+	s, err := newServer(cfg, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start warms up eagerly initialized providers itself
+	if !cfg.IAM.EagerInit {
+		s.warmup(context.Background(), s.iamProvider)
+	}
+	return s, nil
+}
+
+// newServer creates a server from a loaded config, reloading it on changes to the config
+// file when watch is set
+func newServer(cfg *config.Config, watch bool) (*Server, error) {
 	// Initialize logger
-	log, _ := logger.NewLogger(&cfg.Logging)
+	log, err := logger.NewLogger(&cfg.Logging)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logger: %w", err)
+	}
+
+	// Initialize the shared token cache, if configured. It is kept across provider reloads.
+	// Entries are authenticated with the key salt, which the instances sharing it agree on.
+	var tokenCache provider.TokenCache
+	if strings.ToLower(cfg.IAM.Cache.Backend) == "redis" {
+		cache, err := rediscache.New(&cfg.IAM.Cache.Redis, []byte(cfg.IAM.Cache.KeySalt))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create redis token cache: %w", err)
+		}
+		tokenCache = cache
+	}
+
+	// Initialize the jti denylist, kept across provider reloads like the token cache
+	var jtiDenylist provider.JTIDenylist = provider.NewMemoryJTIDenylist(nil)
+	if strings.ToLower(cfg.IAM.JTIDenylist.Backend) == "redis" {
+		denylist, err := rediscache.NewJTIDenylist(&cfg.IAM.JTIDenylist.Redis)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create redis jti denylist: %w", err)
+		}
+		jtiDenylist = denylist
+	}
 
 	// Initialize IAM provider
-	iamProvider, err := provider.NewIAMProvider(&cfg.IAM, &log)
+	iamProvider, err := provider.NewIAMProvider(&cfg.IAM, &log,
+		provider.WithTokenCache(tokenCache), provider.WithJTIDenylist(jtiDenylist))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create IAM provider: %w", err)
 	}
 
+	// Initialize the internal token minter if internal tokens are minted
+	var internal *provider.InternalTokenMinter
+	if cfg.IAM.InternalToken.Enabled {
+		internal, err = provider.NewInternalTokenMinter(&cfg.IAM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create internal token minter: %w", err)
+		}
+	}
+
+	// Initialize session store if enabled
+	var sessions session.Store
+	if cfg.Security.Session.Enabled {
+		sessions, err = session.NewStore(&cfg.Security.Session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create session store: %w", err)
+		}
+	}
+
+	// Started logins are kept until the callback exchanges the code
+	loginStates, err := session.NewStateStore(&cfg.Security.LoginState)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create login state store: %w", err)
+	}
+
+	// Client IPs are only taken from X-Forwarded-For behind trusted proxies
+	proxies, err := middleware.ParseTrustedProxies(cfg.App.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trusted proxies: %w", err)
+	}
+
+	// Audit events are shipped to the webhook in batches, in the background
+	var auditLog *audit.AuditLogger
+	if a := cfg.Security.Audit; a.Enabled {
+		webhook := audit.NewWebhookSink(a.Webhook.URL, a.Webhook.Headers, a.Webhook.Timeout)
+		auditLog = audit.NewAuditLogger(audit.NewBufferedSink(webhook, audit.BufferOptions{
+			BatchSize:     a.BatchSize,
+			QueueSize:     a.QueueSize,
+			FlushInterval: a.FlushInterval,
+		}, log), log)
+	}
+
+	// Validations on /api/v1/auth/validate answer with the forwarded claims as trusted headers,
+	// and with an internal token when internal tokens are minted
+	headerOpts := []middleware.TrustedHeadersOption{middleware.WithForwardedClaims(cfg.IAM.ForwardedClaims...)}
+	if internal != nil {
+		headerOpts = append(headerOpts, middleware.WithInternalToken(internal, cfg.IAM.InternalToken.Header))
+	}
+	forwardAuth := middleware.NewTrustedHeaderWriter(middleware.DefaultTrustedHeaderPrefix, headerOpts...)
+
 	// Set Gin mode based on environment
-	if cfg.App.Environment == "production" {
+	if cfg.App.IsProduction() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
@@ -57,13 +216,39 @@ func NewServer() (*Server, error) {
 		config:      cfg,
 		logger:      log,
 		router:      router,
-		iamProvider: iamProvider,
+		iamProvider: provider.NewAtomicProvider(iamProvider),
+		tokenCache:  tokenCache,
+		jtiDenylist: jtiDenylist,
+		rateLimit:   middleware.NewRateLimit(cfg.Security.RateLimit.RequestsPerSecond),
+		cors:        middleware.NewCORSPolicy(&cfg.Security.CORS),
+		proxies:     proxies,
+		forwardAuth: forwardAuth,
+		internal:    internal,
+		sessions:    sessions,
+		loginStates: loginStates,
+		auditLog:    auditLog,
+	}
+	server.limiter = middleware.NewRateLimiter(server.rateLimit)
+	if rl := &cfg.Security.RateLimit; rl.Enabled && (len(rl.Tiers) > 0 || len(rl.ExemptClients) > 0) {
+		server.clientRates = middleware.NewClientRateLimits(&cfg.Security.RateLimit, middleware.WithRateLimitLogger(log))
 	}
 
+	// Authentication is rebuilt from reloaded configs; requests use the current one
+	auth, err := server.newAuthSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	server.auth.Store(auth)
+
 	// Initialize server
 	server.setupMiddleware()
 	server.setupRoutes()
 
+	// Apply the changed settings when the config changes on disk
+	if watch {
+		config.WatchConfig(cfg, server.reload, secretResolvers...)
+	}
+
 	return server, nil
 }
 
@@ -71,20 +256,250 @@ func NewServer() (*Server, error) {
 func (s *Server) setupMiddleware() {
 	// Swagger endpoint
 	s.router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	
+
+	// Recover from panics first, so it covers every other middleware
+	if s.config.App.RecoverPanics {
+		s.use("recovery", middleware.RecoveryMiddleware(s.logger))
+	}
+
 	// Add basic middleware
-	s.router.Use(
-		middleware.RequestIDMiddleware(),
-		middleware.LoggerMiddleware(s.logger),
-		middleware.RecoveryMiddleware(s.logger),
-		middleware.CORSMiddleware(&s.config.Security.CORS),
-		middleware.ErrorHandlerMiddleware(),
-	)
+	s.use("in_flight", s.trackInFlight)
+	s.use("request_id", middleware.RequestIDMiddleware())
+	s.use("client_ip", middleware.ClientIPMiddleware(s.proxies))
+	s.use("metrics", middleware.MetricsMiddleware())
+	s.use("log_context", middleware.LogContextMiddleware(s.config.IAM.CurrentProvider()))
+	s.use("logger", middleware.LoggerMiddleware(s.logger))
+	s.use("cors", middleware.CORSPolicyMiddleware(s.cors))
+	s.use("error_handler", func(c *gin.Context) { s.auth.Load().errorHandler(c) })
+	s.use("body_limit", middleware.LimitBody(s.config.App.MaxBodyBytes))
+
+	// Load server-side sessions if enabled
+	if s.sessions != nil {
+		s.use("session", middleware.SessionMiddleware(s.sessions, s.iamProvider, s.logger))
+	}
 
 	// Add rate limiting if enabled
 	if s.config.Security.RateLimit.Enabled {
-		// TODO: Implement rate limiting middleware
+		s.use("rate_limit", middleware.RateLimitMiddleware(&s.config.Security.RateLimit,
+			middleware.WithLimiter(s.limiter), middleware.WithRateLimitLogger(s.logger)))
+	}
+}
+
+// use applies handler to every route and records it under name for MiddlewareChain
+func (s *Server) use(name string, handler gin.HandlerFunc) {
+	s.router.Use(handler)
+	s.middleware = append(s.middleware, name)
+}
+
+// MiddlewareChain returns the names of the middleware applied to every route, in the order
+// they run. Middleware of single routes, such as authentication, is not included.
+func (s *Server) MiddlewareChain() []string {
+	return slices.Clone(s.middleware)
+}
+
+// reload applies the settings of a reloaded config that can change at runtime, only
+// touching the subsystems diff reports as changed. Other settings only take effect on restart.
+func (s *Server) reload(cfg *config.Config, diff config.ConfigDiff) {
+	s.logger.InfoContext(context.Background(), "Config changed", "changed", diff.String())
+
+	if diff.Provider {
+		s.reloadProvider(cfg)
+		s.reloadAuth(cfg)
+	}
+	if diff.RateLimit {
+		s.reloadRateLimit(cfg)
+	}
+	if diff.CORS {
+		s.cors.Set(&cfg.Security.CORS)
+		s.logger.InfoContext(context.Background(), "Reloaded CORS settings")
+	}
+	if diff.Logging {
+		s.reloadLogging(cfg)
+	}
+	if diff.Other {
+		s.logger.WarnContext(context.Background(), "Config changes outside iam, logging, security.cors and "+
+			"security.rate_limit only take effect on restart")
+	}
+}
+
+// reloadLogging applies a changed log level. The log format only changes on restart.
+func (s *Server) reloadLogging(cfg *config.Config) {
+	setter, ok := s.logger.(logger.LevelSetter)
+	if !ok {
+		return
+	}
+
+	setter.SetLevel(cfg.Logging.Level)
+	s.logger.InfoContext(context.Background(), "Reloaded log level", "level", cfg.Logging.Level)
+}
+
+// reloadRateLimit applies a changed rate to the running rate limiter, keeping the
+// clients' current counts
+func (s *Server) reloadRateLimit(cfg *config.Config) {
+	rps := cfg.Security.RateLimit.RequestsPerSecond
+	if !cfg.Security.RateLimit.Enabled || rps == s.rateLimit.Get() {
+		return
+	}
+
+	s.rateLimit.Set(rps)
+	s.logger.InfoContext(context.Background(), "Reloaded rate limit", "requests_per_second", rps)
+}
+
+// reloadProvider rebuilds the IAM provider from a reloaded config and swaps it in.
+// Requests already in flight finish on the previous provider.
+func (s *Server) reloadProvider(cfg *config.Config) {
+	ctx := context.Background()
+
+	next, err := provider.NewIAMProvider(&cfg.IAM, &s.logger,
+		provider.WithTokenCache(s.tokenCache), provider.WithJTIDenylist(s.jtiDenylist))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to reload IAM provider, keeping the current one", "error", err)
+		return
+	}
+
+	if cfg.IAM.EagerInit {
+		s.warmup(ctx, next)
+	}
+
+	previous := s.iamProvider.Swap(next)
+	s.logger.InfoContext(ctx, "Reloaded IAM provider", "provider", cfg.IAM.CurrentProvider())
+
+	// Requests in flight on the previous provider keep their connections; only idle
+	// ones are released
+	if err := provider.Close(previous); err != nil {
+		s.logger.WarnContext(ctx, "Failed to close the previous IAM provider", "error", err)
+	}
+}
+
+// reloadAuth rebuilds the authentication settings from a reloaded config and swaps them in,
+// for the next requests to authenticate with them
+func (s *Server) reloadAuth(cfg *config.Config) {
+	ctx := context.Background()
+
+	auth, err := s.newAuthSettings(cfg)
+	if err != nil {
+		s.logger.ErrorContext(ctx, "Failed to reload authentication settings, keeping the current ones", "error", err)
+		return
+	}
+
+	s.auth.Store(auth)
+	s.logger.InfoContext(ctx, "Reloaded authentication settings")
+}
+
+// warmup prefetches what the provider needs to validate tokens. Failures are logged and
+// left for the first validations to retry.
+func (s *Server) warmup(ctx context.Context, p provider.IAMProvider) {
+	warmer, ok := provider.As[provider.Warmer](p)
+	if !ok {
+		return
+	}
+
+	if err := warmer.Warmup(ctx); err != nil {
+		s.logger.WarnContext(ctx, "IAM provider warmup failed, keys will be fetched on first use", "error", err)
+		return
+	}
+	s.logger.InfoContext(ctx, "IAM provider warmed up")
+}
+
+// selfTest checks the provider's configuration against the live IdP, for misconfigurations
+// to fail startup rather than the first requests
+func (s *Server) selfTest(ctx context.Context) error {
+	tester, ok := provider.As[provider.SelfTester](s.iamProvider)
+	if !ok {
+		s.logger.WarnContext(ctx, "IAM provider doesn't support self tests, skipping")
+		return nil
+	}
+
+	if err := tester.SelfTest(ctx); err != nil {
+		return fmt.Errorf("IAM provider self test failed: %w", err)
+	}
+	s.logger.InfoContext(ctx, "IAM provider self test passed")
+	return nil
+}
+
+// authSettings is the authentication built from one config: where tokens are looked for,
+// how they are validated and how rejections are reported
+type authSettings struct {
+	tokens       middleware.TokenExtractor
+	handler      gin.HandlerFunc
+	errorHandler gin.HandlerFunc
+}
+
+// newAuthSettings builds the authentication middleware from the IAM configuration of cfg.
+// With gateway tokens enabled, requests authenticate with the gateway JWT instead.
+func (s *Server) newAuthSettings(cfg *config.Config) (*authSettings, error) {
+	// Tokens are looked for in the configured places, in order
+	tokens, err := middleware.NewTokenExtractor(cfg.IAM.TokenSources, cfg.IAM.TokenCookie,
+		cfg.IAM.TokenCookieCSRFHeader, cfg.IAM.TokenQueryParam)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure token sources: %w", err)
+	}
+
+	auth := &authSettings{
+		tokens:       tokens,
+		errorHandler: middleware.ErrorHandlerMiddleware(middleware.WithVerboseErrors(cfg.VerboseAuthErrors())),
+	}
+
+	if cfg.IAM.Gateway.Enabled {
+		gateway, err := provider.NewGatewayValidator(&cfg.IAM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gateway validator: %w", err)
+		}
+		auth.handler = middleware.GatewayAuthMiddleware(gateway, cfg.IAM.Gateway.Header)
+		return auth, nil
+	}
+
+	opts := []middleware.AuthOption{middleware.WithTokenExtractor(tokens)}
+	if cfg.IAM.DPoPEnabled {
+		opts = append(opts, middleware.WithDPoP())
 	}
+	if cfg.IAM.MTLSBoundTokens {
+		opts = append(opts, middleware.WithMTLSBoundTokens())
+	}
+	if !cfg.IAM.RejectMultipleAuthHeaders {
+		opts = append(opts, middleware.WithMultipleAuthHeaders())
+	}
+	if len(cfg.IAM.AllowedClients) > 0 {
+		opts = append(opts, middleware.WithAllowedClients(cfg.IAM.AllowedClients...))
+	}
+	if s.clientRates != nil {
+		opts = append(opts, middleware.WithClientRateLimits(s.clientRates))
+	}
+	if s.auditLog != nil {
+		opts = append(opts, middleware.WithAuditLogger(s.auditLog))
+	}
+	if redirect := cfg.Security.LoginRedirect; redirect.Enabled {
+		loginURL := middleware.AuthCodeLoginURL(s.iamProvider, s.loginStates, cfg.Security.LoginState.TTL)
+		if redirect.URL != "" {
+			loginURL = middleware.StaticLoginURL(redirect.URL)
+		}
+		opts = append(opts, middleware.WithLoginRedirect(loginURL))
+	}
+
+	auth.handler = middleware.AuthMiddleware(s.iamProvider, opts...)
+	return auth, nil
+}
+
+// authMiddleware authenticates requests with the authentication settings of the current
+// config, so reloaded settings apply to the next request
+func (s *Server) authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		s.auth.Load().handler(c)
+	}
+}
+
+// userManagementAuth authenticates requests to the user management routes, requiring the
+// configured authentication level and methods on top
+func (s *Server) userManagementAuth() []gin.HandlerFunc {
+	um := s.config.Security.UserManagement
+	handlers := []gin.HandlerFunc{s.authMiddleware()}
+	if len(um.RequiredACR) > 0 {
+		handlers = append(handlers, middleware.RequireACR(um.RequiredACR...))
+	}
+	if len(um.RequiredAMR) > 0 {
+		handlers = append(handlers, middleware.RequireAMR(um.RequiredAMR...))
+	}
+	return handlers
 }
 
 // setupRoutes configures all routes for the server
@@ -97,7 +512,23 @@ func (s *Server) setupRoutes() {
 	// @Success 200 {object} map[string]interface{}
 	// @Failure 503 {object} map[string]interface{}
 	// @Router /health [get]
-	s.router.GET("/health", s.handleHealthCheck)
+	s.router.GET("/health", s.healthCacheControl(), s.handleHealthCheck)
+
+	// Keys internal tokens are verified with, for upstream services to fetch
+	if s.internal != nil {
+		s.router.GET("/.well-known/jwks.json", gin.WrapF(JWKSHandler(s.internal.SigningKeys(), s.config.IAM.InternalToken.JWKSMaxAge)))
+	}
+
+	// Troubleshooting endpoints, only in debug mode: they expose internals such as memstats,
+	// the command line and operational counters, and accept tokens for dry-run validation
+	if s.config.IsDebug() {
+		s.router.GET("/debug/vars", s.healthCacheControl(), gin.WrapH(expvar.Handler()))
+		s.router.POST("/debug/validate", middleware.SensitiveBody(), gin.WrapF(DebugValidateHandler(s.iamProvider)))
+		s.router.GET("/debug/middleware", gin.WrapF(MiddlewareChainHandler(s.MiddlewareChain())))
+		if s.config.Security.RateLimit.Enabled {
+			s.router.GET("/debug/ratelimit", gin.WrapF(RateLimitStateHandler(s.limiter)))
+		}
+	}
 
 	// API routes
 	api := s.router.Group("/api/v1")
@@ -144,14 +575,38 @@ func (s *Server) setupRoutes() {
 			// @Failure 400 {object} map[string]interface{}
 			// @Router /api/v1/auth/validate [get]
 			auth.GET("/validate", s.handleValidateToken)
+
+			// @Summary Authorization URL
+			// @Description Builds the IAM provider authorization URL for the code flow with PKCE
+			// @Tags Authentication
+			// @Param state query string true "Opaque state value"
+			// @Param code_challenge query string true "S256 PKCE code challenge"
+			// @Produce json
+			// @Success 200 {object} map[string]string
+			// @Failure 400 {object} map[string]interface{}
+			// @Router /api/v1/auth/authorize [get]
+			auth.GET("/authorize", s.handleAuthCodeURL)
+
+			// @Summary Exchange Code
+			// @Description Exchanges an authorization code and PKCE verifier for tokens
+			// @Tags Authentication
+			// @Accept json
+			// @Produce json
+			// @Param exchange body struct{Code string; CodeVerifier string; State string} true "Code and verifier, or the state of a login started by a redirect"
+			// @Success 200 {object} provider.TokenResponse
+			// @Success 204 "Tokens stored in the server-side session"
+			// @Failure 400 {object} map[string]interface{}
+			// @Router /api/v1/auth/exchange [post]
+			auth.POST("/exchange", s.handleExchangeCode)
 		}
 
 		// User management routes
-		users := api.Group("/users")
+		users := api.Group("/users", s.userManagementAuth()...)
 		{
 			// @Summary Get User Info
 			// @Description Retrieves information about a specific user
 			// @Tags Users
+			// @Security BearerAuth
 			// @Param id path string true "User ID"
 			// @Produce json
 			// @Success 200 {object} map[string]interface{}
@@ -162,6 +617,7 @@ func (s *Server) setupRoutes() {
 			// @Summary Update User Info
 			// @Description Updates the information of a specific user
 			// @Tags Users
+			// @Security BearerAuth
 			// @Param id path string true "User ID"
 			// @Param userInfo body struct{...} true "User information payload"
 			// @Success 204
@@ -172,6 +628,7 @@ func (s *Server) setupRoutes() {
 			// @Summary Assign Role
 			// @Description Assigns a role to a specific user
 			// @Tags Users
+			// @Security BearerAuth
 			// @Param id path string true "User ID"
 			// @Param role body struct{Role string} true "Role payload"
 			// @Success 204
@@ -182,6 +639,7 @@ func (s *Server) setupRoutes() {
 			// @Summary Remove Role
 			// @Description Removes a role from a specific user
 			// @Tags Users
+			// @Security BearerAuth
 			// @Param id path string true "User ID"
 			// @Param role path string true "Role"
 			// @Success 204
@@ -192,6 +650,7 @@ func (s *Server) setupRoutes() {
 			// @Summary Get User Roles
 			// @Description Retrieves the roles of a specific user
 			// @Tags Users
+			// @Security BearerAuth
 			// @Param id path string true "User ID"
 			// @Produce json
 			// @Success 200 {object} map[string]interface{}
@@ -199,11 +658,31 @@ func (s *Server) setupRoutes() {
 			// @Router /api/v1/users/{id}/roles [get]
 			users.GET("/:id/roles", s.handleGetUserRoles)
 		}
+
+		// @Summary List Realm Roles
+		// @Description Lists the roles defined in the IAM provider's realm
+		// @Tags Roles
+		// @Security BearerAuth
+		// @Produce json
+		// @Success 200 {object} map[string]interface{}
+		// @Failure 403 {object} map[string]interface{}
+		// @Failure 501 {object} map[string]interface{}
+		// @Router /api/v1/roles [get]
+		api.GET("/roles", s.authMiddleware(), middleware.RequireRoles(s.config.Security.UserManagement.AdminRole), s.handleListRealmRoles)
 	}
 }
 
 // Start starts the HTTP server
 func (s *Server) Start() error {
+	if s.config.IAM.SelfTestOnStart {
+		if err := s.selfTest(context.Background()); err != nil {
+			return err
+		}
+	}
+	if s.config.IAM.EagerInit {
+		s.warmup(context.Background(), s.iamProvider)
+	}
+
 	s.httpServer = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.config.App.Port),
 		Handler: s.router,
@@ -215,6 +694,7 @@ func (s *Server) Start() error {
 	// Start the server in a goroutine
 	go func() {
 		s.logger.Info("Starting server", "port", s.config.App.Port)
+		s.logger.InfoContext(context.Background(), "Middleware chain", "middleware", s.MiddlewareChain())
 		serverErrors <- s.httpServer.ListenAndServe()
 	}()
 
@@ -228,23 +708,81 @@ func (s *Server) Start() error {
 		return fmt.Errorf("server error: %w", err)
 
 	case <-shutdown:
-		s.logger.Info("Starting shutdown")
+		return s.shutdown()
+	}
+}
 
-		// Create a deadline for graceful shutdown
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-		defer cancel()
+// shutdown drains in-flight requests for up to app.shutdown_timeout, then closes the server
+// forcefully and returns ErrShutdownTimeout
+func (s *Server) shutdown() error {
+	timeout := s.config.App.ShutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	s.logger.InfoContext(context.Background(), "Starting shutdown",
+		"in_flight", s.inFlight.Load(), "timeout", timeout)
 
-		// Shut down the server
-		if err := s.httpServer.Shutdown(ctx); err != nil {
-			// If shutdown times out, forcefully close
-			s.httpServer.Close()
-			return fmt.Errorf("could not stop server gracefully: %w", err)
+	// Create a deadline for graceful shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Shut down the server. What the server holds is released even when it didn't stop
+	// gracefully, so queued audit events are flushed on every path.
+	err := s.httpServer.Shutdown(ctx)
+	if err != nil {
+		inFlight := s.inFlight.Load()
+		// If shutdown times out, forcefully close
+		s.httpServer.Close()
+		if errors.Is(err, context.DeadlineExceeded) {
+			s.logger.ErrorContext(context.Background(), "Shutdown timed out, closing connections",
+				"in_flight", inFlight)
+			err = fmt.Errorf("%w after %s with %d requests in flight", ErrShutdownTimeout, timeout, inFlight)
+		} else {
+			err = fmt.Errorf("could not stop server gracefully: %w", err)
 		}
 	}
+	s.release()
 
+	if err != nil {
+		return err
+	}
+	s.logger.InfoContext(context.Background(), "Shutdown complete")
 	return nil
 }
 
+// auditFlushTimeout bounds flushing the queued audit events on shutdown. It is separate from
+// the shutdown timeout, which requests in flight may have used up.
+const auditFlushTimeout = 10 * time.Second
+
+// release closes the provider, caches and audit log once the server stopped
+func (s *Server) release() {
+	if err := s.iamProvider.Close(); err != nil {
+		s.logger.WarnContext(context.Background(), "Failed to close the IAM provider", "error", err)
+	}
+	if closer, ok := s.tokenCache.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	if closer, ok := s.jtiDenylist.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	if s.auditLog != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), auditFlushTimeout)
+		defer cancel()
+		if err := s.auditLog.Close(ctx); err != nil {
+			s.logger.WarnContext(context.Background(), "Failed to flush audit events", "error", err)
+		}
+	}
+}
+
+// trackInFlight counts the requests being handled
+func (s *Server) trackInFlight(c *gin.Context) {
+	s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+
+	c.Next()
+}
+
 // Stop stops the HTTP server
 func (s *Server) Stop(ctx context.Context) error {
 	if s.httpServer != nil {
@@ -253,19 +791,60 @@ func (s *Server) Stop(ctx context.Context) error {
 	return nil
 }
 
+// healthChecks returns the dependency checks enabled by app.health_checks, keyed by name
+func (s *Server) healthChecks() map[string]func(ctx context.Context) error {
+	available := map[string]func(ctx context.Context) error{
+		config.HealthCheckIDP: s.iamProvider.HealthCheck,
+	}
+
+	checks := make(map[string]func(ctx context.Context) error, len(s.config.App.HealthChecks))
+	for _, name := range s.config.App.HealthChecks {
+		if check, ok := available[name]; ok {
+			checks[name] = check
+		}
+	}
+	return checks
+}
+
+// healthCacheControl sets the configured Cache-Control header on the health and metrics
+// endpoints, which are polled by probes that must not get a cached result
+func (s *Server) healthCacheControl() gin.HandlerFunc {
+	value := s.config.App.HealthCacheControl
+	return func(c *gin.Context) {
+		if value != "" {
+			c.Header("Cache-Control", value)
+		}
+		c.Next()
+	}
+}
+
 // Handler functions
 func (s *Server) handleHealthCheck(c *gin.Context) {
-	// Check IAM provider health
-	if err := s.iamProvider.HealthCheck(c.Request.Context()); err != nil {
+	// Run only the dependency checks this deployment enables
+	results := make(map[string]string)
+	healthy := true
+	for name, check := range s.healthChecks() {
+		if err := check(c.Request.Context()); err != nil {
+			s.logger.ErrorContext(c.Request.Context(), "Health check failed", "check", name, "error", err)
+			results[name] = "error"
+			healthy = false
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	if !healthy {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"status":  "error",
-			"message": "IAM provider health check failed",
+			"message": "health check failed",
+			"checks":  results,
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "ok",
+		"checks":    results,
 		"timestamp": time.Now().UTC(),
 	})
 }
@@ -293,20 +872,64 @@ func (s *Server) handleLogin(c *gin.Context) {
 }
 
 func (s *Server) handleLogout(c *gin.Context) {
-	token := extractToken(c)
+	// Sessions log out with the refresh token kept on the server side
+	if sess := middleware.GetSession(c); sess != nil {
+		if err := s.iamProvider.Logout(c.Request.Context(), sess.RefreshToken); err != nil {
+			c.Error(err)
+			return
+		}
+		if tokenInfo := middleware.GetTokenInfo(c); tokenInfo != nil {
+			s.revokeToken(c, tokenInfo)
+		}
+		if err := s.sessions.Delete(c); err != nil {
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	_, token, err := s.auth.Load().tokens.Extract(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
 	if token == "" {
 		c.Error(provider.ErrTokenInvalid)
 		return
 	}
 
+	// Logging out ends the IdP session but not the access token, so with the jti denylist
+	// checked it is revoked too. Tokens that don't validate, such as refresh tokens, are
+	// left to the IdP.
+	var tokenInfo *provider.TokenInfo
+	if _, ok := provider.As[provider.TokenRevoker](s.iamProvider); ok {
+		tokenInfo, _ = s.iamProvider.ValidateToken(c.Request.Context(), token)
+	}
+
 	if err := s.iamProvider.Logout(c.Request.Context(), token); err != nil {
 		c.Error(err)
 		return
 	}
+	if tokenInfo != nil {
+		s.revokeToken(c, tokenInfo)
+	}
 
 	c.Status(http.StatusNoContent)
 }
 
+// revokeToken revokes a logged out access token when the jti denylist is checked. Failures
+// are logged rather than failing the logout, which already succeeded at the IdP.
+func (s *Server) revokeToken(c *gin.Context, tokenInfo *provider.TokenInfo) {
+	revoker, ok := provider.As[provider.TokenRevoker](s.iamProvider)
+	if !ok {
+		return
+	}
+	if err := revoker.RevokeToken(c.Request.Context(), tokenInfo); err != nil {
+		s.logger.WarnContext(c.Request.Context(), "Failed to revoke logged out token", "error", err)
+	}
+}
+
 func (s *Server) handleRefreshToken(c *gin.Context) {
 	var req struct {
 		RefreshToken string `json:"refresh_token" binding:"required"`
@@ -329,7 +952,11 @@ func (s *Server) handleRefreshToken(c *gin.Context) {
 }
 
 func (s *Server) handleValidateToken(c *gin.Context) {
-	token := extractToken(c)
+	_, token, err := s.auth.Load().tokens.Extract(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
 	if token == "" {
 		c.Error(provider.ErrTokenInvalid)
 		return
@@ -341,9 +968,121 @@ func (s *Server) handleValidateToken(c *gin.Context) {
 		return
 	}
 
+	// Reverse proxies using this as a forward-auth endpoint copy these onto the upstream request.
+	// Internal tokens are only handed to trusted proxies: a client holding one could call the
+	// upstream services directly, past the proxy.
+	s.forwardAuth.Set(c.Writer.Header(), tokenInfo)
+	if s.proxies.FromProxy(c.Request) {
+		if err := s.forwardAuth.SetInternalToken(c.Request.Context(), c.Writer.Header(), tokenInfo); err != nil {
+			c.Error(err)
+			return
+		}
+	}
 	c.JSON(http.StatusOK, tokenInfo)
 }
 
+func (s *Server) handleAuthCodeURL(c *gin.Context) {
+	flow, ok := provider.As[provider.AuthCodeFlowProvider](s.iamProvider)
+	if !ok {
+		c.Error(provider.ErrNotSupported)
+		return
+	}
+
+	state := c.Query("state")
+	challenge := c.Query("code_challenge")
+
+	// Without either, the server starts the login itself and keeps the verifier and nonce
+	// until the code is exchanged with the returned state
+	if state == "" && challenge == "" {
+		authURL, state, err := middleware.StartLogin(c, flow, s.loginStates, s.config.Security.LoginState.TTL)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"url":   authURL,
+			"state": state,
+		})
+		return
+	}
+	if state == "" || challenge == "" {
+		c.Error(fmt.Errorf("state and code_challenge are required"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"url": flow.AuthCodeURL(state, challenge),
+	})
+}
+
+func (s *Server) handleExchangeCode(c *gin.Context) {
+	flow, ok := provider.As[provider.AuthCodeFlowProvider](s.iamProvider)
+	if !ok {
+		c.Error(provider.ErrNotSupported)
+		return
+	}
+
+	var req struct {
+		Code         string `json:"code" binding:"required"`
+		CodeVerifier string `json:"code_verifier"`
+		State        string `json:"state"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	// Logins started by the server keep the verifier and nonce in the login state store.
+	// Consuming the state is one-shot, so a replayed callback is rejected.
+	var nonce string
+	if req.CodeVerifier == "" {
+		if req.State == "" {
+			c.Error(fmt.Errorf("invalid request: code_verifier or state is required"))
+			return
+		}
+		flowState, ok := s.loginStates.Consume(c, req.State)
+		if !ok {
+			c.Error(fmt.Errorf("invalid request: unknown or expired state"))
+			return
+		}
+		req.CodeVerifier, nonce = flowState.CodeVerifier, flowState.Nonce
+	}
+
+	tokens, err := flow.ExchangeCode(c.Request.Context(), req.Code, req.CodeVerifier)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	// A login started with a nonce is only complete with an ID token proven to carry it
+	validator, ok := provider.As[provider.IDTokenValidator](s.iamProvider)
+	if nonce != "" && (tokens.IDToken == "" || !ok) {
+		c.Error(provider.ErrNonceMismatch)
+		return
+	}
+
+	// Make sure the ID token belongs to this client and the access token wasn't substituted
+	if ok && tokens.IDToken != "" {
+		if _, err := validator.ValidateIDToken(c.Request.Context(), tokens.IDToken, nonce, tokens.AccessToken); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+
+	// Keep the tokens server-side when sessions are enabled
+	if s.sessions != nil {
+		if err := s.sessions.Set(c, session.FromTokens(tokens)); err != nil {
+			c.Error(err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
 func (s *Server) handleGetUserInfo(c *gin.Context) {
 	userID := c.Param("id")
 	if userID == "" {
@@ -439,17 +1178,20 @@ func (s *Server) handleGetUserRoles(c *gin.Context) {
 	})
 }
 
-// Helper functions
-func extractToken(c *gin.Context) string {
-	token := c.GetHeader("Authorization")
-	if token == "" {
-		return ""
+func (s *Server) handleListRealmRoles(c *gin.Context) {
+	lister, ok := provider.As[provider.RoleLister](s.iamProvider)
+	if !ok {
+		c.Error(provider.ErrNotSupported)
+		return
 	}
 
-	// Remove "Bearer " prefix if present
-	if len(token) > 7 && token[:7] == "Bearer " {
-		return token[7:]
+	roles, err := lister.ListRealmRoles(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
 	}
 
-	return token
+	c.JSON(http.StatusOK, gin.H{
+		"roles": roles,
+	})
 }
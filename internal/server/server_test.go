@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+	"github.com/zahidhasanpapon/iam-bridge/pkg/logger"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// staticProvider accepts every token as issued to one client
+type staticProvider struct {
+	provider.IAMProvider
+	client string
+}
+
+func (p staticProvider) ValidateToken(context.Context, string) (*provider.TokenInfo, error) {
+	return &provider.TokenInfo{UserID: "user-1", AuthorizedParty: p.client}, nil
+}
+
+// newTestServer returns a server with the authentication of cfg, routing / through it
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	log, err := logger.NewLogger(&config.LogConfig{Level: "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{
+		config:      cfg,
+		logger:      log,
+		router:      gin.New(),
+		iamProvider: provider.NewAtomicProvider(staticProvider{client: "web"}),
+	}
+	auth, err := s.newAuthSettings(cfg)
+	if err != nil {
+		t.Fatalf("newAuthSettings() error = %v", err)
+	}
+	s.auth.Store(auth)
+
+	s.router.Use(func(c *gin.Context) { s.auth.Load().errorHandler(c) })
+	s.router.GET("/", s.authMiddleware(), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	return s
+}
+
+func (s *Server) get(t *testing.T) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestReloadAuthAppliesToNextRequest(t *testing.T) {
+	cfg := &config.Config{IAM: config.IAMConfig{TokenSources: []string{"header"}}}
+	s := newTestServer(t, cfg)
+	if code := s.get(t); code != http.StatusNoContent {
+		t.Fatalf("status = %d before the reload, want %d", code, http.StatusNoContent)
+	}
+
+	// The routes were set up before the reload; they must still pick up allowed_clients
+	reloaded := &config.Config{IAM: config.IAMConfig{TokenSources: []string{"header"}, AllowedClients: []string{"mobile"}}}
+	s.reloadAuth(reloaded)
+	if code := s.get(t); code != http.StatusForbidden {
+		t.Errorf("status = %d after restricting allowed_clients, want %d", code, http.StatusForbidden)
+	}
+
+	// A config that fails to build keeps the current settings
+	s.reloadAuth(&config.Config{IAM: config.IAMConfig{TokenSources: []string{"carrier-pigeon"}}})
+	if code := s.get(t); code != http.StatusForbidden {
+		t.Errorf("status = %d after a rejected reload, want %d", code, http.StatusForbidden)
+	}
+}
+
+// roleListingProvider lists one realm role, accepting every token with roles
+type roleListingProvider struct {
+	provider.IAMProvider
+	roles []string
+}
+
+func (p roleListingProvider) ValidateToken(context.Context, string) (*provider.TokenInfo, error) {
+	return &provider.TokenInfo{UserID: "user-1", Roles: p.roles}, nil
+}
+
+func (p roleListingProvider) ListRealmRoles(context.Context) ([]provider.Role, error) {
+	return []provider.Role{{Name: "admin"}}, nil
+}
+
+func TestListRealmRolesRequiresAdminRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		roles      []string
+		wantStatus int
+	}{
+		{"admin", []string{"user", "admin"}, http.StatusOK},
+		{"not an admin", []string{"user"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				IAM:      config.IAMConfig{TokenSources: []string{"header"}},
+				Security: config.SecurityConfig{UserManagement: config.UserManagementConfig{AdminRole: "admin"}},
+			}
+			s := newTestServer(t, cfg)
+			s.iamProvider.Swap(roleListingProvider{roles: tt.roles})
+			s.setupRoutes()
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/roles", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			rec := httptest.NewRecorder()
+			s.router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+// serveSlow serves s with a route held until release is closed, returning its URL
+func serveSlow(t *testing.T, s *Server, release chan struct{}) string {
+	t.Helper()
+	s.router.Use(s.trackInFlight)
+	s.router.GET("/slow", func(c *gin.Context) {
+		<-release
+		c.Status(http.StatusNoContent)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.httpServer = &http.Server{Handler: s.router}
+	go func() { _ = s.httpServer.Serve(listener) }()
+	return "http://" + listener.Addr().String()
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	tests := []struct {
+		name         string
+		timeout      time.Duration
+		releaseAfter time.Duration
+		wantErr      bool
+	}{
+		{"requests finish within the timeout", time.Second, 20 * time.Millisecond, false},
+		{"requests outlast the timeout", 20 * time.Millisecond, time.Second, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				App: config.AppConfig{ShutdownTimeout: tt.timeout},
+				IAM: config.IAMConfig{TokenSources: []string{"header"}},
+			}
+			s := newTestServer(t, cfg)
+			release := make(chan struct{})
+			url := serveSlow(t, s, release)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if resp, err := http.Get(url + "/slow"); err == nil {
+					resp.Body.Close()
+				}
+			}()
+			for s.inFlight.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			timer := time.AfterFunc(tt.releaseAfter, func() { close(release) })
+			defer func() {
+				if timer.Stop() {
+					close(release)
+				}
+			}()
+
+			err := s.shutdown()
+			if !tt.wantErr && err != nil {
+				t.Errorf("shutdown() error = %v", err)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, ErrShutdownTimeout) {
+					t.Errorf("shutdown() error = %v, want ErrShutdownTimeout", err)
+				} else if !strings.Contains(err.Error(), "with 1 requests in flight") {
+					t.Errorf("shutdown() error = %q, want the requests in flight", err)
+				}
+			}
+			<-done
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// minCookieSecretLength is the minimum accepted length of the cookie secret
+const minCookieSecretLength = 32
+
+// CookieStore keeps the whole session in an AES-GCM encrypted cookie.
+// Browsers cap cookies at roughly 4KB, so large tokens are better served by the MemoryStore.
+type CookieStore struct {
+	config *config.SessionConfig
+	aead   cipher.AEAD
+}
+
+type cookiePayload struct {
+	Session   Session   `json:"session"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewCookieStore creates a new CookieStore instance
+func NewCookieStore(cfg *config.SessionConfig) (*CookieStore, error) {
+	if len(cfg.CookieSecret) < minCookieSecretLength {
+		return nil, fmt.Errorf("session cookie secret must be at least %d characters", minCookieSecretLength)
+	}
+
+	aead, err := newAEAD(cfg.CookieSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookieStore{
+		config: cfg,
+		aead:   aead,
+	}, nil
+}
+
+// newAEAD derives the AES-GCM cipher sealing cookie values from the secret
+func newAEAD(secret string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// Get decrypts the session from the request cookie
+func (s *CookieStore) Get(c *gin.Context) (*Session, error) {
+	value, err := c.Cookie(s.config.CookieName)
+	if err != nil || value == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return nil, ErrSessionInvalid
+	}
+
+	nonce, ciphertext := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(s.config.CookieName))
+	if err != nil {
+		return nil, ErrSessionInvalid
+	}
+
+	var payload cookiePayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, ErrSessionInvalid
+	}
+	if time.Now().After(payload.ExpiresAt) {
+		return nil, ErrSessionNotFound
+	}
+
+	return &payload.Session, nil
+}
+
+// Set encrypts the session into the response cookie
+func (s *CookieStore) Set(c *gin.Context, session *Session) error {
+	plaintext, err := json.Marshal(cookiePayload{
+		Session:   *session,
+		ExpiresAt: time.Now().Add(s.config.TTL),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// The cookie name is bound as additional data so a value can't be replayed under another cookie
+	sealed := s.aead.Seal(nonce, nonce, plaintext, []byte(s.config.CookieName))
+	setCookie(c, s.config, base64.RawURLEncoding.EncodeToString(sealed), int(s.config.TTL.Seconds()))
+	return nil
+}
+
+// Delete clears the session cookie
+func (s *CookieStore) Delete(c *gin.Context) error {
+	setCookie(c, s.config, "", -1)
+	return nil
+}
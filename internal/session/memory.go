@@ -0,0 +1,110 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+type memoryEntry struct {
+	session   Session
+	expiresAt time.Time
+}
+
+// MemoryStore keeps sessions in process memory; the cookie only carries a random session ID
+type MemoryStore struct {
+	config   *config.SessionConfig
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+}
+
+// NewMemoryStore creates a new MemoryStore instance
+func NewMemoryStore(cfg *config.SessionConfig) *MemoryStore {
+	return &MemoryStore{
+		config:   cfg,
+		sessions: make(map[string]memoryEntry),
+	}
+}
+
+// Get returns the session referenced by the request cookie
+func (m *MemoryStore) Get(c *gin.Context) (*Session, error) {
+	id, err := c.Cookie(m.config.CookieName)
+	if err != nil || id == "" {
+		return nil, ErrSessionNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(m.sessions, id)
+		return nil, ErrSessionNotFound
+	}
+
+	session := entry.session
+	return &session, nil
+}
+
+// Set stores the session under a freshly generated session ID and drops the one the request
+// cookie referenced, if any. Never reusing the client's ID prevents session fixation: an ID
+// planted in a victim's browser can't become authenticated when the victim logs in.
+func (m *MemoryStore) Set(c *gin.Context, session *Session) error {
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if old, err := c.Cookie(m.config.CookieName); err == nil {
+		delete(m.sessions, old)
+	}
+	m.evictExpired()
+	m.sessions[id] = memoryEntry{
+		session:   *session,
+		expiresAt: time.Now().Add(m.config.TTL),
+	}
+	m.mu.Unlock()
+
+	setCookie(c, m.config, id, int(m.config.TTL.Seconds()))
+	return nil
+}
+
+// Delete removes the session and clears the cookie
+func (m *MemoryStore) Delete(c *gin.Context) error {
+	if id, err := c.Cookie(m.config.CookieName); err == nil {
+		m.mu.Lock()
+		delete(m.sessions, id)
+		m.mu.Unlock()
+	}
+
+	setCookie(c, m.config, "", -1)
+	return nil
+}
+
+// evictExpired drops expired sessions; callers must hold m.mu
+func (m *MemoryStore) evictExpired() {
+	now := time.Now()
+	for id, entry := range m.sessions {
+		if now.After(entry.expiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// newSessionID generates a random, URL-safe session identifier
+func newSessionID() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
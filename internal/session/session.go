@@ -0,0 +1,68 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionInvalid  = errors.New("session invalid")
+)
+
+// Session holds the tokens of an authenticated user kept on the server side
+type Session struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	IDToken      string    `json:"id_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// FromTokens builds a session from a token endpoint response
+func FromTokens(tokens *provider.TokenResponse) *Session {
+	return &Session{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		IDToken:      tokens.IDToken,
+		ExpiresAt:    time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second),
+	}
+}
+
+// ExpiresWithin reports whether the access token expires within the given duration
+func (s *Session) ExpiresWithin(d time.Duration) bool {
+	return time.Until(s.ExpiresAt) < d
+}
+
+// Store defines the interface all session stores must implement.
+// Stores are keyed by a cookie on the request, so tokens are never exposed to the browser
+// in readable form.
+type Store interface {
+	Get(c *gin.Context) (*Session, error)
+	Set(c *gin.Context, session *Session) error
+	Delete(c *gin.Context) error
+}
+
+// NewStore creates a new session store based on the given configuration
+func NewStore(cfg *config.SessionConfig) (Store, error) {
+	switch strings.ToLower(cfg.Store) {
+	case "", "memory":
+		return NewMemoryStore(cfg), nil
+	case "cookie":
+		return NewCookieStore(cfg)
+	default:
+		return nil, fmt.Errorf("invalid session store: %s", cfg.Store)
+	}
+}
+
+// setCookie writes the session cookie with the configured attributes
+func setCookie(c *gin.Context, cfg *config.SessionConfig, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(cfg.CookieName, value, maxAge, "/", "", cfg.Secure, true)
+}
@@ -0,0 +1,289 @@
+package session
+
+import (
+	"container/list"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+// FlowState is what a login started with the authorization code flow needs when the
+// callback exchanges the code
+type FlowState struct {
+	CodeVerifier string `json:"code_verifier"`
+	Nonce        string `json:"nonce,omitempty"`
+}
+
+// StateStore keeps the FlowState of started logins under their state parameter. Both
+// stores bind the state to the browser that started the login with a cookie, so a callback
+// carrying a state the browser didn't start is rejected (login CSRF). Consume is one-shot:
+// a state can be consumed once, so a replayed callback fails.
+type StateStore interface {
+	Save(c *gin.Context, state string, data *FlowState, ttl time.Duration) error
+	Consume(c *gin.Context, state string) (*FlowState, bool)
+}
+
+// defaultMaxLoginStates is the number of started logins the memory store holds when
+// security.login_state.max_entries is not set
+const defaultMaxLoginStates = 10000
+
+// StateStoreOption configures a StateStore
+type StateStoreOption func(*stateStoreOptions)
+
+type stateStoreOptions struct {
+	clock clock.Clock
+}
+
+// WithClock sets the time source for login state expiry. Without it, the system clock is used.
+func WithClock(c clock.Clock) StateStoreOption {
+	return func(o *stateStoreOptions) {
+		o.clock = c
+	}
+}
+
+func newStateStoreOptions(opts []StateStoreOption) *stateStoreOptions {
+	options := &stateStoreOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	options.clock = clock.OrSystem(options.clock)
+	return options
+}
+
+// NewStateStore creates a new login state store based on the given configuration
+func NewStateStore(cfg *config.LoginStateConfig, opts ...StateStoreOption) (StateStore, error) {
+	switch strings.ToLower(cfg.Store) {
+	case "", "memory":
+		return NewMemoryStateStore(cfg, opts...), nil
+	case "cookie":
+		return NewCookieStateStore(cfg, opts...)
+	default:
+		return nil, fmt.Errorf("invalid login state store: %s", cfg.Store)
+	}
+}
+
+type stateEntry struct {
+	state     string
+	data      FlowState
+	expiresAt time.Time
+}
+
+// MemoryStateStore keeps login states in process memory; the cookie only carries the state.
+// Logins must be completed on the instance that started them. It holds at most max_entries
+// started logins, dropping the oldest when full, so logins started and never completed
+// can't grow it without bound.
+type MemoryStateStore struct {
+	config     *config.LoginStateConfig
+	clock      clock.Clock
+	maxEntries int
+
+	mu     sync.Mutex
+	states map[string]*list.Element
+	// order holds the states oldest first. Saves share the configured TTL, so they expire
+	// in this order too.
+	order *list.List
+}
+
+// NewMemoryStateStore creates a new MemoryStateStore instance
+func NewMemoryStateStore(cfg *config.LoginStateConfig, opts ...StateStoreOption) *MemoryStateStore {
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxLoginStates
+	}
+
+	return &MemoryStateStore{
+		config:     cfg,
+		clock:      newStateStoreOptions(opts).clock,
+		maxEntries: maxEntries,
+		states:     make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Save stores the flow state and binds it to the browser with the state cookie
+func (m *MemoryStateStore) Save(c *gin.Context, state string, data *FlowState, ttl time.Duration) error {
+	now := m.clock.Now()
+
+	m.mu.Lock()
+	m.evict(now)
+	if elem, ok := m.states[state]; ok {
+		m.order.Remove(elem)
+	}
+	m.states[state] = m.order.PushBack(&stateEntry{
+		state:     state,
+		data:      *data,
+		expiresAt: now.Add(ttl),
+	})
+	m.mu.Unlock()
+
+	setStateCookie(c, m.config, state, int(ttl.Seconds()))
+	return nil
+}
+
+// Consume returns the flow state saved under state and removes it, provided the request
+// carries the state cookie of the same login
+func (m *MemoryStateStore) Consume(c *gin.Context, state string) (*FlowState, bool) {
+	bound, err := c.Cookie(m.config.CookieName)
+	if err != nil || bound == "" {
+		return nil, false
+	}
+	setStateCookie(c, m.config, "", -1)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The state is removed even when the cookie doesn't match: it was leaked to a browser
+	// that didn't start the login, and must not be usable by the one that did either
+	elem, ok := m.states[state]
+	if !ok {
+		return nil, false
+	}
+	m.order.Remove(elem)
+	delete(m.states, state)
+
+	entry := elem.Value.(*stateEntry)
+	if subtle.ConstantTimeCompare([]byte(bound), []byte(state)) != 1 || m.clock.Now().After(entry.expiresAt) {
+		return nil, false
+	}
+
+	data := entry.data
+	return &data, true
+}
+
+// evict drops the expired states at the front and, when the store is full, the oldest
+// one, making room for a new state; callers must hold m.mu
+func (m *MemoryStateStore) evict(now time.Time) {
+	for oldest := m.order.Front(); oldest != nil; oldest = m.order.Front() {
+		entry := oldest.Value.(*stateEntry)
+		if !now.After(entry.expiresAt) && m.order.Len() < m.maxEntries {
+			return
+		}
+		m.order.Remove(oldest)
+		delete(m.states, entry.state)
+	}
+}
+
+// CookieStateStore keeps the flow state in an AES-GCM encrypted cookie, so logins can be
+// completed on any instance sharing the secret. Being a single cookie, only the latest
+// login started by a browser can be completed.
+type CookieStateStore struct {
+	config *config.LoginStateConfig
+	aead   cipher.AEAD
+	clock  clock.Clock
+
+	// consumed remembers consumed states until they expire, as a client keeping a copy of
+	// the cookie could otherwise replay it. It is per instance, so a replay on another
+	// instance within the TTL is not caught.
+	mu       sync.Mutex
+	consumed map[string]time.Time
+}
+
+type statePayload struct {
+	State     string    `json:"state"`
+	Data      FlowState `json:"data"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewCookieStateStore creates a new CookieStateStore instance
+func NewCookieStateStore(cfg *config.LoginStateConfig, opts ...StateStoreOption) (*CookieStateStore, error) {
+	if len(cfg.CookieSecret) < minCookieSecretLength {
+		return nil, fmt.Errorf("login state cookie secret must be at least %d characters", minCookieSecretLength)
+	}
+
+	aead, err := newAEAD(cfg.CookieSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CookieStateStore{
+		config:   cfg,
+		aead:     aead,
+		clock:    newStateStoreOptions(opts).clock,
+		consumed: make(map[string]time.Time),
+	}, nil
+}
+
+// Save encrypts the state and flow state into the response cookie
+func (s *CookieStateStore) Save(c *gin.Context, state string, data *FlowState, ttl time.Duration) error {
+	plaintext, err := json.Marshal(statePayload{
+		State:     state,
+		Data:      *data,
+		ExpiresAt: s.clock.Now().Add(ttl),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode login state: %w", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, plaintext, []byte(s.config.CookieName))
+	setStateCookie(c, s.config, base64.RawURLEncoding.EncodeToString(sealed), int(ttl.Seconds()))
+	return nil
+}
+
+// Consume decrypts the flow state from the request cookie, provided it was saved under
+// state and not consumed before, and clears the cookie
+func (s *CookieStateStore) Consume(c *gin.Context, state string) (*FlowState, bool) {
+	value, err := c.Cookie(s.config.CookieName)
+	if err != nil || value == "" {
+		return nil, false
+	}
+	setStateCookie(c, s.config, "", -1)
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return nil, false
+	}
+
+	nonce, ciphertext := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(s.config.CookieName))
+	if err != nil {
+		return nil, false
+	}
+
+	var payload statePayload
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, false
+	}
+	if subtle.ConstantTimeCompare([]byte(payload.State), []byte(state)) != 1 {
+		return nil, false
+	}
+	now := s.clock.Now()
+	if now.After(payload.ExpiresAt) {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for consumed, expiresAt := range s.consumed {
+		if now.After(expiresAt) {
+			delete(s.consumed, consumed)
+		}
+	}
+	if _, replayed := s.consumed[payload.State]; replayed {
+		return nil, false
+	}
+	s.consumed[payload.State] = payload.ExpiresAt
+
+	return &payload.Data, true
+}
+
+// setStateCookie writes the login state cookie with the configured attributes
+func setStateCookie(c *gin.Context, cfg *config.LoginStateConfig, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(cfg.CookieName, value, maxAge, "/", "", cfg.Secure, true)
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/zahidhasanpapon/iam-bridge/internal/clock"
+	"github.com/zahidhasanpapon/iam-bridge/internal/config"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newStateConfig(maxEntries int) *config.LoginStateConfig {
+	return &config.LoginStateConfig{CookieName: "login", TTL: 10 * time.Minute, MaxEntries: maxEntries}
+}
+
+// save starts a login under state on store
+func save(t *testing.T, store StateStore, state string) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/login", nil)
+	if err := store.Save(c, state, &FlowState{CodeVerifier: "verifier-" + state}, 10*time.Minute); err != nil {
+		t.Fatalf("Save(%s) error = %v", state, err)
+	}
+}
+
+// consume completes the login of state, with the state cookie of that login
+func consume(store StateStore, state string) (*FlowState, bool) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/callback", nil)
+	c.Request.AddCookie(&http.Cookie{Name: "login", Value: state})
+	return store.Consume(c, state)
+}
+
+func TestMemoryStateStoreConsume(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStateStore(newStateConfig(0), WithClock(clock.Func(func() time.Time { return now })))
+
+	save(t, store, "a")
+	data, ok := consume(store, "a")
+	if !ok || data.CodeVerifier != "verifier-a" {
+		t.Fatalf("Consume() = %v, %v, want the saved flow state", data, ok)
+	}
+	if _, ok := consume(store, "a"); ok {
+		t.Error("a state was consumed twice")
+	}
+
+	save(t, store, "b")
+	now = now.Add(11 * time.Minute)
+	if _, ok := consume(store, "b"); ok {
+		t.Error("an expired state was consumed")
+	}
+}
+
+func TestMemoryStateStoreMaxEntries(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStateStore(newStateConfig(3), WithClock(clock.Func(func() time.Time { return now })))
+
+	for i := 0; i < 5; i++ {
+		save(t, store, fmt.Sprintf("s%d", i))
+	}
+	if len(store.states) != 3 || store.order.Len() != 3 {
+		t.Fatalf("store holds %d states, want 3", len(store.states))
+	}
+	for _, state := range []string{"s0", "s1"} {
+		if _, ok := consume(store, state); ok {
+			t.Errorf("the oldest state %s was kept over the cap", state)
+		}
+	}
+	if _, ok := consume(store, "s4"); !ok {
+		t.Error("the latest state was dropped")
+	}
+
+	// Expired states are dropped on the next save, without filling the store
+	now = now.Add(11 * time.Minute)
+	save(t, store, "fresh")
+	if len(store.states) != 1 {
+		t.Errorf("store holds %d states after the others expired, want 1", len(store.states))
+	}
+}
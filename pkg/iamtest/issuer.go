@@ -0,0 +1,201 @@
+// Package iamtest provides helpers for testing code that validates tokens issued by an IAM provider
+package iamtest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zahidhasanpapon/iam-bridge/internal/provider"
+)
+
+const (
+	// DefaultIssuer matches a Keycloak provider configured with base URL
+	// https://iam.test and realm "test"
+	DefaultIssuer = "https://iam.test/realms/test"
+	// DefaultKID is the key ID of the issuer's signing key
+	DefaultKID = "iamtest-key"
+	// DefaultTTL is the lifetime of signed tokens unless overridden
+	DefaultTTL = time.Hour
+)
+
+// Claims is the claim set of a token to sign
+type Claims map[string]interface{}
+
+// TokenIssuer signs RS256 tokens with a generated key and exposes the matching JWKS
+type TokenIssuer struct {
+	Issuer string
+
+	key *rsa.PrivateKey
+	kid string
+}
+
+// SignOption customizes a single signed token
+type SignOption func(*signOptions)
+
+type signOptions struct {
+	issuer    string
+	audiences []string
+	kid       string
+	expiresAt time.Time
+	noExpiry  bool
+}
+
+// WithExpiry sets the token's exp relative to now; negative values produce expired tokens
+func WithExpiry(d time.Duration) SignOption {
+	return func(o *signOptions) {
+		o.expiresAt = time.Now().Add(d)
+	}
+}
+
+// WithoutExpiry omits the exp claim
+func WithoutExpiry() SignOption {
+	return func(o *signOptions) {
+		o.noExpiry = true
+	}
+}
+
+// WithAudience sets the token's aud claim
+func WithAudience(audiences ...string) SignOption {
+	return func(o *signOptions) {
+		o.audiences = audiences
+	}
+}
+
+// WithIssuer overrides the token's iss claim
+func WithIssuer(issuer string) SignOption {
+	return func(o *signOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithKID overrides the kid header, e.g. to simulate an unknown signing key
+func WithKID(kid string) SignOption {
+	return func(o *signOptions) {
+		o.kid = kid
+	}
+}
+
+// NewTokenIssuer creates a TokenIssuer with a freshly generated 2048-bit RSA key
+func NewTokenIssuer() (*TokenIssuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate RSA key: %w", err)
+	}
+
+	return &TokenIssuer{
+		Issuer: DefaultIssuer,
+		key:    key,
+		kid:    DefaultKID,
+	}, nil
+}
+
+// Sign produces a signed JWT for the claims. iss, exp and iat are set unless the claims
+// already carry them; options take precedence over both.
+func (i *TokenIssuer) Sign(claims Claims, opts ...SignOption) (string, error) {
+	options := &signOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	payload := make(Claims, len(claims)+4)
+	payload["iss"] = i.Issuer
+	payload["iat"] = time.Now().Unix()
+	payload["exp"] = time.Now().Add(DefaultTTL).Unix()
+	for k, v := range claims {
+		payload[k] = v
+	}
+
+	if options.issuer != "" {
+		payload["iss"] = options.issuer
+	}
+	if len(options.audiences) > 0 {
+		payload["aud"] = options.audiences
+	}
+	if !options.expiresAt.IsZero() {
+		payload["exp"] = options.expiresAt.Unix()
+	}
+	if options.noExpiry {
+		delete(payload, "exp")
+	}
+
+	kid := i.kid
+	if options.kid != "" {
+		kid = options.kid
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"kid": kid,
+	})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// JWKS returns the key set holding the issuer's public key
+func (i *TokenIssuer) JWKS() provider.JSONWebKeySet {
+	return provider.JSONWebKeySet{
+		Keys: []provider.JSONWebKey{{
+			Kty: "RSA",
+			Kid: i.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	}
+}
+
+// JWKSHandler serves the issuer's JWKS, e.g. from an httptest.Server standing in for the IdP
+func (i *TokenIssuer) JWKSHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(i.JWKS())
+	})
+}
+
+// JWKSInline returns the issuer's JWKS as JSON, for use as jwks_inline
+func (i *TokenIssuer) JWKSInline() string {
+	data, _ := json.Marshal(i.JWKS())
+	return string(data)
+}
+
+// WriteJWKSFile writes the issuer's JWKS to dir and returns the file path, for use as jwks_file
+func (i *TokenIssuer) WriteJWKSFile(dir string) (string, error) {
+	data, err := json.Marshal(i.JWKS())
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write JWKS file: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"context"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	subjectKey
+	providerKey
+)
+
+// redactedValue replaces the value of any sensitive key passed to a context-aware log call
+const redactedValue = "[REDACTED]"
+
+// sensitiveKeyParts marks log keys whose values must never be written
+var sensitiveKeyParts = []string{"token", "secret", "password", "authorization", "cookie"}
+
+// WithRequestID returns a copy of ctx carrying the request ID for context-aware logging
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// WithSubject returns a copy of ctx carrying the authenticated subject for context-aware logging
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey, subject)
+}
+
+// WithProvider returns a copy of ctx carrying the IAM provider name for context-aware logging
+func WithProvider(ctx context.Context, provider string) context.Context {
+	return context.WithValue(ctx, providerKey, provider)
+}
+
+// contextFields prepends the request fields found in ctx to keysAndValues and
+// redacts the values of sensitive keys. Fields only appear when present in ctx.
+func contextFields(ctx context.Context, keysAndValues []interface{}) []interface{} {
+	fields := make([]interface{}, 0, len(keysAndValues)+6)
+
+	if ctx != nil {
+		for _, f := range []struct {
+			key  contextKey
+			name string
+		}{
+			{requestIDKey, "request_id"},
+			{subjectKey, "subject"},
+			{providerKey, "provider"},
+		} {
+			if v, ok := ctx.Value(f.key).(string); ok && v != "" {
+				fields = append(fields, f.name, v)
+			}
+		}
+	}
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			fields = append(fields, keysAndValues[i])
+			break
+		}
+
+		key, value := keysAndValues[i], keysAndValues[i+1]
+		if k, ok := key.(string); ok && isSensitiveKey(k) {
+			value = redactedValue
+		}
+		fields = append(fields, key, value)
+	}
+
+	return fields
+}
+
+// isSensitiveKey reports whether the log key names a secret or token
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,8 @@
 package logger
 
 import (
+	"context"
+
 	"github.com/zahidhasanpapon/iam-bridge/internal/config"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -13,28 +15,36 @@ type Logger interface {
 	Errorf(template string, args ...interface{})
 	Fatal(args ...interface{})
 	Fatalf(template string, args ...interface{})
+
+	// InfoContext, WarnContext and ErrorContext log a message with key-value pairs and add
+	// the request fields carried by ctx (request ID, subject, provider)
+	InfoContext(ctx context.Context, msg string, keysAndValues ...interface{})
+	WarnContext(ctx context.Context, msg string, keysAndValues ...interface{})
+	ErrorContext(ctx context.Context, msg string, keysAndValues ...interface{})
+}
+
+// DebugLogger is implemented by loggers that also log at debug level, like the Logger
+// returned by NewLogger. Callers check for it so other Logger implementations needn't.
+type DebugLogger interface {
+	DebugContext(ctx context.Context, msg string, keysAndValues ...interface{})
+}
+
+// LevelSetter is implemented by loggers whose level can be changed while they are in use,
+// e.g. on config reload
+type LevelSetter interface {
+	SetLevel(level string)
 }
 
 type zapLogger struct {
 	sugaredLogger *zap.SugaredLogger
+	level         zap.AtomicLevel
 }
 
 func NewLogger(cfg *config.LogConfig) (Logger, error) {
 	logConfig := zap.NewProductionConfig()
 
 	// Set log level based on logConfig
-	switch cfg.Level {
-	case "debug":
-		logConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
-	case "info":
-		logConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-	case "warn":
-		logConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
-	case "error":
-		logConfig.Level = zap.NewAtomicLevelAt(zap.ErrorLevel)
-	default:
-		logConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-	}
+	logConfig.Level = zap.NewAtomicLevelAt(parseLevel(cfg.Level))
 
 	logConfig.EncoderConfig.TimeKey = "timestamp"
 	logConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
@@ -46,9 +56,29 @@ func NewLogger(cfg *config.LogConfig) (Logger, error) {
 
 	return &zapLogger{
 		sugaredLogger: logger.Sugar(),
+		level:         logConfig.Level,
 	}, nil
 }
 
+// parseLevel maps a configured level name to its zap level, defaulting to info
+func parseLevel(level string) zapcore.Level {
+	switch level {
+	case "debug":
+		return zap.DebugLevel
+	case "warn":
+		return zap.WarnLevel
+	case "error":
+		return zap.ErrorLevel
+	default:
+		return zap.InfoLevel
+	}
+}
+
+// SetLevel changes the minimum level of logged messages
+func (l *zapLogger) SetLevel(level string) {
+	l.level.SetLevel(parseLevel(level))
+}
+
 func (l *zapLogger) Info(args ...interface{}) {
 	l.sugaredLogger.Info(args...)
 }
@@ -72,3 +102,19 @@ func (l *zapLogger) Fatal(args ...interface{}) {
 func (l *zapLogger) Fatalf(template string, args ...interface{}) {
 	l.sugaredLogger.Fatalf(template, args...)
 }
+
+func (l *zapLogger) DebugContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.sugaredLogger.Debugw(msg, contextFields(ctx, keysAndValues)...)
+}
+
+func (l *zapLogger) InfoContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.sugaredLogger.Infow(msg, contextFields(ctx, keysAndValues)...)
+}
+
+func (l *zapLogger) WarnContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.sugaredLogger.Warnw(msg, contextFields(ctx, keysAndValues)...)
+}
+
+func (l *zapLogger) ErrorContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	l.sugaredLogger.Errorw(msg, contextFields(ctx, keysAndValues)...)
+}